@@ -4,6 +4,8 @@ package client
 import (
 	"bufio"
 	"context"
+	"crypto/ed25519"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,6 +14,7 @@ import (
 	"io"
 	"net/http"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -30,6 +33,13 @@ var (
 	topicRegex = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`) // Same as in server/server.go
 )
 
+// Errors returned by VerifyMessage and ParsePublicKey
+var (
+	ErrMessageNotSigned = errors.New("message is not signed")
+	ErrInvalidSignature = errors.New("invalid message signature")
+	ErrInvalidPublicKey = errors.New("invalid public key, must be a base64-encoded Ed25519 public key")
+)
+
 // Client is the ntfy client that can be used to publish and subscribe to ntfy topics
 type Client struct {
 	Messages      chan *Message
@@ -51,6 +61,8 @@ type Message struct { // TODO combine with server.message
 	Click      string
 	Icon       string
 	Attachment *Attachment
+	Encoding   string
+	Signature  string
 
 	// Additional fields
 	TopicURL       string
@@ -68,6 +80,17 @@ type Attachment struct {
 	Owner   string `json:"-"` // IP address of uploader, used for rate limiting
 }
 
+type signedURLRequest struct {
+	Topic   string   `json:"topic"`
+	Expires int64    `json:"expires"`
+	Fields  []string `json:"fields,omitempty"`
+	Uses    int      `json:"uses,omitempty"`
+}
+
+type signedURLResponse struct {
+	URL string `json:"url"`
+}
+
 type subscription struct {
 	ID       string
 	topicURL string
@@ -96,7 +119,7 @@ func (c *Client) Publish(topic, message string, options ...PublishOption) (*Mess
 // config (e.g. mytopic -> https://ntfy.sh/mytopic).
 //
 // To pass title, priority and tags, check out WithTitle, WithPriority, WithTagsList, WithDelay, WithNoCache,
-// WithNoFirebase, and the generic WithHeader.
+// WithNoFirebase, and the generic WithHeader. To encrypt the message end-to-end, see WithEncryption.
 func (c *Client) PublishReader(topic string, body io.Reader, options ...PublishOption) (*Message, error) {
 	topicURL, err := c.expandTopicURL(topic)
 	if err != nil {
@@ -111,6 +134,9 @@ func (c *Client) PublishReader(topic string, body io.Reader, options ...PublishO
 			return nil, err
 		}
 	}
+	if err := encryptRequest(req, topicURL); err != nil {
+		return nil, err
+	}
 	log.Debug("%s Publishing message with headers %s", util.ShortTopicURL(topicURL), req.Header)
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -131,6 +157,56 @@ func (c *Client) PublishReader(topic string, body io.Reader, options ...PublishO
 	return m, nil
 }
 
+// SignPublishURL asks the server to create a pre-signed publish URL for a topic, which can be used to publish
+// without any credentials until it expires. The sender may only set the given fields (e.g. "title", "priority")
+// in addition to the message, and the URL can only be used the given number of times (0 means unlimited).
+//
+// The topic is expanded like in Publish. Credentials of a user with write access to the topic must be passed
+// as a RequestOption, see WithBasicAuth and WithBearerAuth.
+func (c *Client) SignPublishURL(topic string, expires time.Time, fields []string, uses int, options ...RequestOption) (string, error) {
+	topicURL, err := c.expandTopicURL(topic)
+	if err != nil {
+		return "", err
+	}
+	i := strings.LastIndex(topicURL, "/")
+	baseURL, topicName := topicURL[:i], topicURL[i+1:]
+	body, err := json.Marshal(&signedURLRequest{
+		Topic:   topicName,
+		Expires: expires.Unix(),
+		Fields:  fields,
+		Uses:    uses,
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", baseURL+"/v1/account/signed-url", strings.NewReader(string(body)))
+	if err != nil {
+		return "", err
+	}
+	for _, option := range options {
+		if err := option(req); err != nil {
+			return "", err
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(strings.TrimSpace(string(b)))
+	}
+	var response signedURLResponse
+	if err := json.Unmarshal(b, &response); err != nil {
+		return "", err
+	}
+	return response.URL, nil
+}
+
 // Poll queries a topic for all (or a limited set) of messages. Unlike Subscribe, this method only polls for
 // messages and does not subscribe to messages that arrive after this call.
 //
@@ -139,7 +215,8 @@ func (c *Client) PublishReader(topic string, body io.Reader, options ...PublishO
 // config (e.g. mytopic -> https://ntfy.sh/mytopic).
 //
 // By default, all messages will be returned, but you can change this behavior using a SubscribeOption.
-// See WithSince, WithSinceAll, WithSinceUnixTime, WithScheduled, and the generic WithQueryParam.
+// See WithSince, WithSinceAll, WithSinceUnixTime, WithScheduled, and the generic WithQueryParam. To page
+// through the cached messages, see WithLimit, WithBefore and WithAfter.
 func (c *Client) Poll(topic string, options ...SubscribeOption) ([]*Message, error) {
 	topicURL, err := c.expandTopicURL(topic)
 	if err != nil {
@@ -152,7 +229,8 @@ func (c *Client) Poll(topic string, options ...SubscribeOption) ([]*Message, err
 	log.Debug("%s Polling from topic", util.ShortTopicURL(topicURL))
 	options = append(options, WithPoll())
 	go func() {
-		err := performSubscribeRequest(ctx, msgChan, topicURL, "", options...)
+		var lastMessageID string
+		err := performSubscribeRequest(ctx, msgChan, topicURL, "", &lastMessageID, options...)
 		close(msgChan)
 		errChan <- err
 	}()
@@ -226,22 +304,28 @@ func (c *Client) expandTopicURL(topic string) (string, error) {
 }
 
 func handleSubscribeConnLoop(ctx context.Context, msgChan chan *Message, topicURL, subcriptionID string, options ...SubscribeOption) {
+	var lastMessageID string
 	for {
-		// TODO The retry logic is crude and may lose messages. It should record the last message like the
-		//      Android client, use since=, and do incremental backoff too
-		if err := performSubscribeRequest(ctx, msgChan, topicURL, subcriptionID, options...); err != nil {
+		// When reconnecting, resume after the last message we received (like the Android client), so that
+		// messages published while the connection was down are not lost.
+		// TODO Add incremental backoff
+		connOptions := options
+		if lastMessageID != "" {
+			connOptions = append(slices.Clone(options), WithSince(lastMessageID))
+		}
+		if err := performSubscribeRequest(ctx, msgChan, topicURL, subcriptionID, &lastMessageID, connOptions...); err != nil {
 			log.Warn("%s Connection failed: %s", util.ShortTopicURL(topicURL), err.Error())
 		}
 		select {
 		case <-ctx.Done():
 			log.Info("%s Connection exited", util.ShortTopicURL(topicURL))
 			return
-		case <-time.After(10 * time.Second): // TODO Add incremental backoff
+		case <-time.After(10 * time.Second):
 		}
 	}
 }
 
-func performSubscribeRequest(ctx context.Context, msgChan chan *Message, topicURL string, subscriptionID string, options ...SubscribeOption) error {
+func performSubscribeRequest(ctx context.Context, msgChan chan *Message, topicURL string, subscriptionID string, lastMessageID *string, options ...SubscribeOption) error {
 	streamURL := fmt.Sprintf("%s/json", topicURL)
 	log.Debug("%s Listening to %s", util.ShortTopicURL(topicURL), streamURL)
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, streamURL, nil)
@@ -274,12 +358,45 @@ func performSubscribeRequest(ctx context.Context, msgChan chan *Message, topicUR
 		}
 		log.Trace("%s Message received: %s", util.ShortTopicURL(topicURL), messageJSON)
 		if m.Event == MessageEvent {
+			*lastMessageID = m.ID
 			msgChan <- m
 		}
 	}
 	return nil
 }
 
+// VerifyMessage verifies the server signature of a message (see the message-signing-key server option) using
+// the server's Ed25519 public key. The signature is computed over the canonical JSON representation of the raw
+// message, without the "signature" and "expires" fields. It returns ErrMessageNotSigned if the message has no
+// signature, and ErrInvalidSignature if the signature does not match.
+func VerifyMessage(m *Message, publicKey ed25519.PublicKey) error {
+	if m.Signature == "" {
+		return ErrMessageNotSigned
+	}
+	signature, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	canonical, err := util.CanonicalJSON([]byte(m.Raw), "signature", "expires")
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, canonical, signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// ParsePublicKey parses a base64-encoded Ed25519 public key, as published by the server
+// at /.well-known/ntfy-signing-key
+func ParsePublicKey(key string) (ed25519.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil || len(b) != ed25519.PublicKeySize {
+		return nil, ErrInvalidPublicKey
+	}
+	return b, nil
+}
+
 func toMessage(s, topicURL, subscriptionID string) (*Message, error) {
 	var m *Message
 	if err := json.NewDecoder(strings.NewReader(s)).Decode(&m); err != nil {
@@ -1,12 +1,16 @@
 package client_test
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
 	"fmt"
 	"github.com/stretchr/testify/require"
 	"heckel.io/ntfy/v2/client"
 	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/server"
 	"heckel.io/ntfy/v2/test"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -101,6 +105,98 @@ func TestClient_Publish_Poll(t *testing.T) {
 	require.Equal(t, "some delayed message", messages[1].Message)
 }
 
+func TestClient_Poll_Limit(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	c := client.New(newTestConfig(port))
+
+	for i := 1; i <= 5; i++ {
+		_, err := c.Publish("mytopic", fmt.Sprintf("message %d", i))
+		require.Nil(t, err)
+	}
+
+	messages, err := c.Poll("mytopic", client.WithLimit(2))
+	require.Nil(t, err)
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, "message 1", messages[0].Message)
+	require.Equal(t, "message 2", messages[1].Message)
+
+	messages, err = c.Poll("mytopic", client.WithLimit(2), client.WithAfter(messages[1].ID))
+	require.Nil(t, err)
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, "message 3", messages[0].Message)
+	require.Equal(t, "message 4", messages[1].Message)
+
+	messages, err = c.Poll("mytopic", client.WithLimit(2), client.WithBefore(messages[0].ID))
+	require.Nil(t, err)
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, "message 1", messages[0].Message)
+	require.Equal(t, "message 2", messages[1].Message)
+}
+
+func TestClient_VerifyMessage(t *testing.T) {
+	seed := make([]byte, ed25519.SeedSize)
+	copy(seed, "some-seed-for-the-signing-key")
+	conf := server.NewConfig()
+	conf.MessageSigningKey = base64.StdEncoding.EncodeToString(seed)
+	s, port := test.StartServerWithConfig(t, conf)
+	defer test.StopServer(t, s, port)
+	c := client.New(newTestConfig(port))
+	publicKey, err := client.ParsePublicKey(base64.StdEncoding.EncodeToString(ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)))
+	require.Nil(t, err)
+
+	_, err = c.Publish("mytopic", "some message", client.WithTitle("some <title>"), client.WithTags([]string{"tag1", "tag2"}))
+	require.Nil(t, err)
+	messages, err := c.Poll("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+	m := messages[0]
+	require.NotEmpty(t, m.Signature)
+	require.Nil(t, client.VerifyMessage(m, publicKey))
+
+	m.Raw = strings.Replace(m.Raw, "some message", "other message", 1)
+	require.Equal(t, client.ErrInvalidSignature, client.VerifyMessage(m, publicKey))
+	m.Signature = ""
+	require.Equal(t, client.ErrMessageNotSigned, client.VerifyMessage(m, publicKey))
+
+	_, err = client.ParsePublicKey("not a key")
+	require.Equal(t, client.ErrInvalidPublicKey, err)
+}
+
+func TestClient_PublishEncrypted_Decrypt(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	c := client.New(newTestConfig(port))
+
+	m, err := c.Publish("mytopic", "secret message",
+		client.WithEncryption("my password"),
+		client.WithTitle("secret title"),
+		client.WithTags([]string{"tag1", "tag2"}),
+		client.WithPriority("high"))
+	require.Nil(t, err)
+	require.Equal(t, client.EncodingJWE, m.Encoding)
+	require.NotContains(t, m.Raw, "secret")
+	require.Equal(t, 4, m.Priority)
+
+	messages, err := c.Poll("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, client.ErrDecryptionFailed, client.DecryptMessage(messages[0], "wrong password"))
+
+	m = messages[0]
+	require.Nil(t, client.DecryptMessage(m, "my password"))
+	require.Equal(t, "secret message", m.Message)
+	require.Equal(t, "secret title", m.Title)
+	require.Equal(t, []string{"tag1", "tag2"}, m.Tags)
+	require.Equal(t, "", m.Encoding)
+	require.Contains(t, m.Raw, `"message":"secret message"`)
+	require.NotContains(t, m.Raw, `"encoding"`)
+	require.Equal(t, client.ErrMessageNotEncrypted, client.DecryptMessage(m, "my password"))
+
+	_, err = c.Publish("mytopic", "secret message", client.WithEncryption("my password"), client.WithAttach("https://example.com/file.jpg"))
+	require.Error(t, err)
+}
+
 func newTestConfig(port int) *client.Config {
 	c := client.NewConfig()
 	c.DefaultHost = fmt.Sprintf("http://127.0.0.1:%d", port)
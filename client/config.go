@@ -13,33 +13,38 @@ const (
 
 // Config is the config struct for a Client
 type Config struct {
-	DefaultHost     string      `yaml:"default-host"`
-	DefaultUser     string      `yaml:"default-user"`
-	DefaultPassword *string     `yaml:"default-password"`
-	DefaultToken    string      `yaml:"default-token"`
-	DefaultCommand  string      `yaml:"default-command"`
-	Subscribe       []Subscribe `yaml:"subscribe"`
+	DefaultHost          string      `yaml:"default-host"`
+	DefaultUser          string      `yaml:"default-user"`
+	DefaultPassword      *string     `yaml:"default-password"`
+	DefaultToken         string      `yaml:"default-token"`
+	DefaultCommand       string      `yaml:"default-command"`
+	DefaultEncryptionKey string      `yaml:"default-encryption-key"`
+	VerifyKey            string      `yaml:"verify-key"`
+	Subscribe            []Subscribe `yaml:"subscribe"`
 }
 
 // Subscribe is the struct for a Subscription within Config
 type Subscribe struct {
-	Topic    string            `yaml:"topic"`
-	User     *string           `yaml:"user"`
-	Password *string           `yaml:"password"`
-	Token    *string           `yaml:"token"`
-	Command  string            `yaml:"command"`
-	If       map[string]string `yaml:"if"`
+	Topic         string            `yaml:"topic"`
+	User          *string           `yaml:"user"`
+	Password      *string           `yaml:"password"`
+	Token         *string           `yaml:"token"`
+	Command       string            `yaml:"command"`
+	EncryptionKey string            `yaml:"encryption-key"`
+	If            map[string]string `yaml:"if"`
 }
 
 // NewConfig creates a new Config struct for a Client
 func NewConfig() *Config {
 	return &Config{
-		DefaultHost:     DefaultBaseURL,
-		DefaultUser:     "",
-		DefaultPassword: nil,
-		DefaultToken:    "",
-		DefaultCommand:  "",
-		Subscribe:       nil,
+		DefaultHost:          DefaultBaseURL,
+		DefaultUser:          "",
+		DefaultPassword:      nil,
+		DefaultToken:         "",
+		DefaultCommand:       "",
+		DefaultEncryptionKey: "",
+		VerifyKey:            "",
+		Subscribe:            nil,
 	}
 }
 
@@ -0,0 +1,223 @@
+package client
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"heckel.io/ntfy/v2/util"
+)
+
+// End-to-end encryption:
+//
+// Messages can be encrypted with a password that is shared between publisher and subscribers, so that the server
+// never sees the message in plain text. The message, title, tags and click URL are encrypted as a JSON object
+// (see encryptedPayload) into a JWE in compact serialization, using direct encryption with AES-256-GCM
+// ("alg":"dir", "enc":"A256GCM"). The key is derived from the password using PBKDF2-SHA256, with the topic name
+// as salt. The message is published with the "jwe" encoding, all other fields (e.g. priority) are not encrypted.
+
+const (
+	// EncodingJWE is the message encoding of encrypted messages, see WithEncryption
+	EncodingJWE = "jwe"
+
+	keyDerivationIterations = 50000
+	keyLength               = 32
+	jweHeader               = `{"alg":"dir","enc":"A256GCM"}`
+)
+
+// Errors returned by DecryptMessage
+var (
+	ErrMessageNotEncrypted = errors.New("message is not encrypted")
+	ErrDecryptionFailed    = errors.New("decrypting message failed, wrong password?")
+)
+
+var errEncryptionAttachmentsNotSupported = errors.New("encrypted messages cannot have attachments")
+
+type encryptionContextKey struct{}
+
+// encryptedPayload is the plain text of an encrypted message
+type encryptedPayload struct {
+	Message string   `json:"message"`
+	Title   string   `json:"title,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	Click   string   `json:"click,omitempty"`
+}
+
+// WithEncryption encrypts the message, title, tags and click URL with a key derived from the given password
+// before the message is sent to the server. Subscribers need the same password to decrypt the message,
+// see DecryptMessage. Encrypted messages cannot have attachments.
+func WithEncryption(password string) PublishOption {
+	return func(r *http.Request) error {
+		*r = *r.WithContext(context.WithValue(r.Context(), encryptionContextKey{}, password))
+		return nil
+	}
+}
+
+// DecryptMessage decrypts a message that was encrypted with WithEncryption, and replaces the message, title,
+// tags and click URL (and the raw JSON message) with the decrypted values.
+func DecryptMessage(m *Message, password string) error {
+	if m.Encoding != EncodingJWE {
+		return ErrMessageNotEncrypted
+	}
+	plaintext, err := decryptJWE(deriveKey(password, m.Topic), m.Message)
+	if err != nil {
+		return err
+	}
+	var payload encryptedPayload
+	if err := json.Unmarshal(plaintext, &payload); err != nil {
+		return ErrDecryptionFailed
+	}
+	var raw map[string]any
+	decoder := json.NewDecoder(strings.NewReader(m.Raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return err
+	}
+	delete(raw, "encoding")
+	raw["message"] = payload.Message
+	setOrDelete(raw, "title", payload.Title, payload.Title != "")
+	setOrDelete(raw, "tags", payload.Tags, len(payload.Tags) > 0)
+	setOrDelete(raw, "click", payload.Click, payload.Click != "")
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	m.Message, m.Title, m.Tags, m.Click = payload.Message, payload.Title, payload.Tags, payload.Click
+	m.Encoding = ""
+	m.Raw = string(b)
+	return nil
+}
+
+// encryptRequest replaces the body of the publish request, and the X-Title, X-Tags and X-Click headers with
+// an encrypted message, if WithEncryption was passed
+func encryptRequest(r *http.Request, topicURL string) error {
+	password, ok := r.Context().Value(encryptionContextKey{}).(string)
+	if !ok {
+		return nil
+	} else if r.Header.Get("X-Filename") != "" || r.Header.Get("X-Attach") != "" {
+		return errEncryptionAttachmentsNotSupported
+	}
+	payload := &encryptedPayload{
+		Title: r.Header.Get("X-Title"),
+		Tags:  util.SplitNoEmpty(r.Header.Get("X-Tags"), ","),
+		Click: r.Header.Get("X-Click"),
+	}
+	if r.Header.Get("X-Message") != "" {
+		payload.Message = r.Header.Get("X-Message")
+	} else if r.Body != nil {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		payload.Message = string(body)
+	}
+	plaintext, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	topic := topicURL[strings.LastIndex(topicURL, "/")+1:]
+	jwe, err := encryptJWE(deriveKey(password, topic), plaintext)
+	if err != nil {
+		return err
+	}
+	for _, header := range []string{"X-Message", "X-Title", "X-Tags", "X-Click"} {
+		r.Header.Del(header)
+	}
+	r.Header.Set("X-Encoding", EncodingJWE)
+	r.Body = io.NopCloser(strings.NewReader(jwe))
+	r.ContentLength = int64(len(jwe))
+	r.GetBody = nil
+	return nil
+}
+
+func deriveKey(password, topic string) []byte {
+	key, err := pbkdf2.Key(sha256.New, password, []byte(topic), keyDerivationIterations, keyLength)
+	if err != nil {
+		panic(err) // Cannot happen with the given parameters
+	}
+	return key
+}
+
+func encryptJWE(key, plaintext []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	header := base64.RawURLEncoding.EncodeToString([]byte(jweHeader))
+	sealed := gcm.Seal(nil, iv, plaintext, []byte(header))
+	ciphertext, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+	return strings.Join([]string{
+		header,
+		"", // No encrypted key with direct encryption
+		base64.RawURLEncoding.EncodeToString(iv),
+		base64.RawURLEncoding.EncodeToString(ciphertext),
+		base64.RawURLEncoding.EncodeToString(tag),
+	}, "."), nil
+}
+
+func decryptJWE(key []byte, jwe string) ([]byte, error) {
+	parts := strings.Split(strings.TrimSpace(jwe), ".")
+	if len(parts) != 5 {
+		return nil, ErrDecryptionFailed
+	}
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || !supportedJWEHeader(header) {
+		return nil, ErrDecryptionFailed
+	}
+	iv, err1 := base64.RawURLEncoding.DecodeString(parts[2])
+	ciphertext, err2 := base64.RawURLEncoding.DecodeString(parts[3])
+	tag, err3 := base64.RawURLEncoding.DecodeString(parts[4])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return nil, ErrDecryptionFailed
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	} else if len(iv) != gcm.NonceSize() {
+		return nil, ErrDecryptionFailed
+	}
+	plaintext, err := gcm.Open(nil, iv, append(ciphertext, tag...), []byte(parts[0]))
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return plaintext, nil
+}
+
+// supportedJWEHeader checks if a JWE header uses direct encryption with AES-256-GCM, allowing for
+// additional header fields set by other implementations
+func supportedJWEHeader(header []byte) bool {
+	var h struct {
+		Alg string `json:"alg"`
+		Enc string `json:"enc"`
+	}
+	return json.Unmarshal(header, &h) == nil && h.Alg == "dir" && h.Enc == "A256GCM"
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func setOrDelete(m map[string]any, key string, value any, set bool) {
+	if set {
+		m[key] = value
+	} else {
+		delete(m, key)
+	}
+}
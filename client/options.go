@@ -51,6 +51,13 @@ func WithDelay(delay string) PublishOption {
 	return WithHeader("X-Delay", delay)
 }
 
+// WithExpires instructs the server to delete the message after the given time, and to not deliver it anymore
+// after that. The expires parameter can be a Unix timestamp, a duration string (relative to the delivery time)
+// or a natural language string. See https://ntfy.sh/docs/publish/#message-expiry for details.
+func WithExpires(expires string) PublishOption {
+	return WithHeader("X-Expires", expires)
+}
+
 // WithClick makes the notification action open the given URL as opposed to entering the detail view
 func WithClick(url string) PublishOption {
 	return WithHeader("X-Click", url)
@@ -133,6 +140,24 @@ func WithSinceUnixTime(since int64) SubscribeOption {
 	return WithSince(fmt.Sprintf("%d", since))
 }
 
+// WithLimit instructs the server to return at most limit cached messages. To fetch the next page, pass the ID of
+// the last returned message to WithAfter, or the ID of the first returned message to WithBefore.
+func WithLimit(limit int) SubscribeOption {
+	return WithQueryParam("limit", fmt.Sprintf("%d", limit))
+}
+
+// WithBefore instructs the server to only return cached messages that were published before the message with the
+// given ID. Combined with WithLimit, this returns the page of messages right before this message.
+func WithBefore(messageID string) SubscribeOption {
+	return WithQueryParam("before", messageID)
+}
+
+// WithAfter instructs the server to only return cached messages that were published after the message with the
+// given ID. Combined with WithLimit, this returns the page of messages right after this message.
+func WithAfter(messageID string) SubscribeOption {
+	return WithQueryParam("after", messageID)
+}
+
 // WithPoll instructs the server to close the connection after messages have been returned. Don't use this option
 // directly. Use Client.Poll instead.
 func WithPoll() SubscribeOption {
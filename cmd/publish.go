@@ -27,6 +27,7 @@ var flagsPublish = append(
 	&cli.StringFlag{Name: "priority", Aliases: []string{"p"}, EnvVars: []string{"NTFY_PRIORITY"}, Usage: "priority of the message (1=min, 2=low, 3=default, 4=high, 5=max)"},
 	&cli.StringFlag{Name: "tags", Aliases: []string{"tag", "T"}, EnvVars: []string{"NTFY_TAGS"}, Usage: "comma separated list of tags and emojis"},
 	&cli.StringFlag{Name: "delay", Aliases: []string{"at", "in", "D"}, EnvVars: []string{"NTFY_DELAY"}, Usage: "delay/schedule message"},
+	&cli.StringFlag{Name: "expires", Aliases: []string{"ttl"}, EnvVars: []string{"NTFY_EXPIRES"}, Usage: "delete message and stop delivering it after this time/duration"},
 	&cli.StringFlag{Name: "click", Aliases: []string{"U"}, EnvVars: []string{"NTFY_CLICK"}, Usage: "URL to open when notification is clicked"},
 	&cli.StringFlag{Name: "icon", Aliases: []string{"i"}, EnvVars: []string{"NTFY_ICON"}, Usage: "URL to use as notification icon"},
 	&cli.StringFlag{Name: "actions", Aliases: []string{"A"}, EnvVars: []string{"NTFY_ACTIONS"}, Usage: "actions JSON array or simple definition"},
@@ -42,6 +43,12 @@ var flagsPublish = append(
 	&cli.BoolFlag{Name: "no-cache", Aliases: []string{"no_cache", "C"}, EnvVars: []string{"NTFY_NO_CACHE"}, Usage: "do not cache message server-side"},
 	&cli.BoolFlag{Name: "no-firebase", Aliases: []string{"no_firebase", "F"}, EnvVars: []string{"NTFY_NO_FIREBASE"}, Usage: "do not forward message to Firebase"},
 	&cli.BoolFlag{Name: "quiet", Aliases: []string{"q"}, EnvVars: []string{"NTFY_QUIET"}, Usage: "do not print message"},
+	&cli.BoolFlag{Name: "encrypt", EnvVars: []string{"NTFY_ENCRYPT"}, Usage: "encrypt message end-to-end with a shared password (see --key)"},
+	&cli.StringFlag{Name: "key", EnvVars: []string{"NTFY_ENCRYPTION_KEY"}, Usage: "password used to encrypt the message; implies --encrypt"},
+	&cli.BoolFlag{Name: "sign", EnvVars: []string{"NTFY_SIGN"}, Usage: "print a pre-signed publish URL instead of publishing"},
+	&cli.StringFlag{Name: "sign-expires", Aliases: []string{"sign_expires"}, EnvVars: []string{"NTFY_SIGN_EXPIRES"}, Value: "24h", Usage: "expiry of the pre-signed publish URL (e.g. 30m, 7d, tomorrow)"},
+	&cli.StringFlag{Name: "sign-fields", Aliases: []string{"sign_fields"}, EnvVars: []string{"NTFY_SIGN_FIELDS"}, Usage: "comma separated list of fields the pre-signed publish URL may set (e.g. title,priority)"},
+	&cli.IntFlag{Name: "sign-uses", Aliases: []string{"sign_uses"}, EnvVars: []string{"NTFY_SIGN_USES"}, Usage: "maximum number of uses of the pre-signed publish URL (0 = unlimited)"},
 )
 
 var cmdPublish = &cli.Command{
@@ -64,6 +71,7 @@ Examples:
   ntfy pub --tags=warning,skull backups "Backups failed"  # Add tags/emojis to message
   ntfy pub --delay=10s delayed_topic Laterzz              # Delay message by 10s
   ntfy pub --at=8:30am delayed_topic Laterzz              # Send message at 8:30am
+  ntfy pub --expires=5m mytopic 'Door bell'               # Message is irrelevant after 5 minutes
   ntfy pub -e phil@example.com alerts 'App is down!'      # Also send email to phil@example.com
   ntfy pub --click="https://reddit.com" redd 'New msg'    # Opens Reddit when notification is clicked
   ntfy pub --icon="http://some.tld/icon.png" 'Icon!'      # Send notification with custom icon
@@ -77,6 +85,8 @@ Examples:
   NTFY_TOPIC=mytopic ntfy pub "some message"              # Use NTFY_TOPIC variable as topic 
   cat flower.jpg | ntfy pub --file=- flowers 'Nice!'      # Same as above, send image.jpg as attachment
   ntfy trigger mywebhook                                  # Sending without message, useful for webhooks
+  ntfy pub -u phil --sign --sign-uses=10 sensors          # Print a publish URL for devices without credentials
+  ntfy pub --key=mypass secrets 'Server is down'          # Encrypt message end-to-end with a shared password
  
 Please also check out the docs on publishing messages. Especially for the --tags and --delay options, 
 it has incredibly useful information: https://ntfy.sh/docs/publish/.
@@ -93,6 +103,7 @@ func execPublish(c *cli.Context) error {
 	priority := c.String("priority")
 	tags := c.String("tags")
 	delay := c.String("delay")
+	expires := c.String("expires")
 	click := c.String("click")
 	icon := c.String("icon")
 	actions := c.String("actions")
@@ -107,10 +118,15 @@ func execPublish(c *cli.Context) error {
 	noFirebase := c.Bool("no-firebase")
 	quiet := c.Bool("quiet")
 	pid := c.Int("wait-pid")
+	sign := c.Bool("sign")
+	encrypt := c.Bool("encrypt")
+	key := c.String("key")
 
 	// Checks
 	if user != "" && token != "" {
 		return errors.New("cannot set both --user and --token")
+	} else if (encrypt || key != "") && (file != "" || attach != "" || filename != "") {
+		return errors.New("cannot encrypt messages with attachments")
 	}
 
 	// Do the things
@@ -131,6 +147,9 @@ func execPublish(c *cli.Context) error {
 	if delay != "" {
 		options = append(options, client.WithDelay(delay))
 	}
+	if expires != "" {
+		options = append(options, client.WithExpires(expires))
+	}
 	if click != "" {
 		options = append(options, client.WithClick(click))
 	}
@@ -158,8 +177,18 @@ func execPublish(c *cli.Context) error {
 	if noFirebase {
 		options = append(options, client.WithNoFirebase())
 	}
+	if encrypt || key != "" {
+		if key == "" {
+			key = encryptionKey(conf, topic)
+		}
+		if key == "" {
+			return errors.New("--encrypt requires --key, or an encryption key in the client config")
+		}
+		options = append(options, client.WithEncryption(key))
+	}
+	var authOptions []client.RequestOption
 	if token != "" {
-		options = append(options, client.WithBearerAuth(token))
+		authOptions = append(authOptions, client.WithBearerAuth(token))
 	} else if user != "" {
 		var pass string
 		parts := strings.SplitN(user, ":", 2)
@@ -175,12 +204,16 @@ func execPublish(c *cli.Context) error {
 			pass = string(p)
 			fmt.Fprintf(c.App.ErrWriter, "\r%s\r", strings.Repeat(" ", 20))
 		}
-		options = append(options, client.WithBasicAuth(user, pass))
+		authOptions = append(authOptions, client.WithBasicAuth(user, pass))
 	} else if conf.DefaultToken != "" {
-		options = append(options, client.WithBearerAuth(conf.DefaultToken))
+		authOptions = append(authOptions, client.WithBearerAuth(conf.DefaultToken))
 	} else if conf.DefaultUser != "" && conf.DefaultPassword != nil {
-		options = append(options, client.WithBasicAuth(conf.DefaultUser, *conf.DefaultPassword))
+		authOptions = append(authOptions, client.WithBasicAuth(conf.DefaultUser, *conf.DefaultPassword))
 	}
+	if sign {
+		return execPublishSign(c, conf, topic, authOptions)
+	}
+	options = append(options, authOptions...)
 	if pid > 0 {
 		newMessage, err := waitForProcess(pid)
 		if err != nil {
@@ -229,6 +262,21 @@ func execPublish(c *cli.Context) error {
 	return nil
 }
 
+func execPublishSign(c *cli.Context, conf *client.Config, topic string, authOptions []client.RequestOption) error {
+	expires, err := util.ParseFutureTime(c.String("sign-expires"), time.Now())
+	if err != nil {
+		return fmt.Errorf("invalid --sign-expires value: %s", err.Error())
+	}
+	fields := util.Map(util.SplitNoEmpty(c.String("sign-fields"), ","), strings.TrimSpace)
+	cl := client.New(conf)
+	signedURL, err := cl.SignPublishURL(topic, expires, fields, c.Int("sign-uses"), authOptions...)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.App.Writer, signedURL)
+	return nil
+}
+
 // parseTopicMessageCommand reads the topic and the remaining arguments from the context.
 
 // There are a few cases to consider:
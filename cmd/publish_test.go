@@ -50,6 +50,31 @@ func TestCLI_Publish_Subscribe_Poll(t *testing.T) {
 	require.Equal(t, "some message", m.Message)
 }
 
+func TestCLI_Publish_Subscribe_Poll_Encrypted(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	topic := fmt.Sprintf("http://127.0.0.1:%d/mytopic", port)
+
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "publish", "--key=mypass", "--title=secret title", topic, "secret message"}))
+	m := toMessage(t, stdout.String())
+	require.Equal(t, "jwe", m.Encoding)
+	require.NotContains(t, stdout.String(), "secret")
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "subscribe", "--poll", "--key=mypass", topic}))
+	m = toMessage(t, stdout.String())
+	require.Equal(t, "secret message", m.Message)
+	require.Equal(t, "secret title", m.Title)
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "subscribe", "--poll", "--key=wrong", topic}))
+	require.Empty(t, strings.TrimSpace(stdout.String()))
+
+	app, _, _, _ = newTestApp()
+	require.Error(t, app.Run([]string{"ntfy", "publish", "--encrypt", topic, "secret message"}))
+}
+
 func TestCLI_Publish_All_The_Things(t *testing.T) {
 	s, port := test.StartServer(t)
 	defer test.StopServer(t, s, port)
@@ -12,6 +12,7 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"regexp"
 	"strings"
 	"syscall"
 	"time"
@@ -33,6 +34,10 @@ const (
 	defaultServerConfigFile = "/etc/ntfy/server.yml"
 )
 
+var (
+	federationNameRegex = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`)
+)
+
 var flagsServe = append(
 	append([]cli.Flag{}, flagsDefault...),
 	&cli.StringFlag{Name: "config", Aliases: []string{"c"}, EnvVars: []string{"NTFY_CONFIG_FILE"}, Value: defaultServerConfigFile, Usage: "config file"},
@@ -56,13 +61,24 @@ var flagsServe = append(
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "attachment-total-size-limit", Aliases: []string{"attachment_total_size_limit", "A"}, EnvVars: []string{"NTFY_ATTACHMENT_TOTAL_SIZE_LIMIT"}, Value: util.FormatSize(server.DefaultAttachmentTotalSizeLimit), Usage: "limit of the on-disk attachment cache"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "attachment-file-size-limit", Aliases: []string{"attachment_file_size_limit", "Y"}, EnvVars: []string{"NTFY_ATTACHMENT_FILE_SIZE_LIMIT"}, Value: util.FormatSize(server.DefaultAttachmentFileSizeLimit), Usage: "per-file attachment size limit (e.g. 300k, 2M, 100M)"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "attachment-expiry-duration", Aliases: []string{"attachment_expiry_duration", "X"}, EnvVars: []string{"NTFY_ATTACHMENT_EXPIRY_DURATION"}, Value: util.FormatDuration(server.DefaultAttachmentExpiryDuration), Usage: "duration after which uploaded attachments will be deleted (e.g. 3h, 20h)"}),
+	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "attachment-public-download", Aliases: []string{"attachment_public_download"}, EnvVars: []string{"NTFY_ATTACHMENT_PUBLIC_DOWNLOAD"}, Value: false, Usage: "allows anyone who knows the attachment URL to download it, even if the topic is read-protected"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "attachment-signing-key", Aliases: []string{"attachment_signing_key"}, EnvVars: []string{"NTFY_ATTACHMENT_SIGNING_KEY"}, Usage: "secret used to sign attachment download URLs; generated and stored in the attachment cache dir if not set"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "keepalive-interval", Aliases: []string{"keepalive_interval", "k"}, EnvVars: []string{"NTFY_KEEPALIVE_INTERVAL"}, Value: util.FormatDuration(server.DefaultKeepaliveInterval), Usage: "interval of keepalive messages"}),
+	altsrc.NewIntFlag(&cli.IntFlag{Name: "subscriber-queue-size", Aliases: []string{"subscriber_queue_size"}, EnvVars: []string{"NTFY_SUBSCRIBER_QUEUE_SIZE"}, Value: server.DefaultSubscriberQueueSize, Usage: "number of messages buffered per subscriber before the queue policy applies"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "subscriber-queue-policy", Aliases: []string{"subscriber_queue_policy"}, EnvVars: []string{"NTFY_SUBSCRIBER_QUEUE_POLICY"}, Value: server.DefaultSubscriberQueuePolicy, Usage: "what to do if a subscriber's queue is full: 'drop' messages, or 'disconnect' the subscriber"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "subscriber-write-timeout", Aliases: []string{"subscriber_write_timeout"}, EnvVars: []string{"NTFY_SUBSCRIBER_WRITE_TIMEOUT"}, Value: util.FormatDuration(server.DefaultSubscriberWriteTimeout), Usage: "max time a write to a subscriber (JSON/SSE/raw stream, WebSocket) may take"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "manager-interval", Aliases: []string{"manager_interval", "m"}, EnvVars: []string{"NTFY_MANAGER_INTERVAL"}, Value: util.FormatDuration(server.DefaultManagerInterval), Usage: "interval of for message pruning and stats printing"}),
 	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "disallowed-topics", Aliases: []string{"disallowed_topics"}, EnvVars: []string{"NTFY_DISALLOWED_TOPICS"}, Usage: "topics that are not allowed to be used"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "web-root", Aliases: []string{"web_root"}, EnvVars: []string{"NTFY_WEB_ROOT"}, Value: "/", Usage: "sets root of the web app (e.g. /, or /app), or disables it (disable)"}),
 	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "enable-signup", Aliases: []string{"enable_signup"}, EnvVars: []string{"NTFY_ENABLE_SIGNUP"}, Value: false, Usage: "allows users to sign up via the web app, or API"}),
 	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "enable-login", Aliases: []string{"enable_login"}, EnvVars: []string{"NTFY_ENABLE_LOGIN"}, Value: false, Usage: "allows users to log in via the web app, or API"}),
 	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "enable-reservations", Aliases: []string{"enable_reservations"}, EnvVars: []string{"NTFY_ENABLE_RESERVATIONS"}, Value: false, Usage: "allows users to reserve topics (if their tier allows it)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "federation-name", Aliases: []string{"federation_name"}, EnvVars: []string{"NTFY_FEDERATION_NAME"}, Usage: "name this server identifies itself with towards federation peers"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "federation-peers", Aliases: []string{"federation_peers"}, EnvVars: []string{"NTFY_FEDERATION_PEERS"}, Usage: "trusted servers to exchange messages with (format: <name> <base-url> <secret> <topic-pattern> [<topic-pattern>..])"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "relay-subscriptions", Aliases: []string{"relay_subscriptions"}, EnvVars: []string{"NTFY_RELAY_SUBSCRIPTIONS"}, Usage: "remote topics to mirror to local topics (format: <remote-topic-url> <local-topic> [<username>:<password>|<token>])"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "publish-signing-key", Aliases: []string{"publish_signing_key"}, EnvVars: []string{"NTFY_PUBLISH_SIGNING_KEY"}, Usage: "secret used to sign pre-signed publish URLs; enables the signed URL API if set"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "routing-rules", Aliases: []string{"routing_rules"}, EnvVars: []string{"NTFY_ROUTING_RULES"}, Usage: "rules that change how messages are delivered (format: <conditions> -> <actions>, e.g. \"topic=prod-* priority=5 -> call=+12223334444\")"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "message-signing-key", Aliases: []string{"message_signing_key"}, EnvVars: []string{"NTFY_MESSAGE_SIGNING_KEY"}, Usage: "base64-encoded Ed25519 private key used to sign messages; enables message signatures if set"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "upstream-base-url", Aliases: []string{"upstream_base_url"}, EnvVars: []string{"NTFY_UPSTREAM_BASE_URL"}, Value: "", Usage: "forward poll request to an upstream server, this is needed for iOS push notifications for self-hosted servers"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "upstream-access-token", Aliases: []string{"upstream_access_token"}, EnvVars: []string{"NTFY_UPSTREAM_ACCESS_TOKEN"}, Value: "", Usage: "access token to use for the upstream server; needed only if upstream rate limits are exceeded or upstream server requires auth"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "smtp-sender-addr", Aliases: []string{"smtp_sender_addr"}, EnvVars: []string{"NTFY_SMTP_SENDER_ADDR"}, Usage: "SMTP server address (host:port) for outgoing emails"}),
@@ -72,6 +88,10 @@ var flagsServe = append(
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "smtp-server-listen", Aliases: []string{"smtp_server_listen"}, EnvVars: []string{"NTFY_SMTP_SERVER_LISTEN"}, Usage: "SMTP server address (ip:port) for incoming emails, e.g. :25"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "smtp-server-domain", Aliases: []string{"smtp_server_domain"}, EnvVars: []string{"NTFY_SMTP_SERVER_DOMAIN"}, Usage: "SMTP domain for incoming e-mail, e.g. ntfy.sh"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "smtp-server-addr-prefix", Aliases: []string{"smtp_server_addr_prefix"}, EnvVars: []string{"NTFY_SMTP_SERVER_ADDR_PREFIX"}, Usage: "SMTP email address prefix for topics to prevent spam (e.g. 'ntfy-')"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "mqtt-listen", Aliases: []string{"mqtt_listen"}, EnvVars: []string{"NTFY_MQTT_LISTEN"}, Usage: "MQTT bridge address (ip:port) for MQTT clients, e.g. :1883"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "syslog-listen", Aliases: []string{"syslog_listen"}, EnvVars: []string{"NTFY_SYSLOG_LISTEN"}, Usage: "syslog receiver address (ip:port) for incoming syslog messages via UDP and TCP, e.g. :514"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "syslog-rules", Aliases: []string{"syslog_rules"}, EnvVars: []string{"NTFY_SYSLOG_RULES"}, Usage: "rules routing syslog messages to topics (format: [conditions] -> <topic>[+<token>], e.g. \"severity=err -> alerts\")"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "syslog-allowed-sources", Aliases: []string{"syslog_allowed_sources"}, EnvVars: []string{"NTFY_SYSLOG_ALLOWED_SOURCES"}, Value: strings.Join(server.DefaultSyslogAllowedSources, ","), Usage: "comma-separated list of IP addresses, hosts, or CIDRs that syslog messages are accepted from"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "twilio-account", Aliases: []string{"twilio_account"}, EnvVars: []string{"NTFY_TWILIO_ACCOUNT"}, Usage: "Twilio account SID, used for phone calls, e.g. AC123..."}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "twilio-auth-token", Aliases: []string{"twilio_auth_token"}, EnvVars: []string{"NTFY_TWILIO_AUTH_TOKEN"}, Usage: "Twilio auth token"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "twilio-phone-number", Aliases: []string{"twilio_phone_number"}, EnvVars: []string{"NTFY_TWILIO_PHONE_NUMBER"}, Usage: "Twilio number to use for outgoing calls"}),
@@ -107,6 +127,8 @@ var flagsServe = append(
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "web-push-startup-queries", Aliases: []string{"web_push_startup_queries"}, EnvVars: []string{"NTFY_WEB_PUSH_STARTUP_QUERIES"}, Usage: "queries run when the web push database is initialized"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "web-push-expiry-duration", Aliases: []string{"web_push_expiry_duration"}, EnvVars: []string{"NTFY_WEB_PUSH_EXPIRY_DURATION"}, Value: util.FormatDuration(server.DefaultWebPushExpiryDuration), Usage: "automatically expire unused subscriptions after this time"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "web-push-expiry-warning-duration", Aliases: []string{"web_push_expiry_warning_duration"}, EnvVars: []string{"NTFY_WEB_PUSH_EXPIRY_WARNING_DURATION"}, Value: util.FormatDuration(server.DefaultWebPushExpiryWarningDuration), Usage: "send web push warning notification after this time before expiring unused subscriptions"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "gotify-apps", Aliases: []string{"gotify_apps"}, EnvVars: []string{"NTFY_GOTIFY_APPS"}, Usage: "enable Gotify compatibility, mapping Gotify tokens to topics (format: <gotify-token>:<topic>[:<access-token>])"}),
+	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "enable-pushover", Aliases: []string{"enable_pushover"}, EnvVars: []string{"NTFY_ENABLE_PUSHOVER"}, Value: false, Usage: "enable Pushover-compatible publishing via /1/messages.json"}),
 )
 
 var cmdServe = &cli.Command{
@@ -149,6 +171,8 @@ func execServe(c *cli.Context) error {
 	webPushStartupQueries := c.String("web-push-startup-queries")
 	webPushExpiryDurationStr := c.String("web-push-expiry-duration")
 	webPushExpiryWarningDurationStr := c.String("web-push-expiry-warning-duration")
+	gotifyAppsRaw := c.StringSlice("gotify-apps")
+	enablePushover := c.Bool("enable-pushover")
 	cacheFile := c.String("cache-file")
 	cacheDurationStr := c.String("cache-duration")
 	cacheStartupQueries := c.String("cache-startup-queries")
@@ -161,7 +185,12 @@ func execServe(c *cli.Context) error {
 	attachmentTotalSizeLimitStr := c.String("attachment-total-size-limit")
 	attachmentFileSizeLimitStr := c.String("attachment-file-size-limit")
 	attachmentExpiryDurationStr := c.String("attachment-expiry-duration")
+	attachmentPublicDownload := c.Bool("attachment-public-download")
+	attachmentSigningKey := c.String("attachment-signing-key")
 	keepaliveIntervalStr := c.String("keepalive-interval")
+	subscriberQueueSize := c.Int("subscriber-queue-size")
+	subscriberQueuePolicy := c.String("subscriber-queue-policy")
+	subscriberWriteTimeoutStr := c.String("subscriber-write-timeout")
 	managerIntervalStr := c.String("manager-interval")
 	disallowedTopics := c.StringSlice("disallowed-topics")
 	webRoot := c.String("web-root")
@@ -169,12 +198,22 @@ func execServe(c *cli.Context) error {
 	enableLogin := c.Bool("enable-login")
 	enableReservations := c.Bool("enable-reservations")
 	upstreamBaseURL := c.String("upstream-base-url")
+	federationName := c.String("federation-name")
+	federationPeersRaw := c.StringSlice("federation-peers")
+	relaySubscriptionsRaw := c.StringSlice("relay-subscriptions")
+	publishSigningKey := c.String("publish-signing-key")
+	messageSigningKey := c.String("message-signing-key")
+	routingRulesRaw := c.StringSlice("routing-rules")
 	upstreamAccessToken := c.String("upstream-access-token")
 	smtpSenderAddr := c.String("smtp-sender-addr")
 	smtpSenderUser := c.String("smtp-sender-user")
 	smtpSenderPass := c.String("smtp-sender-pass")
 	smtpSenderFrom := c.String("smtp-sender-from")
 	smtpServerListen := c.String("smtp-server-listen")
+	mqttListen := c.String("mqtt-listen")
+	syslogListen := c.String("syslog-listen")
+	syslogRulesRaw := c.StringSlice("syslog-rules")
+	syslogAllowedSources := util.SplitNoEmpty(c.String("syslog-allowed-sources"), ",")
 	smtpServerDomain := c.String("smtp-server-domain")
 	smtpServerAddrPrefix := c.String("smtp-server-addr-prefix")
 	twilioAccount := c.String("twilio-account")
@@ -223,6 +262,10 @@ func execServe(c *cli.Context) error {
 	if err != nil {
 		return fmt.Errorf("invalid keepalive interval: %s", keepaliveIntervalStr)
 	}
+	subscriberWriteTimeout, err := util.ParseDuration(subscriberWriteTimeoutStr)
+	if err != nil {
+		return fmt.Errorf("invalid subscriber write timeout: %s", subscriberWriteTimeoutStr)
+	}
 	managerInterval, err := util.ParseDuration(managerIntervalStr)
 	if err != nil {
 		return fmt.Errorf("invalid manager interval: %s", managerIntervalStr)
@@ -279,6 +322,12 @@ func execServe(c *cli.Context) error {
 		return errors.New("if web push is enabled, web-push-private-key, web-push-public-key, web-push-file, web-push-email-address, and base-url should be set. run 'ntfy webpush keys' to generate keys")
 	} else if keepaliveInterval < 5*time.Second {
 		return errors.New("keepalive interval cannot be lower than five seconds")
+	} else if subscriberQueueSize < 1 {
+		return errors.New("subscriber-queue-size must be at least 1")
+	} else if subscriberQueuePolicy != server.SubscriberQueuePolicyDrop && subscriberQueuePolicy != server.SubscriberQueuePolicyDisconnect {
+		return errors.New("if set, subscriber-queue-policy must be 'drop' or 'disconnect'")
+	} else if subscriberWriteTimeout < time.Second {
+		return errors.New("subscriber write timeout cannot be lower than one second")
 	} else if managerInterval < 5*time.Second {
 		return errors.New("manager interval cannot be lower than five seconds")
 	} else if cacheDuration > 0 && cacheDuration < managerInterval {
@@ -293,6 +342,8 @@ func execServe(c *cli.Context) error {
 		return errors.New("if smtp-sender-addr is set, base-url, and smtp-sender-from must also be set")
 	} else if smtpServerListen != "" && smtpServerDomain == "" {
 		return errors.New("if smtp-server-listen is set, smtp-server-domain must also be set")
+	} else if syslogListen != "" && len(syslogRulesRaw) == 0 {
+		return errors.New("if syslog-listen is set, syslog-rules must also be set")
 	} else if attachmentCacheDir != "" && baseURL == "" {
 		return errors.New("if attachment-cache-dir is set, base-url must also be set")
 	} else if baseURL != "" {
@@ -304,6 +355,10 @@ func execServe(c *cli.Context) error {
 		} else if u.Path != "" {
 			return fmt.Errorf("if set, base-url must not have a path (%s), as hosting ntfy on a sub-path is not supported, e.g. https://ntfy.mydomain.com", u.Path)
 		}
+	} else if len(federationPeersRaw) > 0 && !federationNameRegex.MatchString(federationName) {
+		return errors.New("if federation-peers is set, federation-name must also be set, and may only contain letters, numbers, - and _")
+	} else if publishSigningKey != "" && (len(publishSigningKey) < 32 || baseURL == "" || authFile == "") {
+		return errors.New("if publish-signing-key is set, it must be at least 32 characters long, and base-url and auth-file must also be set")
 	} else if upstreamBaseURL != "" && !strings.HasPrefix(upstreamBaseURL, "http://") && !strings.HasPrefix(upstreamBaseURL, "https://") {
 		return errors.New("if set, upstream-base-url must start with http:// or https://")
 	} else if upstreamBaseURL != "" && strings.HasSuffix(upstreamBaseURL, "/") {
@@ -378,14 +433,81 @@ func execServe(c *cli.Context) error {
 		trustedProxyPrefixes = append(trustedProxyPrefixes, prefixes...)
 	}
 
+	// Parse syslog allowed sources
+	syslogAllowedPrefixes := make([]netip.Prefix, 0)
+	for _, host := range syslogAllowedSources {
+		prefixes, err := parseIPHostPrefix(host)
+		if err != nil {
+			return fmt.Errorf("cannot resolve syslog allowed source %s: %s", host, err.Error())
+		}
+		syslogAllowedPrefixes = append(syslogAllowedPrefixes, prefixes...)
+	}
+
 	// Stripe things
 	if stripeSecretKey != "" {
 		stripe.EnableTelemetry = false // Whoa!
 		stripe.Key = stripeSecretKey
 	}
 
+	// Parse Gotify apps
+	gotifyApps := make([]*server.GotifyApp, 0)
+	for _, gotifyAppRaw := range gotifyAppsRaw {
+		gotifyApp, err := parseGotifyApp(gotifyAppRaw)
+		if err != nil {
+			return err
+		} else if gotifyApp.AccessToken != "" && authFile == "" {
+			return errors.New("if gotify-apps contains access tokens, auth-file must also be set")
+		}
+		gotifyApps = append(gotifyApps, gotifyApp)
+	}
+
+	// Parse syslog rules
+	syslogRules := make([]*server.SyslogRule, 0)
+	for _, syslogRuleRaw := range syslogRulesRaw {
+		syslogRule, err := server.ParseSyslogRule(syslogRuleRaw)
+		if err != nil {
+			return err
+		}
+		syslogRules = append(syslogRules, syslogRule)
+	}
+
+	// Parse routing rules
+	routingRules := make([]*server.RoutingRule, 0)
+	for _, routingRuleRaw := range routingRulesRaw {
+		routingRule, err := server.ParseRoutingRule(routingRuleRaw)
+		if err != nil {
+			return err
+		}
+		routingRules = append(routingRules, routingRule)
+	}
+
+	// Parse federation peers
+	federationPeers := make([]*server.FederationPeer, 0)
+	for _, federationPeerRaw := range federationPeersRaw {
+		federationPeer, err := parseFederationPeer(federationPeerRaw)
+		if err != nil {
+			return err
+		} else if federationPeer.Name == federationName {
+			return fmt.Errorf("invalid federation-peers entry %s, peer name must be different from federation-name", federationPeer.Name)
+		}
+		federationPeers = append(federationPeers, federationPeer)
+	}
+
+	// Parse relay subscriptions
+	relaySubscriptions := make([]*server.RelaySubscription, 0)
+	for _, relaySubscriptionRaw := range relaySubscriptionsRaw {
+		relaySubscription, err := parseRelaySubscription(relaySubscriptionRaw)
+		if err != nil {
+			return err
+		}
+		relaySubscriptions = append(relaySubscriptions, relaySubscription)
+	}
+
 	// Add default forbidden topics
 	disallowedTopics = append(disallowedTopics, server.DefaultDisallowedTopics...)
+	if len(gotifyApps) > 0 {
+		disallowedTopics = append(disallowedTopics, server.GotifyDisallowedTopics...)
+	}
 
 	// Run server
 	conf := server.NewConfig()
@@ -410,17 +532,32 @@ func execServe(c *cli.Context) error {
 	conf.AttachmentTotalSizeLimit = attachmentTotalSizeLimit
 	conf.AttachmentFileSizeLimit = attachmentFileSizeLimit
 	conf.AttachmentExpiryDuration = attachmentExpiryDuration
+	conf.AttachmentPublicDownload = attachmentPublicDownload
+	conf.AttachmentSigningKey = attachmentSigningKey
 	conf.KeepaliveInterval = keepaliveInterval
+	conf.SubscriberQueueSize = subscriberQueueSize
+	conf.SubscriberQueuePolicy = subscriberQueuePolicy
+	conf.SubscriberWriteTimeout = subscriberWriteTimeout
 	conf.ManagerInterval = managerInterval
 	conf.DisallowedTopics = disallowedTopics
 	conf.WebRoot = webRoot
 	conf.UpstreamBaseURL = upstreamBaseURL
+	conf.FederationName = federationName
+	conf.FederationPeers = federationPeers
+	conf.RelaySubscriptions = relaySubscriptions
+	conf.PublishSigningKey = publishSigningKey
+	conf.MessageSigningKey = messageSigningKey
+	conf.RoutingRules = routingRules
 	conf.UpstreamAccessToken = upstreamAccessToken
 	conf.SMTPSenderAddr = smtpSenderAddr
 	conf.SMTPSenderUser = smtpSenderUser
 	conf.SMTPSenderPass = smtpSenderPass
 	conf.SMTPSenderFrom = smtpSenderFrom
 	conf.SMTPServerListen = smtpServerListen
+	conf.MQTTListen = mqttListen
+	conf.SyslogListen = syslogListen
+	conf.SyslogRules = syslogRules
+	conf.SyslogAllowedPrefixes = syslogAllowedPrefixes
 	conf.SMTPServerDomain = smtpServerDomain
 	conf.SMTPServerAddrPrefix = smtpServerAddrPrefix
 	conf.TwilioAccount = twilioAccount
@@ -461,6 +598,8 @@ func execServe(c *cli.Context) error {
 	conf.WebPushStartupQueries = webPushStartupQueries
 	conf.WebPushExpiryDuration = webPushExpiryDuration
 	conf.WebPushExpiryWarningDuration = webPushExpiryWarningDuration
+	conf.GotifyApps = gotifyApps
+	conf.EnablePushover = enablePushover
 	conf.Version = c.App.Version
 
 	// Set up hot-reloading of config
@@ -518,6 +657,80 @@ func parseIPHostPrefix(host string) (prefixes []netip.Prefix, err error) {
 	return
 }
 
+// parseGotifyApp parses a Gotify app definition in the format <gotify-token>:<topic>[:<access-token>]
+func parseGotifyApp(s string) (*server.GotifyApp, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+		return nil, fmt.Errorf("invalid gotify-apps entry %s, expected format <gotify-token>:<topic>[:<access-token>]", s)
+	} else if !user.AllowedTopic(parts[1]) {
+		return nil, fmt.Errorf("invalid gotify-apps entry %s, topic %s is not allowed", s, parts[1])
+	}
+	app := &server.GotifyApp{
+		Token: parts[0],
+		Topic: parts[1],
+	}
+	if len(parts) == 3 {
+		if !strings.HasPrefix(parts[2], "tk_") {
+			return nil, fmt.Errorf("invalid gotify-apps entry %s, access token must start with tk_", s)
+		}
+		app.AccessToken = parts[2]
+	}
+	return app, nil
+}
+
+// parseFederationPeer parses a federation peer definition in the format <name> <base-url> <secret> <topic-pattern> [<topic-pattern>..]
+func parseFederationPeer(s string) (*server.FederationPeer, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("invalid federation-peers entry, expected format <name> <base-url> <secret> <topic-pattern> [<topic-pattern>..]")
+	} else if !federationNameRegex.MatchString(fields[0]) {
+		return nil, fmt.Errorf("invalid federation-peers entry %s, name may only contain letters, numbers, - and _", fields[0])
+	} else if !strings.HasPrefix(fields[1], "http://") && !strings.HasPrefix(fields[1], "https://") {
+		return nil, fmt.Errorf("invalid federation-peers entry %s, base URL must start with http:// or https://", fields[0])
+	} else if len(fields[2]) < 16 {
+		return nil, fmt.Errorf("invalid federation-peers entry %s, secret must be at least 16 characters long", fields[0])
+	}
+	for _, pattern := range fields[3:] {
+		if !user.AllowedTopicPattern(pattern) {
+			return nil, fmt.Errorf("invalid federation-peers entry %s, topic pattern %s is not allowed", fields[0], pattern)
+		}
+	}
+	return &server.FederationPeer{
+		Name:    fields[0],
+		BaseURL: strings.TrimSuffix(fields[1], "/"),
+		Secret:  fields[2],
+		Topics:  fields[3:],
+	}, nil
+}
+
+// parseRelaySubscription parses a relay subscription in the format <remote-topic-url> <local-topic> [<username>:<password>|<token>]
+func parseRelaySubscription(s string) (*server.RelaySubscription, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 2 || len(fields) > 3 {
+		return nil, fmt.Errorf("invalid relay-subscriptions entry, expected format <remote-topic-url> <local-topic> [<username>:<password>|<token>]")
+	}
+	topicURL, err := url.Parse(fields[0])
+	if err != nil || (topicURL.Scheme != "http" && topicURL.Scheme != "https") || topicURL.Host == "" || !user.AllowedTopic(strings.TrimPrefix(topicURL.Path, "/")) {
+		return nil, fmt.Errorf("invalid relay-subscriptions entry %s, remote topic URL must be a valid topic URL, e.g. https://ntfy.sh/mytopic", fields[0])
+	} else if !user.AllowedTopic(fields[1]) {
+		return nil, fmt.Errorf("invalid relay-subscriptions entry %s, local topic %s is not allowed", fields[0], fields[1])
+	}
+	sub := &server.RelaySubscription{
+		TopicURL: fields[0],
+		Topic:    fields[1],
+	}
+	if len(fields) == 3 {
+		if strings.HasPrefix(fields[2], "tk_") {
+			sub.Token = fields[2]
+		} else if username, password, ok := strings.Cut(fields[2], ":"); ok && username != "" {
+			sub.Username, sub.Password = username, password
+		} else {
+			return nil, fmt.Errorf("invalid relay-subscriptions entry %s, credentials must be <username>:<password> or an access token", fields[0])
+		}
+	}
+	return sub, nil
+}
+
 func reloadLogLevel(inputSource altsrc.InputSourceContext) error {
 	newLevelStr, err := inputSource.String("log-level")
 	if err != nil {
@@ -84,6 +84,61 @@ func TestIP_Host_Parsing(t *testing.T) {
 	}
 }
 
+func TestGotifyApp_Parsing(t *testing.T) {
+	app, err := parseGotifyApp("AbCdEf123:mytopic")
+	require.Nil(t, err)
+	require.Equal(t, "AbCdEf123", app.Token)
+	require.Equal(t, "mytopic", app.Topic)
+	require.Equal(t, "", app.AccessToken)
+
+	app, err = parseGotifyApp("AbCdEf123:mytopic:tk_AgQdq7mVBoFD37zQVN29RhuMzNIz2")
+	require.Nil(t, err)
+	require.Equal(t, "tk_AgQdq7mVBoFD37zQVN29RhuMzNIz2", app.AccessToken)
+
+	for _, invalid := range []string{"", "AbCdEf123", ":mytopic", "AbCdEf123:my topic", "AbCdEf123:mytopic:notatoken", "a:b:c:d"} {
+		_, err := parseGotifyApp(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestFederationPeer_Parsing(t *testing.T) {
+	peer, err := parseFederationPeer("ntfy-b https://ntfy.example.com/ s3cr3t-s3cr3t-s3cr3t alerts team-*")
+	require.Nil(t, err)
+	require.Equal(t, "ntfy-b", peer.Name)
+	require.Equal(t, "https://ntfy.example.com", peer.BaseURL)
+	require.Equal(t, "s3cr3t-s3cr3t-s3cr3t", peer.Secret)
+	require.Equal(t, []string{"alerts", "team-*"}, peer.Topics)
+
+	for _, invalid := range []string{"", "ntfy-b https://ntfy.example.com s3cr3t-s3cr3t-s3cr3t", "ntfy/b https://ntfy.example.com s3cr3t-s3cr3t-s3cr3t alerts", "ntfy-b ntfy.example.com s3cr3t-s3cr3t-s3cr3t alerts", "ntfy-b https://ntfy.example.com short alerts", "ntfy-b https://ntfy.example.com s3cr3t-s3cr3t-s3cr3t alerts/x"} {
+		_, err := parseFederationPeer(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestRelaySubscription_Parsing(t *testing.T) {
+	sub, err := parseRelaySubscription("https://ntfy.sh/announcements announcements")
+	require.Nil(t, err)
+	require.Equal(t, "https://ntfy.sh/announcements", sub.TopicURL)
+	require.Equal(t, "announcements", sub.Topic)
+	require.Equal(t, "", sub.Username)
+	require.Equal(t, "", sub.Token)
+
+	sub, err = parseRelaySubscription("https://ntfy.example.com/alerts remote-alerts phil:my:pass")
+	require.Nil(t, err)
+	require.Equal(t, "remote-alerts", sub.Topic)
+	require.Equal(t, "phil", sub.Username)
+	require.Equal(t, "my:pass", sub.Password)
+
+	sub, err = parseRelaySubscription("https://ntfy.example.com/alerts alerts tk_AgQdq7mVBoFD37zQVN29RhuMzNIz2")
+	require.Nil(t, err)
+	require.Equal(t, "tk_AgQdq7mVBoFD37zQVN29RhuMzNIz2", sub.Token)
+
+	for _, invalid := range []string{"", "https://ntfy.sh/alerts", "ntfy.sh/alerts alerts", "https://ntfy.sh alerts", "https://ntfy.sh/a/b alerts", "https://ntfy.sh/alerts my/topic", "https://ntfy.sh/alerts alerts nocolon", "https://ntfy.sh/alerts alerts a:b c"} {
+		_, err := parseRelaySubscription(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
 func newEmptyFile(t *testing.T) string {
 	filename := filepath.Join(t.TempDir(), "empty")
 	require.Nil(t, os.WriteFile(filename, []byte{}, 0600))
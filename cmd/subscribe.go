@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"crypto/ed25519"
 	"errors"
 	"fmt"
 	"github.com/urfave/cli/v2"
@@ -34,6 +35,11 @@ var flagsSubscribe = append(
 	&cli.BoolFlag{Name: "from-config", Aliases: []string{"from_config", "C"}, Usage: "read subscriptions from config file (service mode)"},
 	&cli.BoolFlag{Name: "poll", Aliases: []string{"p"}, Usage: "return events and exit, do not listen for new events"},
 	&cli.BoolFlag{Name: "scheduled", Aliases: []string{"sched", "S"}, Usage: "also return scheduled/delayed events"},
+	&cli.IntFlag{Name: "limit", Aliases: []string{"l"}, Usage: "return at most `LIMIT` cached events (only with --poll)"},
+	&cli.StringFlag{Name: "before", Usage: "return cached events published before message `ID` (only with --poll)"},
+	&cli.StringFlag{Name: "after", Usage: "return cached events published after message `ID` (only with --poll)"},
+	&cli.StringFlag{Name: "key", EnvVars: []string{"NTFY_ENCRYPTION_KEY"}, Usage: "password used to decrypt end-to-end encrypted messages"},
+	&cli.StringFlag{Name: "verify-key", Aliases: []string{"verify_key"}, EnvVars: []string{"NTFY_VERIFY_KEY"}, Usage: "drop messages that are not signed with the server's public `KEY` (base64)"},
 )
 
 var cmdSubscribe = &cli.Command{
@@ -57,7 +63,10 @@ ntfy subscribe TOPIC
     ntfy subscribe mytopic            # Prints JSON for incoming messages for ntfy.sh/mytopic
     ntfy sub home.lan/backups         # Subscribe to topic on different server
     ntfy sub --poll home.lan/backups  # Just query for latest messages and exit
+    ntfy sub -p -l 20 mytopic         # Query only the first 20 cached messages and exit
     ntfy sub -u phil:mypass secret    # Subscribe with username/password
+    ntfy sub --verify-key=KEY secret  # Drop messages without a valid server signature
+    ntfy sub --key=mypass secrets     # Decrypt end-to-end encrypted messages
   
 ntfy subscribe TOPIC COMMAND
   This executes COMMAND for every incoming messages. The message fields are passed to the
@@ -101,6 +110,11 @@ func execSubscribe(c *cli.Context) error {
 	token := c.String("token")
 	poll := c.Bool("poll")
 	scheduled := c.Bool("scheduled")
+	limit := c.Int("limit")
+	before := c.String("before")
+	after := c.String("after")
+	key := c.String("key")
+	verifyKey := c.String("verify-key")
 	fromConfig := c.Bool("from-config")
 	topic := c.Args().Get(0)
 	command := c.Args().Get(1)
@@ -108,11 +122,28 @@ func execSubscribe(c *cli.Context) error {
 	// Checks
 	if user != "" && token != "" {
 		return errors.New("cannot set both --user and --token")
+	} else if !poll && (limit != 0 || before != "" || after != "") {
+		return errors.New("--limit, --before and --after can only be used with --poll")
+	} else if limit < 0 {
+		return errors.New("--limit must be a positive number")
 	}
 
+	if topic != "" && key == "" {
+		key = encryptionKey(conf, topic) // before wiping subscriptions, they may define the key
+	}
 	if !fromConfig {
 		conf.Subscribe = nil // wipe if --from-config not passed
 	}
+	if verifyKey == "" {
+		verifyKey = conf.VerifyKey
+	}
+	var publicKey ed25519.PublicKey
+	if verifyKey != "" {
+		publicKey, err = client.ParsePublicKey(verifyKey)
+		if err != nil {
+			return err
+		}
+	}
 	var options []client.SubscribeOption
 	if since != "" {
 		options = append(options, client.WithSince(since))
@@ -143,47 +174,60 @@ func execSubscribe(c *cli.Context) error {
 	if scheduled {
 		options = append(options, client.WithScheduled())
 	}
+	if limit > 0 {
+		options = append(options, client.WithLimit(limit))
+	}
+	if before != "" {
+		options = append(options, client.WithBefore(before))
+	}
+	if after != "" {
+		options = append(options, client.WithAfter(after))
+	}
 	if topic == "" && len(conf.Subscribe) == 0 {
 		return errors.New("must specify topic, type 'ntfy subscribe --help' for help")
 	}
 
 	// Execute poll or subscribe
 	if poll {
-		return doPoll(c, cl, conf, topic, command, options...)
+		return doPoll(c, cl, conf, topic, command, key, publicKey, options...)
 	}
-	return doSubscribe(c, cl, conf, topic, command, options...)
+	return doSubscribe(c, cl, conf, topic, command, key, publicKey, options...)
 }
 
-func doPoll(c *cli.Context, cl *client.Client, conf *client.Config, topic, command string, options ...client.SubscribeOption) error {
+func doPoll(c *cli.Context, cl *client.Client, conf *client.Config, topic, command, key string, publicKey ed25519.PublicKey, options ...client.SubscribeOption) error {
 	for _, s := range conf.Subscribe { // may be nil
 		if auth := maybeAddAuthHeader(s, conf); auth != nil {
 			options = append(options, auth)
 		}
-		if err := doPollSingle(c, cl, s.Topic, s.Command, options...); err != nil {
+		if err := doPollSingle(c, cl, s.Topic, s.Command, subscriptionEncryptionKey(s, conf), publicKey, options...); err != nil {
 			return err
 		}
 	}
 	if topic != "" {
-		if err := doPollSingle(c, cl, topic, command, options...); err != nil {
+		if err := doPollSingle(c, cl, topic, command, key, publicKey, options...); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func doPollSingle(c *cli.Context, cl *client.Client, topic, command string, options ...client.SubscribeOption) error {
+func doPollSingle(c *cli.Context, cl *client.Client, topic, command, key string, publicKey ed25519.PublicKey, options ...client.SubscribeOption) error {
 	messages, err := cl.Poll(topic, options...)
 	if err != nil {
 		return err
 	}
 	for _, m := range messages {
+		if !verifyMessage(m, publicKey) || !decryptMessage(m, key) {
+			continue
+		}
 		printMessageOrRunCommand(c, m, command)
 	}
 	return nil
 }
 
-func doSubscribe(c *cli.Context, cl *client.Client, conf *client.Config, topic, command string, options ...client.SubscribeOption) error {
+func doSubscribe(c *cli.Context, cl *client.Client, conf *client.Config, topic, command, key string, publicKey ed25519.PublicKey, options ...client.SubscribeOption) error {
 	cmds := make(map[string]string)    // Subscription ID -> command
+	keys := make(map[string]string)    // Subscription ID -> encryption key
 	for _, s := range conf.Subscribe { // May be nil
 		topicOptions := append(make([]client.SubscribeOption, 0), options...)
 		for filter, value := range s.If {
@@ -205,6 +249,7 @@ func doSubscribe(c *cli.Context, cl *client.Client, conf *client.Config, topic,
 		} else {
 			cmds[subscriptionID] = ""
 		}
+		keys[subscriptionID] = subscriptionEncryptionKey(s, conf)
 	}
 	if topic != "" {
 		subscriptionID, err := cl.Subscribe(topic, options...)
@@ -212,10 +257,11 @@ func doSubscribe(c *cli.Context, cl *client.Client, conf *client.Config, topic,
 			return err
 		}
 		cmds[subscriptionID] = command
+		keys[subscriptionID] = key
 	}
 	for m := range cl.Messages {
 		cmd, ok := cmds[m.SubscriptionID]
-		if !ok {
+		if !ok || !verifyMessage(m, publicKey) || !decryptMessage(m, keys[m.SubscriptionID]) {
 			continue
 		}
 		log.Debug("%s Dispatching received message: %s", logMessagePrefix(m), m.Raw)
@@ -248,6 +294,50 @@ func maybeAddAuthHeader(s client.Subscribe, conf *client.Config) client.Subscrib
 	return nil
 }
 
+// encryptionKey returns the password used to encrypt and decrypt messages for the given topic, either
+// from a matching subscription in the config file, or the default encryption key
+func encryptionKey(conf *client.Config, topic string) string {
+	for _, s := range conf.Subscribe {
+		if s.Topic == topic && s.EncryptionKey != "" {
+			return s.EncryptionKey
+		}
+	}
+	return conf.DefaultEncryptionKey
+}
+
+func subscriptionEncryptionKey(s client.Subscribe, conf *client.Config) string {
+	if s.EncryptionKey != "" {
+		return s.EncryptionKey
+	}
+	return conf.DefaultEncryptionKey
+}
+
+// decryptMessage decrypts an end-to-end encrypted message if a password is given, and logs
+// a warning if the message cannot be decrypted
+func decryptMessage(m *client.Message, key string) bool {
+	if key == "" || m.Encoding != client.EncodingJWE {
+		return true
+	}
+	if err := client.DecryptMessage(m, key); err != nil {
+		log.Warn("%s Dropping message: %s", logMessagePrefix(m), err.Error())
+		return false
+	}
+	return true
+}
+
+// verifyMessage checks the server signature of a message if a public key is given, and logs
+// a warning if the signature is missing or invalid
+func verifyMessage(m *client.Message, publicKey ed25519.PublicKey) bool {
+	if publicKey == nil {
+		return true
+	}
+	if err := client.VerifyMessage(m, publicKey); err != nil {
+		log.Warn("%s Dropping message: %s", logMessagePrefix(m), err.Error())
+		return false
+	}
+	return true
+}
+
 func printMessageOrRunCommand(c *cli.Context, m *client.Message, command string) {
 	if command != "" {
 		runCommand(c, command, m)
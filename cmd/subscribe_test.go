@@ -1,8 +1,11 @@
 package cmd
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
 	"fmt"
 	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/util"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -311,6 +314,31 @@ func TestCLI_Subscribe_Token_And_UserPass(t *testing.T) {
 	require.Equal(t, "cannot set both --user and --token", err.Error())
 }
 
+func TestCLI_Subscribe_Poll_Limit_Before_After(t *testing.T) {
+	message := `{"id":"RXIQBFaieLVr","time":124,"expires":1124,"event":"message","topic":"mytopic","message":"triggered"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/mytopic/json", r.URL.Path)
+		require.Equal(t, "10", r.URL.Query().Get("limit"))
+		require.Equal(t, "Cm02DsxUHb12", r.URL.Query().Get("before"))
+		require.Equal(t, "dzJJm7BCWs12", r.URL.Query().Get("after"))
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(message))
+	}))
+	defer server.Close()
+
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "subscribe", "--poll", "--limit", "10", "--before", "Cm02DsxUHb12", "--after", "dzJJm7BCWs12", server.URL + "/mytopic"}))
+	require.Equal(t, message, strings.TrimSpace(stdout.String()))
+}
+
+func TestCLI_Subscribe_Limit_Without_Poll(t *testing.T) {
+	app, _, _, _ := newTestApp()
+	err := app.Run([]string{"ntfy", "subscribe", "--limit", "10", "mytopic"})
+	require.Error(t, err)
+	require.Equal(t, "--limit, --before and --after can only be used with --poll", err.Error())
+}
+
 func TestCLI_Subscribe_Default_Token(t *testing.T) {
 	message := `{"id":"RXIQBFaieLVr","time":124,"expires":1124,"event":"message","topic":"mytopic","message":"triggered"}`
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -415,3 +443,27 @@ subscribe:
 
 	require.Equal(t, message, strings.TrimSpace(stdout.String()))
 }
+
+func TestCLI_Subscribe_VerifyKey(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	require.Nil(t, err)
+	unsigned := `{"id":"RXIQBFaieLVr","time":124,"expires":1124,"event":"message","topic":"mytopic","message":"triggered"}`
+	canonical, err := util.CanonicalJSON([]byte(unsigned), "expires")
+	require.Nil(t, err)
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, canonical))
+	signed := strings.TrimSuffix(unsigned, "}") + `,"signature":"` + signature + `"}`
+	tampered := strings.Replace(signed, "triggered", "tampered", 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(signed + "\n" + tampered + "\n" + unsigned + "\n"))
+	}))
+	defer server.Close()
+
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "subscribe", "--poll", "--verify-key=" + base64.StdEncoding.EncodeToString(publicKey), server.URL + "/mytopic"}))
+	require.Equal(t, signed, strings.TrimSpace(stdout.String()))
+
+	app, _, _, _ = newTestApp()
+	require.Error(t, app.Run([]string{"ntfy", "subscribe", "--poll", "--verify-key=invalid", server.URL + "/mytopic"}))
+}
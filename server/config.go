@@ -24,6 +24,22 @@ const (
 	DefaultStripePriceCacheDuration             = 3 * time.Hour    // Time to keep Stripe prices cached in memory before a refresh is needed
 )
 
+// Defines default subscriber settings
+// - queue size: number of messages buffered per subscriber connection, before the queue policy applies
+// - queue policy: whether to drop messages or to disconnect the subscriber if its queue is full
+// - write timeout: max time a single write to a JSON/SSE/raw stream or a WebSocket may take
+const (
+	DefaultSubscriberQueueSize    = 100
+	DefaultSubscriberQueuePolicy  = SubscriberQueuePolicyDisconnect
+	DefaultSubscriberWriteTimeout = 10 * time.Second
+)
+
+// Defines the possible subscriber queue policies, see Config.SubscriberQueuePolicy
+const (
+	SubscriberQueuePolicyDrop       = "drop"
+	SubscriberQueuePolicyDisconnect = "disconnect"
+)
+
 // Defines default Web Push settings
 const (
 	DefaultWebPushExpiryWarningDuration = 55 * 24 * time.Hour
@@ -72,6 +88,14 @@ var (
 	// DefaultDisallowedTopics defines the topics that are forbidden, because they are used elsewhere. This array can be
 	// extended using the server.yml config. If updated, also update in Android and web app.
 	DefaultDisallowedTopics = []string{"docs", "static", "file", "app", "metrics", "account", "settings", "signup", "login", "v1"}
+
+	// DefaultSyslogAllowedSources defines the networks that syslog messages are accepted from: loopback and private
+	// networks, since the source address of UDP packets can be spoofed, see Config.SyslogAllowedPrefixes
+	DefaultSyslogAllowedSources = []string{"127.0.0.0/8", "::1/128", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"}
+
+	// GotifyDisallowedTopics defines the topics that are additionally forbidden if Gotify compatibility is enabled,
+	// because they clash with the Gotify endpoints, see Config.GotifyApps
+	GotifyDisallowedTopics = []string{"message", "stream"}
 )
 
 // Config is the main config struct for the application. Use New to instantiate a default config struct.
@@ -99,7 +123,12 @@ type Config struct {
 	AttachmentTotalSizeLimit             int64
 	AttachmentFileSizeLimit              int64
 	AttachmentExpiryDuration             time.Duration
+	AttachmentPublicDownload             bool   // Serve attachments without checking read access to the topic (old behavior)
+	AttachmentSigningKey                 string // Secret used to sign attachment download URLs; generated and stored in AttachmentCacheDir if empty
 	KeepaliveInterval                    time.Duration
+	SubscriberQueueSize                  int
+	SubscriberQueuePolicy                string // Either SubscriberQueuePolicyDrop or SubscriberQueuePolicyDisconnect
+	SubscriberWriteTimeout               time.Duration
 	ManagerInterval                      time.Duration
 	DisallowedTopics                     []string
 	WebRoot                              string // empty to disable
@@ -166,7 +195,19 @@ type Config struct {
 	WebPushStartupQueries                string
 	WebPushExpiryDuration                time.Duration
 	WebPushExpiryWarningDuration         time.Duration
-	Version                              string // injected by App
+	GotifyApps                           []*GotifyApp         // Gotify tokens and the topics they map to; enables Gotify compatibility if non-empty
+	EnablePushover                       bool                 // Enables the Pushover-compatible /1/messages.json endpoint
+	MQTTListen                           string               // Address the MQTT bridge listens on, e.g. ":1883"; disabled if empty
+	SyslogListen                         string               // Address the syslog receiver listens on (UDP and TCP), e.g. ":514"; disabled if empty
+	SyslogRules                          []*SyslogRule        // Rules that route syslog messages to topics, first match wins
+	SyslogAllowedPrefixes                []netip.Prefix       // Networks that syslog messages are accepted from, see DefaultSyslogAllowedSources
+	FederationName                       string               // Name this server identifies itself with towards federation peers
+	FederationPeers                      []*FederationPeer    // Trusted servers that messages are exchanged with; federation is disabled if empty
+	RelaySubscriptions                   []*RelaySubscription // Remote topics that are mirrored to local topics
+	PublishSigningKey                    string               // Secret used to sign and verify pre-signed publish URLs; disabled if empty
+	MessageSigningKey                    string               // Base64-encoded Ed25519 private key used to sign messages; disabled if empty
+	RoutingRules                         []*RoutingRule       // Rules that change how matching messages are delivered, all matching rules are applied
+	Version                              string               // injected by App
 }
 
 // NewConfig instantiates a default new server config
@@ -195,7 +236,12 @@ func NewConfig() *Config {
 		AttachmentTotalSizeLimit:             DefaultAttachmentTotalSizeLimit,
 		AttachmentFileSizeLimit:              DefaultAttachmentFileSizeLimit,
 		AttachmentExpiryDuration:             DefaultAttachmentExpiryDuration,
+		AttachmentPublicDownload:             false,
+		AttachmentSigningKey:                 "",
 		KeepaliveInterval:                    DefaultKeepaliveInterval,
+		SubscriberQueueSize:                  DefaultSubscriberQueueSize,
+		SubscriberQueuePolicy:                DefaultSubscriberQueuePolicy,
+		SubscriberWriteTimeout:               DefaultSubscriberWriteTimeout,
 		ManagerInterval:                      DefaultManagerInterval,
 		DisallowedTopics:                     DefaultDisallowedTopics,
 		WebRoot:                              "/",
@@ -257,5 +303,25 @@ func NewConfig() *Config {
 		WebPushEmailAddress:                  "",
 		WebPushExpiryDuration:                DefaultWebPushExpiryDuration,
 		WebPushExpiryWarningDuration:         DefaultWebPushExpiryWarningDuration,
+		GotifyApps:                           make([]*GotifyApp, 0),
+		EnablePushover:                       false,
+		MQTTListen:                           "",
+		SyslogListen:                         "",
+		SyslogRules:                          make([]*SyslogRule, 0),
+		SyslogAllowedPrefixes:                defaultSyslogAllowedPrefixes(),
+		FederationName:                       "",
+		FederationPeers:                      make([]*FederationPeer, 0),
+		RelaySubscriptions:                   make([]*RelaySubscription, 0),
+		PublishSigningKey:                    "",
+		MessageSigningKey:                    "",
+		RoutingRules:                         make([]*RoutingRule, 0),
+	}
+}
+
+func defaultSyslogAllowedPrefixes() []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(DefaultSyslogAllowedSources))
+	for _, source := range DefaultSyslogAllowedSources {
+		prefixes = append(prefixes, netip.MustParsePrefix(source))
 	}
+	return prefixes
 }
@@ -123,17 +123,61 @@ var (
 	errHTTPBadRequestTemplateDisallowedFunctionCalls = &errHTTP{40044, http.StatusBadRequest, "invalid request: template contains disallowed function calls, e.g. template, call, or define", "https://ntfy.sh/docs/publish/#message-templating", nil}
 	errHTTPBadRequestTemplateExecuteFailed           = &errHTTP{40045, http.StatusBadRequest, "invalid request: template execution failed", "https://ntfy.sh/docs/publish/#message-templating", nil}
 	errHTTPBadRequestInvalidUsername                 = &errHTTP{40046, http.StatusBadRequest, "invalid request: invalid username", "", nil}
+	errHTTPBadRequestConsumerInvalid                 = &errHTTP{40047, http.StatusBadRequest, "invalid request: consumer name invalid", "https://ntfy.sh/docs/subscribe/api/#durable-consumers", nil}
+	errHTTPBadRequestConsumerNotFound                = &errHTTP{40048, http.StatusBadRequest, "invalid request: consumer does not exist", "https://ntfy.sh/docs/subscribe/api/#durable-consumers", nil}
+	errHTTPBadRequestConsumerMessageIDInvalid        = &errHTTP{40049, http.StatusBadRequest, "invalid request: message ID to acknowledge is invalid", "https://ntfy.sh/docs/subscribe/api/#durable-consumers", nil}
+	errHTTPBadRequestLimitInvalid                    = &errHTTP{40050, http.StatusBadRequest, "invalid request: limit must be a positive number", "https://ntfy.sh/docs/subscribe/api/#paginating-cached-messages", nil}
+	errHTTPBadRequestGotifyMessageInvalid            = &errHTTP{40052, http.StatusBadRequest, "invalid request: Gotify message must not be empty", "https://ntfy.sh/docs/config/#gotify-compatibility", nil}
+	errHTTPBadRequestPushoverUserInvalid             = &errHTTP{40053, http.StatusBadRequest, "invalid request: Pushover user must be a valid topic", "https://ntfy.sh/docs/config/#pushover-compatibility", nil}
+	errHTTPBadRequestPushoverMessageInvalid          = &errHTTP{40054, http.StatusBadRequest, "invalid request: Pushover message cannot be blank", "https://ntfy.sh/docs/config/#pushover-compatibility", nil}
+	errHTTPBadRequestPushoverEmergencyInvalid        = &errHTTP{40055, http.StatusBadRequest, "invalid request: retry (at least 30) and expire (at most 10800) must be supplied with emergency priority", "https://ntfy.sh/docs/config/#pushover-compatibility", nil}
+	errHTTPBadRequestPushoverAttachmentInvalid       = &errHTTP{40056, http.StatusBadRequest, "invalid request: Pushover attachment_base64 must be base64-encoded", "https://ntfy.sh/docs/config/#pushover-compatibility", nil}
+	errHTTPBadRequestFederationMessageInvalid        = &errHTTP{40057, http.StatusBadRequest, "invalid request: federated message is invalid", "https://ntfy.sh/docs/config/#federation", nil}
+	errHTTPBadRequestSignedURLRequestInvalid         = &errHTTP{40058, http.StatusBadRequest, "invalid request: topic, expiry, fields or uses invalid", "https://ntfy.sh/docs/publish/#pre-signed-publish-urls", nil}
+	errHTTPBadRequestWebhookInvalid                  = &errHTTP{40059, http.StatusBadRequest, "invalid request: webhook scheme, header or secret invalid", "https://ntfy.sh/docs/publish/#webhook-signatures", nil}
+	errHTTPBadRequestEncodingInvalid                 = &errHTTP{40060, http.StatusBadRequest, "invalid request: unsupported encoding, only 'jwe' can be used when publishing", "https://ntfy.sh/docs/publish/#end-to-end-encryption", nil}
+	errHTTPBadRequestEncryptedMessageInvalid         = &errHTTP{40061, http.StatusBadRequest, "invalid request: encrypted message must be a JWE in compact serialization, and cannot have attachments, templates, e-mails or phone calls", "https://ntfy.sh/docs/publish/#end-to-end-encryption", nil}
+	errHTTPBadRequestBatchInvalid                    = &errHTTP{40062, http.StatusBadRequest, "invalid request: batch must contain between 1 and 100 messages", "https://ntfy.sh/docs/publish/#batch-publishing", nil}
+	errHTTPBadRequestTopicLinkInvalid                = &errHTTP{40063, http.StatusBadRequest, "invalid request: source and target must be different topics, and min_priority must be between 0 and 5", "https://ntfy.sh/docs/publish/#topic-links", nil}
+	errHTTPBadRequestRoutingRuleInvalid              = &errHTTP{40064, http.StatusBadRequest, "invalid request: routing rule invalid", "https://ntfy.sh/docs/config/#routing-rules", nil}
+	errHTTPBadRequestTopicRetentionInvalid           = &errHTTP{40065, http.StatusBadRequest, "invalid request: messages_expiry_duration must be set, and must not exceed the message expiry duration of your tier", "https://ntfy.sh/docs/publish/#message-retention", nil}
+	errHTTPBadRequestExpiresInvalid                  = &errHTTP{40066, http.StatusBadRequest, "invalid request: expires must be a duration, timestamp or date after the delivery time", "https://ntfy.sh/docs/publish/#message-expiry", nil}
+	errHTTPBadRequestLabelsInvalid                   = &errHTTP{40067, http.StatusBadRequest, "invalid request: labels invalid", "https://ntfy.sh/docs/publish/#message-labels", nil}
+	errHTTPBadRequestAttachmentsTooMany              = &errHTTP{40068, http.StatusBadRequest, "invalid request: too many attachments", "https://ntfy.sh/docs/publish/#attach-multiple-files", nil}
+	errHTTPBadRequestAttachmentsMultipartInvalid     = &errHTTP{40069, http.StatusBadRequest, "invalid request: multipart body invalid, or combined with attachment URL", "https://ntfy.sh/docs/publish/#attach-multiple-files", nil}
+	errHTTPBadRequestUploadInvalid                   = &errHTTP{40070, http.StatusBadRequest, "invalid request: Upload-Length, Upload-Offset or Content-Type header missing or invalid", "https://ntfy.sh/docs/publish/#resumable-uploads", nil}
+	errHTTPBadRequestUploadParamsInvalid             = &errHTTP{40071, http.StatusBadRequest, "invalid request: resumable uploads cannot be combined with attachment URLs, templates, encrypted, UnifiedPush or poll request messages", "https://ntfy.sh/docs/publish/#resumable-uploads", nil}
+	errHTTPBadRequestCursorInvalid                   = &errHTTP{40051, http.StatusBadRequest, "invalid request: before and after parameters must be message IDs", "https://ntfy.sh/docs/subscribe/api/#paginating-cached-messages", nil}
 	errHTTPNotFound                                  = &errHTTP{40401, http.StatusNotFound, "page not found", "", nil}
+	errHTTPNotFoundUpload                            = &errHTTP{40402, http.StatusNotFound, "upload not found, it may have expired or been completed", "https://ntfy.sh/docs/publish/#resumable-uploads", nil}
 	errHTTPUnauthorized                              = &errHTTP{40101, http.StatusUnauthorized, "unauthorized", "https://ntfy.sh/docs/publish/#authentication", nil}
+	errHTTPUnauthorizedFederation                    = &errHTTP{40102, http.StatusUnauthorized, "unauthorized: unknown federation peer, or invalid signature", "https://ntfy.sh/docs/config/#federation", nil}
+	errHTTPUnauthorizedSignedURL                     = &errHTTP{40103, http.StatusUnauthorized, "unauthorized: signed URL is invalid or expired", "https://ntfy.sh/docs/publish/#pre-signed-publish-urls", nil}
+	errHTTPUnauthorizedWebhook                       = &errHTTP{40104, http.StatusUnauthorized, "unauthorized: invalid webhook signature", "https://ntfy.sh/docs/publish/#webhook-signatures", nil}
+	errHTTPUnauthorizedSignedDownloadURL             = &errHTTP{40105, http.StatusUnauthorized, "unauthorized: signed download URL is invalid or expired", "https://ntfy.sh/docs/config/#attachments", nil}
 	errHTTPForbidden                                 = &errHTTP{40301, http.StatusForbidden, "forbidden", "https://ntfy.sh/docs/publish/#authentication", nil}
+	errHTTPForbiddenFederationTopic                  = &errHTTP{40302, http.StatusForbidden, "forbidden: topic is not exchanged with this federation peer", "https://ntfy.sh/docs/config/#federation", nil}
+	errHTTPForbiddenSignedURLField                   = &errHTTP{40303, http.StatusForbidden, "forbidden: field is not allowed by signed URL", "https://ntfy.sh/docs/publish/#pre-signed-publish-urls", nil}
+	errHTTPForbiddenSignedURLUsed                    = &errHTTP{40304, http.StatusForbidden, "forbidden: signed URL has been used the maximum number of times", "https://ntfy.sh/docs/publish/#pre-signed-publish-urls", nil}
+	errHTTPForbiddenWebhookNotOwner                  = &errHTTP{40305, http.StatusForbidden, "forbidden: webhooks can only be attached to reserved topics owned by you", "https://ntfy.sh/docs/publish/#webhook-signatures", nil}
+	errHTTPForbiddenTopicLinkNotOwner                = &errHTTP{40306, http.StatusForbidden, "forbidden: links can only be added from reserved topics owned by you, and to topics you can write to", "https://ntfy.sh/docs/publish/#topic-links", nil}
+	errHTTPForbiddenTopicRetentionNotOwner           = &errHTTP{40307, http.StatusForbidden, "forbidden: retention can only be changed for reserved topics owned by you", "https://ntfy.sh/docs/publish/#message-retention", nil}
+	errHTTPForbiddenWebhookParam                     = &errHTTP{40308, http.StatusForbidden, "forbidden: webhooks can only set the message, title, tags and priority", "https://ntfy.sh/docs/publish/#webhook-signatures", nil}
 	errHTTPConflictUserExists                        = &errHTTP{40901, http.StatusConflict, "conflict: user already exists", "", nil}
 	errHTTPConflictTopicReserved                     = &errHTTP{40902, http.StatusConflict, "conflict: access control entry for topic or topic pattern already exists", "", nil}
 	errHTTPConflictSubscriptionExists                = &errHTTP{40903, http.StatusConflict, "conflict: topic subscription already exists", "", nil}
 	errHTTPConflictPhoneNumberExists                 = &errHTTP{40904, http.StatusConflict, "conflict: phone number already exists", "", nil}
+	errHTTPConflictTopicLinkLoop                     = &errHTTP{40905, http.StatusConflict, "conflict: topic link would create a loop", "https://ntfy.sh/docs/publish/#topic-links", nil}
+	errHTTPConflictUploadOffsetMismatch              = &errHTTP{40906, http.StatusConflict, "conflict: Upload-Offset does not match the number of bytes received", "https://ntfy.sh/docs/publish/#resumable-uploads", nil}
+	errHTTPConflictUploadInProgress                  = &errHTTP{40907, http.StatusConflict, "conflict: upload is already being written to by another request", "https://ntfy.sh/docs/publish/#resumable-uploads", nil}
+	errHTTPConflictWebhookReplayed                   = &errHTTP{40908, http.StatusConflict, "conflict: webhook has already been delivered", "https://ntfy.sh/docs/publish/#webhook-signatures", nil}
 	errHTTPGonePhoneVerificationExpired              = &errHTTP{41001, http.StatusGone, "phone number verification expired or does not exist", "", nil}
 	errHTTPEntityTooLargeAttachment                  = &errHTTP{41301, http.StatusRequestEntityTooLarge, "attachment too large, or bandwidth limit reached", "https://ntfy.sh/docs/publish/#limitations", nil}
 	errHTTPEntityTooLargeMatrixRequest               = &errHTTP{41302, http.StatusRequestEntityTooLarge, "Matrix request is larger than the max allowed length", "", nil}
 	errHTTPEntityTooLargeJSONBody                    = &errHTTP{41303, http.StatusRequestEntityTooLarge, "JSON body too large", "", nil}
+	errHTTPEntityTooLargeWebhook                     = &errHTTP{41304, http.StatusRequestEntityTooLarge, "signed webhook body too large", "https://ntfy.sh/docs/publish/#webhook-signatures", nil}
+	errHTTPEntityTooLargeEncryptedMessage            = &errHTTP{41305, http.StatusRequestEntityTooLarge, "encrypted message too large", "https://ntfy.sh/docs/publish/#end-to-end-encryption", nil}
+	errHTTPEntityTooLargePushoverRequest             = &errHTTP{41306, http.StatusRequestEntityTooLarge, "Pushover request too large, larger attachments must be uploaded as file", "https://ntfy.sh/docs/config/#pushover-compatibility", nil}
 	errHTTPTooManyRequestsLimitRequests              = &errHTTP{42901, http.StatusTooManyRequests, "limit reached: too many requests", "https://ntfy.sh/docs/publish/#limitations", nil}
 	errHTTPTooManyRequestsLimitEmails                = &errHTTP{42902, http.StatusTooManyRequests, "limit reached: too many emails", "https://ntfy.sh/docs/publish/#limitations", nil}
 	errHTTPTooManyRequestsLimitSubscriptions         = &errHTTP{42903, http.StatusTooManyRequests, "limit reached: too many active subscriptions", "https://ntfy.sh/docs/publish/#limitations", nil}
@@ -144,6 +188,8 @@ var (
 	errHTTPTooManyRequestsLimitMessages              = &errHTTP{42908, http.StatusTooManyRequests, "limit reached: daily message quota reached", "https://ntfy.sh/docs/publish/#limitations", nil}
 	errHTTPTooManyRequestsLimitAuthFailure           = &errHTTP{42909, http.StatusTooManyRequests, "limit reached: too many auth failures", "https://ntfy.sh/docs/publish/#limitations", nil} // FIXME document limit
 	errHTTPTooManyRequestsLimitCalls                 = &errHTTP{42910, http.StatusTooManyRequests, "limit reached: daily phone call quota reached", "https://ntfy.sh/docs/publish/#limitations", nil}
+	errHTTPTooManyRequestsLimitConsumers             = &errHTTP{42911, http.StatusTooManyRequests, "limit reached: too many durable consumers", "https://ntfy.sh/docs/subscribe/api/#durable-consumers", nil}
+	errHTTPTooManyRequestsLimitUploads               = &errHTTP{42912, http.StatusTooManyRequests, "limit reached: too many incomplete uploads", "https://ntfy.sh/docs/publish/#resumable-uploads", nil}
 	errHTTPInternalError                             = &errHTTP{50001, http.StatusInternalServerError, "internal server error", "", nil}
 	errHTTPInternalErrorInvalidPath                  = &errHTTP{50002, http.StatusInternalServerError, "internal server error: invalid path", "", nil}
 	errHTTPInternalErrorMissingBaseURL               = &errHTTP{50003, http.StatusInternalServerError, "internal server error: base-url must be be configured for this feature", "https://ntfy.sh/docs/config/", nil}
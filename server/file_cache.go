@@ -13,9 +13,10 @@ import (
 )
 
 var (
-	fileIDRegex      = regexp.MustCompile(fmt.Sprintf(`^[-_A-Za-z0-9]{%d}$`, messageIDLength))
+	fileIDRegex      = regexp.MustCompile(fmt.Sprintf(`^[-_A-Za-z0-9]{%d}(-\d{1,2}|-thumb)?$`, messageIDLength)) // Message ID, and attachment index if > 0, or thumbnail suffix
 	errInvalidFileID = errors.New("invalid file ID")
 	errFileExists    = errors.New("file exists")
+	errFileTooShort  = errors.New("file is shorter than offset")
 )
 
 type fileCache struct {
@@ -72,15 +73,60 @@ func (c *fileCache) Write(id string, in io.Reader, limiters ...util.Limiter) (in
 	return size, nil
 }
 
+// Append writes to the file with the given ID, starting at the given offset, and creates the file if it does not
+// exist. It is used for resumable uploads. Unlike Write, bytes that were written before an error occurred are kept,
+// so that the upload can be resumed from there. The number of bytes written is returned, even if there is an error.
+func (c *fileCache) Append(id string, offset int64, in io.Reader, limiters ...util.Limiter) (int64, error) {
+	if !fileIDRegex.MatchString(id) {
+		return 0, errInvalidFileID
+	}
+	log.Tag(tagFileCache).Field("message_id", id).Field("file_offset", offset).Debug("Appending to attachment")
+	f, err := os.OpenFile(filepath.Join(c.dir, id), os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, err
+	} else if stat.Size() < offset {
+		return 0, errFileTooShort
+	}
+	if err := f.Truncate(offset); err != nil { // Bytes beyond the offset were not acknowledged to the client
+		return 0, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	limiters = append(limiters, util.NewFixedLimiter(c.Remaining()))
+	limitWriter := util.NewLimitWriter(f, limiters...)
+	size, err := io.Copy(limitWriter, in)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	c.mu.Lock()
+	c.totalSizeCurrent += offset - stat.Size() + size
+	mset(metricAttachmentsTotalSize, c.totalSizeCurrent)
+	c.mu.Unlock()
+	return size, err
+}
+
+// Remove deletes the attachment files of the given messages, including all additional attachments
+// of messages with multiple attachments (see attachmentFileID)
 func (c *fileCache) Remove(ids ...string) error {
 	for _, id := range ids {
 		if !fileIDRegex.MatchString(id) {
 			return errInvalidFileID
 		}
 		log.Tag(tagFileCache).Field("message_id", id).Debug("Deleting attachment")
-		file := filepath.Join(c.dir, id)
-		if err := os.Remove(file); err != nil {
-			log.Tag(tagFileCache).Field("message_id", id).Err(err).Debug("Error deleting attachment")
+		files, err := filepath.Glob(filepath.Join(c.dir, id+"-*"))
+		if err != nil {
+			return err
+		}
+		for _, file := range append(files, filepath.Join(c.dir, id)) {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				log.Tag(tagFileCache).Field("message_id", id).Err(err).Debug("Error deleting attachment")
+			}
 		}
 	}
 	size, err := dirSize(c.dir)
@@ -117,6 +163,9 @@ func dirSize(dir string) (int64, error) {
 	}
 	var size int64
 	for _, e := range entries {
+		if e.Name() == fileSigningKeyFilename {
+			continue // Not an attachment, see loadFileSigningKey
+		}
 		info, err := e.Info()
 		if err != nil {
 			return 0, err
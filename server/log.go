@@ -29,7 +29,15 @@ const (
 	tagResetter     = "resetter"
 	tagWebsocket    = "websocket"
 	tagMatrix       = "matrix"
+	tagPushover     = "pushover"
+	tagMQTT         = "mqtt"
+	tagSyslog       = "syslog"
+	tagFederation   = "federation"
+	tagRelay        = "relay"
 	tagWebPush      = "webpush"
+	tagTopicLink    = "topic_link"
+	tagUpload       = "upload"
+	tagThumbnail    = "thumbnail"
 )
 
 var (
@@ -71,6 +79,23 @@ func logem(smtpConn *smtp.Conn) *log.Event {
 	return ev
 }
 
+// logmq creates a new log event with MQTT session fields, and visitor fields (if already connected)
+func logmq(s *mqttSession) *log.Event {
+	ev := log.Tag(tagMQTT).Field("mqtt_remote_addr", s.conn.RemoteAddr().String())
+	if s.v != nil {
+		ev = ev.With(s.v)
+	}
+	return ev
+}
+
+// logrs creates a new log event with relay subscription fields
+func logrs(sub *RelaySubscription) *log.Event {
+	return log.Tag(tagRelay).Fields(log.Context{
+		"relay_topic_url": sub.TopicURL,
+		"relay_topic":     sub.Topic,
+	})
+}
+
 func httpContext(r *http.Request) log.Context {
 	requestURI := r.RequestURI
 	if requestURI == "" {
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"net/netip"
+	"slices"
 	"strings"
 	"time"
 
@@ -18,6 +19,8 @@ var (
 	errUnexpectedMessageType = errors.New("unexpected message type")
 	errMessageNotFound       = errors.New("message not found")
 	errNoRows                = errors.New("no rows found")
+	errConsumerNotFound      = errors.New("consumer not found")
+	errUploadNotFound        = errors.New("upload not found")
 )
 
 // Messages cache
@@ -42,11 +45,17 @@ const (
 			attachment_size INT NOT NULL,
 			attachment_expires INT NOT NULL,
 			attachment_url TEXT NOT NULL,
+			attachment_thumbnail TEXT NOT NULL,
 			attachment_deleted INT NOT NULL,
+			extra_attachments TEXT NOT NULL,
+			extra_attachments_size INT NOT NULL,
 			sender TEXT NOT NULL,
 			user TEXT NOT NULL,
 			content_type TEXT NOT NULL,
 			encoding TEXT NOT NULL,
+			signature TEXT NOT NULL,
+			labels TEXT NOT NULL,
+			routing TEXT NOT NULL,
 			published INT NOT NULL
 		);
 		CREATE INDEX IF NOT EXISTS idx_mid ON messages (mid);
@@ -61,58 +70,100 @@ const (
 			value INT
 		);
 		INSERT INTO stats (key, value) VALUES ('messages', 0);
+		CREATE TABLE IF NOT EXISTS consumers (
+			user TEXT NOT NULL,
+			name TEXT NOT NULL,
+			topics TEXT NOT NULL,
+			mid TEXT NOT NULL,
+			updated INT NOT NULL,
+			PRIMARY KEY (user, name)
+		);
+		CREATE TABLE IF NOT EXISTS signed_urls (
+			id TEXT PRIMARY KEY,
+			uses INT NOT NULL,
+			expires INT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS uploads (
+			id TEXT PRIMARY KEY,
+			topic TEXT NOT NULL,
+			user TEXT NOT NULL,
+			sender TEXT NOT NULL,
+			length INT NOT NULL,
+			received INT NOT NULL,
+			headers TEXT NOT NULL,
+			query TEXT NOT NULL,
+			expires INT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id TEXT PRIMARY KEY,
+			expires INT NOT NULL
+		);
 		COMMIT;
 	`
 	insertMessageQuery = `
-		INSERT INTO messages (mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_deleted, sender, user, content_type, encoding, published)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`
-	deleteMessageQuery                = `DELETE FROM messages WHERE mid = ?`
-	updateMessagesForTopicExpiryQuery = `UPDATE messages SET expires = ? WHERE topic = ?`
-	selectRowIDFromMessageID          = `SELECT id FROM messages WHERE mid = ?` // Do not include topic, see #336 and TestServer_PollSinceID_MultipleTopics
-	selectMessagesByIDQuery           = `
-		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		INSERT INTO messages (mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_thumbnail, attachment_deleted, extra_attachments, extra_attachments_size, sender, user, content_type, encoding, signature, labels, routing, published)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	deleteMessageQuery                        = `DELETE FROM messages WHERE mid = ?`
+	updateMessagesForTopicExpiryQuery         = `UPDATE messages SET expires = ? WHERE topic = ?`
+	updateMessagesForTopicExpiryDurationQuery = `UPDATE messages SET expires = MIN(expires, time + ?) WHERE topic = ? AND expires > 0`
+	updateMessagesForTopicBeyondCountQuery    = `
+		UPDATE messages SET expires = ?
+		WHERE topic = ? AND published = 1 AND id NOT IN (
+			SELECT id FROM messages WHERE topic = ? AND published = 1 ORDER BY time DESC, id DESC LIMIT ?
+		)
+	`
+	selectRowIDFromMessageID = `SELECT id FROM messages WHERE mid = ?` // Do not include topic, see #336 and TestServer_PollSinceID_MultipleTopics
+	selectMessagesByIDQuery  = `
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_thumbnail, extra_attachments, sender, user, content_type, encoding, signature, labels
 		FROM messages 
 		WHERE mid = ?
 	`
 	selectMessagesSinceTimeQuery = `
-		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_thumbnail, extra_attachments, sender, user, content_type, encoding, signature, labels
 		FROM messages 
 		WHERE topic = ? AND time >= ? AND published = 1
 		ORDER BY time, id
 	`
 	selectMessagesSinceTimeIncludeScheduledQuery = `
-		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_thumbnail, extra_attachments, sender, user, content_type, encoding, signature, labels
 		FROM messages 
 		WHERE topic = ? AND time >= ?
 		ORDER BY time, id
 	`
 	selectMessagesSinceIDQuery = `
-		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_thumbnail, extra_attachments, sender, user, content_type, encoding, signature, labels
 		FROM messages 
 		WHERE topic = ? AND id > ? AND published = 1 
 		ORDER BY time, id
 	`
 	selectMessagesSinceIDIncludeScheduledQuery = `
-		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_thumbnail, extra_attachments, sender, user, content_type, encoding, signature, labels
 		FROM messages 
 		WHERE topic = ? AND (id > ? OR published = 0)
 		ORDER BY time, id
 	`
 	selectMessagesLatestQuery = `
-		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_thumbnail, extra_attachments, sender, user, content_type, encoding, signature, labels
 		FROM messages
 		WHERE topic = ? AND published = 1
 		ORDER BY time DESC, id DESC
 		LIMIT 1
   `
+	selectMessagesPageQuery = `
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_thumbnail, extra_attachments, sender, user, content_type, encoding, signature, labels
+		FROM messages
+		WHERE topic IN (%s) AND %s
+		ORDER BY id %s
+	`
 	selectMessagesDueQuery = `
-		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_thumbnail, extra_attachments, sender, user, content_type, encoding, signature, labels
 		FROM messages 
 		WHERE time <= ? AND published = 0
 		ORDER BY time, id
 	`
 	selectMessagesExpiredQuery      = `SELECT mid FROM messages WHERE expires <= ? AND published = 1`
+	selectMessageRoutingQuery       = `SELECT routing FROM messages WHERE mid = ?`
 	updateMessagePublishedQuery     = `UPDATE messages SET published = 1 WHERE mid = ?`
 	selectMessagesCountQuery        = `SELECT COUNT(*) FROM messages`
 	selectMessageCountPerTopicQuery = `SELECT topic, COUNT(*) FROM messages GROUP BY topic`
@@ -120,16 +171,75 @@ const (
 
 	updateAttachmentDeleted            = `UPDATE messages SET attachment_deleted = 1 WHERE mid = ?`
 	selectAttachmentsExpiredQuery      = `SELECT mid FROM messages WHERE attachment_expires > 0 AND attachment_expires <= ? AND attachment_deleted = 0`
-	selectAttachmentsSizeBySenderQuery = `SELECT IFNULL(SUM(attachment_size), 0) FROM messages WHERE user = '' AND sender = ? AND attachment_expires >= ?`
-	selectAttachmentsSizeByUserIDQuery = `SELECT IFNULL(SUM(attachment_size), 0) FROM messages WHERE user = ? AND attachment_expires >= ?`
+	selectAttachmentsSizeBySenderQuery = `
+		SELECT
+			(SELECT IFNULL(SUM(attachment_size + extra_attachments_size), 0) FROM messages WHERE user = '' AND sender = ? AND attachment_expires >= ?) +
+			(SELECT IFNULL(SUM(length), 0) FROM uploads WHERE user = '' AND sender = ?)
+	`
+	selectAttachmentsSizeByUserIDQuery = `
+		SELECT
+			(SELECT IFNULL(SUM(attachment_size + extra_attachments_size), 0) FROM messages WHERE user = ? AND attachment_expires >= ?) +
+			(SELECT IFNULL(SUM(length), 0) FROM uploads WHERE user = ?)
+	`
 
 	selectStatsQuery = `SELECT value FROM stats WHERE key = 'messages'`
 	updateStatsQuery = `UPDATE stats SET value = ? WHERE key = 'messages'`
 )
 
+// Durable consumers
+const (
+	upsertConsumerQuery = `
+		INSERT INTO consumers (user, name, topics, mid, updated)
+		VALUES (?, ?, ?, '', ?)
+		ON CONFLICT (user, name) DO UPDATE SET topics = excluded.topics, updated = excluded.updated
+	`
+	updateConsumerMessageIDQuery    = `UPDATE consumers SET mid = ?, updated = ? WHERE user = ? AND name = ?`
+	selectConsumerQuery             = `SELECT user, name, topics, mid, updated FROM consumers WHERE user = ? AND name = ?`
+	selectConsumersQuery            = `SELECT user, name, topics, mid, updated FROM consumers ORDER BY user, name`
+	selectConsumersCountQuery       = `SELECT COUNT(*) FROM consumers WHERE user = ?`
+	deleteConsumersQuery            = `DELETE FROM consumers WHERE user = ?`
+	deleteStaleConsumersQuery       = `DELETE FROM consumers WHERE updated < ?`
+	selectConsumerPendingCountQuery = `
+		SELECT COUNT(*)
+		FROM messages
+		WHERE topic = ? AND published = 1 AND id > IFNULL((SELECT id FROM messages WHERE mid = ?), 0)
+	`
+)
+
+// Signed publish URLs
+const (
+	upsertSignedURLUsesQuery = `
+		INSERT INTO signed_urls (id, uses, expires)
+		VALUES (?, 1, ?)
+		ON CONFLICT (id) DO UPDATE SET uses = uses + 1
+		RETURNING uses
+	`
+	deleteExpiredSignedURLsQuery = `DELETE FROM signed_urls WHERE expires < ?`
+)
+
+// Webhook deliveries
+const (
+	insertWebhookDeliveryQuery          = `INSERT OR IGNORE INTO webhook_deliveries (id, expires) VALUES (?, ?)`
+	deleteExpiredWebhookDeliveriesQuery = `DELETE FROM webhook_deliveries WHERE expires < ?`
+)
+
+// Resumable uploads
+const (
+	insertUploadQuery = `
+		INSERT INTO uploads (id, topic, user, sender, length, received, headers, query, expires)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	selectUploadQuery               = `SELECT id, topic, user, sender, length, received, headers, query, expires FROM uploads WHERE id = ?`
+	selectUploadsExpiredQuery       = `SELECT id FROM uploads WHERE expires <= ?`
+	selectUploadsCountBySenderQuery = `SELECT COUNT(*) FROM uploads WHERE user = '' AND sender = ?`
+	selectUploadsCountByUserIDQuery = `SELECT COUNT(*) FROM uploads WHERE user = ?`
+	updateUploadReceivedQuery       = `UPDATE uploads SET received = ?, expires = ? WHERE id = ?`
+	deleteUploadQuery               = `DELETE FROM uploads WHERE id = ?`
+)
+
 // Schema management queries
 const (
-	currentSchemaVersion          = 13
+	currentSchemaVersion          = 22
 	createSchemaVersionTableQuery = `
 		CREATE TABLE IF NOT EXISTS schemaVersion (
 			id INT PRIMARY KEY,
@@ -258,6 +368,76 @@ const (
 	migrate12To13AlterMessagesTableQuery = `
 		CREATE INDEX IF NOT EXISTS idx_topic ON messages (topic);
 	`
+
+	// 13 -> 14
+	migrate13To14CreateConsumersTableQuery = `
+		CREATE TABLE IF NOT EXISTS consumers (
+			user TEXT NOT NULL,
+			name TEXT NOT NULL,
+			topics TEXT NOT NULL,
+			mid TEXT NOT NULL,
+			updated INT NOT NULL,
+			PRIMARY KEY (user, name)
+		);
+	`
+
+	// 14 -> 15
+	migrate14To15CreateSignedURLsTableQuery = `
+		CREATE TABLE IF NOT EXISTS signed_urls (
+			id TEXT PRIMARY KEY,
+			uses INT NOT NULL,
+			expires INT NOT NULL
+		);
+	`
+
+	// 15 -> 16
+	migrate15To16AlterMessagesTableQuery = `
+		ALTER TABLE messages ADD COLUMN signature TEXT NOT NULL DEFAULT('');
+	`
+
+	// 16 -> 17
+	migrate16To17AlterMessagesTableQuery = `
+		ALTER TABLE messages ADD COLUMN labels TEXT NOT NULL DEFAULT('');
+	`
+
+	// 17 -> 18
+	migrate17To18AlterMessagesTableQuery = `
+		ALTER TABLE messages ADD COLUMN extra_attachments TEXT NOT NULL DEFAULT('');
+		ALTER TABLE messages ADD COLUMN extra_attachments_size INT NOT NULL DEFAULT('0');
+	`
+
+	// 18 -> 19
+	migrate18To19CreateUploadsTableQuery = `
+		CREATE TABLE IF NOT EXISTS uploads (
+			id TEXT PRIMARY KEY,
+			topic TEXT NOT NULL,
+			user TEXT NOT NULL,
+			sender TEXT NOT NULL,
+			length INT NOT NULL,
+			received INT NOT NULL,
+			headers TEXT NOT NULL,
+			query TEXT NOT NULL,
+			expires INT NOT NULL
+		);
+	`
+
+	// 19 -> 20
+	migrate19To20AlterMessagesTableQuery = `
+		ALTER TABLE messages ADD COLUMN attachment_thumbnail TEXT NOT NULL DEFAULT('');
+	`
+
+	// 20 -> 21
+	migrate20To21CreateWebhookDeliveriesTableQuery = `
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id TEXT PRIMARY KEY,
+			expires INT NOT NULL
+		);
+	`
+
+	// 21 -> 22
+	migrate21To22AlterMessagesTableQuery = `
+		ALTER TABLE messages ADD COLUMN routing TEXT NOT NULL DEFAULT('');
+	`
 )
 
 var (
@@ -275,6 +455,15 @@ var (
 		10: migrateFrom10,
 		11: migrateFrom11,
 		12: migrateFrom12,
+		13: migrateFrom13,
+		14: migrateFrom14,
+		15: migrateFrom15,
+		16: migrateFrom16,
+		17: migrateFrom17,
+		18: migrateFrom18,
+		19: migrateFrom19,
+		20: migrateFrom20,
+		21: migrateFrom21,
 	}
 )
 
@@ -337,6 +526,18 @@ func (c *messageCache) AddMessage(m *message) error {
 	return c.addMessages([]*message{m})
 }
 
+// AddMessages stores multiple messages to the message cache in a single transaction, or queues them to be stored
+// at a later date asyncronously, see AddMessage.
+func (c *messageCache) AddMessages(ms []*message) error {
+	if c.queue != nil {
+		for _, m := range ms {
+			c.queue.Enqueue(m)
+		}
+		return nil
+	}
+	return c.addMessages(ms)
+}
+
 // addMessages synchronously stores a match of messages. If the database is locked, the transaction waits until
 // SQLite's busy_timeout is exceeded before erroring out.
 func (c *messageCache) addMessages(ms []*message) error {
@@ -363,7 +564,7 @@ func (c *messageCache) addMessages(ms []*message) error {
 		}
 		published := m.Time <= time.Now().Unix()
 		tags := strings.Join(m.Tags, ",")
-		var attachmentName, attachmentType, attachmentURL string
+		var attachmentName, attachmentType, attachmentURL, attachmentThumbnail string
 		var attachmentSize, attachmentExpires, attachmentDeleted int64
 		if m.Attachment != nil {
 			attachmentName = m.Attachment.Name
@@ -371,6 +572,19 @@ func (c *messageCache) addMessages(ms []*message) error {
 			attachmentSize = m.Attachment.Size
 			attachmentExpires = m.Attachment.Expires
 			attachmentURL = m.Attachment.URL
+			attachmentThumbnail = m.Attachment.Thumbnail
+		}
+		var extraAttachmentsStr string
+		var extraAttachmentsSize int64
+		if len(m.Attachments) > 1 {
+			extraAttachmentsBytes, err := json.Marshal(m.Attachments[1:])
+			if err != nil {
+				return err
+			}
+			extraAttachmentsStr = string(extraAttachmentsBytes)
+			for _, a := range m.Attachments[1:] {
+				extraAttachmentsSize += a.Size
+			}
 		}
 		var actionsStr string
 		if len(m.Actions) > 0 {
@@ -380,6 +594,22 @@ func (c *messageCache) addMessages(ms []*message) error {
 			}
 			actionsStr = string(actionsBytes)
 		}
+		var labelsStr string
+		if len(m.Labels) > 0 {
+			labelsBytes, err := json.Marshal(m.Labels)
+			if err != nil {
+				return err
+			}
+			labelsStr = string(labelsBytes)
+		}
+		var routingStr string
+		if m.Routing != nil {
+			routingBytes, err := json.Marshal(m.Routing)
+			if err != nil {
+				return err
+			}
+			routingStr = string(routingBytes)
+		}
 		var sender string
 		if m.Sender.IsValid() {
 			sender = m.Sender.String()
@@ -401,11 +631,17 @@ func (c *messageCache) addMessages(ms []*message) error {
 			attachmentSize,
 			attachmentExpires,
 			attachmentURL,
+			attachmentThumbnail,
 			attachmentDeleted, // Always zero
+			extraAttachmentsStr,
+			extraAttachmentsSize,
 			sender,
 			m.User,
 			m.ContentType,
 			m.Encoding,
+			m.Signature,
+			labelsStr,
+			routingStr,
 			published,
 		)
 		if err != nil {
@@ -479,6 +715,100 @@ func (c *messageCache) messagesLatest(topic string) ([]*message, error) {
 	return readMessages(rows)
 }
 
+// MessagesPage returns a page of at most page.limit messages for the given topics, ordered by the order in which they
+// were added to the cache. If the limit is zero, the page is not limited in size. Messages are returned starting right
+// after the since marker. If page.before is set, only messages added before the message with this ID are returned, and
+// the page ends right before it (unless since is a message ID). Likewise, if page.newest is set, the page ends with the
+// newest message. The returned more flag indicates whether there are further messages beyond the page. The "latest"
+// since marker is not supported here, see Messages.
+//
+// Expired messages and messages that do not pass the filters are skipped before the limit is applied, so that
+// a page is only ever short if there are no more messages. If filters is nil, all messages pass.
+func (c *messageCache) MessagesPage(topics []string, since sinceMarker, scheduled bool, page *pagination, filters *queryFilter) (messages []*message, more bool, err error) {
+	if since.IsNone() || since.IsLatest() || len(topics) == 0 {
+		return make([]*message, 0), false, nil
+	}
+	args := make([]any, 0)
+	for _, t := range topics {
+		args = append(args, t)
+	}
+	where := []string{"(expires = 0 OR expires > ?)"}
+	args = append(args, time.Now().Unix())
+	if since.IsID() {
+		rowID, found, err := c.rowIDFromMessageID(since.ID())
+		if err != nil {
+			return nil, false, err
+		} else if !found {
+			return make([]*message, 0), false, nil // Unknown or expired cursor, the client must not be sent the entire history
+		}
+		where = append(where, "id > ?")
+		args = append(args, rowID)
+	} else {
+		where = append(where, "time >= ?")
+		args = append(args, since.Time().Unix())
+	}
+	if page.before != "" {
+		rowID, found, err := c.rowIDFromMessageID(page.before)
+		if err != nil {
+			return nil, false, err
+		} else if !found {
+			return make([]*message, 0), false, nil // Messages before an expired message are gone as well
+		}
+		where = append(where, "id < ?")
+		args = append(args, rowID)
+	}
+	if !scheduled {
+		where = append(where, "published = 1")
+	}
+	order := "ASC"
+	backwards := (page.before != "" || page.newest) && !since.IsID()
+	if backwards {
+		order = "DESC"
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(topics)), ",")
+	query := fmt.Sprintf(selectMessagesPageQuery, placeholders, strings.Join(where, " AND "), order)
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+	messages = make([]*message, 0)
+	for rows.Next() {
+		m, err := readMessage(rows)
+		if err != nil {
+			return nil, false, err
+		} else if filters != nil && !filters.Pass(m) {
+			continue
+		} else if page.limit > 0 && len(messages) == page.limit {
+			more = true // Stop reading rows once we know that there is at least one more message
+			break
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	if backwards {
+		slices.Reverse(messages)
+	}
+	return messages, more, nil
+}
+
+func (c *messageCache) rowIDFromMessageID(id string) (rowID int64, found bool, err error) {
+	rows, err := c.db.Query(selectRowIDFromMessageID, id)
+	if err != nil {
+		return 0, false, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return 0, false, nil
+	}
+	if err := rows.Scan(&rowID); err != nil {
+		return 0, false, err
+	}
+	return rowID, true, nil
+}
+
 func (c *messageCache) MessagesDue() ([]*message, error) {
 	rows, err := c.db.Query(selectMessagesDueQuery, time.Now().Unix())
 	if err != nil {
@@ -520,6 +850,24 @@ func (c *messageCache) Message(id string) (*message, error) {
 	return readMessage(rows)
 }
 
+// MessageRouting returns the routing result that was stored with a scheduled message, see sendDelayedMessage.
+// If none was stored, an empty result is returned.
+func (c *messageCache) MessageRouting(id string) (*routingResult, error) {
+	var routingStr string
+	if err := c.db.QueryRow(selectMessageRoutingQuery, id).Scan(&routingStr); errors.Is(err, sql.ErrNoRows) {
+		return nil, errMessageNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	routing := &routingResult{}
+	if routingStr != "" {
+		if err := json.Unmarshal([]byte(routingStr), routing); err != nil {
+			return nil, err
+		}
+	}
+	return routing, nil
+}
+
 func (c *messageCache) MarkPublished(m *message) error {
 	_, err := c.db.Exec(updateMessagePublishedQuery, m.ID)
 	return err
@@ -593,6 +941,21 @@ func (c *messageCache) ExpireMessages(topics ...string) error {
 	return tx.Commit()
 }
 
+// UpdateMessagesExpiry shortens the expiry of all cached messages of the given topic to expiryDuration after the
+// time they were published, e.g. after the retention settings of the topic were changed. The expiry is never
+// extended, so that messages published with a short TTL still expire in time.
+func (c *messageCache) UpdateMessagesExpiry(topic string, expiryDuration time.Duration) error {
+	_, err := c.db.Exec(updateMessagesForTopicExpiryDurationQuery, int64(expiryDuration.Seconds()), topic)
+	return err
+}
+
+// ExpireMessagesBeyondCount marks all but the newest count messages of the given topic as expired. Scheduled
+// messages are not counted or expired until they are published.
+func (c *messageCache) ExpireMessagesBeyondCount(topic string, count int64) error {
+	_, err := c.db.Exec(updateMessagesForTopicBeyondCountQuery, time.Now().Unix()-1, topic, topic, count)
+	return err
+}
+
 func (c *messageCache) AttachmentsExpired() ([]string, error) {
 	rows, err := c.db.Query(selectAttachmentsExpiredQuery, time.Now().Unix())
 	if err != nil {
@@ -628,7 +991,7 @@ func (c *messageCache) MarkAttachmentsDeleted(ids ...string) error {
 }
 
 func (c *messageCache) AttachmentBytesUsedBySender(sender string) (int64, error) {
-	rows, err := c.db.Query(selectAttachmentsSizeBySenderQuery, sender, time.Now().Unix())
+	rows, err := c.db.Query(selectAttachmentsSizeBySenderQuery, sender, time.Now().Unix(), sender)
 	if err != nil {
 		return 0, err
 	}
@@ -636,7 +999,7 @@ func (c *messageCache) AttachmentBytesUsedBySender(sender string) (int64, error)
 }
 
 func (c *messageCache) AttachmentBytesUsedByUser(userID string) (int64, error) {
-	rows, err := c.db.Query(selectAttachmentsSizeByUserIDQuery, userID, time.Now().Unix())
+	rows, err := c.db.Query(selectAttachmentsSizeByUserIDQuery, userID, time.Now().Unix(), userID)
 	if err != nil {
 		return 0, err
 	}
@@ -657,6 +1020,228 @@ func (c *messageCache) readAttachmentBytesUsed(rows *sql.Rows) (int64, error) {
 	return size, nil
 }
 
+// AddConsumer registers a durable consumer for the given topics, or updates the topics of an existing one.
+// The last delivered or acknowledged message of an existing consumer is not changed.
+func (c *messageCache) AddConsumer(userID, name string, topics []string) error {
+	_, err := c.db.Exec(upsertConsumerQuery, userID, name, strings.Join(topics, ","), time.Now().Unix())
+	return err
+}
+
+// UpdateConsumerMessageID sets the last delivered or acknowledged message of a durable consumer
+func (c *messageCache) UpdateConsumerMessageID(userID, name, messageID string) error {
+	_, err := c.db.Exec(updateConsumerMessageIDQuery, messageID, time.Now().Unix(), userID, name)
+	return err
+}
+
+// Consumer returns the durable consumer with the given name, or errConsumerNotFound if it does not exist
+func (c *messageCache) Consumer(userID, name string) (*consumer, error) {
+	rows, err := c.db.Query(selectConsumerQuery, userID, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, errConsumerNotFound
+	}
+	return readConsumer(rows)
+}
+
+// Consumers returns all durable consumers, ordered by user and name
+func (c *messageCache) Consumers() ([]*consumer, error) {
+	rows, err := c.db.Query(selectConsumersQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	consumers := make([]*consumer, 0)
+	for rows.Next() {
+		cs, err := readConsumer(rows)
+		if err != nil {
+			return nil, err
+		}
+		consumers = append(consumers, cs)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return consumers, nil
+}
+
+// ConsumersCount returns the number of durable consumers of the given user
+func (c *messageCache) ConsumersCount(userID string) (int, error) {
+	var count int
+	if err := c.db.QueryRow(selectConsumersCountQuery, userID).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// DeleteConsumers deletes all durable consumers of the given user
+func (c *messageCache) DeleteConsumers(userID string) error {
+	_, err := c.db.Exec(deleteConsumersQuery, userID)
+	return err
+}
+
+// DeleteStaleConsumers deletes all durable consumers that have not been used since the given time
+func (c *messageCache) DeleteStaleConsumers(olderThan time.Time) error {
+	_, err := c.db.Exec(deleteStaleConsumersQuery, olderThan.Unix())
+	return err
+}
+
+// ConsumerPending returns the number of cached messages that a durable consumer has not yet
+// received (or acknowledged). If the consumer's last message is not cached anymore, all cached
+// messages are counted.
+func (c *messageCache) ConsumerPending(cs *consumer) (int64, error) {
+	var pending int64
+	for _, t := range cs.Topics {
+		var count int64
+		if err := c.db.QueryRow(selectConsumerPendingCountQuery, t, cs.MessageID).Scan(&count); err != nil {
+			return 0, err
+		}
+		pending += count
+	}
+	return pending, nil
+}
+
+// IncrementSignedURLUses counts a use of the signed publish URL with the given ID, and returns the
+// number of times it has been used, including this use. The row is kept until the URL expires.
+func (c *messageCache) IncrementSignedURLUses(id string, expires int64) (int, error) {
+	var uses int
+	if err := c.db.QueryRow(upsertSignedURLUsesQuery, id, expires).Scan(&uses); err != nil {
+		return 0, err
+	}
+	return uses, nil
+}
+
+// DeleteExpiredSignedURLs removes the use counts of signed publish URLs that have expired
+func (c *messageCache) DeleteExpiredSignedURLs() error {
+	_, err := c.db.Exec(deleteExpiredSignedURLsQuery, time.Now().Unix())
+	return err
+}
+
+// AddWebhookDelivery records a webhook delivery with the given ID, and returns false if it was already recorded.
+// The row is kept until it expires.
+func (c *messageCache) AddWebhookDelivery(id string, expires int64) (bool, error) {
+	result, err := c.db.Exec(insertWebhookDeliveryQuery, id, expires)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// DeleteExpiredWebhookDeliveries removes webhook deliveries that have expired
+func (c *messageCache) DeleteExpiredWebhookDeliveries() error {
+	_, err := c.db.Exec(deleteExpiredWebhookDeliveriesQuery, time.Now().Unix())
+	return err
+}
+
+// AddUpload stores a new resumable upload
+func (c *messageCache) AddUpload(u *upload) error {
+	headers, err := json.Marshal(u.Header)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec(insertUploadQuery, u.ID, u.Topic, u.User, u.Sender.String(), u.Length, u.Received, string(headers), u.Query, u.Expires)
+	return err
+}
+
+// Upload returns the resumable upload with the given ID, or errUploadNotFound if it does not exist
+func (c *messageCache) Upload(id string) (*upload, error) {
+	var u upload
+	var sender, headers string
+	if err := c.db.QueryRow(selectUploadQuery, id).Scan(&u.ID, &u.Topic, &u.User, &sender, &u.Length, &u.Received, &headers, &u.Query, &u.Expires); errors.Is(err, sql.ErrNoRows) {
+		return nil, errUploadNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	senderIP, err := netip.ParseAddr(sender)
+	if err != nil {
+		senderIP = netip.Addr{} // if no IP stored in database, return invalid address
+	}
+	u.Sender = senderIP
+	if err := json.Unmarshal([]byte(headers), &u.Header); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// UploadsCount returns the number of incomplete resumable uploads of the given user, or of the given
+// sender if the user ID is empty
+func (c *messageCache) UploadsCount(userID string, sender netip.Addr) (int, error) {
+	var count int
+	var err error
+	if userID != "" {
+		err = c.db.QueryRow(selectUploadsCountByUserIDQuery, userID).Scan(&count)
+	} else {
+		err = c.db.QueryRow(selectUploadsCountBySenderQuery, sender.String()).Scan(&count)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// UpdateUploadReceived sets the number of bytes received for a resumable upload, and extends its expiry time
+func (c *messageCache) UpdateUploadReceived(id string, received, expires int64) error {
+	_, err := c.db.Exec(updateUploadReceivedQuery, received, expires, id)
+	return err
+}
+
+// DeleteUploads removes the given resumable uploads. It does not delete the uploaded files.
+func (c *messageCache) DeleteUploads(ids ...string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, id := range ids {
+		if _, err := tx.Exec(deleteUploadQuery, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UploadsExpired returns the IDs of all resumable uploads that have not been completed in time
+func (c *messageCache) UploadsExpired() ([]string, error) {
+	rows, err := c.db.Query(selectUploadsExpiredQuery, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func readConsumer(rows *sql.Rows) (*consumer, error) {
+	var userID, name, topics, messageID string
+	var updated int64
+	if err := rows.Scan(&userID, &name, &topics, &messageID, &updated); err != nil {
+		return nil, err
+	}
+	return &consumer{
+		UserID:    userID,
+		Name:      name,
+		Topics:    strings.Split(topics, ","),
+		MessageID: messageID,
+		Updated:   updated,
+	}, nil
+}
+
 func (c *messageCache) processMessageBatches() {
 	if c.queue == nil {
 		return
@@ -687,7 +1272,7 @@ func readMessages(rows *sql.Rows) ([]*message, error) {
 func readMessage(rows *sql.Rows) (*message, error) {
 	var timestamp, expires, attachmentSize, attachmentExpires int64
 	var priority int
-	var id, topic, msg, title, tagsStr, click, icon, actionsStr, attachmentName, attachmentType, attachmentURL, sender, user, contentType, encoding string
+	var id, topic, msg, title, tagsStr, click, icon, actionsStr, attachmentName, attachmentType, attachmentURL, attachmentThumbnail, extraAttachmentsStr, sender, user, contentType, encoding, signature, labelsStr string
 	err := rows.Scan(
 		&id,
 		&timestamp,
@@ -705,10 +1290,14 @@ func readMessage(rows *sql.Rows) (*message, error) {
 		&attachmentSize,
 		&attachmentExpires,
 		&attachmentURL,
+		&attachmentThumbnail,
+		&extraAttachmentsStr,
 		&sender,
 		&user,
 		&contentType,
 		&encoding,
+		&signature,
+		&labelsStr,
 	)
 	if err != nil {
 		return nil, err
@@ -723,6 +1312,12 @@ func readMessage(rows *sql.Rows) (*message, error) {
 			return nil, err
 		}
 	}
+	var labels map[string]string
+	if labelsStr != "" {
+		if err := json.Unmarshal([]byte(labelsStr), &labels); err != nil {
+			return nil, err
+		}
+	}
 	senderIP, err := netip.ParseAddr(sender)
 	if err != nil {
 		senderIP = netip.Addr{} // if no IP stored in database, return invalid address
@@ -730,12 +1325,21 @@ func readMessage(rows *sql.Rows) (*message, error) {
 	var att *attachment
 	if attachmentName != "" && attachmentURL != "" {
 		att = &attachment{
-			Name:    attachmentName,
-			Type:    attachmentType,
-			Size:    attachmentSize,
-			Expires: attachmentExpires,
-			URL:     attachmentURL,
+			Name:      attachmentName,
+			Type:      attachmentType,
+			Size:      attachmentSize,
+			Expires:   attachmentExpires,
+			URL:       attachmentURL,
+			Thumbnail: attachmentThumbnail,
+		}
+	}
+	var attachments []*attachment
+	if att != nil && extraAttachmentsStr != "" {
+		var extraAttachments []*attachment
+		if err := json.Unmarshal([]byte(extraAttachmentsStr), &extraAttachments); err != nil {
+			return nil, err
 		}
+		attachments = append([]*attachment{att}, extraAttachments...)
 	}
 	return &message{
 		ID:          id,
@@ -751,10 +1355,13 @@ func readMessage(rows *sql.Rows) (*message, error) {
 		Icon:        icon,
 		Actions:     actions,
 		Attachment:  att,
+		Attachments: attachments,
 		Sender:      senderIP, // Must parse assuming database must be correct
 		User:        user,
 		ContentType: contentType,
 		Encoding:    encoding,
+		Signature:   signature,
+		Labels:      labels,
 	}, nil
 }
 
@@ -1009,3 +1616,147 @@ func migrateFrom12(db *sql.DB, _ time.Duration) error {
 	}
 	return tx.Commit()
 }
+
+func migrateFrom13(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 13 to 14")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate13To14CreateConsumersTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 14); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom14(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 14 to 15")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate14To15CreateSignedURLsTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 15); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom15(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 15 to 16")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate15To16AlterMessagesTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 16); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom16(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 16 to 17")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate16To17AlterMessagesTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 17); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom17(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 17 to 18")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate17To18AlterMessagesTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 18); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom18(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 18 to 19")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate18To19CreateUploadsTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 19); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom19(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 19 to 20")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate19To20AlterMessagesTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 20); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom20(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 20 to 21")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate20To21CreateWebhookDeliveriesTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 21); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom21(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 21 to 22")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate21To22AlterMessagesTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 22); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
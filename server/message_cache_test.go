@@ -168,6 +168,26 @@ func testCacheMessagesTagsPrioAndTitle(t *testing.T, c *messageCache) {
 	require.Equal(t, "some title", messages[0].Title)
 }
 
+func TestSqliteCache_MessagesLabels(t *testing.T) {
+	testCacheMessagesLabels(t, newSqliteTestCache(t))
+}
+
+func TestMemCache_MessagesLabels(t *testing.T) {
+	testCacheMessagesLabels(t, newMemTestCache(t))
+}
+
+func testCacheMessagesLabels(t *testing.T, c *messageCache) {
+	m1 := newDefaultMessage("mytopic", "with labels")
+	m1.Labels = map[string]string{"env": "prod", "host": "db1"}
+	m2 := newDefaultMessage("mytopic", "without labels")
+	require.Nil(t, c.AddMessages([]*message{m1, m2}))
+
+	messages, _ := c.Messages("mytopic", sinceAllMessages, false)
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, map[string]string{"env": "prod", "host": "db1"}, messages[0].Labels)
+	require.Nil(t, messages[1].Labels)
+}
+
 func TestSqliteCache_MessagesSinceID(t *testing.T) {
 	testCacheMessagesSinceID(t, newSqliteTestCache(t))
 }
@@ -312,11 +332,12 @@ func testCacheAttachments(t *testing.T, c *messageCache) {
 	m.ID = "m2"
 	m.Sender = netip.MustParseAddr("1.2.3.4")
 	m.Attachment = &attachment{
-		Name:    "car.jpg",
-		Type:    "image/jpeg",
-		Size:    10000,
-		Expires: expires2,
-		URL:     "https://ntfy.sh/file/aCaRURL.jpg",
+		Name:      "car.jpg",
+		Type:      "image/jpeg",
+		Size:      10000,
+		Expires:   expires2,
+		URL:       "https://ntfy.sh/file/aCaRURL.jpg",
+		Thumbnail: "https://ntfy.sh/file/aCaRURL-thumb.jpg",
 	}
 	require.Nil(t, c.AddMessage(m))
 
@@ -344,6 +365,7 @@ func testCacheAttachments(t *testing.T, c *messageCache) {
 	require.Equal(t, int64(5000), messages[0].Attachment.Size)
 	require.Equal(t, expires1, messages[0].Attachment.Expires)
 	require.Equal(t, "https://ntfy.sh/file/AbDeFgJhal.jpg", messages[0].Attachment.URL)
+	require.Equal(t, "", messages[0].Attachment.Thumbnail)
 	require.Equal(t, "1.2.3.4", messages[0].Sender.String())
 
 	require.Equal(t, "sending you a car", messages[1].Message)
@@ -352,6 +374,7 @@ func testCacheAttachments(t *testing.T, c *messageCache) {
 	require.Equal(t, int64(10000), messages[1].Attachment.Size)
 	require.Equal(t, expires2, messages[1].Attachment.Expires)
 	require.Equal(t, "https://ntfy.sh/file/aCaRURL.jpg", messages[1].Attachment.URL)
+	require.Equal(t, "https://ntfy.sh/file/aCaRURL-thumb.jpg", messages[1].Attachment.Thumbnail)
 	require.Equal(t, "1.2.3.4", messages[1].Sender.String())
 
 	size, err := c.AttachmentBytesUsedBySender("1.2.3.4")
@@ -422,6 +445,169 @@ func testCacheAttachmentsExpired(t *testing.T, c *messageCache) {
 	require.Equal(t, "m4", ids[0])
 }
 
+func TestSqliteCache_MessagesPage(t *testing.T) {
+	testCacheMessagesPage(t, newSqliteTestCache(t))
+}
+
+func TestMemCache_MessagesPage(t *testing.T) {
+	testCacheMessagesPage(t, newMemTestCache(t))
+}
+
+func testCacheMessagesPage(t *testing.T, c *messageCache) {
+	ms := make([]*message, 0)
+	for i := 0; i < 5; i++ {
+		m := newDefaultMessage("mytopic", fmt.Sprintf("message %d", i))
+		require.Nil(t, c.AddMessage(m))
+		ms = append(ms, m)
+	}
+	require.Nil(t, c.AddMessage(newDefaultMessage("othertopic", "other")))
+
+	// First page, then page forward
+	messages, more, err := c.MessagesPage([]string{"mytopic"}, sinceAllMessages, false, &pagination{limit: 2}, nil)
+	require.Nil(t, err)
+	require.True(t, more)
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, "message 0", messages[0].Message)
+	require.Equal(t, "message 1", messages[1].Message)
+
+	messages, more, err = c.MessagesPage([]string{"mytopic"}, newSinceID(ms[1].ID), false, &pagination{limit: 2}, nil)
+	require.Nil(t, err)
+	require.True(t, more)
+	require.Equal(t, "message 2", messages[0].Message)
+	require.Equal(t, "message 3", messages[1].Message)
+
+	messages, more, err = c.MessagesPage([]string{"mytopic"}, newSinceID(ms[3].ID), false, &pagination{limit: 2}, nil)
+	require.Nil(t, err)
+	require.False(t, more)
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "message 4", messages[0].Message)
+
+	// Page backwards, oldest message first
+	messages, more, err = c.MessagesPage([]string{"mytopic"}, sinceAllMessages, false, &pagination{limit: 3, before: ms[4].ID}, nil)
+	require.Nil(t, err)
+	require.True(t, more)
+	require.Equal(t, 3, len(messages))
+	require.Equal(t, "message 1", messages[0].Message)
+	require.Equal(t, "message 3", messages[2].Message)
+
+	messages, more, err = c.MessagesPage([]string{"mytopic"}, sinceAllMessages, false, &pagination{limit: 3, before: ms[1].ID}, nil)
+	require.Nil(t, err)
+	require.False(t, more)
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "message 0", messages[0].Message)
+
+	// Between two cursors, and across topics
+	messages, more, err = c.MessagesPage([]string{"mytopic"}, newSinceID(ms[0].ID), false, &pagination{before: ms[3].ID}, nil)
+	require.Nil(t, err)
+	require.False(t, more)
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, "message 1", messages[0].Message)
+	require.Equal(t, "message 2", messages[1].Message)
+
+	messages, more, err = c.MessagesPage([]string{"mytopic", "othertopic"}, newSinceID(ms[3].ID), false, &pagination{limit: 10}, nil)
+	require.Nil(t, err)
+	require.False(t, more)
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, "message 4", messages[0].Message)
+	require.Equal(t, "other", messages[1].Message)
+
+	// Unknown before and after cursors
+	messages, more, err = c.MessagesPage([]string{"mytopic"}, sinceAllMessages, false, &pagination{limit: 2, before: "abcdefghijkl"}, nil)
+	require.Nil(t, err)
+	require.False(t, more)
+	require.Empty(t, messages)
+
+	messages, more, err = c.MessagesPage([]string{"mytopic"}, newSinceID("abcdefghijkl"), false, &pagination{limit: 2}, nil)
+	require.Nil(t, err)
+	require.False(t, more)
+	require.Empty(t, messages)
+}
+
+func TestSqliteCache_MessagesPage_FiltersAndExpiredMessages(t *testing.T) {
+	testCacheMessagesPageFiltersAndExpiredMessages(t, newSqliteTestCache(t))
+}
+
+func TestMemCache_MessagesPage_FiltersAndExpiredMessages(t *testing.T) {
+	testCacheMessagesPageFiltersAndExpiredMessages(t, newMemTestCache(t))
+}
+
+func testCacheMessagesPageFiltersAndExpiredMessages(t *testing.T, c *messageCache) {
+	for i := 0; i < 10; i++ {
+		m := newDefaultMessage("mytopic", fmt.Sprintf("message %d", i))
+		if i%2 == 0 {
+			m.Tags = []string{"even"}
+		}
+		if i < 4 {
+			m.Expires = time.Now().Add(-time.Minute).Unix() // Expired, but not pruned yet
+		}
+		require.Nil(t, c.AddMessage(m))
+	}
+
+	// Filtered and expired messages do not count towards the limit
+	filters := &queryFilter{Tags: []string{"even"}}
+	messages, more, err := c.MessagesPage([]string{"mytopic"}, sinceAllMessages, false, &pagination{limit: 2}, filters)
+	require.Nil(t, err)
+	require.True(t, more)
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, "message 4", messages[0].Message)
+	require.Equal(t, "message 6", messages[1].Message)
+
+	messages, more, err = c.MessagesPage([]string{"mytopic"}, newSinceID(messages[1].ID), false, &pagination{limit: 2}, filters)
+	require.Nil(t, err)
+	require.False(t, more)
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "message 8", messages[0].Message)
+}
+
+func TestSqliteCache_Consumers(t *testing.T) {
+	testCacheConsumers(t, newSqliteTestCache(t))
+}
+
+func TestMemCache_Consumers(t *testing.T) {
+	testCacheConsumers(t, newMemTestCache(t))
+}
+
+func testCacheConsumers(t *testing.T, c *messageCache) {
+	m1 := newDefaultMessage("topic1", "message 1")
+	m2 := newDefaultMessage("topic2", "message 2")
+	m3 := newDefaultMessage("topic1", "message 3")
+	require.Nil(t, c.AddMessage(m1))
+	require.Nil(t, c.AddMessage(m2))
+	require.Nil(t, c.AddMessage(m3))
+
+	_, err := c.Consumer("u_123", "worker1")
+	require.Equal(t, errConsumerNotFound, err)
+
+	// New consumer has not received anything
+	require.Nil(t, c.AddConsumer("u_123", "worker1", []string{"topic1", "topic2"}))
+	consumer, err := c.Consumer("u_123", "worker1")
+	require.Nil(t, err)
+	require.Equal(t, []string{"topic1", "topic2"}, consumer.Topics)
+	require.Equal(t, "", consumer.MessageID)
+	pending, err := c.ConsumerPending(consumer)
+	require.Nil(t, err)
+	require.Equal(t, int64(3), pending)
+
+	// Position is kept when the consumer re-registers
+	require.Nil(t, c.UpdateConsumerMessageID("u_123", "worker1", m2.ID))
+	require.Nil(t, c.AddConsumer("u_123", "worker1", []string{"topic1"}))
+	consumer, err = c.Consumer("u_123", "worker1")
+	require.Nil(t, err)
+	require.Equal(t, []string{"topic1"}, consumer.Topics)
+	require.Equal(t, m2.ID, consumer.MessageID)
+	pending, err = c.ConsumerPending(consumer)
+	require.Nil(t, err)
+	require.Equal(t, int64(1), pending)
+
+	// Consumers are per user
+	require.Nil(t, c.AddConsumer("", "worker1", []string{"topic2"}))
+	consumers, err := c.Consumers()
+	require.Nil(t, err)
+	require.Equal(t, 2, len(consumers))
+	require.Equal(t, "", consumers[0].UserID)
+	require.Equal(t, "u_123", consumers[1].UserID)
+}
+
 func TestSqliteCache_Migration_From0(t *testing.T) {
 	filename := newSqliteTestCacheFile(t)
 	db, err := sql.Open("sqlite3", filename)
@@ -0,0 +1,267 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// This file implements the subset of the MQTT 3.1.1 wire protocol (https://docs.oasis-open.org/mqtt/mqtt/v3.1.1/)
+// that the MQTT bridge needs: CONNECT, PUBLISH (QoS 0 and 1), SUBSCRIBE, UNSUBSCRIBE, PINGREQ and DISCONNECT
+// from the client, and the corresponding acknowledgements from the server.
+
+const (
+	mqttPacketConnect     = 1
+	mqttPacketConnack     = 2
+	mqttPacketPublish     = 3
+	mqttPacketPuback      = 4
+	mqttPacketSubscribe   = 8
+	mqttPacketSuback      = 9
+	mqttPacketUnsubscribe = 10
+	mqttPacketUnsuback    = 11
+	mqttPacketPingreq     = 12
+	mqttPacketPingresp    = 13
+	mqttPacketDisconnect  = 14
+)
+
+const (
+	mqttProtocolName  = "MQTT"
+	mqttProtocolLevel = 4 // MQTT 3.1.1
+
+	mqttConnackAccepted            = 0x00
+	mqttConnackBadProtocolVersion  = 0x01
+	mqttConnackBadUsernamePassword = 0x04
+	mqttConnackNotAuthorized       = 0x05
+
+	mqttSubackFailure = 0x80
+
+	mqttConnectFlagUsername = 0x80
+	mqttConnectFlagPassword = 0x40
+	mqttConnectFlagWill     = 0x04
+	mqttConnectFlagReserved = 0x01
+
+	mqttMaxRemainingLength = 268435455 // Max. value that can be encoded in the 4 byte variable length encoding
+)
+
+var (
+	errMQTTMalformedPacket   = errors.New("malformed MQTT packet")
+	errMQTTPacketTooLarge    = errors.New("MQTT packet too large")
+	errMQTTUnsupportedPacket = errors.New("unsupported MQTT packet type")
+)
+
+// mqttPacket is a raw MQTT control packet, consisting of the packet type, the flags (lower four bits
+// of the fixed header), and the remaining bytes (variable header and payload)
+type mqttPacket struct {
+	Type  byte
+	Flags byte
+	Body  []byte
+}
+
+type mqttConnectPacket struct {
+	ProtocolName  string
+	ProtocolLevel byte
+	KeepAlive     uint16
+	ClientID      string
+	Username      string
+	Password      string
+	HasUsername   bool
+	HasPassword   bool
+}
+
+type mqttPublishPacket struct {
+	Topic    string
+	QoS      byte
+	PacketID uint16
+	Payload  []byte
+}
+
+type mqttSubscribePacket struct {
+	PacketID uint16
+	Topics   []string // Topic filters, requested QoS is ignored
+}
+
+// readMQTTPacket reads a single MQTT control packet. Packets with a remaining length larger than
+// maxLength are rejected, so that clients cannot make the server allocate arbitrary amounts of memory.
+func readMQTTPacket(r *bufio.Reader, maxLength int) (*mqttPacket, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return nil, errMQTTMalformedPacket
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		length += int(b&0x7f) * multiplier
+		multiplier *= 128
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	if length > maxLength {
+		return nil, errMQTTPacketTooLarge
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return &mqttPacket{Type: header >> 4, Flags: header & 0x0f, Body: body}, nil
+}
+
+// writeMQTTPacket writes an MQTT control packet with the given type, flags and body
+func writeMQTTPacket(w io.Writer, packetType, flags byte, body []byte) error {
+	if len(body) > mqttMaxRemainingLength {
+		return errMQTTPacketTooLarge
+	}
+	header := []byte{packetType<<4 | flags}
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		header = append(header, b)
+		if length == 0 {
+			break
+		}
+	}
+	_, err := w.Write(append(header, body...))
+	return err
+}
+
+func (p *mqttPacket) ParseConnect() (*mqttConnectPacket, error) {
+	d := &mqttDecoder{b: p.Body}
+	c := &mqttConnectPacket{
+		ProtocolName:  d.UTF8(),
+		ProtocolLevel: d.Byte(),
+	}
+	flags := d.Byte()
+	c.KeepAlive = d.Uint16()
+	c.ClientID = d.UTF8()
+	if flags&mqttConnectFlagWill != 0 {
+		d.UTF8() // Will topic and message are not supported, and ignored
+		d.UTF8()
+	}
+	if flags&mqttConnectFlagUsername != 0 {
+		c.Username, c.HasUsername = d.UTF8(), true
+	}
+	if flags&mqttConnectFlagPassword != 0 {
+		c.Password, c.HasPassword = d.UTF8(), true
+	}
+	if d.err != nil || flags&mqttConnectFlagReserved != 0 {
+		return nil, errMQTTMalformedPacket
+	}
+	return c, nil
+}
+
+func (p *mqttPacket) ParsePublish() (*mqttPublishPacket, error) {
+	d := &mqttDecoder{b: p.Body}
+	m := &mqttPublishPacket{
+		Topic: d.UTF8(),
+		QoS:   (p.Flags >> 1) & 0x03,
+	}
+	if m.QoS > 0 {
+		m.PacketID = d.Uint16()
+	}
+	if d.err != nil || m.QoS == 3 {
+		return nil, errMQTTMalformedPacket
+	}
+	m.Payload = d.Rest()
+	return m, nil
+}
+
+func (p *mqttPacket) ParseSubscribe() (*mqttSubscribePacket, error) {
+	d := &mqttDecoder{b: p.Body}
+	s := &mqttSubscribePacket{PacketID: d.Uint16()}
+	for d.err == nil && d.Len() > 0 {
+		s.Topics = append(s.Topics, d.UTF8())
+		if p.Type == mqttPacketSubscribe {
+			d.Byte() // Requested QoS
+		}
+	}
+	if d.err != nil || len(s.Topics) == 0 {
+		return nil, errMQTTMalformedPacket
+	}
+	return s, nil
+}
+
+func (p *mqttPacket) String() string {
+	return fmt.Sprintf("type=%d flags=%d length=%d", p.Type, p.Flags, len(p.Body))
+}
+
+func newMQTTConnack(returnCode byte) []byte {
+	return []byte{0x00, returnCode} // Session present is always false, since there are no persistent sessions
+}
+
+func newMQTTPublish(topic string, payload []byte) []byte {
+	body := appendMQTTString(make([]byte, 0, 2+len(topic)+len(payload)), topic)
+	return append(body, payload...)
+}
+
+func newMQTTPacketID(packetID uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, packetID)
+}
+
+func newMQTTSuback(packetID uint16, returnCodes []byte) []byte {
+	return append(newMQTTPacketID(packetID), returnCodes...)
+}
+
+func appendMQTTString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// mqttDecoder reads the fields of an MQTT packet body. The first error is remembered, and subsequent
+// reads return zero values, so that callers only have to check for errors once.
+type mqttDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *mqttDecoder) Byte() byte {
+	if d.err != nil || len(d.b) < 1 {
+		d.err = errMQTTMalformedPacket
+		return 0
+	}
+	b := d.b[0]
+	d.b = d.b[1:]
+	return b
+}
+
+func (d *mqttDecoder) Uint16() uint16 {
+	if d.err != nil || len(d.b) < 2 {
+		d.err = errMQTTMalformedPacket
+		return 0
+	}
+	v := binary.BigEndian.Uint16(d.b)
+	d.b = d.b[2:]
+	return v
+}
+
+func (d *mqttDecoder) UTF8() string {
+	length := int(d.Uint16())
+	if d.err != nil || len(d.b) < length {
+		d.err = errMQTTMalformedPacket
+		return ""
+	}
+	s := string(d.b[:length])
+	d.b = d.b[length:]
+	return s
+}
+
+func (d *mqttDecoder) Rest() []byte {
+	rest := d.b
+	d.b = nil
+	return rest
+}
+
+func (d *mqttDecoder) Len() int {
+	return len(d.b)
+}
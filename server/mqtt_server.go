@@ -0,0 +1,330 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+// MQTT bridge:
+//
+// If mqtt-listen is set, ntfy accepts MQTT 3.1.1 connections, and maps the MQTT topics ntfy/<topic> onto ntfy
+// topics in both directions:
+//
+//   - MQTT publishes are turned into regular ntfy publish requests (just like e-mails received via SMTP), so
+//     that access control, visitor rate limits and the message cache apply exactly as they do for HTTP.
+//     The MQTT payload is the message body.
+//   - MQTT subscribers receive the JSON-encoded ntfy messages (same format as the /json endpoint) of the topics
+//     they subscribed to, if they have read access to them.
+//
+// The MQTT username/password is mapped to a ntfy user. Access tokens can be passed as password. Only QoS 0 and 1
+// are supported for publishing; messages are always delivered to subscribers with QoS 0. Wildcard subscriptions,
+// retained messages, will messages and persistent sessions are not supported.
+
+const (
+	mqttTopicPrefix      = "ntfy/"
+	mqttMaxPacketSize    = 1024 * 1024 // Must be larger than the message size limit, see smtpServer.MaxMessageBytes
+	mqttConnectTimeout   = 10 * time.Second
+	mqttKeepAliveGrace   = 1.5 // Clients are disconnected after 1.5x the keep alive interval, see MQTT spec 3.1.2.10
+	mqttReadBufferSize   = 4096
+	mqttSubscribeSuccess = 0x00 // Granted QoS 0
+)
+
+var (
+	errMQTTProtocolViolation = errors.New("MQTT protocol violation")
+	errMQTTTopicInvalid      = errors.New("invalid MQTT topic, must be ntfy/<topic>")
+	errMQTTQoSUnsupported    = errors.New("MQTT QoS 2 is not supported")
+)
+
+// mqttSession is a single MQTT client connection
+type mqttSession struct {
+	server        *Server
+	conn          net.Conn
+	keepAlive     *mqttKeepAliveReader
+	reader        *bufio.Reader
+	v             *visitor
+	authHeader    string         // Authorization header derived from the MQTT username/password, used for publishing
+	subscriptions map[string]int // ntfy topic ID -> subscriber ID
+	subscribed    bool           // True if the visitor's subscription count was incremented
+	queue         *subscriberQueue
+	ctx           context.Context
+	cancel        context.CancelFunc
+	wlock         sync.Mutex
+}
+
+func (s *Server) runMQTTServer() error {
+	listener, err := net.Listen("tcp", s.config.MQTTListen)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.mqttListener = listener
+	s.mu.Unlock()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleMQTTConn(conn)
+	}
+}
+
+// handleMQTTConn handles a single MQTT client connection until the client disconnects, or an error occurs
+func (s *Server) handleMQTTConn(conn net.Conn) {
+	ctx, cancel := context.WithCancel(context.Background())
+	keepAlive := &mqttKeepAliveReader{conn: conn, timeout: mqttConnectTimeout}
+	session := &mqttSession{
+		server:        s,
+		conn:          conn,
+		keepAlive:     keepAlive,
+		reader:        bufio.NewReaderSize(keepAlive, mqttReadBufferSize),
+		subscriptions: make(map[string]int),
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+	session.queue = newSubscriberQueue(session.deliver, s.config.SubscriberQueueSize, s.config.SubscriberQueuePolicy, cancel)
+	go session.queue.Run(ctx)
+	go func() {
+		<-ctx.Done()
+		conn.Close() // Interrupts the read loop, e.g. if the subscriber queue overflows
+	}()
+	defer session.close()
+	if err := session.run(); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		logmq(session).Err(err).Debug("MQTT connection closed with error")
+	} else {
+		logmq(session).Debug("MQTT connection closed")
+	}
+}
+
+func (s *mqttSession) run() error {
+	if err := s.handleConnect(); err != nil {
+		return err
+	}
+	for {
+		packet, err := readMQTTPacket(s.reader, mqttMaxPacketSize)
+		if err != nil {
+			return err
+		}
+		if ev := logmq(s); ev.IsTrace() {
+			ev.Trace("Received MQTT packet: %s", packet.String())
+		}
+		switch packet.Type {
+		case mqttPacketPublish:
+			err = s.handlePublish(packet)
+		case mqttPacketSubscribe, mqttPacketUnsubscribe:
+			err = s.handleSubscribe(packet)
+		case mqttPacketPuback:
+			// Ignore, messages are delivered with QoS 0
+		case mqttPacketPingreq:
+			err = s.write(mqttPacketPingresp, 0, nil)
+		case mqttPacketDisconnect:
+			return nil
+		case mqttPacketConnect:
+			err = errMQTTProtocolViolation // CONNECT may only be sent once, see MQTT spec 3.1.0-2
+		default:
+			err = errMQTTUnsupportedPacket
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handleConnect reads the CONNECT packet, authenticates the user, and responds with CONNACK
+func (s *mqttSession) handleConnect() error {
+	packet, err := readMQTTPacket(s.reader, mqttMaxPacketSize)
+	if err != nil {
+		return err
+	} else if packet.Type != mqttPacketConnect {
+		return errMQTTProtocolViolation
+	}
+	connect, err := packet.ParseConnect()
+	if err != nil {
+		return err
+	} else if connect.ProtocolName != mqttProtocolName || connect.ProtocolLevel != mqttProtocolLevel {
+		_ = s.write(mqttPacketConnack, 0, newMQTTConnack(mqttConnackBadProtocolVersion))
+		return fmt.Errorf("unsupported MQTT protocol %s, level %d", connect.ProtocolName, connect.ProtocolLevel)
+	}
+	if connect.HasPassword && strings.HasPrefix(connect.Password, "tk_") {
+		s.authHeader = util.BearerAuth(connect.Password)
+	} else if connect.HasUsername || connect.HasPassword {
+		s.authHeader = util.BasicAuth(connect.Username, connect.Password)
+	}
+	s.v, err = s.server.maybeAuthenticate(s.newRequest(http.MethodGet, "/", nil))
+	if err != nil {
+		returnCode := byte(mqttConnackBadUsernamePassword)
+		if errors.Is(err, errHTTPTooManyRequestsLimitAuthFailure) {
+			returnCode = mqttConnackNotAuthorized
+		}
+		_ = s.write(mqttPacketConnack, 0, newMQTTConnack(returnCode))
+		return err
+	}
+	s.keepAlive.timeout = time.Duration(float64(connect.KeepAlive)*mqttKeepAliveGrace) * time.Second // Zero means no timeout
+	logmq(s).Field("mqtt_client_id", connect.ClientID).Debug("MQTT client connected")
+	return s.write(mqttPacketConnack, 0, newMQTTConnack(mqttConnackAccepted))
+}
+
+// handlePublish publishes the MQTT message to the ntfy topic by calling the HTTP handler with a fake publish
+// request, the same way the SMTP server does. Since MQTT 3.1.1 has no way to reject a publish, the client is
+// disconnected if publishing fails (e.g. due to access control or rate limiting).
+func (s *mqttSession) handlePublish(packet *mqttPacket) error {
+	publish, err := packet.ParsePublish()
+	if err != nil {
+		return err
+	} else if publish.QoS > 1 {
+		return errMQTTQoSUnsupported
+	}
+	topic, ok := strings.CutPrefix(publish.Topic, mqttTopicPrefix)
+	if !ok || !topicRegex.MatchString(topic) {
+		return errMQTTTopicInvalid
+	}
+	req := s.newRequest(http.MethodPost, "/"+topic, bytes.NewReader(publish.Payload))
+	rr := httptest.NewRecorder()
+	s.server.handle(rr, req)
+	if rr.Code != http.StatusOK {
+		return fmt.Errorf("publishing to topic %s failed: %s", topic, strings.TrimSpace(rr.Body.String()))
+	}
+	if publish.QoS == 1 {
+		return s.write(mqttPacketPuback, 0, newMQTTPacketID(publish.PacketID))
+	}
+	return nil
+}
+
+// handleSubscribe handles SUBSCRIBE and UNSUBSCRIBE packets. Topic filters that are invalid or that the user
+// does not have read access to are rejected individually, as the MQTT spec intends.
+func (s *mqttSession) handleSubscribe(packet *mqttPacket) error {
+	if packet.Flags != 0x02 {
+		return errMQTTProtocolViolation // Reserved flags must be 0010, see MQTT spec 3.8.1-1
+	}
+	subscribe, err := packet.ParseSubscribe()
+	if err != nil {
+		return err
+	}
+	if packet.Type == mqttPacketUnsubscribe {
+		for _, filter := range subscribe.Topics {
+			s.unsubscribe(strings.TrimPrefix(filter, mqttTopicPrefix))
+		}
+		return s.write(mqttPacketUnsuback, 0, newMQTTPacketID(subscribe.PacketID))
+	}
+	returnCodes := make([]byte, len(subscribe.Topics))
+	for i, filter := range subscribe.Topics {
+		if err := s.subscribe(filter); err != nil {
+			logmq(s).Err(err).Debug("MQTT subscription to %s rejected", filter)
+			returnCodes[i] = mqttSubackFailure
+		} else {
+			returnCodes[i] = mqttSubscribeSuccess
+		}
+	}
+	return s.write(mqttPacketSuback, 0, newMQTTSuback(subscribe.PacketID, returnCodes))
+}
+
+func (s *mqttSession) subscribe(filter string) error {
+	topicID, ok := strings.CutPrefix(filter, mqttTopicPrefix)
+	if !ok || !topicRegex.MatchString(topicID) {
+		return errMQTTTopicInvalid
+	} else if _, ok := s.subscriptions[topicID]; ok {
+		return nil
+	}
+	if s.server.userManager != nil {
+		if err := s.server.userManager.Authorize(s.v.User(), topicID, user.PermissionRead); err != nil {
+			return err
+		}
+	}
+	t, err := s.server.topicFromID(topicID)
+	if err != nil {
+		return err
+	}
+	if !s.subscribed {
+		if !s.v.SubscriptionAllowed() {
+			return errHTTPTooManyRequestsLimitSubscriptions
+		}
+		s.subscribed = true
+	}
+	s.subscriptions[topicID] = t.Subscribe(s.queue.Enqueue, s.v.MaybeUserID(), s.cancel)
+	return nil
+}
+
+func (s *mqttSession) unsubscribe(topicID string) {
+	subscriberID, ok := s.subscriptions[topicID]
+	if !ok {
+		return
+	}
+	if t, err := s.server.topicFromID(topicID); err == nil {
+		t.Unsubscribe(subscriberID)
+	}
+	delete(s.subscriptions, topicID)
+}
+
+// deliver sends a ntfy message to the MQTT client. It is called by the subscriber queue.
+func (s *mqttSession) deliver(_ *visitor, m *message) error {
+	if m.Event != messageEvent {
+		return nil
+	}
+	payload, err := json.Marshal(s.server.withSignedAttachmentURLs(m))
+	if err != nil {
+		return err
+	}
+	return s.write(mqttPacketPublish, 0, newMQTTPublish(mqttTopicPrefix+m.Topic, payload))
+}
+
+func (s *mqttSession) write(packetType, flags byte, body []byte) error {
+	s.wlock.Lock()
+	defer s.wlock.Unlock()
+	if err := s.conn.SetWriteDeadline(time.Now().Add(s.server.config.SubscriberWriteTimeout)); err != nil {
+		return err
+	}
+	return writeMQTTPacket(s.conn, packetType, flags, body)
+}
+
+// newRequest creates a fake HTTP request for this session, so that authentication, access control and rate
+// limiting can be performed by the HTTP handlers, based on the client's IP address and credentials
+func (s *mqttSession) newRequest(method, path string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, path, body)
+	req.RemoteAddr = s.conn.RemoteAddr().String()
+	if s.authHeader != "" {
+		req.Header.Set("Authorization", s.authHeader)
+	}
+	return req
+}
+
+func (s *mqttSession) close() {
+	s.queue.Stop()
+	for topicID := range s.subscriptions {
+		s.unsubscribe(topicID)
+	}
+	if s.subscribed {
+		s.v.RemoveSubscription()
+	}
+}
+
+// mqttKeepAliveReader extends the read deadline of the connection with every read, so that clients are only
+// disconnected if they have not sent anything within the keep alive interval (including the grace period).
+// The timeout is only modified by the read loop, so it does not need to be synchronized.
+type mqttKeepAliveReader struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (r *mqttKeepAliveReader) Read(p []byte) (int, error) {
+	var deadline time.Time // Zero value means no deadline
+	if r.timeout > 0 {
+		deadline = time.Now().Add(r.timeout)
+	}
+	if err := r.conn.SetReadDeadline(deadline); err != nil {
+		return 0, err
+	}
+	return r.conn.Read(p)
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestMQTTServer_PublishAndSubscribe(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	addr := newTestMQTTListener(t, s)
+
+	sub := newTestMQTTClient(t, addr)
+	require.Equal(t, byte(mqttConnackAccepted), sub.Connect("", "", false))
+	require.Equal(t, []byte{mqttSubscribeSuccess, mqttSubackFailure, mqttSubackFailure}, sub.Subscribe(1, "ntfy/mytopic", "ntfy/#", "other/mytopic"))
+
+	pub := newTestMQTTClient(t, addr)
+	require.Equal(t, byte(mqttConnackAccepted), pub.Connect("", "", false))
+	pub.Publish("ntfy/mytopic", "hello from mqtt", 1, 17)
+	packet := pub.Read()
+	require.Equal(t, byte(mqttPacketPuback), packet.Type)
+	require.Equal(t, uint16(17), binary.BigEndian.Uint16(packet.Body))
+
+	// Subscriber receives JSON-encoded message
+	topic, payload := sub.ReadPublish()
+	require.Equal(t, "ntfy/mytopic", topic)
+	var m message
+	require.Nil(t, json.Unmarshal(payload, &m))
+	require.Equal(t, "mytopic", m.Topic)
+	require.Equal(t, "hello from mqtt", m.Message)
+
+	// Messages published via HTTP are forwarded, and MQTT messages are cached
+	response := request(t, s, "PUT", "/mytopic", "hello from http", nil)
+	require.Equal(t, 200, response.Code)
+	_, payload = sub.ReadPublish()
+	require.Nil(t, json.Unmarshal(payload, &m))
+	require.Equal(t, "hello from http", m.Message)
+
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, "hello from mqtt", messages[0].Message)
+
+	// Ping
+	sub.Write(mqttPacketPingreq, 0, nil)
+	require.Equal(t, byte(mqttPacketPingresp), sub.Read().Type)
+}
+
+func TestMQTTServer_Auth(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("phil", "mytopic", user.PermissionReadWrite))
+	require.Nil(t, s.userManager.AllowAccess(user.Everyone, "announcements", user.PermissionRead))
+	addr := newTestMQTTListener(t, s)
+
+	// Wrong password
+	client := newTestMQTTClient(t, addr)
+	require.Equal(t, byte(mqttConnackBadUsernamePassword), client.Connect("phil", "wrong", true))
+
+	// Anonymous: can only read announcements, publishing disconnects
+	anon := newTestMQTTClient(t, addr)
+	require.Equal(t, byte(mqttConnackAccepted), anon.Connect("", "", false))
+	require.Equal(t, []byte{mqttSubackFailure, mqttSubscribeSuccess}, anon.Subscribe(1, "ntfy/mytopic", "ntfy/announcements"))
+	anon.Publish("ntfy/mytopic", "not allowed", 1, 1)
+	_, err := readMQTTPacket(anon.reader, mqttMaxPacketSize)
+	require.Error(t, err)
+
+	// User can publish and subscribe
+	client = newTestMQTTClient(t, addr)
+	require.Equal(t, byte(mqttConnackAccepted), client.Connect("phil", "phil", true))
+	require.Equal(t, []byte{mqttSubscribeSuccess}, client.Subscribe(2, "ntfy/mytopic"))
+	client.Publish("ntfy/mytopic", "secret", 0, 0)
+	_, payload := client.ReadPublish()
+	var m message
+	require.Nil(t, json.Unmarshal(payload, &m))
+	require.Equal(t, "secret", m.Message)
+
+	response := request(t, s, "GET", "/mytopic/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, "secret", toMessage(t, response.Body.String()).Message)
+
+	// Attachment URLs are signed, since MQTT clients cannot pass credentials when downloading the file
+	response = request(t, s, "PUT", "/mytopic", util.RandomString(5000), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	_, payload = client.ReadPublish()
+	require.Nil(t, json.Unmarshal(payload, &m))
+	require.Contains(t, m.Attachment.URL, "?sig=")
+	response = request(t, s, "GET", strings.TrimPrefix(m.Attachment.URL, s.config.BaseURL), "", nil)
+	require.Equal(t, 200, response.Code)
+}
+
+func TestMQTTServer_InvalidProtocol(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	addr := newTestMQTTListener(t, s)
+
+	client := newTestMQTTClient(t, addr)
+	body := appendMQTTString(nil, "MQIsdp")
+	body = append(body, 3, 0x02, 0, 60)
+	body = appendMQTTString(body, "client")
+	client.Write(mqttPacketConnect, 0, body)
+	packet := client.Read()
+	require.Equal(t, byte(mqttPacketConnack), packet.Type)
+	require.Equal(t, byte(mqttConnackBadProtocolVersion), packet.Body[1])
+}
+
+func TestMQTTPacket_RemainingLength(t *testing.T) {
+	for _, length := range []int{0, 127, 128, 16383, 16384, 100000} {
+		conn1, conn2 := net.Pipe()
+		go func() {
+			require.Nil(t, writeMQTTPacket(conn1, mqttPacketPublish, 0x02, make([]byte, length)))
+		}()
+		packet, err := readMQTTPacket(bufio.NewReader(conn2), mqttMaxPacketSize)
+		require.Nil(t, err)
+		require.Equal(t, byte(mqttPacketPublish), packet.Type)
+		require.Equal(t, byte(0x02), packet.Flags)
+		require.Equal(t, length, len(packet.Body))
+		conn1.Close()
+		conn2.Close()
+	}
+}
+
+func newTestMQTTListener(t *testing.T, s *Server) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.handleMQTTConn(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+type testMQTTClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newTestMQTTClient(t *testing.T, addr string) *testMQTTClient {
+	conn, err := net.Dial("tcp", addr)
+	require.Nil(t, err)
+	require.Nil(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	t.Cleanup(func() { conn.Close() })
+	return &testMQTTClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (c *testMQTTClient) Connect(username, password string, auth bool) byte {
+	var flags byte = 0x02 // Clean session
+	if auth {
+		flags |= mqttConnectFlagUsername | mqttConnectFlagPassword
+	}
+	body := appendMQTTString(nil, mqttProtocolName)
+	body = append(body, mqttProtocolLevel, flags, 0, 60)
+	body = appendMQTTString(body, "test-client")
+	if auth {
+		body = appendMQTTString(body, username)
+		body = appendMQTTString(body, password)
+	}
+	c.Write(mqttPacketConnect, 0, body)
+	packet := c.Read()
+	require.Equal(c.t, byte(mqttPacketConnack), packet.Type)
+	return packet.Body[1]
+}
+
+func (c *testMQTTClient) Subscribe(packetID uint16, topics ...string) []byte {
+	body := newMQTTPacketID(packetID)
+	for _, topic := range topics {
+		body = append(appendMQTTString(body, topic), 0)
+	}
+	c.Write(mqttPacketSubscribe, 0x02, body)
+	packet := c.Read()
+	require.Equal(c.t, byte(mqttPacketSuback), packet.Type)
+	require.Equal(c.t, packetID, binary.BigEndian.Uint16(packet.Body))
+	return packet.Body[2:]
+}
+
+func (c *testMQTTClient) Publish(topic, message string, qos byte, packetID uint16) {
+	body := appendMQTTString(nil, topic)
+	if qos > 0 {
+		body = append(body, newMQTTPacketID(packetID)...)
+	}
+	c.Write(mqttPacketPublish, qos<<1, append(body, message...))
+}
+
+func (c *testMQTTClient) ReadPublish() (string, []byte) {
+	packet := c.Read()
+	require.Equal(c.t, byte(mqttPacketPublish), packet.Type)
+	publish, err := packet.ParsePublish()
+	require.Nil(c.t, err)
+	return publish.Topic, publish.Payload
+}
+
+func (c *testMQTTClient) Write(packetType, flags byte, body []byte) {
+	require.Nil(c.t, writeMQTTPacket(c.conn, packetType, flags, body))
+}
+
+func (c *testMQTTClient) Read() *mqttPacket {
+	packet, err := readMQTTPacket(c.reader, mqttMaxPacketSize)
+	require.Nil(c.t, err)
+	return packet
+}
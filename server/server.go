@@ -3,6 +3,7 @@ package server
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
 	"crypto/sha256"
 	"embed"
 	"encoding/base64"
@@ -14,11 +15,10 @@ import (
 	"net/http"
 	"net/http/pprof"
 	"net/netip"
-	"net/url"
 	"os"
-	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -31,6 +31,7 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 	"heckel.io/ntfy/v2/log"
 	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
@@ -46,6 +47,9 @@ type Server struct {
 	unixListener      net.Listener
 	smtpServer        *smtp.Server
 	smtpServerBackend *smtpBackend
+	mqttListener      net.Listener
+	syslogUDPConn     net.PacketConn
+	syslogTCPListener net.Listener
 	smtpSender        mailer
 	topics            map[string]*topic
 	visitors          map[string]*visitor // ip:<ip> or user:<user>
@@ -59,6 +63,13 @@ type Server struct {
 	stripe            stripeAPI                           // Stripe API, can be replaced with a mock
 	priceCache        *util.LookupCache[map[string]int64] // Stripe price ID -> price as cents (USD implied!)
 	metricsHandler    http.Handler                        // Handles /metrics if enable-metrics set, and listen-metrics-http not set
+	messageSigningKey ed25519.PrivateKey                  // Signs messages if message-signing-key is set, may be nil
+	fileSigningKey    []byte                              // Signs attachment download URLs, see loadFileSigningKey
+	routingRules      []*RoutingRule                      // Routing rules from the config and the user database
+	uploadsActive     map[string]bool                     // IDs of resumable uploads that are currently being written to
+	thumbnailLimiter  *semaphore.Weighted                 // Limits the number of thumbnails generated at the same time
+	relayClient       *http.Client                        // Downloads attachments of relayed messages
+	federationSeen    map[string]time.Time                // IDs of recently received federated messages, to drop replays
 	closeChan         chan bool
 	mu                sync.RWMutex
 }
@@ -74,9 +85,15 @@ var (
 	jsonPathRegex          = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}(,[-_A-Za-z0-9]{1,64})*/json$`)
 	ssePathRegex           = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}(,[-_A-Za-z0-9]{1,64})*/sse$`)
 	rawPathRegex           = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}(,[-_A-Za-z0-9]{1,64})*/raw$`)
+	rssPathRegex           = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}(,[-_A-Za-z0-9]{1,64})*/rss$`)
+	atomPathRegex          = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}(,[-_A-Za-z0-9]{1,64})*/atom$`)
 	wsPathRegex            = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}(,[-_A-Za-z0-9]{1,64})*/ws$`)
 	authPathRegex          = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}(,[-_A-Za-z0-9]{1,64})*/auth$`)
+	ackPathRegex           = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}(,[-_A-Za-z0-9]{1,64})*/ack$`)
+	consumerRegex          = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`)
 	publishPathRegex       = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}/(publish|send|trigger)$`)
+	uploadPathRegex        = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}/upload$`)
+	uploadIDPathRegex      = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}/upload/([-_A-Za-z0-9]{12})$`)
 
 	webConfigPath                                        = "/config.js"
 	webManifestPath                                      = "/manifest.webmanifest"
@@ -84,19 +101,28 @@ var (
 	webServiceWorkerPath                                 = "/sw.js"
 	accountPath                                          = "/account"
 	matrixPushPath                                       = "/_matrix/push/v1/notify"
+	messageSigningKeyPath                                = "/.well-known/ntfy-signing-key"
 	metricsPath                                          = "/metrics"
 	apiHealthPath                                        = "/v1/health"
+	apiPublishBatchPath                                  = "/v1/publish/batch"
 	apiStatsPath                                         = "/v1/stats"
 	apiWebPushPath                                       = "/v1/webpush"
 	apiTiersPath                                         = "/v1/tiers"
 	apiUsersPath                                         = "/v1/users"
 	apiUsersAccessPath                                   = "/v1/users/access"
+	apiRoutingRulesPath                                  = "/v1/rules"
+	apiRoutingRulesTestPath                              = "/v1/rules/test"
+	apiConsumersPath                                     = "/v1/consumers"
 	apiAccountPath                                       = "/v1/account"
 	apiAccountTokenPath                                  = "/v1/account/token"
+	apiAccountSignedURLPath                              = "/v1/account/signed-url"
 	apiAccountPasswordPath                               = "/v1/account/password"
 	apiAccountSettingsPath                               = "/v1/account/settings"
 	apiAccountSubscriptionPath                           = "/v1/account/subscription"
 	apiAccountReservationPath                            = "/v1/account/reservation"
+	apiAccountWebhookPath                                = "/v1/account/webhook"
+	apiAccountTopicLinkPath                              = "/v1/account/link"
+	apiAccountTopicRetentionPath                         = "/v1/account/retention"
 	apiAccountPhonePath                                  = "/v1/account/phone"
 	apiAccountPhoneVerifyPath                            = "/v1/account/phone/verify"
 	apiAccountBillingPortalPath                          = "/v1/account/billing/portal"
@@ -105,11 +131,15 @@ var (
 	apiAccountBillingSubscriptionCheckoutSuccessTemplate = "/v1/account/billing/subscription/success/{CHECKOUT_SESSION_ID}"
 	apiAccountBillingSubscriptionCheckoutSuccessRegex    = regexp.MustCompile(`/v1/account/billing/subscription/success/(.+)$`)
 	apiAccountReservationSingleRegex                     = regexp.MustCompile(`/v1/account/reservation/([-_A-Za-z0-9]{1,64})$`)
+	apiAccountWebhookSingleRegex                         = regexp.MustCompile(`/v1/account/webhook/([-_A-Za-z0-9]{1,64})$`)
+	apiAccountTopicLinkSingleRegex                       = regexp.MustCompile(`/v1/account/link/([-_A-Za-z0-9]{1,64})/([-_A-Za-z0-9]{1,64})$`)
+	apiAccountTopicRetentionSingleRegex                  = regexp.MustCompile(`/v1/account/retention/([-_A-Za-z0-9]{1,64})$`)
 	staticRegex                                          = regexp.MustCompile(`^/static/.+`)
 	docsRegex                                            = regexp.MustCompile(`^/docs(|/.*)$`)
 	fileRegex                                            = regexp.MustCompile(`^/file/([-_A-Za-z0-9]{1,64})(?:\.[A-Za-z0-9]{1,16})?$`)
 	urlRegex                                             = regexp.MustCompile(`^https?://`)
 	phoneNumberRegex                                     = regexp.MustCompile(`^\+\d{1,100}$`)
+	labelKeyRegex                                        = regexp.MustCompile(`^[a-z0-9][-_a-z0-9]{0,31}$`)
 
 	//go:embed site
 	webFs       embed.FS
@@ -129,11 +159,19 @@ const (
 	newMessageBody           = "New message"             // Used in poll requests as generic message
 	defaultAttachmentMessage = "You received a file: %s" // Used if message body is empty, and there is an attachment
 	encodingBase64           = "base64"                  // Used mainly for binary UnifiedPush messages
+	encodingJWE              = "jwe"                     // End-to-end encrypted message, JWE in compact serialization
 	jsonBodyBytesLimit       = 32768                     // Max number of bytes for a request bodys (unless MessageLimit is higher)
 	unifiedPushTopicPrefix   = "up"                      // Temporarily, we rate limit all "up*" topics based on the subscriber
 	unifiedPushTopicLength   = 14                        // Length of UnifiedPush topics, including the "up" part
 	messagesHistoryMax       = 10                        // Number of message count values to keep in memory
 	templateMaxExecutionTime = 100 * time.Millisecond
+	labelsLimit              = 16  // Max number of labels per message
+	labelValueLengthLimit    = 256 // Max length of a label value
+	attachmentsLimit         = 10  // Max number of attachments per message
+
+	consumerAnonymousOwnerPrefix = "ip:"               // Prefix of the owner of anonymous durable consumers, see consumerOwner
+	consumerLimit                = 20                  // Max number of durable consumers per user or IP address
+	consumerExpiryDuration       = 30 * 24 * time.Hour // Durable consumers are deleted if they were not used for this long
 )
 
 var (
@@ -144,7 +182,6 @@ var (
 
 // WebSocket constants
 const (
-	wsWriteWait  = 2 * time.Second
 	wsBufferSize = 1024
 	wsReadLimit  = 64 // We only ever receive PINGs
 	wsPongWait   = 15 * time.Second
@@ -187,6 +224,10 @@ func New(conf *Config) (*Server, error) {
 			return nil, err
 		}
 	}
+	fileSigningKey, err := loadFileSigningKey(conf)
+	if err != nil {
+		return nil, err
+	}
 	var userManager *user.Manager
 	if conf.AuthFile != "" {
 		userManager, err = user.NewManager(conf.AuthFile, conf.AuthStartupQueries, conf.AuthDefault, conf.AuthBcryptCost, conf.AuthStatsQueueWriterInterval)
@@ -194,6 +235,13 @@ func New(conf *Config) (*Server, error) {
 			return nil, err
 		}
 	}
+	var messageSigningKey ed25519.PrivateKey
+	if conf.MessageSigningKey != "" {
+		messageSigningKey, err = parseMessageSigningKey(conf.MessageSigningKey)
+		if err != nil {
+			return nil, err
+		}
+	}
 	var firebaseClient *firebaseClient
 	if conf.FirebaseKeyFile != "" {
 		sender, err := newFirebaseSender(conf.FirebaseKeyFile)
@@ -209,20 +257,29 @@ func New(conf *Config) (*Server, error) {
 		firebaseClient = newFirebaseClient(sender, auther)
 	}
 	s := &Server{
-		config:          conf,
-		messageCache:    messageCache,
-		webPush:         webPush,
-		fileCache:       fileCache,
-		firebaseClient:  firebaseClient,
-		smtpSender:      mailer,
-		topics:          topics,
-		userManager:     userManager,
-		messages:        messages,
-		messagesHistory: []int64{messages},
-		visitors:        make(map[string]*visitor),
-		stripe:          stripe,
+		config:            conf,
+		messageCache:      messageCache,
+		webPush:           webPush,
+		fileCache:         fileCache,
+		firebaseClient:    firebaseClient,
+		smtpSender:        mailer,
+		topics:            topics,
+		userManager:       userManager,
+		messages:          messages,
+		messagesHistory:   []int64{messages},
+		visitors:          make(map[string]*visitor),
+		uploadsActive:     make(map[string]bool),
+		federationSeen:    make(map[string]time.Time),
+		thumbnailLimiter:  semaphore.NewWeighted(thumbnailConcurrencyLimit),
+		relayClient:       newRelayAttachmentClient(conf.RelaySubscriptions),
+		stripe:            stripe,
+		messageSigningKey: messageSigningKey,
+		fileSigningKey:    fileSigningKey,
 	}
 	s.priceCache = util.NewLookupCache(s.fetchStripePrices, conf.StripePriceCacheDuration)
+	if err := s.loadRoutingRules(); err != nil {
+		return nil, err
+	}
 	return s, nil
 }
 
@@ -251,6 +308,12 @@ func (s *Server) Run() error {
 	if s.config.SMTPServerListen != "" {
 		listenStr += fmt.Sprintf(" %s[smtp]", s.config.SMTPServerListen)
 	}
+	if s.config.MQTTListen != "" {
+		listenStr += fmt.Sprintf(" %s[mqtt]", s.config.MQTTListen)
+	}
+	if s.config.SyslogListen != "" {
+		listenStr += fmt.Sprintf(" %s[syslog]", s.config.SyslogListen)
+	}
 	if s.config.MetricsListenHTTP != "" {
 		listenStr += fmt.Sprintf(" %s[http/metrics]", s.config.MetricsListenHTTP)
 	}
@@ -330,11 +393,24 @@ func (s *Server) Run() error {
 			errChan <- s.runSMTPServer()
 		}()
 	}
+	if s.config.MQTTListen != "" {
+		go func() {
+			errChan <- s.runMQTTServer()
+		}()
+	}
+	if s.config.SyslogListen != "" {
+		go func() {
+			errChan <- s.runSyslogServer()
+		}()
+	}
 	s.mu.Unlock()
 	go s.runManager()
 	go s.runStatsResetter()
 	go s.runDelayedSender()
 	go s.runFirebaseKeepaliver()
+	if len(s.config.RelaySubscriptions) > 0 {
+		go s.runRelay()
+	}
 
 	return <-errChan
 }
@@ -355,6 +431,15 @@ func (s *Server) Stop() {
 	if s.smtpServer != nil {
 		s.smtpServer.Close()
 	}
+	if s.mqttListener != nil {
+		s.mqttListener.Close()
+	}
+	if s.syslogUDPConn != nil {
+		s.syslogUDPConn.Close()
+	}
+	if s.syslogTCPListener != nil {
+		s.syslogTCPListener.Close()
+	}
 	s.closeDatabases()
 	close(s.closeChan)
 }
@@ -440,6 +525,8 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 		return s.ensureWebEnabled(s.handleEmpty)(w, r, v)
 	} else if r.Method == http.MethodGet && r.URL.Path == apiHealthPath {
 		return s.handleHealth(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == messageSigningKeyPath && s.messageSigningKey != nil {
+		return s.handleMessageSigningKey(w, r, v)
 	} else if r.Method == http.MethodGet && r.URL.Path == webConfigPath {
 		return s.ensureWebEnabled(s.handleWebConfig)(w, r, v)
 	} else if r.Method == http.MethodGet && r.URL.Path == webManifestPath {
@@ -456,6 +543,16 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 		return s.ensureAdmin(s.handleAccessAllow)(w, r, v)
 	} else if r.Method == http.MethodDelete && r.URL.Path == apiUsersAccessPath {
 		return s.ensureAdmin(s.handleAccessReset)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiRoutingRulesPath {
+		return s.ensureAdmin(s.handleRoutingRulesGet)(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiRoutingRulesPath {
+		return s.ensureAdmin(s.handleRoutingRulesAdd)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiRoutingRulesPath {
+		return s.ensureAdmin(s.handleRoutingRulesDelete)(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiRoutingRulesTestPath {
+		return s.ensureAdmin(s.handleRoutingRulesTest)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiConsumersPath {
+		return s.ensureAdmin(s.handleConsumersGet)(w, r, v)
 	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountPath {
 		return s.ensureUserManager(s.handleAccountCreate)(w, r, v)
 	} else if r.Method == http.MethodGet && r.URL.Path == apiAccountPath {
@@ -470,6 +567,8 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 		return s.ensureUser(s.withAccountSync(s.handleAccountTokenUpdate))(w, r, v)
 	} else if r.Method == http.MethodDelete && r.URL.Path == apiAccountTokenPath {
 		return s.ensureUser(s.withAccountSync(s.handleAccountTokenDelete))(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountSignedURLPath && s.config.PublishSigningKey != "" {
+		return s.ensureUser(s.handleAccountSignedURLCreate)(w, r, v)
 	} else if r.Method == http.MethodPatch && r.URL.Path == apiAccountSettingsPath {
 		return s.ensureUser(s.withAccountSync(s.handleAccountSettingsChange))(w, r, v)
 	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountSubscriptionPath {
@@ -482,6 +581,18 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 		return s.ensureUser(s.withAccountSync(s.handleAccountReservationAdd))(w, r, v)
 	} else if r.Method == http.MethodDelete && apiAccountReservationSingleRegex.MatchString(r.URL.Path) {
 		return s.ensureUser(s.withAccountSync(s.handleAccountReservationDelete))(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountWebhookPath {
+		return s.ensureUser(s.withAccountSync(s.handleAccountWebhookAdd))(w, r, v)
+	} else if r.Method == http.MethodDelete && apiAccountWebhookSingleRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.withAccountSync(s.handleAccountWebhookDelete))(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountTopicLinkPath {
+		return s.ensureUser(s.withAccountSync(s.handleAccountTopicLinkAdd))(w, r, v)
+	} else if r.Method == http.MethodDelete && apiAccountTopicLinkSingleRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.withAccountSync(s.handleAccountTopicLinkDelete))(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountTopicRetentionPath {
+		return s.ensureUser(s.withAccountSync(s.handleAccountTopicRetentionChange))(w, r, v)
+	} else if r.Method == http.MethodDelete && apiAccountTopicRetentionSingleRegex.MatchString(r.URL.Path) {
+		return s.ensureUser(s.withAccountSync(s.handleAccountTopicRetentionDelete))(w, r, v)
 	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountBillingSubscriptionPath {
 		return s.ensurePaymentsEnabled(s.ensureUser(s.handleAccountBillingSubscriptionCreate))(w, r, v) // Account sync via incoming Stripe webhook
 	} else if r.Method == http.MethodGet && apiAccountBillingSubscriptionCheckoutSuccessRegex.MatchString(r.URL.Path) {
@@ -504,6 +615,8 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 		return s.ensureWebPushEnabled(s.limitRequests(s.handleWebPushUpdate))(w, r, v)
 	} else if r.Method == http.MethodDelete && apiWebPushPath == r.URL.Path {
 		return s.ensureWebPushEnabled(s.limitRequests(s.handleWebPushDelete))(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == federationPublishPath && len(s.config.FederationPeers) > 0 {
+		return s.handleFederationPublish(w, r, v) // Requests are signed by the peer, and not rate limited
 	} else if r.Method == http.MethodGet && r.URL.Path == apiStatsPath {
 		return s.handleStats(w, r, v)
 	} else if r.Method == http.MethodGet && r.URL.Path == apiTiersPath {
@@ -520,24 +633,46 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 		return s.limitRequests(s.handleFile)(w, r, v)
 	} else if r.Method == http.MethodOptions {
 		return s.limitRequests(s.handleOptions)(w, r, v) // Should work even if the web app is not enabled, see #598
+	} else if r.Method == http.MethodPost && r.URL.Path == apiPublishBatchPath {
+		return s.limitRequests(s.handlePublishBatch)(w, r, v)
 	} else if (r.Method == http.MethodPut || r.Method == http.MethodPost) && r.URL.Path == "/" {
 		return s.transformBodyJSON(s.limitRequestsWithTopic(s.authorizeTopicWrite(s.handlePublish)))(w, r, v)
 	} else if r.Method == http.MethodPost && r.URL.Path == matrixPushPath {
 		return s.transformMatrixJSON(s.limitRequestsWithTopic(s.authorizeTopicWrite(s.handlePublishMatrix)))(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == gotifyMessagePath && len(s.config.GotifyApps) > 0 {
+		return s.transformGotifyMessage(s.limitRequestsWithTopic(s.authorizeTopicWrite(s.handlePublishGotify)))(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == gotifyStreamPath && len(s.config.GotifyApps) > 0 {
+		return s.transformGotifyStream(s.limitRequests(s.authorizeTopicRead(s.handleSubscribeGotifyWS)))(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == pushoverMessagesPath && s.config.EnablePushover {
+		return s.pushoverErrors(s.transformPushover(s.limitRequestsWithTopic(s.authorizeTopicWrite(s.handlePublishPushover))))(w, r, v)
 	} else if (r.Method == http.MethodPut || r.Method == http.MethodPost) && topicPathRegex.MatchString(r.URL.Path) {
 		return s.limitRequestsWithTopic(s.authorizeTopicWrite(s.handlePublish))(w, r, v)
 	} else if r.Method == http.MethodGet && publishPathRegex.MatchString(r.URL.Path) {
 		return s.limitRequestsWithTopic(s.authorizeTopicWrite(s.handlePublish))(w, r, v)
+	} else if r.Method == http.MethodPost && uploadPathRegex.MatchString(r.URL.Path) && s.config.AttachmentCacheDir != "" {
+		return s.limitRequestsWithTopic(s.authorizeTopicWrite(s.handleUploadCreate))(w, r, v)
+	} else if r.Method == http.MethodHead && uploadIDPathRegex.MatchString(r.URL.Path) && s.config.AttachmentCacheDir != "" {
+		return s.limitRequestsWithTopic(s.authorizeTopicWrite(s.handleUploadHead))(w, r, v)
+	} else if r.Method == http.MethodPatch && uploadIDPathRegex.MatchString(r.URL.Path) && s.config.AttachmentCacheDir != "" {
+		return s.limitRequestsWithTopic(s.authorizeTopicWrite(s.handleUploadPatch))(w, r, v)
+	} else if r.Method == http.MethodDelete && uploadIDPathRegex.MatchString(r.URL.Path) && s.config.AttachmentCacheDir != "" {
+		return s.limitRequestsWithTopic(s.authorizeTopicWrite(s.handleUploadDelete))(w, r, v)
 	} else if r.Method == http.MethodGet && jsonPathRegex.MatchString(r.URL.Path) {
 		return s.limitRequests(s.authorizeTopicRead(s.handleSubscribeJSON))(w, r, v)
 	} else if r.Method == http.MethodGet && ssePathRegex.MatchString(r.URL.Path) {
 		return s.limitRequests(s.authorizeTopicRead(s.handleSubscribeSSE))(w, r, v)
 	} else if r.Method == http.MethodGet && rawPathRegex.MatchString(r.URL.Path) {
 		return s.limitRequests(s.authorizeTopicRead(s.handleSubscribeRaw))(w, r, v)
+	} else if r.Method == http.MethodGet && rssPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.authorizeTopicRead(s.handleSubscribeRSS))(w, r, v)
+	} else if r.Method == http.MethodGet && atomPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.authorizeTopicRead(s.handleSubscribeAtom))(w, r, v)
 	} else if r.Method == http.MethodGet && wsPathRegex.MatchString(r.URL.Path) {
 		return s.limitRequests(s.authorizeTopicRead(s.handleSubscribeWS))(w, r, v)
 	} else if r.Method == http.MethodGet && authPathRegex.MatchString(r.URL.Path) {
 		return s.limitRequests(s.authorizeTopicRead(s.handleTopicAuth))(w, r, v)
+	} else if (r.Method == http.MethodPut || r.Method == http.MethodPost) && ackPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.authorizeTopicRead(s.handleSubscribeAck))(w, r, v)
 	} else if r.Method == http.MethodGet && (topicPathRegex.MatchString(r.URL.Path) || externalTopicPathRegex.MatchString(r.URL.Path)) {
 		return s.ensureWebEnabled(s.handleTopic)(w, r, v)
 	}
@@ -666,8 +801,12 @@ func (s *Server) handleFile(w http.ResponseWriter, r *http.Request, v *visitor)
 	if len(matches) != 2 {
 		return errHTTPInternalErrorInvalidPath
 	}
-	messageID := matches[1]
-	file := filepath.Join(s.config.AttachmentCacheDir, messageID)
+	fileID, thumbnail := parseThumbnailFileID(matches[1])
+	messageID, index, ok := parseAttachmentFileID(fileID)
+	if !ok {
+		return errHTTPNotFound
+	}
+	file := filepath.Join(s.config.AttachmentCacheDir, matches[1])
 	stat, err := os.Stat(file)
 	if err != nil {
 		return errHTTPNotFound.Fields(log.Context{
@@ -675,15 +814,7 @@ func (s *Server) handleFile(w http.ResponseWriter, r *http.Request, v *visitor)
 			"error_context": "filesystem",
 		})
 	}
-	w.Header().Set("Access-Control-Allow-Origin", s.config.AccessControlAllowOrigin) // CORS, allow cross-origin requests
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", stat.Size()))
-	if r.Method == http.MethodHead {
-		return nil
-	}
-	// Find message in database, and associate bandwidth to the uploader user
-	// This is an easy way to
-	//   - avoid abuse (e.g. 1 uploader, 1k downloaders)
-	//   - and also uses the higher bandwidth limits of a paying user
+	// Find message in database, to check access to its topic
 	m, err := s.messageCache.Message(messageID)
 	if errors.Is(err, errMessageNotFound) {
 		if s.config.CacheBatchTimeout > 0 {
@@ -702,6 +833,27 @@ func (s *Server) handleFile(w http.ResponseWriter, r *http.Request, v *visitor)
 	} else if err != nil {
 		return err
 	}
+	attachments := m.allAttachments()
+	if index >= len(attachments) {
+		return errHTTPNotFound.Fields(log.Context{
+			"message_id":    messageID,
+			"error_context": "message_cache",
+		})
+	}
+	if err := s.authorizeFileRead(r, v, m, matches[1]); err != nil {
+		return err
+	}
+	w.Header().Set("Access-Control-Allow-Origin", s.config.AccessControlAllowOrigin) // CORS, allow cross-origin requests
+	w.Header().Set("ETag", attachmentETag(matches[1], stat))
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", stat.Size()))
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Last-Modified", stat.ModTime().UTC().Format(http.TimeFormat))
+		return nil
+	}
+	// Associate bandwidth to the uploader user. This is an easy way to
+	//   - avoid abuse (e.g. 1 uploader, 1k downloaders)
+	//   - and also uses the higher bandwidth limits of a paying user
 	bandwidthVisitor := v
 	if s.userManager != nil && m.User != "" {
 		u, err := s.userManager.UserByID(m.User)
@@ -712,20 +864,28 @@ func (s *Server) handleFile(w http.ResponseWriter, r *http.Request, v *visitor)
 	} else if m.Sender.IsValid() {
 		bandwidthVisitor = s.visitor(m.Sender, nil)
 	}
-	if !bandwidthVisitor.BandwidthAllowed(stat.Size()) {
-		return errHTTPTooManyRequestsLimitAttachmentBandwidth.With(m)
-	}
-	// Actually send file
+	// Actually send file; http.ServeContent takes care of range and conditional requests (If-None-Match,
+	// If-Modified-Since), and the bandwidth is only charged for the bytes that are actually served
 	f, err := os.Open(file)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	if m.Attachment.Name != "" {
-		w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(m.Attachment.Name))
+	head := make([]byte, 512)
+	n, err := f.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return err
 	}
-	_, err = io.Copy(util.NewContentTypeWriter(w, r.URL.Path), f)
-	return err
+	w.Header().Set("Content-Type", util.DetectSafeContentType(head[:n], r.URL.Path))
+	if attachments[index].Name != "" && !thumbnail {
+		w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(attachments[index].Name))
+	}
+	bw := newBandwidthLimitedResponseWriter(w, bandwidthVisitor)
+	http.ServeContent(bw, r, "", stat.ModTime(), f)
+	if bw.limited {
+		return errHTTPTooManyRequestsLimitAttachmentBandwidth.With(m)
+	}
+	return nil
 }
 
 func (s *Server) handleMatrixDiscovery(w http.ResponseWriter) error {
@@ -736,41 +896,58 @@ func (s *Server) handleMatrixDiscovery(w http.ResponseWriter) error {
 }
 
 func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*message, error) {
+	m, cache, err := s.handlePublishWithoutCache(r, v)
+	if err != nil {
+		return nil, err
+	}
+	if cache {
+		logvrm(v, r, m).Tag(tagPublish).Debug("Adding message to cache")
+		if err := s.messageCache.AddMessage(m); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// handlePublishWithoutCache parses and publishes a message to the topic in the request context, but does not add
+// it to the message cache. The second return value defines whether the message must be added to the cache by
+// the caller. This allows the batch publishing endpoint to add all messages in one transaction.
+func (s *Server) handlePublishWithoutCache(r *http.Request, v *visitor) (*message, bool, error) {
 	start := time.Now()
 	t, err := fromContext[*topic](r, contextTopic)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	vrate, err := fromContext[*visitor](r, contextRateVisitor)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	body, err := util.Peek(r.Body, s.config.MessageSizeLimit)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	m := newDefaultMessage(t.ID, "")
 	cache, firebase, email, call, template, unifiedpush, e := s.parsePublishParams(r, m)
 	if e != nil {
-		return nil, e.With(t)
+		return nil, false, e.With(t)
 	}
 	if unifiedpush && s.config.VisitorSubscriberRateLimiting && t.RateVisitor() == nil {
 		// UnifiedPush clients must subscribe before publishing to allow proper subscriber-based rate limiting.
 		// The 5xx response is because some app servers (in particular Mastodon) will remove
 		// the subscription as invalid if any 400-499 code (except 429/408) is returned.
 		// See https://github.com/mastodon/mastodon/blob/730bb3e211a84a2f30e3e2bbeae3f77149824a68/app/workers/web/push_notification_worker.rb#L35-L46
-		return nil, errHTTPInsufficientStorageUnifiedPush.With(t)
+		return nil, false, errHTTPInsufficientStorageUnifiedPush.With(t)
 	} else if !util.ContainsIP(s.config.VisitorRequestExemptPrefixes, v.ip) && !vrate.MessageAllowed() {
-		return nil, errHTTPTooManyRequestsLimitMessages.With(t)
+		return nil, false, errHTTPTooManyRequestsLimitMessages.With(t)
 	} else if email != "" && !vrate.EmailAllowed() {
-		return nil, errHTTPTooManyRequestsLimitEmails.With(t)
+		return nil, false, errHTTPTooManyRequestsLimitEmails.With(t)
 	} else if call != "" {
 		var httpErr *errHTTP
 		call, httpErr = s.convertPhoneNumber(v.User(), call)
 		if httpErr != nil {
-			return nil, httpErr.With(t)
+			return nil, false, httpErr.With(t)
 		} else if !vrate.CallAllowed() {
-			return nil, errHTTPTooManyRequestsLimitCalls.With(t)
+			return nil, false, errHTTPTooManyRequestsLimitCalls.With(t)
 		}
 	}
 	if m.PollID != "" {
@@ -779,24 +956,47 @@ func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*message, e
 	m.Sender = v.IP()
 	m.User = v.MaybeUserID()
 	if cache {
+		expires := m.Expires // Set if the publisher passed a TTL
 		m.Expires = time.Unix(m.Time, 0).Add(v.Limits().MessageExpiryDuration).Unix()
+		if cache, err = s.applyTopicRetention(m); err != nil {
+			return nil, false, err
+		}
+		if expires > 0 && expires < m.Expires {
+			m.Expires = expires
+		}
 	}
 	if err := s.handlePublishBody(r, v, m, body, template, unifiedpush); err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	if m.Message == "" {
 		m.Message = emptyMessageBody
 	}
+	routing := s.routeMessage(m)
+	if routing.Drop {
+		logvrm(v, r, m).Tag(tagPublish).Field("message_routing_rules", routing.Rules).Debug("Message dropped by routing rule")
+		return m, false, nil
+	} else if routing.Firebase != nil {
+		firebase = *routing.Firebase
+	}
+	s.limitRoutedMessage(v, vrate, m, routing)
+	if err := s.signMessage(m); err != nil {
+		return nil, false, err
+	}
 	delayed := m.Time > time.Now().Unix()
+	if delayed {
+		routing.Firebase = &firebase
+		m.Routing = routing // Applied when the message is sent, see sendDelayedMessage
+	}
 	ev := logvrm(v, r, m).
 		Tag(tagPublish).
 		With(t).
 		Fields(log.Context{
-			"message_delayed":     delayed,
-			"message_firebase":    firebase,
-			"message_unifiedpush": unifiedpush,
-			"message_email":       email,
-			"message_call":        call,
+			"message_delayed":       delayed,
+			"message_firebase":      firebase,
+			"message_unifiedpush":   unifiedpush,
+			"message_email":         email,
+			"message_call":          call,
+			"message_routing_rules": routing.Rules,
 		})
 	if ev.IsTrace() {
 		ev.Field("message_body", util.MaybeMarshalJSON(m)).Trace("Received message")
@@ -805,7 +1005,7 @@ func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*message, e
 	}
 	if !delayed {
 		if err := t.Publish(v, m); err != nil {
-			return nil, err
+			return nil, false, err
 		}
 		if s.firebaseClient != nil && firebase {
 			go s.sendToFirebase(v, m)
@@ -814,23 +1014,36 @@ func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*message, e
 			go s.sendEmail(v, m, email)
 		}
 		if s.config.TwilioAccount != "" && call != "" {
-			go s.callPhone(v, r, m, call)
+			go s.callPhone(v, m, call)
+		}
+		if s.smtpSender != nil {
+			for _, routingEmail := range routing.Emails {
+				go s.sendEmail(v, m, routingEmail)
+			}
+		}
+		if s.config.TwilioAccount != "" {
+			for _, routingCall := range routing.Calls {
+				go s.callPhone(v, m, routingCall)
+			}
 		}
 		if s.config.UpstreamBaseURL != "" && !unifiedpush { // UP messages are not sent to upstream
 			go s.forwardPollRequest(v, m)
 		}
+		if len(s.config.FederationPeers) > 0 && !unifiedpush {
+			go s.federateMessage(v, m, nil)
+		}
 		if s.config.WebPushPublicKey != "" {
 			go s.publishToWebPushEndpoints(v, m)
 		}
+		if s.userManager != nil && !unifiedpush {
+			go s.fanOutMessage(v, m, cache)
+		}
+		if len(routing.Forward) > 0 {
+			go s.forwardRoutedMessage(v, m, routing.Forward, cache)
+		}
 	} else {
 		logvrm(v, r, m).Tag(tagPublish).Debug("Message delayed, will process later")
 	}
-	if cache {
-		logvrm(v, r, m).Tag(tagPublish).Debug("Adding message to cache")
-		if err := s.messageCache.AddMessage(m); err != nil {
-			return nil, err
-		}
-	}
 	u := v.User()
 	if s.userManager != nil && u != nil && u.Tier != nil {
 		go s.userManager.EnqueueUserStats(u.ID, v.Stats())
@@ -842,7 +1055,7 @@ func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*message, e
 		minc(metricUnifiedPushPublishedSuccess)
 	}
 	mset(metricMessagePublishDurationMillis, time.Since(start).Milliseconds())
-	return m, nil
+	return m, cache, nil
 }
 
 func (s *Server) handlePublish(w http.ResponseWriter, r *http.Request, v *visitor) error {
@@ -852,7 +1065,7 @@ func (s *Server) handlePublish(w http.ResponseWriter, r *http.Request, v *visito
 		return err
 	}
 	minc(metricMessagesPublishedSuccess)
-	return s.writeJSON(w, m)
+	return s.writeJSON(w, s.withSignedAttachmentURLs(m))
 }
 
 func (s *Server) handlePublishMatrix(w http.ResponseWriter, r *http.Request, v *visitor) error {
@@ -882,7 +1095,7 @@ func (s *Server) handlePublishMatrix(w http.ResponseWriter, r *http.Request, v *
 
 func (s *Server) sendToFirebase(v *visitor, m *message) {
 	logvm(v, m).Tag(tagFirebase).Debug("Publishing to Firebase")
-	if err := s.firebaseClient.Send(v, m); err != nil {
+	if err := s.firebaseClient.Send(v, s.withSignedAttachmentURLs(m)); err != nil {
 		minc(metricFirebasePublishedFailure)
 		if errors.Is(err, errFirebaseTemporarilyBanned) {
 			logvm(v, m).Tag(tagFirebase).Err(err).Debug("Unable to publish to Firebase: %v", err.Error())
@@ -896,7 +1109,7 @@ func (s *Server) sendToFirebase(v *visitor, m *message) {
 
 func (s *Server) sendEmail(v *visitor, m *message, email string) {
 	logvm(v, m).Tag(tagEmail).Field("email", email).Debug("Sending email to %s", email)
-	if err := s.smtpSender.Send(v, m, email); err != nil {
+	if err := s.smtpSender.Send(v, s.withSignedAttachmentURLs(m), email); err != nil {
 		logvm(v, m).Tag(tagEmail).Field("email", email).Err(err).Warn("Unable to send email to %s: %v", email, err.Error())
 		minc(metricEmailsPublishedFailure)
 		return
@@ -943,30 +1156,23 @@ func (s *Server) parsePublishParams(r *http.Request, m *message) (cache bool, fi
 	m.Click = readParam(r, "x-click", "click")
 	icon := readParam(r, "x-icon", "icon")
 	filename := readParam(r, "x-filename", "filename", "file", "f")
-	attach := readParam(r, "x-attach", "attach", "a")
-	if attach != "" || filename != "" {
-		m.Attachment = &attachment{}
+	attachURLs := readMultiParam(r, "x-attach", "attach", "a")
+	if len(attachURLs) > attachmentsLimit {
+		return false, false, "", "", false, false, errHTTPBadRequestAttachmentsTooMany
+	} else if len(attachURLs) == 0 && filename != "" {
+		m.Attachment = &attachment{Name: filename}
 	}
-	if filename != "" {
-		m.Attachment.Name = filename
-	}
-	if attach != "" {
+	for i, attach := range attachURLs {
 		if !urlRegex.MatchString(attach) {
 			return false, false, "", "", false, false, errHTTPBadRequestAttachmentURLInvalid
 		}
-		m.Attachment.URL = attach
-		if m.Attachment.Name == "" {
-			u, err := url.Parse(m.Attachment.URL)
-			if err == nil {
-				m.Attachment.Name = path.Base(u.Path)
-				if m.Attachment.Name == "." || m.Attachment.Name == "/" {
-					m.Attachment.Name = ""
-				}
-			}
-		}
-		if m.Attachment.Name == "" {
-			m.Attachment.Name = "attachment"
+		a := &attachment{URL: attach}
+		if i == 0 && filename != "" {
+			a.Name = filename
+		} else {
+			a.Name = attachmentNameFromURL(attach)
 		}
+		m.addAttachment(a)
 	}
 	if icon != "" {
 		if !urlRegex.MatchString(icon) {
@@ -1015,6 +1221,18 @@ func (s *Server) parsePublishParams(r *http.Request, m *message) (cache bool, fi
 		}
 		m.Time = delay.Unix()
 	}
+	expiresStr := readParam(r, "x-expires", "expires", "x-ttl", "ttl")
+	if expiresStr != "" {
+		expires, err := util.ParseFutureTime(expiresStr, time.Unix(m.Time, 0))
+		if err != nil || expires.Unix() <= m.Time {
+			return false, false, "", "", false, false, errHTTPBadRequestExpiresInvalid
+		}
+		m.Expires = expires.Unix() // May only shorten the expiry, see handlePublishWithoutCache
+	}
+	m.Labels, e = parseLabels(readLabelParams(r))
+	if e != nil {
+		return false, false, "", "", false, false, errHTTPBadRequestLabelsInvalid.Wrap("%s", e.Error())
+	}
 	actionsStr := readParam(r, "x-actions", "actions", "action")
 	if actionsStr != "" {
 		m.Actions, e = parseActions(actionsStr)
@@ -1033,6 +1251,13 @@ func (s *Server) parsePublishParams(r *http.Request, m *message) (cache bool, fi
 		firebase = false
 		unifiedpush = true
 	}
+	encoding := readParam(r, "x-encoding", "encoding")
+	if encoding != "" && encoding != encodingJWE {
+		return false, false, "", "", false, false, errHTTPBadRequestEncodingInvalid
+	} else if encoding == encodingJWE && (m.Attachment != nil || template || unifiedpush || email != "" || call != "") {
+		return false, false, "", "", false, false, errHTTPBadRequestEncryptedMessageInvalid
+	}
+	m.Encoding = encoding
 	m.PollID = readParam(r, "x-poll-id", "poll-id")
 	if m.PollID != "" {
 		unifiedpush = false
@@ -1046,33 +1271,45 @@ func (s *Server) parsePublishParams(r *http.Request, m *message) (cache bool, fi
 //
 //  1. curl -X POST -H "Poll: 1234" ntfy.sh/...
 //     If a message is flagged as poll request, the body does not matter and is discarded
-//  2. curl -T somebinarydata.bin "ntfy.sh/mytopic?up=1"
+//  2. curl -H "Encoding: jwe" -d "eyJhbGciOi..." ntfy.sh/mytopic
+//     If the message is end-to-end encrypted, the body must be a JWE, and is stored as is
+//  3. curl -T somebinarydata.bin "ntfy.sh/mytopic?up=1"
 //     If UnifiedPush is enabled, encode as base64 if body is binary, and do not trim
-//  3. curl -H "Attach: http://example.com/file.jpg" ntfy.sh/mytopic
+//  4. curl -X PATCH -H "Upload-Offset: 1024" -T last-chunk.bin ntfy.sh/mytopic/upload/...
+//     If a resumable upload was completed, the uploaded file is the attachment, and there is no body
+//  5. curl -F "file=@app.log" -F "file=@screenshot.png" ntfy.sh/mytopic
+//     If the body is multipart/form-data, each file is stored as a separate attachment
+//  6. curl -H "Attach: http://example.com/file.jpg" ntfy.sh/mytopic
 //     Body must be a message, because we attached an external URL
-//  4. curl -T short.txt -H "Filename: short.txt" ntfy.sh/mytopic
+//  7. curl -T short.txt -H "Filename: short.txt" ntfy.sh/mytopic
 //     Body must be attachment, because we passed a filename
-//  5. curl -H "Template: yes" -T file.txt ntfy.sh/mytopic
+//  8. curl -H "Template: yes" -T file.txt ntfy.sh/mytopic
 //     If templating is enabled, read up to 32k and treat message body as JSON
-//  6. curl -T file.txt ntfy.sh/mytopic
+//  9. curl -T file.txt ntfy.sh/mytopic
 //     If file.txt is <= 4096 (message limit) and valid UTF-8, treat it as a message
-//  7. curl -T file.txt ntfy.sh/mytopic
+//  10. curl -T file.txt ntfy.sh/mytopic
 //     In all other cases, mostly if file.txt is > message limit, treat it as an attachment
 func (s *Server) handlePublishBody(r *http.Request, v *visitor, m *message, body *util.PeekedReadCloser, template, unifiedpush bool) error {
 	if m.Event == pollRequestEvent { // Case 1
 		return s.handleBodyDiscard(body)
+	} else if m.Encoding == encodingJWE {
+		return s.handleBodyAsEncryptedMessage(m, body) // Case 2
 	} else if unifiedpush {
-		return s.handleBodyAsMessageAutoDetect(m, body) // Case 2
+		return s.handleBodyAsMessageAutoDetect(m, body) // Case 3
+	} else if u, err := fromContext[*upload](r, contextUpload); err == nil {
+		return s.handleBodyAsUploadedAttachment(v, m, u) // Case 4
+	} else if boundary, ok := multipartBoundary(r); ok {
+		return s.handleBodyAsMultipartAttachments(r, v, m, body, boundary) // Case 5
 	} else if m.Attachment != nil && m.Attachment.URL != "" {
-		return s.handleBodyAsTextMessage(m, body) // Case 3
+		return s.handleBodyAsTextMessage(m, body) // Case 6
 	} else if m.Attachment != nil && m.Attachment.Name != "" {
-		return s.handleBodyAsAttachment(r, v, m, body) // Case 4
+		return s.handleBodyAsAttachment(r, v, m, body) // Case 7
 	} else if template {
-		return s.handleBodyAsTemplatedTextMessage(m, body) // Case 5
+		return s.handleBodyAsTemplatedTextMessage(m, body) // Case 8
 	} else if !body.LimitReached && utf8.Valid(body.PeekedBytes) {
-		return s.handleBodyAsTextMessage(m, body) // Case 6
+		return s.handleBodyAsTextMessage(m, body) // Case 9
 	}
-	return s.handleBodyAsAttachment(r, v, m, body) // Case 7
+	return s.handleBodyAsAttachment(r, v, m, body) // Case 10
 }
 
 func (s *Server) handleBodyDiscard(body *util.PeekedReadCloser) error {
@@ -1091,6 +1328,18 @@ func (s *Server) handleBodyAsMessageAutoDetect(m *message, body *util.PeekedRead
 	return nil
 }
 
+func (s *Server) handleBodyAsEncryptedMessage(m *message, body *util.PeekedReadCloser) error {
+	if body.LimitReached {
+		return errHTTPEntityTooLargeEncryptedMessage.With(m)
+	} else if len(body.PeekedBytes) > 0 { // Message may also be passed as parameter (publish via GET)
+		m.Message = strings.TrimSpace(string(body.PeekedBytes))
+	}
+	if !isJWECompact(m.Message) {
+		return errHTTPBadRequestEncryptedMessageInvalid.With(m)
+	}
+	return nil
+}
+
 func (s *Server) handleBodyAsTextMessage(m *message, body *util.PeekedReadCloser) error {
 	if !utf8.Valid(body.PeekedBytes) {
 		return errHTTPBadRequestMessageNotUTF8.With(m)
@@ -1190,6 +1439,7 @@ func (s *Server) handleBodyAsAttachment(r *http.Request, v *visitor, m *message,
 	} else if err != nil {
 		return err
 	}
+	s.maybeAddThumbnail(v, m)
 	return nil
 }
 
@@ -1239,7 +1489,11 @@ func (s *Server) handleSubscribeHTTP(w http.ResponseWriter, r *http.Request, v *
 	if err != nil {
 		return err
 	}
-	poll, since, scheduled, filters, err := parseSubscribeParams(r)
+	poll, since, scheduled, page, filters, err := parseSubscribeParams(r)
+	if err != nil {
+		return err
+	}
+	consumer, ack, err := parseConsumerParams(r)
 	if err != nil {
 		return err
 	}
@@ -1251,16 +1505,21 @@ func (s *Server) handleSubscribeHTTP(w http.ResponseWriter, r *http.Request, v *
 		// data race detector. See https://github.com/binwiederhier/ntfy/issues/338#issuecomment-1163425889.
 		wlock.TryLock()
 	}()
+	rc := http.NewResponseController(w)
+	defer rc.SetWriteDeadline(time.Time{}) // Do not leak the deadline to the next request on this connection
 	sub := func(v *visitor, msg *message) error {
 		if !filters.Pass(msg) {
 			return nil
 		}
-		m, err := encoder(msg)
+		m, err := encoder(s.withSignedAttachmentURLs(msg))
 		if err != nil {
 			return err
 		}
 		wlock.Lock()
 		defer wlock.Unlock()
+		if err := rc.SetWriteDeadline(time.Now().Add(s.config.SubscriberWriteTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
 		if _, err := w.Write([]byte(m)); err != nil {
 			return err
 		}
@@ -1269,6 +1528,14 @@ func (s *Server) handleSubscribeHTTP(w http.ResponseWriter, r *http.Request, v *
 		}
 		return nil
 	}
+	if consumer != "" {
+		if since, err = s.resumeConsumer(r, v, consumer, topics, since); err != nil {
+			return err
+		}
+		if !ack {
+			sub = s.trackConsumer(v, consumer, sub)
+		}
+	}
 	if err := s.maybeSetRateVisitors(r, v, topics); err != nil {
 		return err
 	}
@@ -1278,13 +1545,26 @@ func (s *Server) handleSubscribeHTTP(w http.ResponseWriter, r *http.Request, v *
 		for _, t := range topics {
 			t.Keepalive()
 		}
-		return s.sendOldMessages(topics, since, scheduled, v, sub)
+		messages, more, err := s.oldMessages(topics, since, scheduled, page, filters)
+		if err != nil {
+			return err
+		}
+		if more {
+			w.Header().Set("Link", nextPageLink(r, since, page, messages))
+		}
+		for _, m := range messages {
+			if err := sub(v, m); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	queue := newSubscriberQueue(sub, s.config.SubscriberQueueSize, s.config.SubscriberQueuePolicy, cancel)
 	subscriberIDs := make([]int, 0)
 	for _, t := range topics {
-		subscriberIDs = append(subscriberIDs, t.Subscribe(sub, v.MaybeUserID(), cancel))
+		subscriberIDs = append(subscriberIDs, t.Subscribe(queue.Enqueue, v.MaybeUserID(), cancel))
 	}
 	defer func() {
 		for i, subscriberID := range subscriberIDs {
@@ -1294,9 +1574,13 @@ func (s *Server) handleSubscribeHTTP(w http.ResponseWriter, r *http.Request, v *
 	if err := sub(v, newOpenMessage(topicsStr)); err != nil { // Send out open message
 		return err
 	}
-	if err := s.sendOldMessages(topics, since, scheduled, v, sub); err != nil {
+	if err := s.sendOldMessages(topics, since, scheduled, page, filters, v, sub); err != nil {
 		return err
 	}
+	// New messages are only queued until all cached messages have been sent, so that messages are delivered in
+	// order, and durable consumers do not skip cached messages if they disconnect while catching up
+	go queue.Run(ctx)
+	defer queue.Stop() // Must not return while the queue may still write to the response, see wlock
 	for {
 		select {
 		case <-ctx.Done():
@@ -1322,6 +1606,14 @@ func (s *Server) handleSubscribeHTTP(w http.ResponseWriter, r *http.Request, v *
 }
 
 func (s *Server) handleSubscribeWS(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	return s.handleSubscribeWSWithEncoder(w, r, v, func(msg *message) (any, error) {
+		return msg, nil
+	})
+}
+
+// handleSubscribeWSWithEncoder subscribes to the topics in the path, and writes each message as JSON to the
+// WebSocket connection, using the given encoder. If the encoder returns nil, the message is skipped.
+func (s *Server) handleSubscribeWSWithEncoder(w http.ResponseWriter, r *http.Request, v *visitor, encoder func(msg *message) (any, error)) error {
 	if strings.ToLower(r.Header.Get("Upgrade")) != "websocket" {
 		return errHTTPBadRequestWebSocketsUpgradeHeaderMissing
 	}
@@ -1335,10 +1627,19 @@ func (s *Server) handleSubscribeWS(w http.ResponseWriter, r *http.Request, v *vi
 	if err != nil {
 		return err
 	}
-	poll, since, scheduled, filters, err := parseSubscribeParams(r)
+	poll, since, scheduled, page, filters, err := parseSubscribeParams(r)
 	if err != nil {
 		return err
 	}
+	consumer, ack, err := parseConsumerParams(r)
+	if err != nil {
+		return err
+	}
+	if consumer != "" {
+		if since, err = s.resumeConsumer(r, v, consumer, topics, since); err != nil {
+			return err
+		}
+	}
 	upgrader := &websocket.Upgrader{
 		ReadBufferSize:  wsBufferSize,
 		WriteBufferSize: wsBufferSize,
@@ -1385,7 +1686,7 @@ func (s *Server) handleSubscribeWS(w http.ResponseWriter, r *http.Request, v *vi
 		ping := func() error {
 			wlock.Lock()
 			defer wlock.Unlock()
-			if err := conn.SetWriteDeadline(time.Now().Add(wsWriteWait)); err != nil {
+			if err := conn.SetWriteDeadline(time.Now().Add(s.config.SubscriberWriteTimeout)); err != nil {
 				return err
 			}
 			logvr(v, r).Tag(tagWebsocket).Trace("Sending WebSocket ping")
@@ -1414,12 +1715,21 @@ func (s *Server) handleSubscribeWS(w http.ResponseWriter, r *http.Request, v *vi
 		if !filters.Pass(msg) {
 			return nil
 		}
+		encoded, err := encoder(s.withSignedAttachmentURLs(msg))
+		if err != nil {
+			return err
+		} else if encoded == nil {
+			return nil
+		}
 		wlock.Lock()
 		defer wlock.Unlock()
-		if err := conn.SetWriteDeadline(time.Now().Add(wsWriteWait)); err != nil {
+		if err := conn.SetWriteDeadline(time.Now().Add(s.config.SubscriberWriteTimeout)); err != nil {
 			return err
 		}
-		return conn.WriteJSON(msg)
+		return conn.WriteJSON(encoded)
+	}
+	if consumer != "" && !ack {
+		sub = s.trackConsumer(v, consumer, sub)
 	}
 	if err := s.maybeSetRateVisitors(r, v, topics); err != nil {
 		return err
@@ -1429,11 +1739,12 @@ func (s *Server) handleSubscribeWS(w http.ResponseWriter, r *http.Request, v *vi
 		for _, t := range topics {
 			t.Keepalive()
 		}
-		return s.sendOldMessages(topics, since, scheduled, v, sub)
+		return s.sendOldMessages(topics, since, scheduled, page, filters, v, sub)
 	}
+	queue := newSubscriberQueue(sub, s.config.SubscriberQueueSize, s.config.SubscriberQueuePolicy, cancel)
 	subscriberIDs := make([]int, 0)
 	for _, t := range topics {
-		subscriberIDs = append(subscriberIDs, t.Subscribe(sub, v.MaybeUserID(), cancel))
+		subscriberIDs = append(subscriberIDs, t.Subscribe(queue.Enqueue, v.MaybeUserID(), cancel))
 	}
 	defer func() {
 		for i, subscriberID := range subscriberIDs {
@@ -1443,9 +1754,11 @@ func (s *Server) handleSubscribeWS(w http.ResponseWriter, r *http.Request, v *vi
 	if err := sub(v, newOpenMessage(topicsStr)); err != nil { // Send out open message
 		return err
 	}
-	if err := s.sendOldMessages(topics, since, scheduled, v, sub); err != nil {
+	if err := s.sendOldMessages(topics, since, scheduled, page, filters, v, sub); err != nil {
 		return err
 	}
+	go queue.Run(cancelCtx) // New messages are only queued until all cached messages have been sent, see handleSubscribeHTTP
+	defer queue.Stop()
 	err = g.Wait()
 	if err != nil && websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseAbnormalClosure, websocket.CloseNoStatusReceived) {
 		logvr(v, r).Tag(tagWebsocket).Err(err).Fields(websocketErrorContext(err)).Trace("WebSocket connection closed")
@@ -1454,13 +1767,56 @@ func (s *Server) handleSubscribeWS(w http.ResponseWriter, r *http.Request, v *vi
 	return err
 }
 
-func parseSubscribeParams(r *http.Request) (poll bool, since sinceMarker, scheduled bool, filters *queryFilter, err error) {
+// handleSubscribeAck acknowledges a message for a durable consumer (usually subscribed with "ack=1"),
+// so that the consumer resumes right after this message when it reconnects
+func (s *Server) handleSubscribeAck(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	name, _, err := parseConsumerParams(r)
+	if err != nil {
+		return err
+	} else if name == "" {
+		return errHTTPBadRequestConsumerInvalid
+	}
+	messageID := readParam(r, "x-id", "id")
+	if !validMessageID(messageID) {
+		return errHTTPBadRequestConsumerMessageIDInvalid
+	}
+	owner := consumerOwner(v)
+	c, err := s.messageCache.Consumer(owner, name)
+	if errors.Is(err, errConsumerNotFound) {
+		return errHTTPBadRequestConsumerNotFound
+	} else if err != nil {
+		return err
+	}
+	topics, _, err := s.topicsFromPath(r.URL.Path)
+	if err != nil {
+		return err
+	}
+	m, err := s.messageCache.Message(messageID)
+	if errors.Is(err, errMessageNotFound) {
+		return errHTTPBadRequestConsumerMessageIDInvalid
+	} else if err != nil {
+		return err
+	} else if !util.Contains(c.Topics, m.Topic) || !slices.ContainsFunc(topics, func(t *topic) bool { return t.ID == m.Topic }) {
+		return errHTTPBadRequestConsumerMessageIDInvalid // Message must belong to one of the consumer's topics
+	}
+	logvr(v, r).Tag(tagSubscribe).Debug("Acknowledging message %s for durable consumer %s", messageID, name)
+	if err := s.messageCache.UpdateConsumerMessageID(owner, name, messageID); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func parseSubscribeParams(r *http.Request) (poll bool, since sinceMarker, scheduled bool, page *pagination, filters *queryFilter, err error) {
 	poll = readBoolParam(r, false, "x-poll", "poll", "po")
 	scheduled = readBoolParam(r, false, "x-scheduled", "scheduled", "sched")
 	since, err = parseSince(r, poll)
 	if err != nil {
 		return
 	}
+	page, err = parsePagination(r)
+	if err != nil {
+		return
+	}
 	filters, err = parseQueryFilters(r)
 	if err != nil {
 		return
@@ -1534,35 +1890,209 @@ func (s *Server) setRateVisitors(r *http.Request, v *visitor, rateTopics []*topi
 }
 
 // sendOldMessages selects old messages from the messageCache and calls sub for each of them. It uses since as the
-// marker, returning only messages that are newer than the marker.
-func (s *Server) sendOldMessages(topics []*topic, since sinceMarker, scheduled bool, v *visitor, sub subscriber) error {
+// marker, returning only messages that are newer than the marker, and only a single page of messages if requested.
+func (s *Server) sendOldMessages(topics []*topic, since sinceMarker, scheduled bool, page *pagination, filters *queryFilter, v *visitor, sub subscriber) error {
+	messages, _, err := s.oldMessages(topics, since, scheduled, page, filters)
+	if err != nil {
+		return err
+	}
+	for _, m := range messages {
+		if err := sub(v, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// oldMessages selects old messages for the given topics from the messageCache (see sendOldMessages). If a page
+// was requested, more indicates whether there are further messages beyond that page. The filters are applied
+// before the page is cut, so that filtered messages do not shorten the page.
+func (s *Server) oldMessages(topics []*topic, since sinceMarker, scheduled bool, page *pagination, filters *queryFilter) (messages []*message, more bool, err error) {
 	if since.IsNone() {
-		return nil
+		return make([]*message, 0), false, nil
+	} else if !page.IsNone() && !since.IsLatest() {
+		topicIDs := make([]string, len(topics))
+		for i, t := range topics {
+			topicIDs[i] = t.ID
+		}
+		return s.messageCache.MessagesPage(topicIDs, since, scheduled, page, filters)
 	}
-	messages := make([]*message, 0)
+	messages = make([]*message, 0)
 	for _, t := range topics {
 		topicMessages, err := s.messageCache.Messages(t.ID, since, scheduled)
 		if err != nil {
-			return err
+			return nil, false, err
 		}
-		messages = append(messages, topicMessages...)
+		messages = append(messages, withoutExpiredMessages(topicMessages)...)
 	}
 	sort.Slice(messages, func(i, j int) bool {
 		return messages[i].Time < messages[j].Time
 	})
-	for _, m := range messages {
-		if err := sub(v, m); err != nil {
+	return messages, false, nil
+}
+
+// withoutExpiredMessages removes messages that have expired, but that have not been deleted by the
+// manager yet (see pruneMessages), e.g. messages with a short TTL
+func withoutExpiredMessages(messages []*message) []*message {
+	now := time.Now().Unix()
+	return slices.DeleteFunc(messages, func(m *message) bool {
+		return m.Expires > 0 && m.Expires <= now
+	})
+}
+
+// parsePagination reads the "limit=..." and "before=..." parameters, which restrict the cached messages returned
+// to a single page. The "after=..." parameter is the forward cursor, and is handled in parseSince.
+func parsePagination(r *http.Request) (*pagination, error) {
+	page := &pagination{}
+	if limit := readParam(r, "x-limit", "limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l <= 0 {
+			return nil, errHTTPBadRequestLimitInvalid
+		}
+		page.limit = l
+	}
+	page.before = readParam(r, "x-before", "before")
+	if page.before != "" && !validMessageID(page.before) {
+		return nil, errHTTPBadRequestCursorInvalid
+	}
+	return page, nil
+}
+
+// nextPageLink returns a Link header value (RFC 8288) pointing to the next page of cached messages. When paging
+// backwards ("before=..."), the next page ends right before the oldest message of the current page. Otherwise,
+// it starts right after the newest message of the current page.
+func nextPageLink(r *http.Request, since sinceMarker, page *pagination, messages []*message) string {
+	q := r.URL.Query()
+	if (page.before != "" || page.newest) && !since.IsID() {
+		q.Set("before", messages[0].ID)
+	} else {
+		q.Del("since")
+		q.Del("si")
+		q.Set("after", messages[len(messages)-1].ID)
+	}
+	if page.limit > 0 {
+		q.Set("limit", strconv.Itoa(page.limit))
+	}
+	return fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, q.Encode())
+}
+
+// parseConsumerParams reads the name of a durable consumer ("consumer=..."), and whether the consumer
+// acknowledges messages manually ("ack=1") instead of advancing its position with every delivered message.
+// If no consumer is requested, name is empty.
+func parseConsumerParams(r *http.Request) (name string, ack bool, err error) {
+	name = readParam(r, "x-consumer", "consumer")
+	if name != "" && !consumerRegex.MatchString(name) {
+		return "", false, errHTTPBadRequestConsumerInvalid
+	}
+	ack = readBoolParam(r, false, "x-ack", "ack")
+	return name, ack, nil
+}
+
+// consumerOwner returns the owner of the visitor's durable consumers: the user ID, or the IP address for anonymous
+// visitors, so that anonymous visitors cannot resume or acknowledge each other's consumers
+func consumerOwner(v *visitor) string {
+	if userID := v.MaybeUserID(); userID != "" {
+		return userID
+	}
+	return consumerAnonymousOwnerPrefix + v.IP().String()
+}
+
+// resumeConsumer registers the durable consumer for the given topics, and returns the marker from which it
+// should receive cached messages: If the consumer has received or acknowledged messages before, it resumes
+// right after the last of them. Otherwise, the since marker from the request is used, defaulting to all
+// cached messages if the "since=..." parameter was not passed.
+func (s *Server) resumeConsumer(r *http.Request, v *visitor, name string, topics []*topic, since sinceMarker) (sinceMarker, error) {
+	topicIDs := make([]string, len(topics))
+	for i, t := range topics {
+		topicIDs[i] = t.ID
+	}
+	owner := consumerOwner(v)
+	if _, err := s.messageCache.Consumer(owner, name); errors.Is(err, errConsumerNotFound) {
+		count, err := s.messageCache.ConsumersCount(owner)
+		if err != nil {
+			return sinceNoMessages, err
+		} else if count >= consumerLimit {
+			return sinceNoMessages, errHTTPTooManyRequestsLimitConsumers
+		}
+	} else if err != nil {
+		return sinceNoMessages, err
+	}
+	if err := s.messageCache.AddConsumer(owner, name, topicIDs); err != nil {
+		return sinceNoMessages, err
+	}
+	c, err := s.messageCache.Consumer(owner, name)
+	if err != nil {
+		return sinceNoMessages, err
+	}
+	logvr(v, r).Tag(tagSubscribe).With(c).Debug("Resuming durable consumer %s", name)
+	if c.MessageID != "" {
+		return newSinceID(c.MessageID), nil
+	} else if readParam(r, "x-since", "since", "si", "x-after", "after") == "" {
+		return sinceAllMessages, nil
+	}
+	return since, nil
+}
+
+// trackConsumer wraps sub to remember the last message delivered to a durable consumer
+func (s *Server) trackConsumer(v *visitor, name string, sub subscriber) subscriber {
+	owner := consumerOwner(v)
+	return func(v *visitor, msg *message) error {
+		if err := sub(v, msg); err != nil {
 			return err
 		}
+		if msg.Event != messageEvent {
+			return nil
+		}
+		return s.messageCache.UpdateConsumerMessageID(owner, name, msg.ID)
 	}
-	return nil
+}
+
+// consumerStats returns all durable consumers, along with the number of cached messages
+// they have not yet received or acknowledged
+func (s *Server) consumerStats() ([]*apiConsumerResponse, error) {
+	consumers, err := s.messageCache.Consumers()
+	if err != nil {
+		return nil, err
+	}
+	stats := make([]*apiConsumerResponse, 0, len(consumers))
+	for _, c := range consumers {
+		pending, err := s.messageCache.ConsumerPending(c)
+		if err != nil {
+			return nil, err
+		}
+		var username, ip string
+		if strings.HasPrefix(c.UserID, consumerAnonymousOwnerPrefix) {
+			ip = strings.TrimPrefix(c.UserID, consumerAnonymousOwnerPrefix)
+		} else if c.UserID != "" && s.userManager != nil {
+			if u, err := s.userManager.UserByID(c.UserID); err == nil {
+				username = u.Name
+			}
+		}
+		stats = append(stats, &apiConsumerResponse{
+			User:      username,
+			IP:        ip,
+			Consumer:  c.Name,
+			Topics:    c.Topics,
+			MessageID: c.MessageID,
+			Updated:   c.Updated,
+			Pending:   pending,
+		})
+	}
+	return stats, nil
 }
 
 // parseSince returns a timestamp identifying the time span from which cached messages should be received.
 //
 // Values in the "since=..." parameter can be either a unix timestamp or a duration (e.g. 12h),
-// "all" for all messages, or "latest" for the most recent message for a topic
+// "all" for all messages, or "latest" for the most recent message for a topic. The "after=..." parameter
+// is the pagination cursor (see parsePagination), and must be a message ID. It takes precedence over "since=...".
 func parseSince(r *http.Request, poll bool) (sinceMarker, error) {
+	if after := readParam(r, "x-after", "after"); after != "" {
+		if !validMessageID(after) {
+			return sinceNoMessages, errHTTPBadRequestCursorInvalid
+		}
+		return newSinceID(after), nil
+	}
 	since := readParam(r, "x-since", "since", "si")
 
 	// Easy cases (empty, all, none)
@@ -1797,21 +2327,80 @@ func (s *Server) sendDelayedMessage(v *visitor, m *message) error {
 			}
 		}()
 	}
-	if s.firebaseClient != nil { // Firebase subscribers may not show up in topics map
+	routing, err := s.messageCache.MessageRouting(m.ID)
+	if err != nil {
+		return err
+	}
+	if s.firebaseClient != nil && (routing.Firebase == nil || *routing.Firebase) { // Firebase subscribers may not show up in topics map
 		go s.sendToFirebase(v, m)
 	}
+	if s.smtpSender != nil {
+		for _, routingEmail := range routing.Emails {
+			go s.sendEmail(v, m, routingEmail)
+		}
+	}
+	if s.config.TwilioAccount != "" {
+		for _, routingCall := range routing.Calls {
+			go s.callPhone(v, m, routingCall)
+		}
+	}
 	if s.config.UpstreamBaseURL != "" {
 		go s.forwardPollRequest(v, m)
 	}
+	if len(s.config.FederationPeers) > 0 {
+		go s.federateMessage(v, m, nil)
+	}
 	if s.config.WebPushPublicKey != "" {
 		go s.publishToWebPushEndpoints(v, m)
 	}
+	if s.userManager != nil {
+		go s.fanOutMessage(v, m, true) // Delayed messages are always cached
+	}
+	if len(routing.Forward) > 0 {
+		go s.forwardRoutedMessage(v, m, routing.Forward, true)
+	}
 	if err := s.messageCache.MarkPublished(m); err != nil {
 		return err
 	}
 	return nil
 }
 
+// publishForwardedMessage publishes a complete message that was received from another server (via federation, or
+// a relay subscription), keeping its original ID and metadata. The message is delivered to subscribers, Firebase
+// and Web Push, forwarded to federation peers (except the ones listed in via), and added to the cache, according
+// to the retention settings of the topic.
+func (s *Server) publishForwardedMessage(v *visitor, t *topic, m *message, via []string) error {
+	m.Sender = v.IP()
+	m.User = ""
+	expires := m.Expires // Keep the expiry of the original message if it is shorter, e.g. if it had a TTL
+	m.Expires = time.Now().Add(s.config.CacheDuration).Unix()
+	cache, err := s.applyTopicRetention(m)
+	if err != nil {
+		return err
+	} else if expires > 0 && expires < m.Expires {
+		m.Expires = expires
+	}
+	if err := s.signMessage(m); err != nil {
+		return err
+	}
+	if err := t.Publish(v, m); err != nil {
+		return err
+	}
+	if s.firebaseClient != nil {
+		go s.sendToFirebase(v, m)
+	}
+	if s.config.WebPushPublicKey != "" {
+		go s.publishToWebPushEndpoints(v, m)
+	}
+	if len(s.config.FederationPeers) > 0 {
+		go s.federateMessage(v, m, via)
+	}
+	if !cache {
+		return nil
+	}
+	return s.messageCache.AddMessage(m)
+}
+
 // transformBodyJSON peeks the request body, reads the JSON, and converts it to headers
 // before passing it on to the next handler. This is meant to be used in combination with handlePublish.
 func (s *Server) transformBodyJSON(next handleFunc) handleFunc {
@@ -1820,64 +2409,82 @@ func (s *Server) transformBodyJSON(next handleFunc) handleFunc {
 		if err != nil {
 			return err
 		}
-		if !topicRegex.MatchString(m.Topic) {
-			return errHTTPBadRequestTopicInvalid
-		}
-		if m.Message == "" {
-			m.Message = emptyMessageBody
-		}
-		r.URL.Path = "/" + m.Topic
-		r.Body = io.NopCloser(strings.NewReader(m.Message))
-		if m.Title != "" {
-			r.Header.Set("X-Title", m.Title)
-		}
-		if m.Priority != 0 {
-			r.Header.Set("X-Priority", fmt.Sprintf("%d", m.Priority))
-		}
-		if len(m.Tags) > 0 {
-			r.Header.Set("X-Tags", strings.Join(m.Tags, ","))
-		}
-		if m.Attach != "" {
-			r.Header.Set("X-Attach", m.Attach)
-		}
-		if m.Filename != "" {
-			r.Header.Set("X-Filename", m.Filename)
-		}
-		if m.Click != "" {
-			r.Header.Set("X-Click", m.Click)
-		}
-		if m.Icon != "" {
-			r.Header.Set("X-Icon", m.Icon)
-		}
-		if m.Markdown {
-			r.Header.Set("X-Markdown", "yes")
-		}
-		if len(m.Actions) > 0 {
-			actionsStr, err := json.Marshal(m.Actions)
-			if err != nil {
-				return errHTTPBadRequestMessageJSONInvalid
-			}
-			r.Header.Set("X-Actions", string(actionsStr))
-		}
-		if m.Email != "" {
-			r.Header.Set("X-Email", m.Email)
-		}
-		if m.Delay != "" {
-			r.Header.Set("X-Delay", m.Delay)
-		}
-		if m.Call != "" {
-			r.Header.Set("X-Call", m.Call)
-		}
-		if m.Cache != "" {
-			r.Header.Set("X-Cache", m.Cache)
-		}
-		if m.Firebase != "" {
-			r.Header.Set("X-Firebase", m.Firebase)
+		if err := applyPublishMessage(r, m); err != nil {
+			return err
 		}
 		return next(w, r, v)
 	}
 }
 
+// applyPublishMessage rewrites the path, body and headers of the given request, so that it looks like a regular
+// publish request with the fields of the given JSON message. It is used for JSON and batch publishing.
+func applyPublishMessage(r *http.Request, m *publishMessage) error {
+	if !topicRegex.MatchString(m.Topic) {
+		return errHTTPBadRequestTopicInvalid
+	}
+	if m.Message == "" {
+		m.Message = emptyMessageBody
+	}
+	r.URL.Path = "/" + m.Topic
+	r.Body = io.NopCloser(strings.NewReader(m.Message))
+	if m.Title != "" {
+		r.Header.Set("X-Title", m.Title)
+	}
+	if m.Priority != 0 {
+		r.Header.Set("X-Priority", fmt.Sprintf("%d", m.Priority))
+	}
+	if len(m.Tags) > 0 {
+		r.Header.Set("X-Tags", strings.Join(m.Tags, ","))
+	}
+	if m.Attach != "" {
+		r.Header.Set("X-Attach", m.Attach)
+	}
+	if m.Filename != "" {
+		r.Header.Set("X-Filename", m.Filename)
+	}
+	if m.Click != "" {
+		r.Header.Set("X-Click", m.Click)
+	}
+	if m.Icon != "" {
+		r.Header.Set("X-Icon", m.Icon)
+	}
+	if m.Markdown {
+		r.Header.Set("X-Markdown", "yes")
+	}
+	if len(m.Actions) > 0 {
+		actionsStr, err := json.Marshal(m.Actions)
+		if err != nil {
+			return errHTTPBadRequestMessageJSONInvalid
+		}
+		r.Header.Set("X-Actions", string(actionsStr))
+	}
+	if m.Email != "" {
+		r.Header.Set("X-Email", m.Email)
+	}
+	if m.Delay != "" {
+		r.Header.Set("X-Delay", m.Delay)
+	}
+	if m.Expires != "" {
+		r.Header.Set("X-Expires", m.Expires)
+	}
+	if m.Call != "" {
+		r.Header.Set("X-Call", m.Call)
+	}
+	if m.Cache != "" {
+		r.Header.Set("X-Cache", m.Cache)
+	}
+	if m.Firebase != "" {
+		r.Header.Set("X-Firebase", m.Firebase)
+	}
+	if m.Encoding != "" {
+		r.Header.Set("X-Encoding", m.Encoding)
+	}
+	for key, value := range m.Labels {
+		r.Header.Set("X-Label-"+key, value)
+	}
+	return nil
+}
+
 func (s *Server) transformMatrixJSON(next handleFunc) handleFunc {
 	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
 		newRequest, err := newRequestFromMatrixJSON(r, s.config.BaseURL, s.config.MessageSizeLimit)
@@ -1906,9 +2513,23 @@ func (s *Server) authorizeTopicRead(next handleFunc) handleFunc {
 
 func (s *Server) authorizeTopic(next handleFunc, perm user.Permission) handleFunc {
 	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		if token := r.URL.Query().Get(signedURLParam); token != "" && perm == user.PermissionWrite && s.config.PublishSigningKey != "" {
+			if err := s.authorizeSignedURL(r, v, token); err != nil {
+				return err
+			}
+			return next(w, r, v)
+		}
 		if s.userManager == nil {
 			return next(w, r, v)
 		}
+		if perm == user.PermissionWrite && v.User() == nil {
+			webhookVisitor, err := s.authorizeWebhook(r, v)
+			if err != nil {
+				return err
+			} else if webhookVisitor != nil {
+				return next(w, r, webhookVisitor)
+			}
+		}
 		topics, _, err := s.topicsFromPath(r.URL.Path)
 		if err != nil {
 			return err
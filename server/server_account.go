@@ -120,13 +120,46 @@ func (s *Server) handleAccountGet(w http.ResponseWriter, r *http.Request, v *vis
 			if len(reservations) > 0 {
 				response.Reservations = make([]*apiAccountReservation, 0)
 				for _, r := range reservations {
-					response.Reservations = append(response.Reservations, &apiAccountReservation{
+					reservation := &apiAccountReservation{
 						Topic:    r.Topic,
 						Everyone: r.Everyone.String(),
-					})
+					}
+					if r.Retention != nil {
+						reservation.Retention = newTopicRetentionResponse(r.Retention)
+					}
+					response.Reservations = append(response.Reservations, reservation)
 				}
 			}
 		}
+		webhooks, err := s.userManager.Webhooks(u.ID)
+		if err != nil {
+			return err
+		}
+		if len(webhooks) > 0 {
+			response.Webhooks = make([]*apiAccountWebhookResponse, 0)
+			for _, w := range webhooks {
+				response.Webhooks = append(response.Webhooks, &apiAccountWebhookResponse{
+					Topic:  w.Topic,
+					Scheme: string(w.Scheme),
+					Header: w.Header,
+				})
+			}
+		}
+		links, err := s.userManager.TopicLinksByUser(u.ID)
+		if err != nil {
+			return err
+		}
+		if len(links) > 0 {
+			response.Links = make([]*apiAccountTopicLinkResponse, 0)
+			for _, l := range links {
+				response.Links = append(response.Links, &apiAccountTopicLinkResponse{
+					Source:      l.Source,
+					Target:      l.Target,
+					MinPriority: l.MinPriority,
+					Tags:        l.Tags,
+				})
+			}
+		}
 		tokens, err := s.userManager.Tokens(u.ID)
 		if err != nil {
 			return err
@@ -192,6 +225,9 @@ func (s *Server) handleAccountDelete(w http.ResponseWriter, r *http.Request, v *
 	if err := s.userManager.MarkUserRemoved(u); err != nil {
 		return err
 	}
+	if err := s.messageCache.DeleteConsumers(u.ID); err != nil {
+		return err
+	}
 	return s.writeJSON(w, newSuccessResponse())
 }
 
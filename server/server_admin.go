@@ -138,6 +138,9 @@ func (s *Server) handleUsersDelete(w http.ResponseWriter, r *http.Request, v *vi
 	if err := s.userManager.RemoveUser(req.Username); err != nil {
 		return err
 	}
+	if err := s.messageCache.DeleteConsumers(u.ID); err != nil {
+		return err
+	}
 	if err := s.killUserSubscriber(u, "*"); err != nil { // FIXME super inefficient
 		return err
 	}
@@ -193,3 +196,11 @@ func (s *Server) killUserSubscriber(u *user.User, topicPattern string) error {
 	}
 	return nil
 }
+
+func (s *Server) handleConsumersGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	consumers, err := s.consumerStats()
+	if err != nil {
+		return err
+	}
+	return s.writeJSON(w, consumers)
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
+	"io"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -399,3 +400,47 @@ func TestAccess_AllowReset_KillConnection(t *testing.T) {
 		return timeTaken.Load() >= 500
 	})
 }
+
+func TestUser_ConsumersGet(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("ben", "mytopic", user.PermissionReadWrite))
+
+	// Publish, receive one message with consumer, then publish more
+	rr := request(t, s, "PUT", "/mytopic", "test 1", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "GET", "/mytopic/json?poll=1&consumer=worker1", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, rr.Code)
+	for _, m := range []string{"test 2", "test 3"} {
+		request(t, s, "PUT", "/mytopic", m, map[string]string{
+			"Authorization": util.BasicAuth("ben", "ben"),
+		})
+	}
+
+	// Regular users cannot see consumers
+	rr = request(t, s, "GET", "/v1/consumers", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, rr.Code)
+
+	// Admin sees consumer with pending messages
+	rr = request(t, s, "GET", "/v1/consumers", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	consumers, err := util.UnmarshalJSON[[]*apiConsumerResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(*consumers))
+	require.Equal(t, "ben", (*consumers)[0].User)
+	require.Equal(t, "worker1", (*consumers)[0].Consumer)
+	require.Equal(t, []string{"mytopic"}, (*consumers)[0].Topics)
+	require.Equal(t, int64(2), (*consumers)[0].Pending)
+	require.Equal(t, "", (*consumers)[0].IP)
+}
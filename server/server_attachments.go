@@ -0,0 +1,376 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+// Multiple attachments:
+//
+// A message may have up to attachmentsLimit attachments, either uploaded as multipart/form-data, or passed as multiple
+// X-Attach URLs. The first attachment is always stored in message.Attachment (and in the attachment_* columns of the
+// message cache), so that older clients can still display it. All others are only listed in message.Attachments.
+//
+// Uploaded files are stored in the file cache under the message ID (first attachment), or the message ID and the
+// index of the attachment (all others), e.g. "abcdefghijkl" and "abcdefghijkl-1", see attachmentFileID.
+//
+// Attachment downloads:
+//
+// Attachments can only be downloaded by visitors with read access to the topic of the message, unless
+// attachment-public-download is set. Some clients cannot pass credentials when downloading a file (e.g. an <img> tag
+// in the web app, or a link in an e-mail), so the URLs of attachments stored on this server are signed whenever a
+// message is published, delivered to a subscriber (including MQTT and RSS/Atom feeds), or sent via Firebase, e-mail or
+// web push, see withSignedAttachmentURLs. Copies of a message in other topics (topic links, routing rules) are signed
+// when they are created, see withSignedCopiedAttachmentURLs.
+// The signature is passed in the "sig" query parameter: <expires>.base64url(HMAC-SHA256(key, "<file ID>:<expires>")).
+// Signed URLs are valid until the attachment expires, but at most signedDownloadURLMaxExpiry. The key is derived from
+// attachment-signing-key if set (so that multiple servers can share it), or generated once and stored in the
+// attachment cache directory, so that signed URLs survive restarts, see loadFileSigningKey.
+
+const (
+	multipartMessageFieldName  = "message" // Name of the form field that contains the message body
+	signedDownloadURLMaxExpiry = 24 * time.Hour
+	fileSigningKeyFilename     = ".signing-key" // Does not match fileIDRegex, so it can never be downloaded
+	fileSigningKeyLength       = 32
+)
+
+// handleBodyAsMultipartAttachments reads a multipart/form-data body and stores each file as a separate attachment.
+// A form field named "message" is used as the message body; all other fields are ignored. Each file is subject to
+// the attachment file size limit, and all files together to the remaining attachment storage of the visitor.
+func (s *Server) handleBodyAsMultipartAttachments(r *http.Request, v *visitor, m *message, body *util.PeekedReadCloser, boundary string) error {
+	if s.fileCache == nil || s.config.BaseURL == "" || s.config.AttachmentCacheDir == "" {
+		return errHTTPBadRequestAttachmentsDisallowed.With(m)
+	} else if m.Attachment != nil && m.Attachment.URL != "" {
+		return errHTTPBadRequestAttachmentsMultipartInvalid.With(m)
+	}
+	vinfo, err := v.Info()
+	if err != nil {
+		return err
+	}
+	attachmentExpiry := time.Now().Add(vinfo.Limits.AttachmentExpiryDuration).Unix()
+	if m.Time > attachmentExpiry {
+		return errHTTPBadRequestAttachmentsExpiryBeforeDelivery.With(m)
+	}
+	contentLengthStr := r.Header.Get("Content-Length")
+	if contentLengthStr != "" { // Early "do-not-trust" check, hard limit see below
+		contentLength, err := strconv.ParseInt(contentLengthStr, 10, 64)
+		if err == nil && contentLength > vinfo.Stats.AttachmentTotalSizeRemaining {
+			return errHTTPEntityTooLargeAttachment.With(m).Fields(log.Context{
+				"message_content_length":          contentLength,
+				"attachment_total_size_remaining": vinfo.Stats.AttachmentTotalSizeRemaining,
+			})
+		}
+	}
+	m.Attachment = nil // X-Filename does not apply to multipart uploads
+	if err := s.writeMultipartAttachments(v, m, body, boundary, vinfo, attachmentExpiry); err != nil {
+		if removeErr := s.fileCache.Remove(m.ID); removeErr != nil {
+			logvm(v, m).Err(removeErr).Warn("Error deleting attachments of rejected message")
+		}
+		return err
+	}
+	if m.Attachment != nil && m.Message == "" {
+		m.Message = fmt.Sprintf(defaultAttachmentMessage, m.Attachment.Name)
+	}
+	return nil
+}
+
+func (s *Server) writeMultipartAttachments(v *visitor, m *message, body io.Reader, boundary string, vinfo *visitorInfo, attachmentExpiry int64) error {
+	totalSizeLimiter := util.NewFixedLimiter(vinfo.Stats.AttachmentTotalSizeRemaining) // Shared by all files
+	reader := multipart.NewReader(body, boundary)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errHTTPBadRequestAttachmentsMultipartInvalid.With(m)
+		}
+		if part.FileName() == "" {
+			if part.FormName() == multipartMessageFieldName {
+				if err := s.readMultipartMessage(m, part); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		index := len(m.allAttachments())
+		if index >= attachmentsLimit {
+			return errHTTPBadRequestAttachmentsTooMany.With(m)
+		}
+		file, err := util.Peek(io.NopCloser(part), 512) // Enough to detect the content type
+		if err != nil {
+			return err
+		}
+		a := &attachment{
+			Name:    path.Base(part.FileName()),
+			Expires: attachmentExpiry,
+		}
+		var ext string
+		a.Type, ext = util.DetectContentType(file.PeekedBytes, a.Name)
+		fileID := attachmentFileID(m.ID, index)
+		a.URL = fmt.Sprintf("%s/file/%s%s", s.config.BaseURL, fileID, ext)
+		limiters := []util.Limiter{
+			v.BandwidthLimiter(),
+			util.NewFixedLimiter(vinfo.Limits.AttachmentFileSizeLimit),
+			totalSizeLimiter,
+		}
+		a.Size, err = s.fileCache.Write(fileID, file, limiters...)
+		if errors.Is(err, util.ErrLimitReached) {
+			return errHTTPEntityTooLargeAttachment.With(m)
+		} else if err != nil {
+			return err
+		}
+		m.addAttachment(a)
+	}
+}
+
+func (s *Server) readMultipartMessage(m *message, part *multipart.Part) error {
+	body, err := util.Peek(io.NopCloser(part), s.config.MessageSizeLimit)
+	if err != nil {
+		return err
+	} else if !utf8.Valid(body.PeekedBytes) {
+		return errHTTPBadRequestMessageNotUTF8.With(m)
+	}
+	m.Message = strings.TrimSpace(string(body.PeekedBytes)) // Truncates the message to the peek limit if required
+	return nil
+}
+
+// multipartBoundary returns the boundary of a multipart/form-data request body, if the request has one
+func multipartBoundary(r *http.Request) (string, bool) {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return "", false
+	}
+	return params["boundary"], true
+}
+
+// attachmentFileID returns the ID of the attachment with the given index in the file cache
+func attachmentFileID(messageID string, index int) string {
+	if index == 0 {
+		return messageID
+	}
+	return fmt.Sprintf("%s-%d", messageID, index)
+}
+
+// parseAttachmentFileID is the reverse of attachmentFileID. It returns the message ID and attachment index.
+func parseAttachmentFileID(fileID string) (messageID string, index int, ok bool) {
+	if len(fileID) == messageIDLength {
+		return fileID, 0, true
+	} else if len(fileID) < messageIDLength+2 || fileID[messageIDLength] != '-' {
+		return "", 0, false
+	}
+	index, err := strconv.Atoi(fileID[messageIDLength+1:])
+	if err != nil || index < 1 || index >= attachmentsLimit {
+		return "", 0, false
+	}
+	return fileID[:messageIDLength], index, true
+}
+
+// attachmentNameFromURL derives the attachment file name from the path of the given URL
+func attachmentNameFromURL(attachURL string) string {
+	u, err := url.Parse(attachURL)
+	if err == nil {
+		name := path.Base(u.Path)
+		if name != "." && name != "/" {
+			return name
+		}
+	}
+	return "attachment"
+}
+
+// attachmentETag returns a strong ETag for an attachment file. Attachment files are never modified after they were
+// written, so the file ID and size are enough to identify the content.
+func attachmentETag(fileID string, stat os.FileInfo) string {
+	return fmt.Sprintf(`"%s-%d"`, fileID, stat.Size())
+}
+
+// bandwidthLimitedResponseWriter charges the bandwidth of a visitor right before the body of a successful (200) or
+// partial (206) response is written, using the Content-Length set by http.ServeContent. This way, only the bytes
+// that are actually served are charged, and nothing is charged for 304 or 416 responses. If the bandwidth limit is
+// reached, nothing is written, and the caller is expected to respond with an error.
+type bandwidthLimitedResponseWriter struct {
+	w       http.ResponseWriter
+	visitor *visitor
+	limited bool
+}
+
+func newBandwidthLimitedResponseWriter(w http.ResponseWriter, v *visitor) *bandwidthLimitedResponseWriter {
+	return &bandwidthLimitedResponseWriter{
+		w:       w,
+		visitor: v,
+	}
+}
+
+func (w *bandwidthLimitedResponseWriter) Header() http.Header {
+	return w.w.Header()
+}
+
+func (w *bandwidthLimitedResponseWriter) WriteHeader(code int) {
+	if code == http.StatusOK || code == http.StatusPartialContent {
+		size, err := strconv.ParseInt(w.w.Header().Get("Content-Length"), 10, 64)
+		if err != nil || !w.visitor.BandwidthAllowed(size) {
+			w.limited = true
+			for _, header := range []string{"Content-Length", "Content-Range", "Content-Disposition", "Accept-Ranges", "Last-Modified", "ETag"} {
+				w.w.Header().Del(header) // Response is replaced by an error
+			}
+			return
+		}
+	}
+	w.w.WriteHeader(code)
+}
+
+func (w *bandwidthLimitedResponseWriter) Write(p []byte) (int, error) {
+	if w.limited {
+		return 0, util.ErrLimitReached
+	}
+	return w.w.Write(p)
+}
+
+// authorizeFileRead checks if the visitor may download the attachment with the given file ID, either because the
+// request carries a valid signature, or because the visitor has read access to the topic of the message
+func (s *Server) authorizeFileRead(r *http.Request, v *visitor, m *message, fileID string) error {
+	if s.userManager == nil || s.config.AttachmentPublicDownload {
+		return nil
+	} else if token := r.URL.Query().Get(signedURLParam); token != "" {
+		if !s.verifyDownloadURLToken(fileID, token) {
+			return errHTTPUnauthorizedSignedDownloadURL.With(m)
+		}
+		return nil
+	} else if err := s.userManager.Authorize(v.User(), m.Topic, user.PermissionRead); err != nil {
+		logvr(v, r).With(m).Err(err).Debug("Access to attachment of topic %s not authorized", m.Topic)
+		return errHTTPForbidden.With(m)
+	}
+	return nil
+}
+
+// withSignedAttachmentURLs returns a copy of the message in which the URLs of all attachments stored on this
+// server are signed, see authorizeFileRead. The original message is not modified, since it is shared between
+// subscribers. If the message was signed with the message signing key, the copy is signed again.
+func (s *Server) withSignedAttachmentURLs(m *message) *message {
+	if s.userManager == nil || s.config.AttachmentPublicDownload || m.Attachment == nil {
+		return m
+	}
+	signed := *m
+	signed.Attachment = s.signAttachmentURL(m.Attachment)
+	if len(m.Attachments) > 0 {
+		signed.Attachments = make([]*attachment, len(m.Attachments))
+		for i, a := range m.Attachments {
+			signed.Attachments[i] = s.signAttachmentURL(a)
+		}
+	}
+	if signed.Signature != "" {
+		if err := s.signMessage(&signed); err != nil {
+			log.Tag(tagPublish).With(m).Err(err).Warn("Unable to sign message with signed attachment URLs")
+			return m
+		}
+	}
+	return &signed
+}
+
+// withSignedCopiedAttachmentURLs signs the URLs of the attachments of a message copy (see publishCopy) until the
+// attachments expire. The files still belong to the source message, and subscribers of the target topic may not have
+// read access to the source topic, so they could not download the attachments otherwise, see authorizeFileRead.
+// Signed URLs are not signed again when the copy is delivered, see signFileURL.
+func (s *Server) withSignedCopiedAttachmentURLs(m *message) {
+	if s.userManager == nil || s.config.AttachmentPublicDownload || m.Attachment == nil || m.Attachment.Expires == 0 {
+		return
+	}
+	m.Attachment = s.signAttachmentURLUntil(m.Attachment, m.Attachment.Expires)
+	if len(m.Attachments) > 0 {
+		attachments := make([]*attachment, len(m.Attachments))
+		for i, a := range m.Attachments {
+			attachments[i] = s.signAttachmentURLUntil(a, a.Expires)
+		}
+		m.Attachments = attachments
+	}
+}
+
+func (s *Server) signAttachmentURL(a *attachment) *attachment {
+	expires := time.Now().Add(signedDownloadURLMaxExpiry).Unix()
+	if a.Expires > 0 && a.Expires < expires {
+		expires = a.Expires
+	}
+	return s.signAttachmentURLUntil(a, expires)
+}
+
+func (s *Server) signAttachmentURLUntil(a *attachment, expires int64) *attachment {
+	signed := *a
+	signed.URL = s.signFileURL(a.URL, expires)
+	if a.Thumbnail != "" {
+		signed.Thumbnail = s.signFileURL(a.Thumbnail, expires)
+	}
+	return &signed
+}
+
+// signFileURL appends a download token to the given URL, if it points to a file stored on this server, and if
+// it is not signed already
+func (s *Server) signFileURL(fileURL string, expires int64) string {
+	filePath := strings.TrimPrefix(fileURL, s.config.BaseURL)
+	matches := fileRegex.FindStringSubmatch(filePath)
+	if s.config.BaseURL == "" || filePath == fileURL || len(matches) != 2 {
+		return fileURL // External URL
+	}
+	return fmt.Sprintf("%s?%s=%s", fileURL, signedURLParam, s.downloadURLToken(matches[1], expires))
+}
+
+// loadFileSigningKey returns the key used to sign attachment download URLs, see downloadURLToken
+func loadFileSigningKey(conf *Config) ([]byte, error) {
+	if conf.AttachmentSigningKey != "" {
+		key := sha256.Sum256([]byte(conf.AttachmentSigningKey))
+		return key[:], nil
+	} else if conf.AttachmentCacheDir == "" {
+		return nil, nil // No attachments stored on this server, nothing to sign
+	}
+	filename := filepath.Join(conf.AttachmentCacheDir, fileSigningKeyFilename)
+	key, err := os.ReadFile(filename)
+	if err == nil && len(key) == fileSigningKeyLength {
+		return key, nil
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	key = make([]byte, fileSigningKeyLength)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filename, key, 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (s *Server) downloadURLToken(fileID string, expires int64) string {
+	h := hmac.New(sha256.New, s.fileSigningKey)
+	h.Write([]byte(fmt.Sprintf("%s:%d", fileID, expires)))
+	return fmt.Sprintf("%d%s%s", expires, signedURLTokenSeparator, base64.RawURLEncoding.EncodeToString(h.Sum(nil)))
+}
+
+func (s *Server) verifyDownloadURLToken(fileID, token string) bool {
+	expiresStr, _, ok := strings.Cut(token, signedURLTokenSeparator)
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(s.downloadURLToken(fileID, expires)))
+}
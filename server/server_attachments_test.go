@@ -0,0 +1,425 @@
+package server
+
+import (
+	"bytes"
+	"maps"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_PublishMultipleAttachments(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	logContent := "panic: something went wrong\n" + util.RandomString(5000)
+	body, contentType := newTestMultipartBody(t, map[string]string{
+		"message": "App crashed",
+	}, map[string]string{
+		"app.log":        logContent,
+		"screenshot.png": "\x89PNG\r\n\x1a\n" + util.RandomString(100),
+	})
+	response := request(t, s, "PUT", "/mytopic", body, map[string]string{
+		"Content-Type": contentType,
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "App crashed", m.Message)
+	require.Equal(t, 2, len(m.Attachments))
+	require.Equal(t, m.Attachment, m.Attachments[0]) // Backwards compatibility for old clients
+	require.Equal(t, "app.log", m.Attachments[0].Name)
+	require.Equal(t, "text/plain; charset=utf-8", m.Attachments[0].Type)
+	require.Equal(t, int64(len(logContent)), m.Attachments[0].Size)
+	require.Equal(t, "http://127.0.0.1:12345/file/"+m.ID+".txt", m.Attachments[0].URL)
+	require.Equal(t, "screenshot.png", m.Attachments[1].Name)
+	require.Equal(t, "image/png", m.Attachments[1].Type)
+	require.Equal(t, "http://127.0.0.1:12345/file/"+m.ID+"-1.png", m.Attachments[1].URL)
+	require.GreaterOrEqual(t, m.Attachments[1].Expires, time.Now().Add(179*time.Minute).Unix())
+	require.FileExists(t, filepath.Join(s.config.AttachmentCacheDir, m.ID))
+	require.FileExists(t, filepath.Join(s.config.AttachmentCacheDir, m.ID+"-1"))
+
+	// Each file is served by its index
+	response = request(t, s, "GET", "/file/"+m.ID+".txt", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, logContent, response.Body.String())
+	require.Equal(t, `attachment; filename="app.log"`, response.Header().Get("Content-Disposition"))
+	response = request(t, s, "GET", "/file/"+m.ID+"-1.png", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, `attachment; filename="screenshot.png"`, response.Header().Get("Content-Disposition"))
+	response = request(t, s, "GET", "/file/"+m.ID+"-2.png", "", nil)
+	require.Equal(t, 404, response.Code)
+
+	// Attachments are cached, and count towards the visitor's attachment storage
+	messages, err := s.messageCache.Messages("mytopic", sinceAllMessages, false)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, m.Attachments, messages[0].Attachments)
+	size, err := s.messageCache.AttachmentBytesUsedBySender("9.9.9.9") // See request()
+	require.Nil(t, err)
+	require.Equal(t, m.Attachments[0].Size+m.Attachments[1].Size, size)
+
+	// All files are deleted when the message is deleted
+	require.Nil(t, s.fileCache.Remove(m.ID))
+	require.NoFileExists(t, filepath.Join(s.config.AttachmentCacheDir, m.ID))
+	require.NoFileExists(t, filepath.Join(s.config.AttachmentCacheDir, m.ID+"-1"))
+}
+
+func TestServer_PublishMultipleAttachments_SingleFile(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	body, contentType := newTestMultipartBody(t, nil, map[string]string{
+		"notes.txt": "some notes",
+	})
+	response := request(t, s, "PUT", "/mytopic", body, map[string]string{
+		"Content-Type": contentType,
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "You received a file: notes.txt", m.Message)
+	require.Equal(t, "notes.txt", m.Attachment.Name)
+	require.Nil(t, m.Attachments)
+}
+
+func TestServer_PublishMultipleAttachments_Limits(t *testing.T) {
+	c := newTestConfig(t)
+	c.AttachmentFileSizeLimit = 1000
+	c.VisitorAttachmentTotalSizeLimit = 1500
+	s := newTestServer(t, c)
+
+	// Each file is within the file size limit, but both together exceed the total size limit
+	body, contentType := newTestMultipartBody(t, nil, map[string]string{
+		"a.bin": strings.Repeat("a", 900),
+		"b.bin": strings.Repeat("b", 900),
+	})
+	response := request(t, s, "PUT", "/mytopic", body, map[string]string{
+		"Content-Type": contentType,
+	})
+	require.Equal(t, 41301, toHTTPError(t, response.Body.String()).Code)
+
+	// Single file exceeds the file size limit
+	body, contentType = newTestMultipartBody(t, nil, map[string]string{
+		"a.bin": strings.Repeat("a", 1001),
+	})
+	response = request(t, s, "PUT", "/mytopic", body, map[string]string{
+		"Content-Type": contentType,
+	})
+	require.Equal(t, 41301, toHTTPError(t, response.Body.String()).Code)
+
+	// Too many files
+	files := make(map[string]string)
+	for _, name := range strings.Split("a b c d e f g h i j k", " ") {
+		files[name+".txt"] = name
+	}
+	body, contentType = newTestMultipartBody(t, nil, files)
+	response = request(t, s, "PUT", "/mytopic", body, map[string]string{
+		"Content-Type": contentType,
+	})
+	require.Equal(t, 40068, toHTTPError(t, response.Body.String()).Code)
+
+	// No leftover files
+	size, err := dirSize(c.AttachmentCacheDir)
+	require.Nil(t, err)
+	require.Equal(t, int64(0), size)
+}
+
+func TestServer_PublishMultipleAttachmentURLs(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "PUT", "/mytopic", "crash report", nil, func(r *http.Request) {
+		r.Header.Add("X-Attach", "https://example.com/app.log")
+		r.Header.Add("X-Attach", "https://example.com/screenshots/crash.png")
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "crash report", m.Message)
+	require.Equal(t, "app.log", m.Attachment.Name)
+	require.Equal(t, 2, len(m.Attachments))
+	require.Equal(t, "crash.png", m.Attachments[1].Name)
+	require.Equal(t, "https://example.com/screenshots/crash.png", m.Attachments[1].URL)
+
+	// Repeated query parameters
+	response = request(t, s, "PUT", "/mytopic?attach=https://example.com/1.jpg&attach=https://example.com/2.jpg&filename=first.jpg", "", nil)
+	require.Equal(t, 200, response.Code)
+	m = toMessage(t, response.Body.String())
+	require.Equal(t, "first.jpg", m.Attachments[0].Name)
+	require.Equal(t, "2.jpg", m.Attachments[1].Name)
+
+	// Attachment URLs cannot be combined with multipart uploads
+	body, contentType := newTestMultipartBody(t, nil, map[string]string{
+		"app.log": "log",
+	})
+	response = request(t, s, "PUT", "/mytopic", body, map[string]string{
+		"Content-Type": contentType,
+		"X-Attach":     "https://example.com/app.log",
+	})
+	require.Equal(t, 40069, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_AttachmentRangeRequests(t *testing.T) {
+	content := util.RandomString(5000) // > 4096
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "PUT", "/mytopic", content, nil)
+	m := toMessage(t, response.Body.String())
+	path := strings.TrimPrefix(m.Attachment.URL, "http://127.0.0.1:12345")
+
+	// Resume an interrupted download
+	response = request(t, s, "GET", path, "", map[string]string{
+		"Range": "bytes=4000-",
+	})
+	require.Equal(t, 206, response.Code)
+	require.Equal(t, "1000", response.Header().Get("Content-Length"))
+	require.Equal(t, "bytes 4000-4999/5000", response.Header().Get("Content-Range"))
+	require.Equal(t, "text/plain; charset=utf-8", response.Header().Get("Content-Type"))
+	require.Equal(t, content[4000:], response.Body.String())
+
+	// Range outside of file
+	response = request(t, s, "GET", path, "", map[string]string{
+		"Range": "bytes=6000-",
+	})
+	require.Equal(t, 416, response.Code)
+
+	// HEAD advertises range support
+	response = request(t, s, "HEAD", path, "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "bytes", response.Header().Get("Accept-Ranges"))
+	require.Equal(t, "5000", response.Header().Get("Content-Length"))
+	require.NotEmpty(t, response.Header().Get("ETag"))
+}
+
+func TestServer_AttachmentConditionalRequests(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "PUT", "/mytopic", util.RandomString(5000), nil)
+	m := toMessage(t, response.Body.String())
+	path := strings.TrimPrefix(m.Attachment.URL, "http://127.0.0.1:12345")
+
+	response = request(t, s, "GET", path, "", nil)
+	require.Equal(t, 200, response.Code)
+	etag := response.Header().Get("ETag")
+	lastModified := response.Header().Get("Last-Modified")
+	require.Equal(t, `"`+m.ID+`-5000"`, etag)
+	require.NotEmpty(t, lastModified)
+
+	response = request(t, s, "GET", path, "", map[string]string{
+		"If-None-Match": etag,
+	})
+	require.Equal(t, 304, response.Code)
+	require.Empty(t, response.Body.String())
+
+	response = request(t, s, "GET", path, "", map[string]string{
+		"If-Modified-Since": lastModified,
+	})
+	require.Equal(t, 304, response.Code)
+
+	response = request(t, s, "GET", path, "", map[string]string{
+		"If-None-Match": `"something-else"`,
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, 5000, response.Body.Len())
+}
+
+func TestServer_AttachmentBandwidthChargedForServedBytes(t *testing.T) {
+	content := util.RandomString(5000) // > 4096
+	c := newTestConfig(t)
+	c.VisitorAttachmentDailyBandwidthLimit = 5000 + 5000 + 1000 + 123 // Upload, one full download, and a 1,000 byte range
+	s := newTestServer(t, c)
+	response := request(t, s, "PUT", "/mytopic", content, nil)
+	m := toMessage(t, response.Body.String())
+	path := strings.TrimPrefix(m.Attachment.URL, "http://127.0.0.1:12345")
+
+	response = request(t, s, "GET", path, "", nil)
+	require.Equal(t, 200, response.Code)
+	etag := response.Header().Get("ETag")
+
+	// Not modified responses are free
+	for i := 0; i < 10; i++ {
+		response = request(t, s, "GET", path, "", map[string]string{
+			"If-None-Match": etag,
+		})
+		require.Equal(t, 304, response.Code)
+	}
+
+	// Only the requested range is charged
+	response = request(t, s, "GET", path, "", map[string]string{
+		"Range": "bytes=0-999",
+	})
+	require.Equal(t, 206, response.Code)
+	require.Equal(t, content[:1000], response.Body.String())
+
+	// Limit reached
+	response = request(t, s, "GET", path, "", map[string]string{
+		"Range": "bytes=1000-1999",
+	})
+	require.Equal(t, 429, response.Code)
+	require.Equal(t, 42905, toHTTPError(t, response.Body.String()).Code)
+	require.Empty(t, response.Header().Get("Content-Range"))
+}
+
+func TestParseAttachmentFileID(t *testing.T) {
+	messageID, index, ok := parseAttachmentFileID("abcdefghijkl")
+	require.True(t, ok)
+	require.Equal(t, "abcdefghijkl", messageID)
+	require.Equal(t, 0, index)
+
+	messageID, index, ok = parseAttachmentFileID(attachmentFileID("abcdefghijkl", 3))
+	require.True(t, ok)
+	require.Equal(t, "abcdefghijkl", messageID)
+	require.Equal(t, 3, index)
+
+	for _, fileID := range []string{"abc", "abcdefghijkl-", "abcdefghijkl-0", "abcdefghijkl-10", "abcdefghijkl-x", "abcdefghijklm"} {
+		_, _, ok := parseAttachmentFileID(fileID)
+		require.False(t, ok, fileID)
+	}
+}
+
+func TestServer_AttachmentDownload_ProtectedTopic(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("ben", "mytopic", user.PermissionReadWrite))
+	require.Nil(t, s.userManager.AllowAccess("phil", "mytopic", user.PermissionWrite))
+
+	content := util.RandomString(5000)
+	response := request(t, s, "PUT", "/mytopic", content, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	unsignedURL, _, _ := strings.Cut(m.Attachment.URL, "?")
+	fileURL := strings.TrimPrefix(unsignedURL, s.config.BaseURL)
+
+	// Only users with read access can download the file
+	for _, method := range []string{"GET", "HEAD"} {
+		response = request(t, s, method, fileURL, "", nil)
+		require.Equal(t, 403, response.Code)
+		response = request(t, s, method, fileURL, "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 403, response.Code)
+		response = request(t, s, method, fileURL, "", map[string]string{
+			"Authorization": util.BasicAuth("ben", "ben"),
+		})
+		require.Equal(t, 200, response.Code)
+	}
+
+	// Subscribers get a signed URL, which can be used without credentials
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.True(t, strings.HasPrefix(messages[0].Attachment.URL, unsignedURL+"?sig="))
+	signedFileURL := strings.TrimPrefix(messages[0].Attachment.URL, s.config.BaseURL)
+	response = request(t, s, "GET", signedFileURL, "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, content, response.Body.String())
+
+	// Publishers get a signed URL too, so write-only users can download their own upload
+	response = request(t, s, "PUT", "/mytopic", content, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	published := toMessage(t, response.Body.String())
+	require.Contains(t, published.Attachment.URL, "?sig=")
+	response = request(t, s, "GET", strings.TrimPrefix(published.Attachment.URL, s.config.BaseURL), "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, content, response.Body.String())
+
+	// Signatures are bound to the file and cannot be forged
+	response = request(t, s, "GET", signedFileURL+"x", "", nil)
+	require.Equal(t, 401, response.Code)
+	require.Equal(t, 40105, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "GET", fileURL+"?sig="+s.downloadURLToken(m.ID+"-1", time.Now().Add(time.Hour).Unix()), "", nil)
+	require.Equal(t, 401, response.Code)
+	response = request(t, s, "GET", fileURL+"?sig="+s.downloadURLToken(m.ID, time.Now().Add(-time.Minute).Unix()), "", nil)
+	require.Equal(t, 401, response.Code)
+}
+
+func TestServer_AttachmentDownload_PublicDownload(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	c.AttachmentPublicDownload = true
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("ben", "mytopic", user.PermissionReadWrite))
+
+	response := request(t, s, "PUT", "/mytopic", util.RandomString(5000), map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	// Anyone who knows the URL can download the file, and URLs are not signed
+	response = request(t, s, "GET", strings.TrimPrefix(m.Attachment.URL, s.config.BaseURL), "", nil)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, m.Attachment.URL, toMessages(t, response.Body.String())[0].Attachment.URL)
+}
+
+func TestServer_WithSignedAttachmentURLs(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	s := newTestServer(t, c)
+
+	m := newDefaultMessage("mytopic", "some message")
+	m.addAttachment(&attachment{Name: "a.jpg", URL: "http://127.0.0.1:12345/file/" + m.ID + ".jpg", Thumbnail: "http://127.0.0.1:12345/file/" + m.ID + "-thumb.jpg", Expires: time.Now().Add(time.Hour).Unix()})
+	m.addAttachment(&attachment{Name: "b.txt", URL: "http://127.0.0.1:12345/file/" + m.ID + "-1.txt", Expires: time.Now().Add(time.Hour).Unix()})
+	m.addAttachment(&attachment{Name: "c.jpg", URL: "https://example.com/c.jpg"})
+
+	signed := s.withSignedAttachmentURLs(m)
+	require.Equal(t, "http://127.0.0.1:12345/file/"+m.ID+".jpg?sig="+s.downloadURLToken(m.ID, m.Attachment.Expires), signed.Attachment.URL)
+	require.Equal(t, "http://127.0.0.1:12345/file/"+m.ID+"-thumb.jpg?sig="+s.downloadURLToken(m.ID+"-thumb", m.Attachment.Expires), signed.Attachment.Thumbnail)
+	require.Equal(t, signed.Attachment, signed.Attachments[0])
+	require.Equal(t, "http://127.0.0.1:12345/file/"+m.ID+"-1.txt?sig="+s.downloadURLToken(m.ID+"-1", m.Attachments[1].Expires), signed.Attachments[1].URL)
+	require.Equal(t, "https://example.com/c.jpg", signed.Attachments[2].URL) // External URLs are not signed
+
+	// Original message is not modified
+	require.Equal(t, "http://127.0.0.1:12345/file/"+m.ID+".jpg", m.Attachment.URL)
+	require.Equal(t, "http://127.0.0.1:12345/file/"+m.ID+"-thumb.jpg", m.Attachment.Thumbnail)
+	require.Equal(t, "http://127.0.0.1:12345/file/"+m.ID+"-1.txt", m.Attachments[1].URL)
+}
+
+func TestServer_AttachmentDownload_SigningKey(t *testing.T) {
+	// Generated key is stored in the attachment cache directory, and survives restarts
+	c := newTestConfigWithAuthFile(t)
+	s1 := newTestServer(t, c)
+	s2 := newTestServer(t, c)
+	require.Len(t, s1.fileSigningKey, fileSigningKeyLength)
+	require.Equal(t, s1.fileSigningKey, s2.fileSigningKey)
+	require.FileExists(t, filepath.Join(c.AttachmentCacheDir, fileSigningKeyFilename))
+	generatedKey := s1.fileSigningKey
+
+	// Configured key is shared between servers with different cache directories
+	c1 := newTestConfigWithAuthFile(t)
+	c1.AttachmentSigningKey = "some secret"
+	c2 := newTestConfigWithAuthFile(t)
+	c2.AttachmentSigningKey = "some secret"
+	s1, s2 = newTestServer(t, c1), newTestServer(t, c2)
+	expires := time.Now().Add(time.Hour).Unix()
+	require.True(t, s2.verifyDownloadURLToken("abcdefghijkl", s1.downloadURLToken("abcdefghijkl", expires)))
+	require.NotEqual(t, generatedKey, s1.fileSigningKey)
+}
+
+func newTestMultipartBody(t *testing.T, fields map[string]string, files map[string]string) (body string, contentType string) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	for name, value := range fields {
+		require.Nil(t, writer.WriteField(name, value))
+	}
+	for _, filename := range slices.Sorted(maps.Keys(files)) {
+		part, err := writer.CreateFormFile("file", filename)
+		require.Nil(t, err)
+		_, err = part.Write([]byte(files[filename]))
+		require.Nil(t, err)
+	}
+	require.Nil(t, writer.Close())
+	return buf.String(), writer.FormDataContentType()
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/util"
+)
+
+// Federation:
+//
+// If federation peers are configured, messages published to topics matching a peer's topic patterns are forwarded
+// to that peer (POST /v1/federation/publish), and messages received from a peer are accepted for the same topics.
+// In contrast to the upstream server (see forwardPollRequest), the full message is forwarded, so the peer can
+// deliver it to its own subscribers.
+//
+// Requests are signed with the secret shared between the two servers: the signature is the hex-encoded
+// HMAC-SHA256 of "<timestamp>\n<via>\n<body>", and requests older than federationMaxClockSkew are rejected.
+//
+// To prevent loops, each request carries the names of all servers the message has passed through (X-Federation-Via).
+// Messages are never forwarded to a server on that list, and messages that are already known (by ID) are dropped.
+// Message IDs received within the last federationMaxClockSkew are also kept in memory, so that replayed requests
+// are dropped even if the message cache is disabled.
+
+const (
+	federationPublishPath    = "/v1/federation/publish"
+	federationMaxClockSkew   = 5 * time.Minute
+	federationRequestTimeout = 10 * time.Second
+	federationViaSeparator   = ","
+)
+
+// FederationPeer is a trusted ntfy server that messages are exchanged with
+type FederationPeer struct {
+	Name    string   // Name the peer identifies itself with, i.e. its federation-name
+	BaseURL string   // Base URL of the peer, e.g. https://ntfy.example.com
+	Secret  string   // Secret shared with the peer, used to sign requests in both directions
+	Topics  []string // Topics (or patterns, e.g. "alerts-*") that are exchanged with the peer
+}
+
+// AllowsTopic returns true if the given topic is exchanged with this peer
+func (p *FederationPeer) AllowsTopic(topic string) bool {
+	for _, pattern := range p.Topics {
+		if matched, _ := path.Match(pattern, topic); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) handleFederationPublish(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, int64(s.config.MessageSizeLimit*2+jsonBodyBytesLimit)))
+	if err != nil {
+		return err
+	}
+	peer, err := s.federationPeer(r, body)
+	if err != nil {
+		return err
+	}
+	var m message
+	if err := json.Unmarshal(body, &m); err != nil || m.Event != messageEvent || !validMessageID(m.ID) {
+		return errHTTPBadRequestFederationMessageInvalid
+	} else if !peer.AllowsTopic(m.Topic) {
+		return errHTTPForbiddenFederationTopic
+	}
+	t, err := s.topicFromID(m.Topic)
+	if err != nil {
+		return err
+	}
+	via := federationVia(r)
+	ev := logvm(v, &m).Tag(tagFederation).With(t).Fields(log.Context{
+		"federation_peer": peer.Name,
+		"federation_via":  strings.Join(via, federationViaSeparator),
+	})
+	if slices.Contains(via, s.config.FederationName) {
+		ev.Debug("Ignoring federated message, it has already passed through this server")
+		return s.writeJSON(w, newSuccessResponse())
+	} else if _, err := s.messageCache.Message(m.ID); err == nil {
+		ev.Debug("Ignoring federated message, message already exists")
+		return s.writeJSON(w, newSuccessResponse())
+	} else if !s.markFederationMessageSeen(m.ID) {
+		ev.Debug("Ignoring federated message, message has been received recently")
+		return s.writeJSON(w, newSuccessResponse())
+	}
+	ev.Debug("Received federated message")
+	if !slices.Contains(via, peer.Name) {
+		via = append(via, peer.Name)
+	}
+	if err := s.publishForwardedMessage(v, t, &m, via); err != nil {
+		return err
+	}
+	minc(metricFederationMessagesReceived)
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// federationPeer returns the peer that sent the request, and verifies the request signature
+func (s *Server) federationPeer(r *http.Request, body []byte) (*FederationPeer, error) {
+	name := r.Header.Get("X-Federation-Peer")
+	timestamp, err := strconv.ParseInt(r.Header.Get("X-Federation-Timestamp"), 10, 64)
+	if err != nil {
+		return nil, errHTTPUnauthorizedFederation
+	}
+	if skew := time.Since(time.Unix(timestamp, 0)); skew > federationMaxClockSkew || skew < -federationMaxClockSkew {
+		return nil, errHTTPUnauthorizedFederation
+	}
+	signature, err := hex.DecodeString(r.Header.Get("X-Federation-Signature"))
+	if err != nil {
+		return nil, errHTTPUnauthorizedFederation
+	}
+	for _, peer := range s.config.FederationPeers {
+		if peer.Name == name {
+			if !hmac.Equal(signature, federationSignature(peer.Secret, timestamp, r.Header.Get("X-Federation-Via"), body)) {
+				return nil, errHTTPUnauthorizedFederation
+			}
+			return peer, nil
+		}
+	}
+	return nil, errHTTPUnauthorizedFederation
+}
+
+// federateMessage forwards a message to all peers that exchange the message's topic, except for the
+// peers the message has already passed through
+func (s *Server) federateMessage(v *visitor, m *message, via []string) {
+	if m.Event != messageEvent {
+		return
+	}
+	for _, peer := range s.config.FederationPeers {
+		if !peer.AllowsTopic(m.Topic) || slices.Contains(via, peer.Name) {
+			continue
+		}
+		ev := logvm(v, m).Tag(tagFederation).Field("federation_peer", peer.Name)
+		ev.Debug("Forwarding message to federation peer %s", peer.Name)
+		if err := s.sendToFederationPeer(peer, m, append(via, s.config.FederationName)); err != nil {
+			ev.Err(err).Warn("Unable to forward message to federation peer %s", peer.Name)
+			minc(metricFederationMessagesSentFailure)
+			continue
+		}
+		minc(metricFederationMessagesSentSuccess)
+	}
+}
+
+func (s *Server) sendToFederationPeer(peer *FederationPeer, m *message, via []string) error {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	viaHeader := strings.Join(via, federationViaSeparator)
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(peer.BaseURL, "/")+federationPublishPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "ntfy/"+s.config.Version)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Federation-Peer", s.config.FederationName)
+	req.Header.Set("X-Federation-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Federation-Signature", hex.EncodeToString(federationSignature(peer.Secret, timestamp, viaHeader, body)))
+	req.Header.Set("X-Federation-Via", viaHeader)
+	httpClient := &http.Client{
+		Timeout: federationRequestTimeout,
+	}
+	response, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("peer responded with HTTP %s", response.Status)
+	}
+	return nil
+}
+
+// markFederationMessageSeen records the ID of a received federated message, and returns false if the
+// message has already been received within the last federationMaxClockSkew
+func (s *Server) markFederationMessageSeen(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.federationSeen[id]; ok {
+		return false
+	}
+	s.federationSeen[id] = time.Now()
+	return true
+}
+
+func (s *Server) pruneFederationSeen() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, received := range s.federationSeen {
+		if time.Since(received) > 2*federationMaxClockSkew {
+			delete(s.federationSeen, id)
+		}
+	}
+}
+
+func federationSignature(secret string, timestamp int64, via string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(strconv.FormatInt(timestamp, 10) + "\n" + via + "\n"))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+func federationVia(r *http.Request) []string {
+	return util.SplitNoEmpty(r.Header.Get("X-Federation-Via"), federationViaSeparator)
+}
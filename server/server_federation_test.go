@@ -0,0 +1,180 @@
+package server
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testFederationSecret = "s3cr3t-s3cr3t-s3cr3t"
+
+func TestServer_Federation_PublishAndMirror(t *testing.T) {
+	a, b := newTestFederatedServers(t, []string{"alerts", "team-*"}, []string{"alerts", "team-*"})
+
+	response := request(t, a, "PUT", "/alerts", "disk full", map[string]string{
+		"Title":    "Server 1",
+		"Priority": "5",
+		"Tags":     "warning",
+	})
+	require.Equal(t, 200, response.Code)
+	sent := toMessage(t, response.Body.String())
+
+	// Message is mirrored to B, with the same ID and contents
+	waitFor(t, func() bool {
+		return len(toMessages(t, request(t, b, "GET", "/alerts/json?poll=1", "", nil).Body.String())) == 1
+	})
+	received := toMessage(t, request(t, b, "GET", "/alerts/json?poll=1", "", nil).Body.String())
+	require.Equal(t, sent.ID, received.ID)
+	require.Equal(t, "disk full", received.Message)
+	require.Equal(t, "Server 1", received.Title)
+	require.Equal(t, 5, received.Priority)
+	require.Equal(t, []string{"warning"}, received.Tags)
+
+	// Other direction, and topic patterns
+	response = request(t, b, "PUT", "/team-ops", "hello from b", nil)
+	require.Equal(t, 200, response.Code)
+	waitFor(t, func() bool {
+		return len(toMessages(t, request(t, a, "GET", "/team-ops/json?poll=1", "", nil).Body.String())) == 1
+	})
+
+	// Messages are not sent back to where they came from
+	time.Sleep(200 * time.Millisecond)
+	require.Equal(t, 1, len(toMessages(t, request(t, a, "GET", "/alerts/json?poll=1", "", nil).Body.String())))
+	require.Equal(t, 1, len(toMessages(t, request(t, b, "GET", "/team-ops/json?poll=1", "", nil).Body.String())))
+}
+
+func TestServer_Federation_TopicNotExchanged(t *testing.T) {
+	a, b := newTestFederatedServers(t, []string{"alerts", "private"}, []string{"alerts"})
+
+	// Not forwarded by A
+	response := request(t, a, "PUT", "/other", "not federated", nil)
+	require.Equal(t, 200, response.Code)
+
+	// Forwarded by A, but rejected by B
+	response = request(t, a, "PUT", "/private", "rejected by b", nil)
+	require.Equal(t, 200, response.Code)
+
+	time.Sleep(200 * time.Millisecond)
+	require.Empty(t, toMessages(t, request(t, b, "GET", "/other/json?poll=1", "", nil).Body.String()))
+	require.Empty(t, toMessages(t, request(t, b, "GET", "/private/json?poll=1", "", nil).Body.String()))
+}
+
+func TestServer_Federation_InvalidSignature(t *testing.T) {
+	c := newTestConfig(t)
+	c.FederationName = "ntfy-b"
+	c.FederationPeers = []*FederationPeer{{Name: "ntfy-a", BaseURL: "http://127.0.0.1:1", Secret: testFederationSecret, Topics: []string{"alerts"}}}
+	s := newTestServer(t, c)
+
+	body := `{"id":"abcdefghijkl","time":1700000000,"event":"message","topic":"alerts","message":"hi"}`
+	timestamp := time.Now().Unix()
+	signature := hex.EncodeToString(federationSignature(testFederationSecret, timestamp, "", []byte(body)))
+	tamperedVia := newTestFederationHeaders("ntfy-a", timestamp, signature)
+	tamperedVia["X-Federation-Via"] = "ntfy-b"
+
+	// Unknown peer, wrong secret, old timestamp, unsigned via header
+	for _, headers := range []map[string]string{
+		newTestFederationHeaders("ntfy-x", timestamp, signature),
+		newTestFederationHeaders("ntfy-a", timestamp, hex.EncodeToString(federationSignature("wrong", timestamp, "", []byte(body)))),
+		newTestFederationHeaders("ntfy-a", timestamp-3600, hex.EncodeToString(federationSignature(testFederationSecret, timestamp-3600, "", []byte(body)))),
+		newTestFederationHeaders("ntfy-a", timestamp, "not hex"),
+		tamperedVia,
+	} {
+		response := request(t, s, "POST", "/v1/federation/publish", body, headers)
+		require.Equal(t, 401, response.Code)
+		require.Equal(t, 40102, toHTTPError(t, response.Body.String()).Code)
+	}
+
+	// Valid, and duplicates and loops are ignored
+	response := request(t, s, "POST", "/v1/federation/publish", body, newTestFederationHeaders("ntfy-a", timestamp, signature))
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "POST", "/v1/federation/publish", body, newTestFederationHeaders("ntfy-a", timestamp, signature))
+	require.Equal(t, 200, response.Code)
+	loopBody := `{"id":"mnopqrstuvwx","time":1700000000,"event":"message","topic":"alerts","message":"loop"}`
+	headers := newTestFederationHeaders("ntfy-a", timestamp, hex.EncodeToString(federationSignature(testFederationSecret, timestamp, "ntfy-b,ntfy-a", []byte(loopBody))))
+	headers["X-Federation-Via"] = "ntfy-b,ntfy-a"
+	response = request(t, s, "POST", "/v1/federation/publish", loopBody, headers)
+	require.Equal(t, 200, response.Code)
+
+	messages := toMessages(t, request(t, s, "GET", "/alerts/json?poll=1", "", nil).Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "abcdefghijkl", messages[0].ID)
+	require.Equal(t, "hi", messages[0].Message)
+
+	// Invalid message
+	invalidBody := `{"id":"abc","event":"message","topic":"alerts"}`
+	response = request(t, s, "POST", "/v1/federation/publish", invalidBody, newTestFederationHeaders("ntfy-a", timestamp, hex.EncodeToString(federationSignature(testFederationSecret, timestamp, "", []byte(invalidBody)))))
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40057, toHTTPError(t, response.Body.String()).Code)
+
+	// Topic not exchanged with peer
+	otherBody := `{"id":"abcdefghijkl","time":1700000000,"event":"message","topic":"other","message":"hi"}`
+	response = request(t, s, "POST", "/v1/federation/publish", otherBody, newTestFederationHeaders("ntfy-a", timestamp, hex.EncodeToString(federationSignature(testFederationSecret, timestamp, "", []byte(otherBody)))))
+	require.Equal(t, 403, response.Code)
+	require.Equal(t, 40302, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_Federation_ReplayWithoutCache(t *testing.T) {
+	c := newTestConfig(t)
+	c.CacheDuration = 0
+	c.FederationName = "ntfy-b"
+	c.FederationPeers = []*FederationPeer{{Name: "ntfy-a", BaseURL: "http://127.0.0.1:1", Secret: testFederationSecret, Topics: []string{"alerts"}}}
+	s := newTestServer(t, c)
+
+	subscribeResponse := httptest.NewRecorder()
+	subscribeCancel := subscribe(t, s, "/alerts/json", subscribeResponse)
+
+	body := `{"id":"abcdefghijkl","time":1700000000,"event":"message","topic":"alerts","message":"hi"}`
+	timestamp := time.Now().Unix()
+	headers := newTestFederationHeaders("ntfy-a", timestamp, hex.EncodeToString(federationSignature(testFederationSecret, timestamp, "", []byte(body))))
+	for i := 0; i < 3; i++ {
+		response := request(t, s, "POST", "/v1/federation/publish", body, headers)
+		require.Equal(t, 200, response.Code)
+	}
+
+	subscribeCancel()
+	messages := toMessages(t, subscribeResponse.Body.String())
+	require.Equal(t, 2, len(messages)) // open + one message
+	require.Equal(t, "abcdefghijkl", messages[1].ID)
+}
+
+func TestServer_Federation_Disabled(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "POST", "/v1/federation/publish", `{}`, nil)
+	require.Equal(t, 404, response.Code)
+}
+
+// newTestFederatedServers creates two servers "ntfy-a" and "ntfy-b" that are peers of each other, exchanging
+// topicsA (as configured on A) and topicsB (as configured on B)
+func newTestFederatedServers(t *testing.T, topicsA, topicsB []string) (a *Server, b *Server) {
+	var handlerA, handlerB http.HandlerFunc
+	httpA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { handlerA(w, r) }))
+	t.Cleanup(httpA.Close)
+	httpB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { handlerB(w, r) }))
+	t.Cleanup(httpB.Close)
+
+	confA := newTestConfig(t)
+	confA.FederationName = "ntfy-a"
+	confA.FederationPeers = []*FederationPeer{{Name: "ntfy-b", BaseURL: httpB.URL, Secret: testFederationSecret, Topics: topicsA}}
+	a = newTestServer(t, confA)
+	handlerA = a.handle
+
+	confB := newTestConfig(t)
+	confB.FederationName = "ntfy-b"
+	confB.FederationPeers = []*FederationPeer{{Name: "ntfy-a", BaseURL: httpA.URL, Secret: testFederationSecret, Topics: topicsB}}
+	b = newTestServer(t, confB)
+	handlerB = b.handle
+	return a, b
+}
+
+func newTestFederationHeaders(peer string, timestamp int64, signature string) map[string]string {
+	return map[string]string{
+		"X-Federation-Peer":      peer,
+		"X-Federation-Timestamp": strconv.FormatInt(timestamp, 10),
+		"X-Federation-Signature": signature,
+	}
+}
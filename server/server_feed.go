@@ -0,0 +1,273 @@
+package server
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// RSS/Atom feeds:
+//
+// The /<topic>/rss and /<topic>/atom endpoints render the cached messages of one or more topics as an RSS 2.0
+// or Atom feed, so that (low-volume) topics can be followed in feed readers. Feeds are always polled, i.e. they
+// behave like /<topic>/json?poll=1, and support the same since, pagination and filter parameters. Entries are
+// listed newest first, as feed readers expect. Unless a limit is passed, only the newest feedDefaultLimit entries
+// are listed. Binary and end-to-end encrypted messages cannot be displayed, so they are rendered as a placeholder.
+
+const (
+	feedGenerator      = "ntfy"
+	feedTitleMaxLength = 80 // Max. number of characters of the message used as entry title, if there is no title
+	feedDefaultLimit   = 50 // Max. number of entries, if no limit is passed
+	feedEncryptedBody  = "(encrypted message)"
+	feedBinaryBody     = "(binary message)"
+	atomNamespace      = "http://www.w3.org/2005/Atom"
+)
+
+type rssFeed struct {
+	XMLName xml.Name    `xml:"rss"`
+	Version string      `xml:"version,attr"`
+	Channel *rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string     `xml:"title"`
+	Link          string     `xml:"link"`
+	Description   string     `xml:"description"`
+	Generator     string     `xml:"generator"`
+	LastBuildDate string     `xml:"lastBuildDate"`
+	Items         []*rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Description string        `xml:"description"`
+	Link        string        `xml:"link,omitempty"`
+	GUID        *rssGUID      `xml:"guid"`
+	PubDate     string        `xml:"pubDate"`
+	Categories  []string      `xml:"category"`
+	Enclosure   *rssEnclosure `xml:"enclosure"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type atomFeed struct {
+	XMLName   xml.Name     `xml:"feed"`
+	Namespace string       `xml:"xmlns,attr"`
+	Title     string       `xml:"title"`
+	ID        string       `xml:"id"`
+	Updated   string       `xml:"updated"`
+	Author    *atomAuthor  `xml:"author"`
+	Generator string       `xml:"generator"`
+	Links     []*atomLink  `xml:"link"`
+	Entries   []*atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title      string          `xml:"title"`
+	ID         string          `xml:"id"`
+	Updated    string          `xml:"updated"`
+	Content    *atomContent    `xml:"content"`
+	Links      []*atomLink     `xml:"link"`
+	Categories []*atomCategory `xml:"category"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+func (s *Server) handleSubscribeRSS(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	return s.handleSubscribeFeed(w, r, v, "application/rss+xml", func(topicsStr string, updated time.Time, messages []*message) any {
+		return newRSSFeed(s.config.BaseURL, topicsStr, updated, messages)
+	})
+}
+
+func (s *Server) handleSubscribeAtom(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	return s.handleSubscribeFeed(w, r, v, "application/atom+xml", func(topicsStr string, updated time.Time, messages []*message) any {
+		return newAtomFeed(s.config.BaseURL, topicsStr, updated, messages)
+	})
+}
+
+func (s *Server) handleSubscribeFeed(w http.ResponseWriter, r *http.Request, v *visitor, contentType string, render func(topicsStr string, updated time.Time, messages []*message) any) error {
+	topics, topicsStr, err := s.topicsFromPath(r.URL.Path)
+	if err != nil {
+		return err
+	}
+	scheduled := readBoolParam(r, false, "x-scheduled", "scheduled", "sched")
+	since, err := parseSince(r, true) // Feeds are always polled, so they include all messages by default
+	if err != nil {
+		return err
+	}
+	page, err := parsePagination(r)
+	if err != nil {
+		return err
+	}
+	filters, err := parseQueryFilters(r)
+	if err != nil {
+		return err
+	}
+	for _, t := range topics {
+		t.Keepalive()
+	}
+	page.newest = true // Only select the newest entries, rather than loading the entire history
+	if page.limit == 0 {
+		page.limit = feedDefaultLimit
+	}
+	messages, _, err := s.oldMessages(topics, since, scheduled, page, filters)
+	if err != nil {
+		return err
+	}
+	entries := make([]*message, 0, len(messages))
+	for i := len(messages) - 1; i >= 0; i-- { // Newest first
+		entries = append(entries, s.withSignedAttachmentURLs(messages[i]))
+	}
+	updated := time.Now()
+	if len(entries) > 0 {
+		updated = time.Unix(entries[0].Time, 0)
+	}
+	logvr(v, r).Tag(tagSubscribe).Debug("Rendering %s feed with %d entries", contentType, len(entries))
+	feed := render(topicsStr, updated, entries)
+	w.Header().Set("Access-Control-Allow-Origin", s.config.AccessControlAllowOrigin) // CORS, allow cross-origin requests
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	if _, err := w.Write([]byte(xml.Header)); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(feed)
+}
+
+func newRSSFeed(baseURL, topicsStr string, updated time.Time, messages []*message) *rssFeed {
+	items := make([]*rssItem, 0, len(messages))
+	for _, m := range messages {
+		item := &rssItem{
+			Title:       feedEntryTitle(m),
+			Description: feedEntryBody(m),
+			Link:        m.Click,
+			GUID:        &rssGUID{IsPermaLink: false, Value: m.ID},
+			PubDate:     time.Unix(m.Time, 0).UTC().Format(time.RFC1123Z),
+			Categories:  m.Tags,
+		}
+		if m.Attachment != nil && m.Attachment.URL != "" {
+			item.Enclosure = &rssEnclosure{
+				URL:    m.Attachment.URL,
+				Length: m.Attachment.Size,
+				Type:   feedAttachmentType(m.Attachment),
+			}
+		}
+		items = append(items, item)
+	}
+	return &rssFeed{
+		Version: "2.0",
+		Channel: &rssChannel{
+			Title:         feedTitle(topicsStr),
+			Link:          baseURL + "/" + topicsStr,
+			Description:   "Messages published to " + topicsStr,
+			Generator:     feedGenerator,
+			LastBuildDate: updated.UTC().Format(time.RFC1123Z),
+			Items:         items,
+		},
+	}
+}
+
+func newAtomFeed(baseURL, topicsStr string, updated time.Time, messages []*message) *atomFeed {
+	entries := make([]*atomEntry, 0, len(messages))
+	for _, m := range messages {
+		entry := &atomEntry{
+			Title:   feedEntryTitle(m),
+			ID:      "urn:ntfy:message:" + m.ID,
+			Updated: time.Unix(m.Time, 0).UTC().Format(time.RFC3339),
+			Content: &atomContent{Type: "text", Value: feedEntryBody(m)},
+		}
+		if m.Click != "" {
+			entry.Links = append(entry.Links, &atomLink{Href: m.Click, Rel: "alternate"})
+		}
+		if m.Attachment != nil && m.Attachment.URL != "" {
+			entry.Links = append(entry.Links, &atomLink{
+				Href:   m.Attachment.URL,
+				Rel:    "enclosure",
+				Type:   feedAttachmentType(m.Attachment),
+				Length: m.Attachment.Size,
+			})
+		}
+		for _, tag := range m.Tags {
+			entry.Categories = append(entry.Categories, &atomCategory{Term: tag})
+		}
+		entries = append(entries, entry)
+	}
+	return &atomFeed{
+		Namespace: atomNamespace,
+		Title:     feedTitle(topicsStr),
+		ID:        "urn:ntfy:topic:" + topicsStr,
+		Updated:   updated.UTC().Format(time.RFC3339),
+		Author:    &atomAuthor{Name: feedGenerator},
+		Generator: feedGenerator,
+		Links: []*atomLink{
+			{Href: baseURL + "/" + topicsStr, Rel: "alternate"},
+			{Href: baseURL + "/" + topicsStr + "/atom", Rel: "self", Type: "application/atom+xml"},
+		},
+		Entries: entries,
+	}
+}
+
+func feedTitle(topicsStr string) string {
+	return "ntfy: " + topicsStr
+}
+
+// feedEntryTitle returns the message title, or the (shortened) first line of the message if there is no title,
+// since feed readers typically only display the title in their entry list
+func feedEntryTitle(m *message) string {
+	if m.Title != "" {
+		return m.Title
+	}
+	title, _, _ := strings.Cut(feedEntryBody(m), "\n")
+	if utf8.RuneCountInString(title) > feedTitleMaxLength {
+		title = string([]rune(title)[:feedTitleMaxLength-3]) + "..."
+	}
+	return title
+}
+
+// feedEntryBody returns the message, or a placeholder if the message is base64-encoded or end-to-end encrypted,
+// since feed readers would display it as gibberish
+func feedEntryBody(m *message) string {
+	switch m.Encoding {
+	case encodingJWE:
+		return feedEncryptedBody
+	case encodingBase64:
+		return feedBinaryBody
+	}
+	return m.Message
+}
+
+func feedAttachmentType(a *attachment) string {
+	if a.Type != "" {
+		return a.Type
+	}
+	return "application/octet-stream"
+}
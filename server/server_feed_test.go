@@ -0,0 +1,219 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_SubscribeRSS(t *testing.T) {
+	c := newTestConfig(t)
+	c.BaseURL = "https://ntfy.example.com"
+	s := newTestServer(t, c)
+
+	request(t, s, "PUT", "/mytopic", "first message", map[string]string{
+		"Title": "Backup done",
+		"Tags":  "backup,white_check_mark",
+		"Click": "https://example.com/backups",
+	})
+	request(t, s, "PUT", "/mytopic", "second message\nwith two lines", map[string]string{
+		"Attach":   "https://example.com/file.jpg",
+		"Priority": "high",
+	})
+
+	response := request(t, s, "GET", "/mytopic/rss", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "application/rss+xml; charset=utf-8", response.Header().Get("Content-Type"))
+	require.True(t, strings.HasPrefix(response.Body.String(), xml.Header))
+
+	var feed rssFeed
+	require.Nil(t, xml.Unmarshal(response.Body.Bytes(), &feed))
+	require.Equal(t, "2.0", feed.Version)
+	require.Equal(t, "ntfy: mytopic", feed.Channel.Title)
+	require.Equal(t, "https://ntfy.example.com/mytopic", feed.Channel.Link)
+	require.Equal(t, 2, len(feed.Channel.Items))
+
+	item := feed.Channel.Items[0] // Newest first
+	require.Equal(t, "second message", item.Title)
+	require.Equal(t, "second message\nwith two lines", item.Description)
+	require.Equal(t, "https://example.com/file.jpg", item.Enclosure.URL)
+	require.Equal(t, "application/octet-stream", item.Enclosure.Type)
+
+	item = feed.Channel.Items[1]
+	require.Equal(t, "Backup done", item.Title)
+	require.Equal(t, "first message", item.Description)
+	require.Equal(t, "https://example.com/backups", item.Link)
+	require.Equal(t, []string{"backup", "white_check_mark"}, item.Categories)
+	require.False(t, item.GUID.IsPermaLink)
+	require.NotEmpty(t, item.GUID.Value)
+	_, err := time.Parse(time.RFC1123Z, item.PubDate)
+	require.Nil(t, err)
+
+	// Filters are applied
+	response = request(t, s, "GET", "/mytopic/rss?priority=high", "", nil)
+	var filtered rssFeed
+	require.Nil(t, xml.Unmarshal(response.Body.Bytes(), &filtered))
+	require.Equal(t, 1, len(filtered.Channel.Items))
+	require.Equal(t, "second message", filtered.Channel.Items[0].Title)
+}
+
+func TestServer_SubscribeAtom(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	request(t, s, "PUT", "/mytopic1", "message 1", map[string]string{"Tags": "tag1", "Click": "https://example.com"})
+	request(t, s, "PUT", "/mytopic2", "message 2", map[string]string{"Attach": "https://example.com/file.pdf"})
+
+	response := request(t, s, "GET", "/mytopic1,mytopic2/atom", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "application/atom+xml; charset=utf-8", response.Header().Get("Content-Type"))
+
+	var feed atomFeed
+	require.Nil(t, xml.Unmarshal(response.Body.Bytes(), &feed))
+	require.Equal(t, atomNamespace, feed.XMLName.Space)
+	require.Equal(t, "ntfy: mytopic1,mytopic2", feed.Title)
+	require.Equal(t, "urn:ntfy:topic:mytopic1,mytopic2", feed.ID)
+	require.Equal(t, 2, len(feed.Entries))
+
+	entry := feed.Entries[0]
+	require.Equal(t, "message 2", entry.Title)
+	require.Equal(t, "message 2", entry.Content.Value)
+	require.Equal(t, 1, len(entry.Links))
+	require.Equal(t, "enclosure", entry.Links[0].Rel)
+	require.Equal(t, "https://example.com/file.pdf", entry.Links[0].Href)
+
+	entry = feed.Entries[1]
+	require.Equal(t, "message 1", entry.Title)
+	require.True(t, strings.HasPrefix(entry.ID, "urn:ntfy:message:"))
+	require.Equal(t, "alternate", entry.Links[0].Rel)
+	require.Equal(t, "https://example.com", entry.Links[0].Href)
+	require.Equal(t, "tag1", entry.Categories[0].Term)
+	_, err := time.Parse(time.RFC3339, entry.Updated)
+	require.Nil(t, err)
+}
+
+func TestServer_SubscribeFeed_DefaultLimit(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	for i := 0; i < feedDefaultLimit+5; i++ {
+		request(t, s, "PUT", "/mytopic", fmt.Sprintf("message %d", i), nil)
+	}
+
+	var feed rssFeed
+	response := request(t, s, "GET", "/mytopic/rss", "", nil)
+	require.Nil(t, xml.Unmarshal(response.Body.Bytes(), &feed))
+	require.Equal(t, feedDefaultLimit, len(feed.Channel.Items))
+	require.Equal(t, fmt.Sprintf("message %d", feedDefaultLimit+4), feed.Channel.Items[0].Description)
+	require.Equal(t, "message 5", feed.Channel.Items[feedDefaultLimit-1].Description)
+
+	// Explicit limit
+	var limited rssFeed
+	response = request(t, s, "GET", "/mytopic/rss?limit=100", "", nil)
+	require.Nil(t, xml.Unmarshal(response.Body.Bytes(), &limited))
+	require.Equal(t, feedDefaultLimit+5, len(limited.Channel.Items))
+
+	// Limit smaller than the number of messages returns the newest messages
+	var newest rssFeed
+	response = request(t, s, "GET", "/mytopic/rss?limit=3", "", nil)
+	require.Nil(t, xml.Unmarshal(response.Body.Bytes(), &newest))
+	require.Equal(t, 3, len(newest.Channel.Items))
+	require.Equal(t, fmt.Sprintf("message %d", feedDefaultLimit+4), newest.Channel.Items[0].Description)
+	require.Equal(t, fmt.Sprintf("message %d", feedDefaultLimit+2), newest.Channel.Items[2].Description)
+}
+
+func TestServer_SubscribeFeed_LimitWithFilter(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	for i := 0; i < 10; i++ {
+		priority := "3"
+		if i < 3 {
+			priority = "5"
+		}
+		request(t, s, "PUT", "/mytopic", fmt.Sprintf("message %d", i), map[string]string{"Priority": priority})
+	}
+
+	// Filters are applied before the limit, so older matching messages are still found
+	var feed atomFeed
+	response := request(t, s, "GET", "/mytopic/atom?priority=5&limit=2", "", nil)
+	require.Nil(t, xml.Unmarshal(response.Body.Bytes(), &feed))
+	require.Equal(t, 2, len(feed.Entries))
+	require.Equal(t, "message 2", feed.Entries[0].Content.Value)
+	require.Equal(t, "message 1", feed.Entries[1].Content.Value)
+}
+
+func TestServer_SubscribeFeed_EncodedMessages(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	jwe := "eyJhbGciOiJkaXIiLCJlbmMiOiJBMjU2R0NNIn0..MTIzNDU2Nzg5MDEy.Y2lwaGVydGV4dA.dGFndGFndGFndGFndGFn"
+	response := request(t, s, "PUT", "/mytopic", jwe, map[string]string{"Encoding": "jwe"})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "PUT", "/mytopic?up=1", "\x00\xff binary", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "base64", toMessage(t, response.Body.String()).Encoding)
+
+	var feed atomFeed
+	response = request(t, s, "GET", "/mytopic/atom", "", nil)
+	require.Nil(t, xml.Unmarshal(response.Body.Bytes(), &feed))
+	require.Equal(t, 2, len(feed.Entries))
+	require.Equal(t, "(binary message)", feed.Entries[0].Title)
+	require.Equal(t, "(binary message)", feed.Entries[0].Content.Value)
+	require.Equal(t, "(encrypted message)", feed.Entries[1].Title)
+	require.Equal(t, "(encrypted message)", feed.Entries[1].Content.Value)
+}
+
+func TestServer_SubscribeFeed_Auth(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("ben", "mytopic", user.PermissionReadWrite))
+	u, err := s.userManager.User("ben")
+	require.Nil(t, err)
+	token, err := s.userManager.CreateToken(u.ID, "", time.Unix(0, 0), netip.IPv4Unspecified())
+	require.Nil(t, err)
+
+	request(t, s, "PUT", "/mytopic", "secret message", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+
+	response := request(t, s, "GET", "/mytopic/rss", "", nil)
+	require.Equal(t, 403, response.Code)
+
+	response = request(t, s, "GET", "/othertopic/atom?auth="+base64.RawURLEncoding.EncodeToString([]byte(util.BearerAuth(token.Value))), "", nil)
+	require.Equal(t, 403, response.Code)
+
+	response = request(t, s, "GET", "/mytopic/rss?auth="+base64.RawURLEncoding.EncodeToString([]byte(util.BearerAuth(token.Value))), "", nil)
+	require.Equal(t, 200, response.Code)
+	var feed rssFeed
+	require.Nil(t, xml.Unmarshal(response.Body.Bytes(), &feed))
+	require.Equal(t, 1, len(feed.Channel.Items))
+	require.Equal(t, "secret message", feed.Channel.Items[0].Description)
+}
+
+func TestServer_SubscribeFeed_SignedAttachmentURL(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("phil", "mytopic", user.PermissionReadWrite))
+	response := request(t, s, "PUT", "/mytopic", util.RandomString(5000), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	// Feed readers cannot pass credentials when downloading an enclosure
+	var feed rssFeed
+	response = request(t, s, "GET", "/mytopic/rss", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Nil(t, xml.Unmarshal(response.Body.Bytes(), &feed))
+	require.Equal(t, 1, len(feed.Channel.Items))
+	require.Contains(t, feed.Channel.Items[0].Enclosure.URL, "?sig=")
+	response = request(t, s, "GET", strings.TrimPrefix(feed.Channel.Items[0].Enclosure.URL, s.config.BaseURL), "", nil)
+	require.Equal(t, 200, response.Code)
+}
@@ -11,6 +11,7 @@ import (
 	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
 	"strings"
+	"time"
 )
 
 const (
@@ -118,6 +119,7 @@ func (c *firebaseSenderImpl) Send(m *messaging.Message) error {
 func toFirebaseMessage(m *message, auther user.Auther) (*messaging.Message, error) {
 	var data map[string]string // Mostly matches https://ntfy.sh/docs/subscribe/api/#json-message-format
 	var apnsConfig *messaging.APNSConfig
+	expires := m.Expires // m may be replaced by a poll request below
 	switch m.Event {
 	case keepaliveEvent, openEvent:
 		data = map[string]string{
@@ -177,11 +179,37 @@ func toFirebaseMessage(m *message, auther user.Auther) (*messaging.Message, erro
 			data["attachment_size"] = fmt.Sprintf("%d", m.Attachment.Size)
 			data["attachment_expires"] = fmt.Sprintf("%d", m.Attachment.Expires)
 			data["attachment_url"] = m.Attachment.URL
+			if m.Attachment.Thumbnail != "" {
+				data["attachment_thumbnail"] = m.Attachment.Thumbnail
+			}
+		}
+		if len(m.Attachments) > 1 {
+			attachments, err := json.Marshal(m.Attachments)
+			if err != nil {
+				return nil, err
+			}
+			data["attachments"] = string(attachments)
+		}
+		if len(m.Labels) > 0 {
+			labels, err := json.Marshal(m.Labels)
+			if err != nil {
+				return nil, err
+			}
+			data["labels"] = string(labels)
 		}
 		if m.PollID != "" {
 			data["poll_id"] = m.PollID
 		}
+		if m.Signature != "" {
+			data["signature"] = m.Signature
+		}
 		apnsConfig = createAPNSAlertConfig(m, data)
+		if m.Attachment != nil && m.Attachment.Thumbnail != "" {
+			// Lets iOS display the image in the notification, even if the app does not download the attachment
+			apnsConfig.FCMOptions = &messaging.APNSFCMOptions{
+				ImageURL: m.Attachment.Thumbnail,
+			}
+		}
 	}
 	var androidConfig *messaging.AndroidConfig
 	if m.Priority >= 4 {
@@ -189,6 +217,17 @@ func toFirebaseMessage(m *message, auther user.Auther) (*messaging.Message, erro
 			Priority: "high",
 		}
 	}
+	if ttl := time.Until(time.Unix(expires, 0)).Truncate(time.Second); expires > 0 && ttl > 0 {
+		// Do not deliver the notification after the message expired, e.g. if the device was offline
+		if androidConfig == nil {
+			androidConfig = &messaging.AndroidConfig{}
+		}
+		androidConfig.TTL = &ttl
+		if apnsConfig.Headers == nil {
+			apnsConfig.Headers = make(map[string]string)
+		}
+		apnsConfig.Headers["apns-expiration"] = fmt.Sprintf("%d", expires)
+	}
 	return maybeTruncateFCMMessage(&messaging.Message{
 		Topic:   m.Topic,
 		Data:    data,
@@ -9,6 +9,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"firebase.google.com/go/v4/messaging"
 	"github.com/stretchr/testify/require"
@@ -244,6 +245,54 @@ func TestToFirebaseMessage_Message_Normal_Not_Allowed(t *testing.T) {
 	require.Equal(t, "New message", fbm.APNS.Payload.Aps.Alert.Body)
 }
 
+func TestToFirebaseMessage_Message_WithExpires(t *testing.T) {
+	m := newDefaultMessage("mytopic", "door bell")
+	m.Priority = 4
+	m.Expires = time.Now().Add(5 * time.Minute).Unix()
+	fbm, err := toFirebaseMessage(m, nil)
+	require.Nil(t, err)
+	require.Equal(t, "high", fbm.Android.Priority)
+	require.True(t, *fbm.Android.TTL > 4*time.Minute && *fbm.Android.TTL <= 5*time.Minute)
+	require.Equal(t, fmt.Sprintf("%d", m.Expires), fbm.APNS.Headers["apns-expiration"])
+
+	// Already expired messages do not get a TTL
+	m.Expires = time.Now().Add(-time.Minute).Unix()
+	fbm, err = toFirebaseMessage(m, nil)
+	require.Nil(t, err)
+	require.Nil(t, fbm.Android.TTL)
+	require.Nil(t, fbm.APNS.Headers)
+}
+
+func TestToFirebaseMessage_Message_WithLabels(t *testing.T) {
+	m := newDefaultMessage("mytopic", "disk full")
+	m.Labels = map[string]string{"env": "prod", "host": "db1"}
+	fbm, err := toFirebaseMessage(m, nil)
+	require.Nil(t, err)
+	require.Equal(t, `{"env":"prod","host":"db1"}`, fbm.Data["labels"])
+	require.Equal(t, `{"env":"prod","host":"db1"}`, fbm.APNS.Payload.CustomData["labels"])
+}
+
+func TestToFirebaseMessage_Message_WithThumbnail(t *testing.T) {
+	m := newDefaultMessage("mytopic", "new photo")
+	m.Attachment = &attachment{
+		Name:      "photo.jpg",
+		Type:      "image/jpeg",
+		URL:       "https://ntfy.sh/file/abcdefghijkl.jpg",
+		Thumbnail: "https://ntfy.sh/file/abcdefghijkl-thumb.jpg",
+	}
+	fbm, err := toFirebaseMessage(m, nil)
+	require.Nil(t, err)
+	require.Equal(t, "https://ntfy.sh/file/abcdefghijkl-thumb.jpg", fbm.Data["attachment_thumbnail"])
+	require.Equal(t, "https://ntfy.sh/file/abcdefghijkl-thumb.jpg", fbm.APNS.FCMOptions.ImageURL)
+
+	// No thumbnail, no image
+	m.Attachment.Thumbnail = ""
+	fbm, err = toFirebaseMessage(m, nil)
+	require.Nil(t, err)
+	require.NotContains(t, fbm.Data, "attachment_thumbnail")
+	require.Nil(t, fbm.APNS.FCMOptions)
+}
+
 func TestToFirebaseMessage_PollRequest(t *testing.T) {
 	m := newPollRequestMessage("mytopic", "fOv6k1QbCzo6")
 	fbm, err := toFirebaseMessage(m, nil)
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"crypto/subtle"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"heckel.io/ntfy/v2/util"
+)
+
+// Gotify compatibility:
+//
+// To make migrating from Gotify (https://gotify.net) easier, ntfy optionally implements the two Gotify endpoints
+// that most scripts and apps use:
+//
+//	POST /message?token=<apptoken>   Publish a message (JSON or form data, with title, message, priority, extras)
+//	GET  /stream?token=<clienttoken> WebSocket stream of messages in Gotify's JSON format
+//
+// Gotify tokens are mapped to a ntfy topic, and optionally to a ntfy access token that is used to authenticate
+// the request (see Config.GotifyApps). Requests are then handled like regular publish/subscribe requests for
+// that topic, so access control, rate limiting, and everything else applies as usual.
+
+const (
+	gotifyMessagePath = "/message"
+	gotifyStreamPath  = "/stream"
+	gotifyMarkdown    = "text/markdown"
+)
+
+// GotifyApp maps a Gotify application or client token to a ntfy topic, and optionally to a ntfy access token
+type GotifyApp struct {
+	Token       string // Gotify token, as passed by the Gotify client via "?token=..." or "X-Gotify-Key"
+	Topic       string // Topic to publish to, or subscribe to
+	AccessToken string // ntfy access token (tk_...) used to authenticate the request, may be empty
+}
+
+type gotifyMessageRequest struct {
+	Title    string        `json:"title"`
+	Message  string        `json:"message"`
+	Priority *int          `json:"priority"`
+	Extras   *gotifyExtras `json:"extras"`
+}
+
+// gotifyMessageResponse is the Gotify message format, used as publish response and in the WebSocket stream
+type gotifyMessageResponse struct {
+	ID       uint32        `json:"id"`
+	AppID    int           `json:"appid"`
+	Title    string        `json:"title"`
+	Message  string        `json:"message"`
+	Priority int           `json:"priority"`
+	Extras   *gotifyExtras `json:"extras,omitempty"`
+	Date     string        `json:"date"`
+}
+
+// gotifyExtras are the well-known Gotify message extras, see https://gotify.net/docs/msgextras
+type gotifyExtras struct {
+	Display      *gotifyExtrasDisplay      `json:"client::display,omitempty"`
+	Notification *gotifyExtrasNotification `json:"client::notification,omitempty"`
+}
+
+type gotifyExtrasDisplay struct {
+	ContentType string `json:"contentType,omitempty"`
+}
+
+type gotifyExtrasNotification struct {
+	Click       *gotifyExtrasClick `json:"click,omitempty"`
+	BigImageURL string             `json:"bigImageUrl,omitempty"`
+}
+
+type gotifyExtrasClick struct {
+	URL string `json:"url,omitempty"`
+}
+
+func (s *Server) handlePublishGotify(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	m, err := s.handlePublishInternal(r, v)
+	if err != nil {
+		minc(metricMessagesPublishedFailure)
+		return err
+	}
+	minc(metricMessagesPublishedSuccess)
+	appID, err := fromContext[int](r, contextGotifyAppID)
+	if err != nil {
+		return err
+	}
+	return s.writeJSON(w, newGotifyMessageResponse(appID, m))
+}
+
+func (s *Server) handleSubscribeGotifyWS(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	appID, err := fromContext[int](r, contextGotifyAppID)
+	if err != nil {
+		return err
+	}
+	return s.handleSubscribeWSWithEncoder(w, r, v, func(msg *message) (any, error) {
+		if msg.Event != messageEvent {
+			return nil, nil // Gotify only knows messages, keepalives are sent as WebSocket pings
+		}
+		return newGotifyMessageResponse(appID, msg), nil
+	})
+}
+
+// transformGotifyMessage turns a Gotify publish request (JSON or form data) into a regular ntfy publish request
+// for the topic the Gotify token maps to, and authenticates the visitor with the mapped access token (if any)
+func (s *Server) transformGotifyMessage(next handleFunc) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		appID, app, err := s.gotifyApp(r)
+		if err != nil {
+			return err
+		}
+		m, err := readGotifyMessageRequest(w, r, s.config.MessageSizeLimit*2) // 2x to account for JSON format overhead
+		if err != nil {
+			return err
+		}
+		r.URL.Path = "/" + app.Topic
+		r.Body = io.NopCloser(strings.NewReader(m.Message))
+		r.Header.Del("Content-Type")
+		if m.Title != "" {
+			r.Header.Set("X-Title", m.Title)
+		}
+		if m.Priority != nil {
+			r.Header.Set("X-Priority", strconv.Itoa(gotifyToPriority(*m.Priority)))
+		}
+		if m.Extras != nil && m.Extras.Display != nil && m.Extras.Display.ContentType == gotifyMarkdown {
+			r.Header.Set("X-Markdown", "yes")
+		}
+		if m.Extras != nil && m.Extras.Notification != nil {
+			if m.Extras.Notification.Click != nil && m.Extras.Notification.Click.URL != "" {
+				r.Header.Set("X-Click", m.Extras.Notification.Click.URL)
+			}
+			if m.Extras.Notification.BigImageURL != "" {
+				r.Header.Set("X-Attach", m.Extras.Notification.BigImageURL)
+			}
+		}
+		v, err = s.authenticateGotifyApp(r, v, app)
+		if err != nil {
+			return err
+		}
+		return next(w, withContext(r, map[contextKey]any{contextGotifyAppID: appID}), v)
+	}
+}
+
+// transformGotifyStream turns a Gotify stream request into a WebSocket subscription to the topic the Gotify
+// token maps to, and authenticates the visitor with the mapped access token (if any)
+func (s *Server) transformGotifyStream(next handleFunc) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		appID, app, err := s.gotifyApp(r)
+		if err != nil {
+			return err
+		}
+		r.URL.Path = "/" + app.Topic + "/ws"
+		v, err = s.authenticateGotifyApp(r, v, app)
+		if err != nil {
+			return err
+		}
+		return next(w, withContext(r, map[contextKey]any{contextGotifyAppID: appID}), v)
+	}
+}
+
+// gotifyApp returns the Gotify app matching the token in the request, and its ID. Like Gotify, app IDs start at 1.
+func (s *Server) gotifyApp(r *http.Request) (int, *GotifyApp, error) {
+	token := readParam(r, "x-gotify-key", "token")
+	if token == "" {
+		return 0, nil, errHTTPUnauthorized
+	}
+	for i, app := range s.config.GotifyApps {
+		if subtle.ConstantTimeCompare([]byte(app.Token), []byte(token)) == 1 {
+			return i + 1, app, nil
+		}
+	}
+	return 0, nil, errHTTPUnauthorized
+}
+
+func (s *Server) authenticateGotifyApp(r *http.Request, v *visitor, app *GotifyApp) (*visitor, error) {
+	if app.AccessToken == "" {
+		return v, nil
+	}
+	r.Header.Set("Authorization", util.BearerAuth(app.AccessToken))
+	return s.maybeAuthenticate(r)
+}
+
+func readGotifyMessageRequest(w http.ResponseWriter, r *http.Request, limit int) (*gotifyMessageRequest, error) {
+	var m *gotifyMessageRequest
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var err error
+		m, err = readJSONWithLimit[gotifyMessageRequest](r.Body, limit, false)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		r.Body = http.MaxBytesReader(w, r.Body, int64(limit))
+		m = &gotifyMessageRequest{
+			Title:   r.FormValue("title"),
+			Message: r.FormValue("message"),
+		}
+		if priority := r.FormValue("priority"); priority != "" {
+			p, err := strconv.Atoi(priority)
+			if err != nil {
+				return nil, errHTTPBadRequestPriorityInvalid
+			}
+			m.Priority = &p
+		}
+	}
+	if m.Message == "" {
+		return nil, errHTTPBadRequestGotifyMessageInvalid
+	}
+	return m, nil
+}
+
+func newGotifyMessageResponse(appID int, m *message) *gotifyMessageResponse {
+	var extras *gotifyExtras
+	bigImageURL := gotifyBigImageURL(m)
+	if m.ContentType == gotifyMarkdown || m.Click != "" || bigImageURL != "" {
+		extras = &gotifyExtras{}
+		if m.ContentType == gotifyMarkdown {
+			extras.Display = &gotifyExtrasDisplay{ContentType: gotifyMarkdown}
+		}
+		if m.Click != "" || bigImageURL != "" {
+			extras.Notification = &gotifyExtrasNotification{BigImageURL: bigImageURL}
+			if m.Click != "" {
+				extras.Notification.Click = &gotifyExtrasClick{URL: m.Click}
+			}
+		}
+	}
+	return &gotifyMessageResponse{
+		ID:       gotifyMessageID(m.ID),
+		AppID:    appID,
+		Title:    m.Title,
+		Message:  m.Message,
+		Priority: priorityToGotify(m.Priority),
+		Extras:   extras,
+		Date:     time.Unix(m.Time, 0).Format(time.RFC3339),
+	}
+}
+
+// gotifyBigImageURL returns the attachment URL if the attachment is an image. External attachments have no
+// type, so they are assumed to be images, as they would be if they were published via Gotify's bigImageUrl.
+func gotifyBigImageURL(m *message) string {
+	if m.Attachment == nil || (m.Attachment.Type != "" && !strings.HasPrefix(m.Attachment.Type, "image/")) {
+		return ""
+	}
+	return m.Attachment.URL
+}
+
+// gotifyMessageID derives a numeric message ID from the ntfy message ID, since Gotify clients expect numbers
+func gotifyMessageID(id string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(id))
+	return h.Sum32()
+}
+
+// gotifyToPriority maps a Gotify priority (0-10) to a ntfy priority (1-5), following the meaning of the
+// priorities in the Gotify Android app: 0 is silent, 1-3 low, 4-7 default, and 8-10 high
+func gotifyToPriority(priority int) int {
+	switch {
+	case priority <= 0:
+		return 1
+	case priority <= 3:
+		return 2
+	case priority <= 7:
+		return 3
+	case priority <= 9:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// priorityToGotify maps a ntfy priority (1-5, or 0 for default) to a Gotify priority, such that mapping it back
+// using gotifyToPriority results in the same ntfy priority
+func priorityToGotify(priority int) int {
+	switch priority {
+	case 1:
+		return 0
+	case 2:
+		return 2
+	case 4:
+		return 8
+	case 5:
+		return 10
+	default:
+		return 5
+	}
+}
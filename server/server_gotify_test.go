@@ -0,0 +1,171 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_Gotify_PublishJSON(t *testing.T) {
+	c := newTestConfig(t)
+	c.GotifyApps = []*GotifyApp{
+		{Token: "AppToken1", Topic: "othertopic"},
+		{Token: "AppToken2", Topic: "mytopic"},
+	}
+	s := newTestServer(t, c)
+
+	body := `{"title":"Backup","message":"Backup **done**","priority":8,"extras":{"client::display":{"contentType":"text/markdown"},"client::notification":{"click":{"url":"https://example.com"},"bigImageUrl":"https://example.com/image.jpg"}}}`
+	response := request(t, s, "POST", "/message?token=AppToken2", body, map[string]string{
+		"Content-Type": "application/json",
+	})
+	require.Equal(t, 200, response.Code)
+
+	var gm gotifyMessageResponse
+	require.Nil(t, json.NewDecoder(response.Body).Decode(&gm))
+	require.NotZero(t, gm.ID)
+	require.Equal(t, 2, gm.AppID)
+	require.Equal(t, "Backup", gm.Title)
+	require.Equal(t, "Backup **done**", gm.Message)
+	require.Equal(t, 8, gm.Priority)
+	require.Equal(t, "text/markdown", gm.Extras.Display.ContentType)
+	require.Equal(t, "https://example.com", gm.Extras.Notification.Click.URL)
+	require.Equal(t, "https://example.com/image.jpg", gm.Extras.Notification.BigImageURL)
+
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "Backup", m.Title)
+	require.Equal(t, "Backup **done**", m.Message)
+	require.Equal(t, 4, m.Priority)
+	require.Equal(t, "text/markdown", m.ContentType)
+	require.Equal(t, "https://example.com", m.Click)
+	require.Equal(t, "https://example.com/image.jpg", m.Attachment.URL)
+	require.Equal(t, gotifyMessageID(m.ID), gm.ID)
+}
+
+func TestServer_Gotify_PublishForm(t *testing.T) {
+	c := newTestConfig(t)
+	c.GotifyApps = []*GotifyApp{{Token: "AppToken", Topic: "mytopic"}}
+	s := newTestServer(t, c)
+
+	response := request(t, s, "POST", "/message", "title=Hi+there&message=This+is+a+test&priority=0", map[string]string{
+		"Content-Type": "application/x-www-form-urlencoded",
+		"X-Gotify-Key": "AppToken",
+	})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "Hi there", m.Title)
+	require.Equal(t, "This is a test", m.Message)
+	require.Equal(t, 1, m.Priority)
+}
+
+func TestServer_Gotify_PublishInvalid(t *testing.T) {
+	c := newTestConfig(t)
+	c.GotifyApps = []*GotifyApp{{Token: "AppToken", Topic: "mytopic"}}
+	s := newTestServer(t, c)
+
+	response := request(t, s, "POST", "/message?token=WrongToken", `{"message":"hi"}`, map[string]string{"Content-Type": "application/json"})
+	require.Equal(t, 401, response.Code)
+
+	response = request(t, s, "POST", "/message", `{"message":"hi"}`, map[string]string{"Content-Type": "application/json"})
+	require.Equal(t, 401, response.Code)
+
+	response = request(t, s, "POST", "/message?token=AppToken", `{"title":"no message"}`, map[string]string{"Content-Type": "application/json"})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40052, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_Gotify_Disabled(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	// Without Gotify apps, "/message" is just a regular topic
+	response := request(t, s, "POST", "/message?token=AppToken", `{"message":"hi"}`, map[string]string{"Content-Type": "application/json"})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "message", toMessage(t, response.Body.String()).Topic)
+}
+
+func TestServer_Gotify_AccessToken(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("ben", "mytopic", user.PermissionReadWrite))
+	u, err := s.userManager.User("ben")
+	require.Nil(t, err)
+	token, err := s.userManager.CreateToken(u.ID, "", time.Unix(0, 0), netip.IPv4Unspecified())
+	require.Nil(t, err)
+
+	s.config.GotifyApps = []*GotifyApp{
+		{Token: "AppToken", Topic: "mytopic", AccessToken: token.Value},
+		{Token: "NoAccessToken", Topic: "mytopic"},
+		{Token: "OtherTopic", Topic: "othertopic", AccessToken: token.Value},
+	}
+
+	response := request(t, s, "POST", "/message?token=AppToken", "message=hi", map[string]string{"Content-Type": "application/x-www-form-urlencoded"})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "POST", "/message?token=NoAccessToken", "message=hi", map[string]string{"Content-Type": "application/x-www-form-urlencoded"})
+	require.Equal(t, 403, response.Code)
+
+	response = request(t, s, "POST", "/message?token=OtherTopic", "message=hi", map[string]string{"Content-Type": "application/x-www-form-urlencoded"})
+	require.Equal(t, 403, response.Code)
+
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, "hi", toMessage(t, response.Body.String()).Message)
+}
+
+func TestServer_Gotify_Stream(t *testing.T) {
+	c := newTestConfig(t)
+	c.GotifyApps = []*GotifyApp{{Token: "ClientToken", Topic: "mytopic"}}
+	s := newTestServer(t, c)
+	httpServer := httptest.NewServer(http.HandlerFunc(s.handle))
+	defer httpServer.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http")+"/stream?token=ClientToken", nil)
+	require.Nil(t, err)
+	defer conn.Close()
+
+	response := request(t, s, "PUT", "/mytopic", "my message", map[string]string{
+		"Title":    "my title",
+		"Priority": "low",
+		"Click":    "https://example.com",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	// The "open" event is not forwarded, so the first message is the published message
+	var gm gotifyMessageResponse
+	require.Nil(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	require.Nil(t, conn.ReadJSON(&gm))
+	require.Equal(t, gotifyMessageID(m.ID), gm.ID)
+	require.Equal(t, 1, gm.AppID)
+	require.Equal(t, "my title", gm.Title)
+	require.Equal(t, "my message", gm.Message)
+	require.Equal(t, 2, gm.Priority)
+	require.Nil(t, gm.Extras.Display)
+	require.Equal(t, "https://example.com", gm.Extras.Notification.Click.URL)
+}
+
+func TestServer_Gotify_PriorityMapping(t *testing.T) {
+	for gotifyPriority, priority := range []int{1, 2, 2, 2, 3, 3, 3, 3, 4, 4, 5} {
+		require.Equal(t, priority, gotifyToPriority(gotifyPriority))
+	}
+	for priority := 1; priority <= 5; priority++ {
+		require.Equal(t, priority, gotifyToPriority(priorityToGotify(priority)))
+	}
+	require.Equal(t, 5, priorityToGotify(0))
+	require.Equal(t, 5, gotifyToPriority(15))
+}
@@ -1,9 +1,12 @@
 package server
 
 import (
+	"errors"
 	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
 	"strings"
+	"time"
 )
 
 func (s *Server) execManager() {
@@ -15,6 +18,11 @@ func (s *Server) execManager() {
 	s.pruneTokens()
 	s.pruneAttachments()
 	s.pruneMessages()
+	s.pruneSignedURLs()
+	s.pruneWebhookDeliveries()
+	s.pruneFederationSeen()
+	s.pruneConsumers()
+	s.pruneUploads()
 	s.pruneAndNotifyWebPushSubscriptions()
 
 	// Message count per topic
@@ -168,10 +176,82 @@ func (s *Server) pruneAttachments() {
 		Debug("Deleted expired attachments")
 }
 
+func (s *Server) pruneWebhookDeliveries() {
+	if s.userManager == nil {
+		return
+	}
+	if err := s.messageCache.DeleteExpiredWebhookDeliveries(); err != nil {
+		log.Tag(tagManager).Err(err).Warn("Error deleting expired webhook deliveries")
+	}
+}
+
+func (s *Server) pruneSignedURLs() {
+	if s.config.PublishSigningKey == "" {
+		return
+	}
+	if err := s.messageCache.DeleteExpiredSignedURLs(); err != nil {
+		log.Tag(tagManager).Err(err).Warn("Error deleting expired signed URLs")
+	}
+}
+
+// pruneConsumers deletes durable consumers that have not been used for consumerExpiryDuration, and consumers
+// of users that no longer exist (e.g. if they were removed via the CLI)
+func (s *Server) pruneConsumers() {
+	if err := s.messageCache.DeleteStaleConsumers(time.Now().Add(-consumerExpiryDuration)); err != nil {
+		log.Tag(tagManager).Err(err).Warn("Error deleting stale durable consumers")
+	}
+	if s.userManager == nil {
+		return
+	}
+	consumers, err := s.messageCache.Consumers()
+	if err != nil {
+		log.Tag(tagManager).Err(err).Warn("Error retrieving durable consumers")
+		return
+	}
+	for _, c := range consumers {
+		if c.UserID == "" || strings.HasPrefix(c.UserID, consumerAnonymousOwnerPrefix) {
+			continue
+		}
+		if _, err := s.userManager.UserByID(c.UserID); errors.Is(err, user.ErrUserNotFound) {
+			if err := s.messageCache.DeleteConsumers(c.UserID); err != nil {
+				log.Tag(tagManager).Err(err).Warn("Error deleting durable consumers of removed user %s", c.UserID)
+			}
+		}
+	}
+}
+
+func (s *Server) pruneUploads() {
+	if s.fileCache == nil {
+		return
+	}
+	log.
+		Tag(tagManager).
+		Timing(func() {
+			ids, err := s.messageCache.UploadsExpired()
+			if err != nil {
+				log.Tag(tagManager).Err(err).Warn("Error retrieving expired uploads")
+			} else if len(ids) > 0 {
+				if log.Tag(tagManager).IsDebug() {
+					log.Tag(tagManager).Debug("Deleting abandoned uploads %s", strings.Join(ids, ", "))
+				}
+				if err := s.fileCache.Remove(ids...); err != nil {
+					log.Tag(tagManager).Err(err).Warn("Error deleting files of abandoned uploads")
+				}
+				if err := s.messageCache.DeleteUploads(ids...); err != nil {
+					log.Tag(tagManager).Err(err).Warn("Error deleting abandoned uploads")
+				}
+			} else {
+				log.Tag(tagManager).Debug("No abandoned uploads to delete")
+			}
+		}).
+		Debug("Deleted abandoned uploads")
+}
+
 func (s *Server) pruneMessages() {
 	log.
 		Tag(tagManager).
 		Timing(func() {
+			s.pruneMessagesBeyondCount()
 			expiredMessageIDs, err := s.messageCache.MessagesExpired()
 			if err != nil {
 				log.Tag(tagManager).Err(err).Warn("Error retrieving expired messages")
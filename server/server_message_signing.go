@@ -0,0 +1,81 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"heckel.io/ntfy/v2/util"
+)
+
+// Message signing:
+//
+// If message-signing-key is set, the server signs every message it publishes with an Ed25519 key, and adds the
+// base64-encoded signature in the "signature" field. This lets subscribers verify that a message was published by
+// this server, even if it was relayed through Firebase, UnifiedPush distributors or federation peers.
+//
+// The signature is computed over the canonical JSON representation of the message (see util.CanonicalJSON),
+// without the "signature" and "expires" fields. The expiry time is left out, because it may change after
+// the message was published, e.g. when a reservation is changed. The public key is published at
+// messageSigningKeyPath.
+
+const (
+	messageSigningAlgorithm = "ed25519"
+)
+
+var (
+	// messageSignatureExcludedFields are the top-level JSON fields that are not covered by the message signature
+	messageSignatureExcludedFields = []string{"signature", "expires"}
+
+	errInvalidMessageSigningKey = errors.New("invalid message signing key, must be a base64-encoded Ed25519 seed (32 bytes) or private key (64 bytes)")
+)
+
+func (s *Server) handleMessageSigningKey(w http.ResponseWriter, _ *http.Request, _ *visitor) error {
+	response := &apiMessageSigningKeyResponse{
+		Algorithm: messageSigningAlgorithm,
+		PublicKey: base64.StdEncoding.EncodeToString(s.messageSigningKey.Public().(ed25519.PublicKey)),
+	}
+	return s.writeJSON(w, response)
+}
+
+// signMessage sets the signature field of the given message, if message signing is enabled. Any existing
+// signature (e.g. of a message forwarded from another server) is replaced.
+func (s *Server) signMessage(m *message) error {
+	if s.messageSigningKey == nil {
+		return nil
+	}
+	m.Signature = ""
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	canonical, err := util.CanonicalJSON(b, messageSignatureExcludedFields...)
+	if err != nil {
+		return err
+	}
+	m.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(s.messageSigningKey, canonical))
+	return nil
+}
+
+// parseMessageSigningKey parses a base64-encoded Ed25519 private key, either as a 32-byte seed, or
+// as a 64-byte private key (seed and public key)
+func parseMessageSigningKey(key string) (ed25519.PrivateKey, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil {
+		return nil, errInvalidMessageSigningKey
+	}
+	switch len(b) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(b), nil
+	case ed25519.PrivateKeySize:
+		privateKey := ed25519.PrivateKey(b)
+		if !ed25519.NewKeyFromSeed(privateKey.Seed()).Equal(privateKey) {
+			return nil, errInvalidMessageSigningKey
+		}
+		return privateKey, nil
+	}
+	return nil, errInvalidMessageSigningKey
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/util"
+)
+
+const testMessageSigningKey = "3n9Zb1y4sQ7f1kR2c0xW6vJ8hT5mLq9uA2dE7pG4oYs="
+
+func TestServer_MessageSigning_PublishAndPoll(t *testing.T) {
+	c := newTestConfig(t)
+	c.MessageSigningKey = testMessageSigningKey
+	s := newTestServer(t, c)
+
+	response := request(t, s, "GET", "/.well-known/ntfy-signing-key", "", nil)
+	require.Equal(t, 200, response.Code)
+	var key apiMessageSigningKeyResponse
+	require.Nil(t, json.NewDecoder(strings.NewReader(response.Body.String())).Decode(&key))
+	require.Equal(t, "ed25519", key.Algorithm)
+	publicKey, err := base64.StdEncoding.DecodeString(key.PublicKey)
+	require.Nil(t, err)
+
+	response = request(t, s, "PUT", "/mytopic", "<b>hi</b> & bye", map[string]string{
+		"Title": "Some title",
+		"Tags":  "warning,skull",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.NotEmpty(t, m.Signature)
+	verifyTestMessageSignature(t, publicKey, response.Body.String(), true)
+
+	// Cached message carries the same signature
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	require.Equal(t, 200, response.Code)
+	polled := toMessage(t, response.Body.String())
+	require.Equal(t, m.Signature, polled.Signature)
+	verifyTestMessageSignature(t, publicKey, response.Body.String(), true)
+
+	// Tampered message
+	verifyTestMessageSignature(t, publicKey, strings.Replace(response.Body.String(), "Some title", "Other title", 1), false)
+}
+
+func TestServer_MessageSigning_Disabled(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "GET", "/.well-known/ntfy-signing-key", "", nil)
+	require.Equal(t, 404, response.Code)
+
+	response = request(t, s, "PUT", "/mytopic", "hi", nil)
+	require.Equal(t, 200, response.Code)
+	require.Empty(t, toMessage(t, response.Body.String()).Signature)
+}
+
+func TestServer_MessageSigning_ParseKey(t *testing.T) {
+	seed, err := base64.StdEncoding.DecodeString(testMessageSigningKey)
+	require.Nil(t, err)
+	privateKey := ed25519.NewKeyFromSeed(seed)
+
+	key, err := parseMessageSigningKey(testMessageSigningKey)
+	require.Nil(t, err)
+	require.True(t, privateKey.Equal(key))
+
+	key, err = parseMessageSigningKey(base64.StdEncoding.EncodeToString(privateKey))
+	require.Nil(t, err)
+	require.True(t, privateKey.Equal(key))
+
+	for _, invalid := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("too short")), base64.StdEncoding.EncodeToString(make([]byte, 64))} {
+		_, err = parseMessageSigningKey(invalid)
+		require.Equal(t, errInvalidMessageSigningKey, err)
+	}
+	c := newTestConfig(t)
+	c.MessageSigningKey = "invalid"
+	_, err = New(c)
+	require.Equal(t, errInvalidMessageSigningKey, err)
+}
+
+func verifyTestMessageSignature(t *testing.T, publicKey ed25519.PublicKey, raw string, valid bool) {
+	var m message
+	require.Nil(t, json.NewDecoder(strings.NewReader(raw)).Decode(&m))
+	signature, err := base64.StdEncoding.DecodeString(m.Signature)
+	require.Nil(t, err)
+	canonical, err := util.CanonicalJSON([]byte(raw), "signature", "expires")
+	require.Nil(t, err)
+	require.Equal(t, valid, ed25519.Verify(publicKey, canonical, signature))
+}
@@ -5,27 +5,37 @@ import (
 )
 
 var (
-	metricMessagesPublishedSuccess     prometheus.Counter
-	metricMessagesPublishedFailure     prometheus.Counter
-	metricMessagesCached               prometheus.Gauge
-	metricMessagePublishDurationMillis prometheus.Gauge
-	metricFirebasePublishedSuccess     prometheus.Counter
-	metricFirebasePublishedFailure     prometheus.Counter
-	metricEmailsPublishedSuccess       prometheus.Counter
-	metricEmailsPublishedFailure       prometheus.Counter
-	metricEmailsReceivedSuccess        prometheus.Counter
-	metricEmailsReceivedFailure        prometheus.Counter
-	metricCallsMadeSuccess             prometheus.Counter
-	metricCallsMadeFailure             prometheus.Counter
-	metricUnifiedPushPublishedSuccess  prometheus.Counter
-	metricMatrixPublishedSuccess       prometheus.Counter
-	metricMatrixPublishedFailure       prometheus.Counter
-	metricAttachmentsTotalSize         prometheus.Gauge
-	metricVisitors                     prometheus.Gauge
-	metricSubscribers                  prometheus.Gauge
-	metricTopics                       prometheus.Gauge
-	metricUsers                        prometheus.Gauge
-	metricHTTPRequests                 *prometheus.CounterVec
+	metricMessagesPublishedSuccess      prometheus.Counter
+	metricMessagesPublishedFailure      prometheus.Counter
+	metricMessagesCached                prometheus.Gauge
+	metricMessagePublishDurationMillis  prometheus.Gauge
+	metricFirebasePublishedSuccess      prometheus.Counter
+	metricFirebasePublishedFailure      prometheus.Counter
+	metricEmailsPublishedSuccess        prometheus.Counter
+	metricEmailsPublishedFailure        prometheus.Counter
+	metricEmailsReceivedSuccess         prometheus.Counter
+	metricEmailsReceivedFailure         prometheus.Counter
+	metricSyslogMessagesReceivedSuccess prometheus.Counter
+	metricSyslogMessagesReceivedFailure prometheus.Counter
+	metricFederationMessagesSentSuccess prometheus.Counter
+	metricFederationMessagesSentFailure prometheus.Counter
+	metricFederationMessagesReceived    prometheus.Counter
+	metricRelayMessagesSuccess          prometheus.Counter
+	metricRelayMessagesFailure          prometheus.Counter
+	metricCallsMadeSuccess              prometheus.Counter
+	metricCallsMadeFailure              prometheus.Counter
+	metricUnifiedPushPublishedSuccess   prometheus.Counter
+	metricMatrixPublishedSuccess        prometheus.Counter
+	metricMatrixPublishedFailure        prometheus.Counter
+	metricAttachmentsTotalSize          prometheus.Gauge
+	metricVisitors                      prometheus.Gauge
+	metricSubscribers                   prometheus.Gauge
+	metricSubscriberQueueDepth          prometheus.Gauge
+	metricSubscriberMessagesDropped     prometheus.Counter
+	metricSubscribersDropped            prometheus.Counter
+	metricTopics                        prometheus.Gauge
+	metricUsers                         prometheus.Gauge
+	metricHTTPRequests                  *prometheus.CounterVec
 )
 
 func initMetrics() {
@@ -59,6 +69,27 @@ func initMetrics() {
 	metricEmailsReceivedFailure = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "ntfy_emails_received_failure",
 	})
+	metricSyslogMessagesReceivedSuccess = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ntfy_syslog_messages_received_success",
+	})
+	metricSyslogMessagesReceivedFailure = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ntfy_syslog_messages_received_failure",
+	})
+	metricFederationMessagesSentSuccess = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ntfy_federation_messages_sent_success",
+	})
+	metricFederationMessagesSentFailure = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ntfy_federation_messages_sent_failure",
+	})
+	metricFederationMessagesReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ntfy_federation_messages_received",
+	})
+	metricRelayMessagesSuccess = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ntfy_relay_messages_success",
+	})
+	metricRelayMessagesFailure = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ntfy_relay_messages_failure",
+	})
 	metricCallsMadeSuccess = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "ntfy_calls_made_success",
 	})
@@ -86,6 +117,15 @@ func initMetrics() {
 	metricSubscribers = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "ntfy_subscribers_total",
 	})
+	metricSubscriberQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ntfy_subscriber_queue_depth",
+	})
+	metricSubscriberMessagesDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ntfy_subscriber_messages_dropped",
+	})
+	metricSubscribersDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ntfy_subscribers_dropped",
+	})
 	metricTopics = prometheus.NewGauge(prometheus.GaugeOpts{
 		Name: "ntfy_topics_total",
 	})
@@ -103,6 +143,13 @@ func initMetrics() {
 		metricEmailsPublishedFailure,
 		metricEmailsReceivedSuccess,
 		metricEmailsReceivedFailure,
+		metricSyslogMessagesReceivedSuccess,
+		metricSyslogMessagesReceivedFailure,
+		metricFederationMessagesSentSuccess,
+		metricFederationMessagesSentFailure,
+		metricFederationMessagesReceived,
+		metricRelayMessagesSuccess,
+		metricRelayMessagesFailure,
 		metricCallsMadeSuccess,
 		metricCallsMadeFailure,
 		metricUnifiedPushPublishedSuccess,
@@ -112,6 +159,9 @@ func initMetrics() {
 		metricVisitors,
 		metricUsers,
 		metricSubscribers,
+		metricSubscriberQueueDepth,
+		metricSubscriberMessagesDropped,
+		metricSubscribersDropped,
 		metricTopics,
 		metricHTTPRequests,
 	)
@@ -130,3 +180,10 @@ func mset[T int | int64 | float64](gauge prometheus.Gauge, value T) {
 		gauge.Set(float64(value))
 	}
 }
+
+// madd adds the given value to a prometheus.Gauge if it is non-nil
+func madd[T int | int64 | float64](gauge prometheus.Gauge, value T) {
+	if gauge != nil {
+		gauge.Add(float64(value))
+	}
+}
@@ -12,6 +12,8 @@ const (
 	contextRateVisitor contextKey = iota + 2586
 	contextTopic
 	contextMatrixPushKey
+	contextGotifyAppID
+	contextUpload
 )
 
 func (s *Server) limitRequests(next handleFunc) handleFunc {
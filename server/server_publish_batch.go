@@ -0,0 +1,100 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"heckel.io/ntfy/v2/user"
+)
+
+const (
+	publishBatchLimit = 100
+)
+
+// handlePublishBatch publishes up to publishBatchLimit JSON messages at once. The request counts as one request, but
+// each message is authorized and rate limited individually, and gets its own result in the response.
+func (s *Server) handlePublishBatch(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[[]*publishMessage](r.Body, publishBatchLimit*s.config.MessageSizeLimit*2, false) // 2x to account for JSON format overhead
+	if err != nil {
+		return err
+	} else if len(*req) == 0 || len(*req) > publishBatchLimit {
+		return errHTTPBadRequestBatchInvalid
+	}
+	results := make([]*apiPublishBatchResult, 0, len(*req))
+	cached := make([]*message, 0, len(*req))
+	cachedIndexes := make([]int, 0, len(*req)) // Index of each cached message in results
+	for _, pm := range *req {
+		m, cache, err := s.handlePublishBatchMessage(r, v, pm)
+		if err != nil {
+			httpErr, ok := err.(*errHTTP)
+			if !ok {
+				logvr(v, r).Tag(tagPublish).Err(err).Warn("Publishing batch message failed")
+				httpErr = errHTTPInternalError
+			}
+			results = append(results, &apiPublishBatchResult{Error: httpErr})
+			continue
+		}
+		if cache {
+			cached = append(cached, m)
+			cachedIndexes = append(cachedIndexes, len(results))
+		}
+		results = append(results, &apiPublishBatchResult{Message: m})
+	}
+	if len(cached) > 0 {
+		// Messages have already been delivered at this point, so an error must not fail the request, or clients
+		// would retry and deliver the messages again. Delayed messages however only exist in the cache, so they
+		// are reported as failed, and can be retried.
+		logvr(v, r).Tag(tagPublish).Debug("Adding %d message(s) to cache", len(cached))
+		if err := s.messageCache.AddMessages(cached); err != nil {
+			logvr(v, r).Tag(tagPublish).Err(err).Error("Unable to add %d batch message(s) to cache", len(cached))
+			now := time.Now().Unix()
+			for i, m := range cached {
+				if m.Time > now {
+					results[cachedIndexes[i]] = &apiPublishBatchResult{Error: errHTTPInternalError}
+				}
+			}
+		}
+	}
+	for _, result := range results {
+		if result.Error != nil {
+			minc(metricMessagesPublishedFailure)
+		} else {
+			minc(metricMessagesPublishedSuccess)
+		}
+	}
+	return s.writeJSON(w, &apiPublishBatchResponse{Results: results})
+}
+
+// handlePublishBatchMessage publishes a single message of a batch request. It rewrites a copy of the original
+// request as if it was a regular JSON publish request, authorizes it and sets the topic and rate visitor, just
+// like authorizeTopicWrite and limitRequestsWithTopic do for single messages.
+func (s *Server) handlePublishBatchMessage(r *http.Request, v *visitor, pm *publishMessage) (*message, bool, error) {
+	if pm == nil {
+		return nil, false, errHTTPBadRequestMessageJSONInvalid
+	}
+	mr := r.Clone(r.Context())
+	mr.URL.RawQuery = ""
+	mr.Header = make(http.Header)
+	if err := applyPublishMessage(mr, pm); err != nil {
+		return nil, false, err
+	}
+	t, err := s.topicFromID(pm.Topic)
+	if err != nil {
+		return nil, false, err
+	}
+	if s.userManager != nil {
+		if err := s.userManager.Authorize(v.User(), t.ID, user.PermissionWrite); err != nil {
+			logvr(v, r).With(t).Err(err).Debug("Access to topic %s not authorized", t.ID)
+			return nil, false, errHTTPForbidden.With(t)
+		}
+	}
+	vrate := v
+	if rateVisitor := t.RateVisitor(); rateVisitor != nil {
+		vrate = rateVisitor
+	}
+	mr = withContext(mr, map[contextKey]any{
+		contextRateVisitor: vrate,
+		contextTopic:       t,
+	})
+	return s.handlePublishWithoutCache(mr, v)
+}
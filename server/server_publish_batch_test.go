@@ -0,0 +1,157 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_PublishBatch(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	body := `[
+		{"topic":"mytopic","message":"first","title":"a title","priority":4,"tags":["tag1"]},
+		{"topic":"anothertopic","message":"second","cache":"no"},
+		{"topic":"invalid topic!","message":"third"},
+		{"topic":"mytopic","message":"fourth","delay":"1 hour"}
+	]`
+	response := request(t, s, "POST", "/v1/publish/batch", body, nil)
+	require.Equal(t, 200, response.Code)
+	results := toPublishBatchResponse(t, response.Body.String()).Results
+	require.Len(t, results, 4)
+
+	require.Nil(t, results[0].Error)
+	require.Equal(t, "mytopic", results[0].Message.Topic)
+	require.Equal(t, "first", results[0].Message.Message)
+	require.Equal(t, "a title", results[0].Message.Title)
+	require.Equal(t, 4, results[0].Message.Priority)
+	require.Equal(t, []string{"tag1"}, results[0].Message.Tags)
+
+	require.Nil(t, results[1].Error)
+	require.Equal(t, "anothertopic", results[1].Message.Topic)
+	require.Equal(t, "second", results[1].Message.Message)
+
+	require.Nil(t, results[2].Message)
+	require.Equal(t, 40009, results[2].Error.Code)
+
+	require.Nil(t, results[3].Error)
+	require.Equal(t, "fourth", results[3].Message.Message)
+
+	// Cached messages were added, non-cached message was not
+	response = request(t, s, "GET", "/mytopic/json?poll=1&scheduled=1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Len(t, messages, 2)
+	require.Equal(t, "first", messages[0].Message)
+	require.Equal(t, "fourth", messages[1].Message)
+
+	response = request(t, s, "GET", "/anothertopic/json?poll=1", "", nil)
+	require.Empty(t, toMessages(t, response.Body.String()))
+}
+
+func TestServer_PublishBatch_Auth(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("ben", "mytopic", user.PermissionReadWrite))
+
+	body := `[{"topic":"mytopic","message":"allowed"},{"topic":"secret","message":"not allowed"}]`
+	response := request(t, s, "POST", "/v1/publish/batch", body, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	results := toPublishBatchResponse(t, response.Body.String()).Results
+	require.Len(t, results, 2)
+	require.Equal(t, "allowed", results[0].Message.Message)
+	require.Nil(t, results[1].Message)
+	require.Equal(t, 40301, results[1].Error.Code)
+
+	// Anonymous users cannot publish to any topic
+	response = request(t, s, "POST", "/v1/publish/batch", body, nil)
+	require.Equal(t, 200, response.Code)
+	results = toPublishBatchResponse(t, response.Body.String()).Results
+	require.Equal(t, 40301, results[0].Error.Code)
+	require.Equal(t, 40301, results[1].Error.Code)
+}
+
+func TestServer_PublishBatch_MessageLimit(t *testing.T) {
+	c := newTestConfig(t)
+	c.VisitorRequestLimitBurst = 1
+	c.VisitorMessageDailyLimit = 3
+	s := newTestServer(t, c)
+
+	items := make([]string, 0)
+	for i := 0; i < 5; i++ {
+		items = append(items, fmt.Sprintf(`{"topic":"mytopic","message":"message %d"}`, i))
+	}
+	response := request(t, s, "POST", "/v1/publish/batch", "["+strings.Join(items, ",")+"]", nil)
+	require.Equal(t, 200, response.Code)
+	results := toPublishBatchResponse(t, response.Body.String()).Results
+	require.Len(t, results, 5)
+	for i := 0; i < 3; i++ {
+		require.Nil(t, results[i].Error)
+	}
+	for i := 3; i < 5; i++ {
+		require.Equal(t, 42908, results[i].Error.Code)
+	}
+
+	// Batch counts as one request against the request limit
+	response = request(t, s, "POST", "/v1/publish/batch", `[{"topic":"mytopic","message":"hi"}]`, nil)
+	require.Equal(t, 429, response.Code)
+}
+
+func TestServer_PublishBatch_CacheError(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	_, err := s.messageCache.db.Exec(`DROP TABLE messages`)
+	require.Nil(t, err)
+
+	// Messages were delivered, so the results are returned even if they could not be cached, but delayed
+	// messages only exist in the cache, so they are reported as failed
+	body := `[
+		{"topic":"mytopic","message":"first"},
+		{"topic":"mytopic","message":"second"},
+		{"topic":"mytopic","message":"delayed","delay":"1 hour"}
+	]`
+	response := request(t, s, "POST", "/v1/publish/batch", body, nil)
+	require.Equal(t, 200, response.Code)
+	results := toPublishBatchResponse(t, response.Body.String()).Results
+	require.Len(t, results, 3)
+	require.Nil(t, results[0].Error)
+	require.Equal(t, "first", results[0].Message.Message)
+	require.Nil(t, results[1].Error)
+	require.Equal(t, "second", results[1].Message.Message)
+	require.Nil(t, results[2].Message)
+	require.Equal(t, 50001, results[2].Error.Code)
+}
+
+func TestServer_PublishBatch_Invalid(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "POST", "/v1/publish/batch", `[]`, nil)
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40062, toHTTPError(t, response.Body.String()).Code)
+
+	items := make([]string, 0)
+	for i := 0; i < publishBatchLimit+1; i++ {
+		items = append(items, `{"topic":"mytopic","message":"hi"}`)
+	}
+	response = request(t, s, "POST", "/v1/publish/batch", "["+strings.Join(items, ",")+"]", nil)
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40062, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "POST", "/v1/publish/batch", `{"topic":"mytopic","message":"not an array"}`, nil)
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40024, toHTTPError(t, response.Body.String()).Code)
+}
+
+func toPublishBatchResponse(t *testing.T, s string) *apiPublishBatchResponse {
+	var response apiPublishBatchResponse
+	require.Nil(t, json.NewDecoder(strings.NewReader(s)).Decode(&response))
+	return &response
+}
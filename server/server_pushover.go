@@ -0,0 +1,286 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"heckel.io/ntfy/v2/util"
+)
+
+// Pushover compatibility:
+//
+// Many tools only speak the Pushover API (https://pushover.net/api), so ntfy optionally implements Pushover's
+// message endpoint (POST /1/messages.json). The Pushover fields are mapped onto a regular ntfy publish request:
+//
+//	token              -> access token (tk_...), used to authenticate the request if auth is enabled
+//	user               -> topic
+//	message, title     -> message, title
+//	priority           -> priority (-2..2 -> 1..5); emergency priority (2) requires retry and expire, like in Pushover
+//	expire             -> message expiry, for emergency priority only
+//	url                -> click action
+//	attachment         -> attachment (multipart file upload, or attachment_base64)
+//
+// All other fields (e.g. sound, device, html) are ignored. Responses and errors are returned in Pushover's format.
+//
+// The request is read before it is rate limited and authorized, since the topic and token are part of it. Only the
+// fields are read into memory (at most pushoverFieldsSizeLimit bytes); uploaded files are streamed into the file
+// cache by the publish handler, which applies the attachment limits of the visitor.
+
+const (
+	pushoverMessagesPath        = "/1/messages.json"
+	pushoverRequestIDLength     = 32
+	pushoverPriorityEmergency   = 2
+	pushoverEmergencyRetryMin   = 30              // Seconds, see https://pushover.net/api#priority
+	pushoverEmergencyExpireMax  = 10800           // Seconds
+	pushoverFieldsSizeLimit     = 2 * 1024 * 1024 // Includes attachment_base64, larger files must be uploaded as multipart file
+	pushoverAttachmentPeekLimit = 512             // Bytes used to detect the content type, see http.DetectContentType
+	pushoverStatusSuccess       = 1
+	pushoverStatusError         = 0
+)
+
+// pushoverErrorFields maps errors to the Pushover request field they refer to, since Pushover
+// includes the invalid field in the error response, e.g. {"user":"invalid","errors":[...],...}
+var pushoverErrorFields = map[int]string{
+	errHTTPUnauthorized.Code:                              "token",
+	errHTTPBadRequestPushoverUserInvalid.Code:             "user",
+	errHTTPBadRequestPushoverMessageInvalid.Code:          "message",
+	errHTTPBadRequestPriorityInvalid.Code:                 "priority",
+	errHTTPBadRequestPushoverEmergencyInvalid.Code:        "priority",
+	errHTTPBadRequestPushoverAttachmentInvalid.Code:       "attachment",
+	errHTTPBadRequestAttachmentURLInvalid.Code:            "url",
+	errHTTPEntityTooLargeAttachment.Code:                  "attachment",
+	errHTTPEntityTooLargePushoverRequest.Code:             "attachment",
+	errHTTPBadRequestAttachmentsDisallowed.Code:           "attachment",
+	errHTTPBadRequestAttachmentsExpiryBeforeDelivery.Code: "attachment",
+}
+
+// pushoverResponse is the Pushover API response, see https://pushover.net/api#response
+type pushoverResponse struct {
+	Status  int      `json:"status"`
+	Request string   `json:"request"`
+	Receipt string   `json:"receipt,omitempty"` // Only for emergency priority messages
+	Errors  []string `json:"errors,omitempty"`
+}
+
+func (s *Server) handlePublishPushover(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	m, err := s.handlePublishInternal(r, v)
+	if err != nil {
+		minc(metricMessagesPublishedFailure)
+		return err
+	}
+	minc(metricMessagesPublishedSuccess)
+	response := &pushoverResponse{
+		Status:  pushoverStatusSuccess,
+		Request: util.RandomString(pushoverRequestIDLength),
+	}
+	if m.Priority == 5 { // Only emergency priority maps to max priority
+		response.Receipt = m.ID
+	}
+	return s.writeJSON(w, response)
+}
+
+// pushoverErrors writes errors returned by the Pushover handler chain in Pushover's error format, i.e. with
+// a "status":0 field, an "errors" list, and the name of the invalid field (if known)
+func (s *Server) pushoverErrors(next handleFunc) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		err := next(w, r, v)
+		if err == nil {
+			return nil
+		}
+		httpErr, ok := err.(*errHTTP)
+		if !ok {
+			httpErr = errHTTPInternalError
+		}
+		logvr(v, r).Tag(tagPushover).Err(err).Info("Connection closed with HTTP %d (ntfy error %d)", httpErr.HTTPCode, httpErr.Code)
+		response := map[string]any{
+			"status":  pushoverStatusError,
+			"request": util.RandomString(pushoverRequestIDLength),
+			"errors":  []string{httpErr.Message},
+		}
+		if field, ok := pushoverErrorFields[httpErr.Code]; ok {
+			response[field] = "invalid"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", s.config.AccessControlAllowOrigin) // CORS, allow cross-origin requests
+		w.WriteHeader(httpErr.HTTPCode)
+		return json.NewEncoder(w).Encode(response)
+	}
+}
+
+// transformPushover turns a Pushover publish request (JSON, form data, or multipart form data) into a regular
+// ntfy publish request, and authenticates the visitor with the given token (if it is a ntfy access token)
+func (s *Server) transformPushover(next handleFunc) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		params, body, filename, err := s.readPushoverRequest(w, r)
+		if err != nil {
+			return err
+		}
+		topic := params.Get("user")
+		if !topicRegex.MatchString(topic) {
+			return errHTTPBadRequestPushoverUserInvalid
+		}
+		message := params.Get("message")
+		if message == "" {
+			return errHTTPBadRequestPushoverMessageInvalid
+		}
+		r.URL.Path = "/" + topic
+		r.URL.RawQuery = "" // Pushover params may be passed in the query, they must not be interpreted as ntfy params
+		r.Header.Del("Content-Type")
+		if body != nil {
+			r.Body = io.NopCloser(body)
+			r.Header.Set("X-Message", message)
+			r.Header.Set("X-Filename", filename)
+		} else {
+			r.Body = io.NopCloser(strings.NewReader(message))
+		}
+		if title := params.Get("title"); title != "" {
+			r.Header.Set("X-Title", title)
+		}
+		if click := params.Get("url"); click != "" {
+			r.Header.Set("X-Click", click)
+		}
+		if priorityStr := params.Get("priority"); priorityStr != "" {
+			priority, err := pushoverToPriority(priorityStr, params.Get("retry"), params.Get("expire"))
+			if err != nil {
+				return err
+			}
+			r.Header.Set("X-Priority", strconv.Itoa(priority))
+			if priority == 5 {
+				// Pushover stops re-alerting after "expire" seconds; ntfy does not re-alert, so the message expires instead
+				r.Header.Set("X-Expires", params.Get("expire")+"s")
+			}
+		}
+		if token := params.Get("token"); strings.HasPrefix(token, "tk_") && s.userManager != nil {
+			r.Header.Set("Authorization", util.BearerAuth(token))
+			v, err = s.maybeAuthenticate(r)
+			if err != nil {
+				return err
+			}
+		}
+		return next(w, r, v)
+	}
+}
+
+// readPushoverRequest reads the Pushover request parameters from the query and from the body, which may be
+// JSON, form data, or multipart form data. If an attachment was passed, its contents and filename are returned.
+// Uploaded files are not read, but returned as a stream; they must hence be the last part of the form.
+func (s *Server) readPushoverRequest(w http.ResponseWriter, r *http.Request) (params url.Values, attachment io.Reader, filename string, err error) {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	params = r.URL.Query()
+	switch contentType {
+	case "application/json":
+		obj, err := readJSONWithLimit[map[string]any](r.Body, pushoverFieldsSizeLimit, false)
+		if errors.Is(err, errHTTPEntityTooLargeJSONBody) {
+			return nil, nil, "", errHTTPEntityTooLargePushoverRequest
+		} else if err != nil {
+			return nil, nil, "", err
+		}
+		for key, value := range *obj {
+			switch value := value.(type) {
+			case string:
+				params.Set(key, value)
+			case float64, bool:
+				params.Set(key, fmt.Sprintf("%v", value))
+			}
+		}
+	case "multipart/form-data":
+		r.Body = http.MaxBytesReader(w, r.Body, pushoverFieldsSizeLimit+s.config.AttachmentFileSizeLimit)
+		attachment, filename, err = readPushoverMultipartForm(r, params)
+		if err != nil {
+			return nil, nil, "", err
+		}
+	default:
+		r.Body = http.MaxBytesReader(w, r.Body, pushoverFieldsSizeLimit)
+		if err := r.ParseForm(); err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return nil, nil, "", errHTTPEntityTooLargePushoverRequest
+			}
+			return nil, nil, "", errHTTPBadRequest
+		}
+		for key, values := range r.PostForm {
+			params[key] = values
+		}
+	}
+	if attachment == nil && params.Get("attachment_base64") != "" {
+		data, err := base64.StdEncoding.DecodeString(params.Get("attachment_base64"))
+		if err != nil {
+			return nil, nil, "", errHTTPBadRequestPushoverAttachmentInvalid
+		}
+		attachment, filename = bytes.NewReader(data), pushoverAttachmentName(data, "")
+	}
+	return params, attachment, filename, nil
+}
+
+// readPushoverMultipartForm reads the fields of a multipart form into params, until it reaches the "attachment"
+// file. The file is not read, but returned as a stream, so that it can be written to the file cache.
+func readPushoverMultipartForm(r *http.Request, params url.Values) (attachment io.Reader, filename string, err error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", errHTTPBadRequest
+	}
+	remaining := int64(pushoverFieldsSizeLimit)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, "", nil
+		} else if err != nil {
+			return nil, "", errHTTPBadRequest
+		}
+		if part.FormName() == "attachment" {
+			peeked, err := util.Peek(part, pushoverAttachmentPeekLimit)
+			if err != nil {
+				return nil, "", err
+			}
+			return peeked, pushoverAttachmentName(peeked.PeekedBytes, part.FileName()), nil
+		}
+		value, err := io.ReadAll(io.LimitReader(part, remaining+1))
+		if err != nil {
+			return nil, "", errHTTPBadRequest
+		}
+		remaining -= int64(len(value))
+		if remaining < 0 {
+			return nil, "", errHTTPEntityTooLargePushoverRequest
+		}
+		params.Add(part.FormName(), string(value))
+	}
+}
+
+// pushoverToPriority maps a Pushover priority (-2..2) to a ntfy priority (1..5). Like Pushover, emergency
+// priority requires the retry and expire parameters. Since ntfy does not re-alert, retry is only validated.
+func pushoverToPriority(priorityStr, retryStr, expireStr string) (int, error) {
+	priority, err := strconv.Atoi(priorityStr)
+	if err != nil || priority < -2 || priority > 2 {
+		return 0, errHTTPBadRequestPriorityInvalid
+	}
+	if priority == pushoverPriorityEmergency {
+		retry, err := strconv.Atoi(retryStr)
+		if err != nil || retry < pushoverEmergencyRetryMin {
+			return 0, errHTTPBadRequestPushoverEmergencyInvalid
+		}
+		expire, err := strconv.Atoi(expireStr)
+		if err != nil || expire < 1 || expire > pushoverEmergencyExpireMax {
+			return 0, errHTTPBadRequestPushoverEmergencyInvalid
+		}
+	}
+	return priority + 3, nil
+}
+
+// pushoverAttachmentName returns the given filename, or "attachment.<ext>" if it is empty. The filename must be
+// set, so that the body is always treated as an attachment, even if it is a text file.
+func pushoverAttachmentName(data []byte, filename string) string {
+	if filename != "" {
+		return filename
+	}
+	_, ext := util.DetectContentType(data, "")
+	return "attachment" + ext
+}
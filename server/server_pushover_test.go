@@ -0,0 +1,244 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_Pushover_PublishForm(t *testing.T) {
+	c := newTestConfig(t)
+	c.EnablePushover = true
+	s := newTestServer(t, c)
+
+	response := request(t, s, "POST", "/1/messages.json", "token=abc&user=mytopic&title=Backup&message=Backup+done&priority=1&url=https%3A%2F%2Fexample.com&sound=pushover", map[string]string{
+		"Content-Type": "application/x-www-form-urlencoded",
+	})
+	require.Equal(t, 200, response.Code)
+
+	var pr pushoverResponse
+	require.Nil(t, json.NewDecoder(response.Body).Decode(&pr))
+	require.Equal(t, 1, pr.Status)
+	require.NotEmpty(t, pr.Request)
+	require.Empty(t, pr.Receipt)
+
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "Backup", m.Title)
+	require.Equal(t, "Backup done", m.Message)
+	require.Equal(t, 4, m.Priority)
+	require.Equal(t, "https://example.com", m.Click)
+}
+
+func TestServer_Pushover_PublishJSON_Emergency(t *testing.T) {
+	c := newTestConfig(t)
+	c.EnablePushover = true
+	s := newTestServer(t, c)
+
+	response := request(t, s, "POST", "/1/messages.json", `{"token":"abc","user":"mytopic","message":"Server down","priority":2,"retry":60,"expire":3600}`, map[string]string{
+		"Content-Type": "application/json",
+	})
+	require.Equal(t, 200, response.Code)
+	var pr pushoverResponse
+	require.Nil(t, json.NewDecoder(response.Body).Decode(&pr))
+	require.Equal(t, 1, pr.Status)
+
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "Server down", m.Message)
+	require.Equal(t, 5, m.Priority)
+	require.Equal(t, m.ID, pr.Receipt)
+	require.Equal(t, m.Time+3600, m.Expires) // Expire is mapped to the message expiry
+
+	// Emergency priority without retry/expire is rejected, like in Pushover
+	response = request(t, s, "POST", "/1/messages.json", `{"token":"abc","user":"mytopic","message":"Server down","priority":"2"}`, map[string]string{
+		"Content-Type": "application/json",
+	})
+	require.Equal(t, 400, response.Code)
+	var errResponse map[string]any
+	require.Nil(t, json.NewDecoder(response.Body).Decode(&errResponse))
+	require.Equal(t, float64(0), errResponse["status"])
+	require.Equal(t, "invalid", errResponse["priority"])
+	require.NotEmpty(t, errResponse["request"])
+	require.Equal(t, 1, len(errResponse["errors"].([]any)))
+}
+
+func TestServer_Pushover_PublishAttachment(t *testing.T) {
+	c := newTestConfig(t)
+	c.EnablePushover = true
+	s := newTestServer(t, c)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	require.Nil(t, mw.WriteField("token", "abc"))
+	require.Nil(t, mw.WriteField("user", "mytopic"))
+	require.Nil(t, mw.WriteField("message", "Here is the log"))
+	fw, err := mw.CreateFormFile("attachment", "backup.log")
+	require.Nil(t, err)
+	_, err = fw.Write([]byte("this is a log file"))
+	require.Nil(t, err)
+	require.Nil(t, mw.Close())
+
+	response := request(t, s, "POST", "/1/messages.json", body.String(), map[string]string{
+		"Content-Type": mw.FormDataContentType(),
+	})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "Here is the log", m.Message)
+	require.Equal(t, "backup.log", m.Attachment.Name)
+	require.Equal(t, int64(18), m.Attachment.Size)
+
+	// Base64-encoded attachment
+	response = request(t, s, "POST", "/1/messages.json", `{"token":"abc","user":"mytopic","message":"Here is the other log","attachment_base64":"dGhpcyBpcyBhIGxvZyBmaWxl","attachment_type":"text/plain"}`, map[string]string{
+		"Content-Type": "application/json",
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, "Here is the other log", messages[1].Message)
+	require.Equal(t, "attachment.txt", messages[1].Attachment.Name)
+	require.Equal(t, int64(18), messages[1].Attachment.Size)
+}
+
+func TestServer_Pushover_PublishAttachment_Streamed(t *testing.T) {
+	c := newTestConfig(t)
+	c.EnablePushover = true
+	c.AttachmentFileSizeLimit = 1000
+	s := newTestServer(t, c)
+
+	multipartBody := func(size int, fieldAfterFile bool) (string, string) {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		require.Nil(t, mw.WriteField("user", "mytopic"))
+		require.Nil(t, mw.WriteField("message", "Here is the file"))
+		fw, err := mw.CreateFormFile("attachment", "")
+		require.Nil(t, err)
+		_, err = fw.Write(bytes.Repeat([]byte{0x00, 0x01}, size/2))
+		require.Nil(t, err)
+		if fieldAfterFile {
+			require.Nil(t, mw.WriteField("title", "Ignored"))
+		}
+		require.Nil(t, mw.Close())
+		return body.String(), mw.FormDataContentType()
+	}
+
+	// Files are streamed into the file cache, so the attachment limits apply
+	body, contentType := multipartBody(2000, false)
+	response := request(t, s, "POST", "/1/messages.json", body, map[string]string{
+		"Content-Type": contentType,
+	})
+	require.Equal(t, 413, response.Code)
+	var errResponse map[string]any
+	require.Nil(t, json.NewDecoder(response.Body).Decode(&errResponse))
+	require.Equal(t, "invalid", errResponse["attachment"])
+
+	// Fields after the file are not read
+	body, contentType = multipartBody(500, true)
+	response = request(t, s, "POST", "/1/messages.json", body, map[string]string{
+		"Content-Type": contentType,
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "", m.Title)
+	require.Equal(t, "attachment.bin", m.Attachment.Name)
+	require.Equal(t, int64(500), m.Attachment.Size)
+}
+
+func TestServer_Pushover_RequestTooLarge(t *testing.T) {
+	c := newTestConfig(t)
+	c.EnablePushover = true
+	s := newTestServer(t, c)
+
+	attachment := strings.Repeat("A", pushoverFieldsSizeLimit)
+	response := request(t, s, "POST", "/1/messages.json", `{"user":"mytopic","message":"hi","attachment_base64":"`+attachment+`"}`, map[string]string{
+		"Content-Type": "application/json",
+	})
+	require.Equal(t, 413, response.Code)
+	require.Contains(t, response.Body.String(), errHTTPEntityTooLargePushoverRequest.Message)
+
+	response = request(t, s, "POST", "/1/messages.json", "user=mytopic&message=hi&attachment_base64="+attachment, map[string]string{
+		"Content-Type": "application/x-www-form-urlencoded",
+	})
+	require.Equal(t, 413, response.Code)
+	require.Contains(t, response.Body.String(), errHTTPEntityTooLargePushoverRequest.Message)
+}
+
+func TestServer_Pushover_Invalid(t *testing.T) {
+	c := newTestConfig(t)
+	c.EnablePushover = true
+	s := newTestServer(t, c)
+
+	response := request(t, s, "POST", "/1/messages.json", "token=abc&user=invalid+topic&message=hi", map[string]string{
+		"Content-Type": "application/x-www-form-urlencoded",
+	})
+	require.Equal(t, 400, response.Code)
+	var errResponse map[string]any
+	require.Nil(t, json.NewDecoder(response.Body).Decode(&errResponse))
+	require.Equal(t, "invalid", errResponse["user"])
+
+	response = request(t, s, "POST", "/1/messages.json?token=abc&user=mytopic", "", nil)
+	require.Equal(t, 400, response.Code)
+	errResponse = nil
+	require.Nil(t, json.NewDecoder(response.Body).Decode(&errResponse))
+	require.Equal(t, "invalid", errResponse["message"])
+
+	response = request(t, s, "POST", "/1/messages.json?token=abc&user=mytopic&message=hi&priority=3", "", nil)
+	require.Equal(t, 400, response.Code)
+}
+
+func TestServer_Pushover_Disabled(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "POST", "/1/messages.json", "token=abc&user=mytopic&message=hi", map[string]string{
+		"Content-Type": "application/x-www-form-urlencoded",
+	})
+	require.Equal(t, 404, response.Code)
+}
+
+func TestServer_Pushover_AccessToken(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	c.EnablePushover = true
+	s := newTestServer(t, c)
+
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("ben", "mytopic", user.PermissionReadWrite))
+	u, err := s.userManager.User("ben")
+	require.Nil(t, err)
+	token, err := s.userManager.CreateToken(u.ID, "", time.Unix(0, 0), netip.IPv4Unspecified())
+	require.Nil(t, err)
+
+	response := request(t, s, "POST", "/1/messages.json", "token="+token.Value+"&user=mytopic&message=hi", map[string]string{
+		"Content-Type": "application/x-www-form-urlencoded",
+	})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "POST", "/1/messages.json", "token="+token.Value+"&user=othertopic&message=hi", map[string]string{
+		"Content-Type": "application/x-www-form-urlencoded",
+	})
+	require.Equal(t, 403, response.Code)
+
+	response = request(t, s, "POST", "/1/messages.json", "token=tk_doesnotexist1234567890abcd&user=mytopic&message=hi", map[string]string{
+		"Content-Type": "application/x-www-form-urlencoded",
+	})
+	require.Equal(t, 401, response.Code)
+	var errResponse map[string]any
+	require.Nil(t, json.NewDecoder(response.Body).Decode(&errResponse))
+	require.Equal(t, "invalid", errResponse["token"])
+
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, "hi", toMessage(t, response.Body.String()).Message)
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"syscall"
+	"time"
+
+	"heckel.io/ntfy/v2/client"
+	"heckel.io/ntfy/v2/util"
+)
+
+// Relay subscriptions:
+//
+// If relay subscriptions are configured, ntfy subscribes to topics on remote ntfy servers (e.g. ntfy.sh) using
+// the client package, and re-publishes the messages to local topics, so that clients only need to talk to this
+// server. Messages keep their original ID and metadata. If the attachment cache is enabled, attachments stored on the
+// remote server are downloaded and stored locally; all other attachment URLs keep pointing to where they were. To
+// prevent anyone who can publish to the remote topic from making this server fetch internal URLs, attachments are
+// only downloaded from the host of the relay subscription, and never from loopback, private or link-local addresses,
+// unless the configured remote server itself resolves to such an address (e.g. a ntfy server in the same network).
+//
+// The client resumes after the last received message when it reconnects. On startup, the relay resumes after the
+// latest message in the local topic. If the remote server does not know that message, it sends all of its cached
+// messages, but since message IDs are kept, messages that have already been relayed are skipped.
+
+const (
+	relayAttachmentTimeout     = time.Minute
+	relayAttachmentDialTimeout = 10 * time.Second
+)
+
+var (
+	errRelayMessageInvalid           = errors.New("invalid relayed message")
+	errRelayAttachmentHostNotAllowed = errors.New("attachment is not hosted on the remote server")
+	errRelayAttachmentAddrNotAllowed = errors.New("attachment address is not allowed")
+)
+
+// RelaySubscription is a topic on a remote ntfy server that is mirrored to a local topic
+type RelaySubscription struct {
+	TopicURL string // Remote topic URL, e.g. https://ntfy.sh/mytopic
+	Topic    string // Local topic the messages are published to
+	Username string // Username for the remote server, may be empty
+	Password string // Password for the remote server, may be empty
+	Token    string // Access token for the remote server, may be empty
+}
+
+func (s *Server) runRelay() {
+	c := client.New(client.NewConfig())
+	subscriptions := make(map[string]*RelaySubscription)
+	for _, sub := range s.config.RelaySubscriptions {
+		subscriptionID, err := c.Subscribe(sub.TopicURL, s.relaySubscribeOptions(sub)...)
+		if err != nil {
+			logrs(sub).Err(err).Warn("Unable to subscribe to remote topic")
+			continue
+		}
+		logrs(sub).Debug("Subscribed to remote topic")
+		subscriptions[subscriptionID] = sub
+	}
+	for {
+		select {
+		case m := <-c.Messages:
+			sub, ok := subscriptions[m.SubscriptionID]
+			if !ok {
+				continue
+			}
+			if err := s.handleRelayMessage(sub, m.Raw); err != nil {
+				logrs(sub).Err(err).Warn("Unable to relay message %s", m.ID)
+				minc(metricRelayMessagesFailure)
+				continue
+			}
+			minc(metricRelayMessagesSuccess)
+		case <-s.closeChan:
+			for subscriptionID := range subscriptions {
+				c.Unsubscribe(subscriptionID)
+			}
+			return
+		}
+	}
+}
+
+func (s *Server) relaySubscribeOptions(sub *RelaySubscription) []client.SubscribeOption {
+	options := make([]client.SubscribeOption, 0)
+	if sub.Token != "" {
+		options = append(options, client.WithBearerAuth(sub.Token))
+	} else if sub.Username != "" {
+		options = append(options, client.WithBasicAuth(sub.Username, sub.Password))
+	}
+	messages, err := s.messageCache.Messages(sub.Topic, sinceLatestMessage, false)
+	if err == nil && len(messages) > 0 {
+		options = append(options, client.WithSince(messages[len(messages)-1].ID))
+	}
+	return options
+}
+
+// handleRelayMessage publishes a message received from a remote server to the local topic
+func (s *Server) handleRelayMessage(sub *RelaySubscription, raw string) error {
+	var m message
+	if err := json.Unmarshal([]byte(raw), &m); err != nil {
+		return err
+	} else if m.Event != messageEvent || !validMessageID(m.ID) {
+		return errRelayMessageInvalid
+	}
+	if _, err := s.messageCache.Message(m.ID); err == nil {
+		logrs(sub).With(&m).Debug("Ignoring relayed message, message already exists")
+		return nil
+	}
+	t, err := s.topicFromID(sub.Topic)
+	if err != nil {
+		return err
+	}
+	v := s.visitor(netip.IPv4Unspecified(), nil)
+	m.Topic = sub.Topic
+	if m.Attachment != nil && m.Attachment.URL != "" && s.fileCache != nil && s.config.BaseURL != "" {
+		if err := s.relayAttachment(v, sub, &m); errors.Is(err, errRelayAttachmentHostNotAllowed) {
+			logrs(sub).With(&m).Debug("Not downloading attachment from %s, keeping attachment URL", m.Attachment.URL)
+		} else if err != nil {
+			logrs(sub).With(&m).Err(err).Warn("Unable to download attachment, keeping remote attachment URL")
+		}
+		if len(m.Attachments) > 0 {
+			m.Attachments[0] = m.Attachment // Only the first attachment is downloaded, all others keep their remote URL
+		}
+	}
+	logrs(sub).With(&m).Debug("Relaying message to topic %s", sub.Topic)
+	return s.publishForwardedMessage(v, t, &m, nil)
+}
+
+// relayAttachment downloads the attachment of a relayed message to the local attachment cache, and
+// rewrites the attachment URL to point to this server. Only attachments hosted on the remote server are downloaded.
+func (s *Server) relayAttachment(v *visitor, sub *RelaySubscription, m *message) error {
+	topicURL, err := url.Parse(sub.TopicURL)
+	if err != nil {
+		return err
+	}
+	attachmentURL, err := url.Parse(m.Attachment.URL)
+	if err != nil || attachmentURL.Host != topicURL.Host || attachmentURL.Scheme != topicURL.Scheme {
+		return errRelayAttachmentHostNotAllowed
+	}
+	vinfo, err := v.Info()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodGet, m.Attachment.URL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "ntfy/"+s.config.Version)
+	if sub.Token != "" {
+		req.Header.Set("Authorization", util.BearerAuth(sub.Token))
+	} else if sub.Username != "" {
+		req.Header.Set("Authorization", util.BasicAuth(sub.Username, sub.Password))
+	}
+	response, err := s.relayClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("remote server responded with HTTP %s", response.Status)
+	}
+	limiters := []util.Limiter{
+		v.BandwidthLimiter(),
+		util.NewFixedLimiter(vinfo.Limits.AttachmentFileSizeLimit),
+		util.NewFixedLimiter(vinfo.Stats.AttachmentTotalSizeRemaining),
+	}
+	size, err := s.fileCache.Write(m.ID, response.Body, limiters...)
+	if err != nil {
+		return err
+	}
+	m.Attachment.Size = size
+	m.Attachment.Expires = time.Now().Add(vinfo.Limits.AttachmentExpiryDuration).Unix()
+	m.Attachment.URL = fmt.Sprintf("%s/file/%s%s", s.config.BaseURL, m.ID, path.Ext(attachmentURL.Path))
+	return nil
+}
+
+// newRelayAttachmentClient creates the HTTP client used to download attachments of relayed messages. It does not
+// use a proxy, does not follow redirects to other hosts, and refuses to connect to internal addresses, except for
+// those of the configured remote servers, see relayAttachmentDialControl.
+func newRelayAttachmentClient(subscriptions []*RelaySubscription) *http.Client {
+	hosts := make([]string, 0)
+	for _, sub := range subscriptions {
+		if u, err := url.Parse(sub.TopicURL); err == nil && u.Hostname() != "" {
+			hosts = append(hosts, u.Hostname())
+		}
+	}
+	dialer := &net.Dialer{
+		Timeout:        relayAttachmentDialTimeout,
+		ControlContext: relayAttachmentDialControl(hosts),
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   relayAttachmentTimeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			} else if req.URL.Host != via[0].URL.Host {
+				return errRelayAttachmentHostNotAllowed
+			}
+			return nil
+		},
+	}
+}
+
+// relayAttachmentDialControl returns a function that is called for every connection after the host name was resolved.
+// It refuses loopback, private and link-local addresses, unless one of the given (admin-configured) hosts resolves
+// to the address, see newRelayAttachmentClient.
+func relayAttachmentDialControl(allowedHosts []string) func(ctx context.Context, network, address string, c syscall.RawConn) error {
+	return func(ctx context.Context, _, address string, _ syscall.RawConn) error {
+		addrPort, err := netip.ParseAddrPort(address)
+		if err != nil {
+			return err
+		}
+		addr := addrPort.Addr().Unmap()
+		if !addr.IsLoopback() && !addr.IsPrivate() && !addr.IsLinkLocalUnicast() && !addr.IsLinkLocalMulticast() && !addr.IsUnspecified() {
+			return nil
+		}
+		for _, host := range allowedHosts {
+			hostAddrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+			if err != nil {
+				continue
+			}
+			for _, hostAddr := range hostAddrs {
+				if hostAddr.Unmap() == addr {
+					return nil
+				}
+			}
+		}
+		return errRelayAttachmentAddrNotAllowed
+	}
+}
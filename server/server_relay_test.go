@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_Relay_Messages(t *testing.T) {
+	remote, remoteURL := newTestRelayRemoteServer(t, newTestConfig(t))
+	local := newTestRelayServer(t, &RelaySubscription{TopicURL: remoteURL + "/announcements", Topic: "remote-announcements"})
+
+	// Wait for relay to subscribe, then publish on remote
+	waitFor(t, func() bool {
+		return testRelaySubscriberCount(remote, "announcements") == 1
+	})
+	response := request(t, remote, "PUT", "/announcements", "maintenance tonight", map[string]string{
+		"Title":    "Maintenance",
+		"Priority": "4",
+		"Tags":     "wrench",
+		"Click":    "https://example.com/maintenance",
+	})
+	require.Equal(t, 200, response.Code)
+	sent := toMessage(t, response.Body.String())
+
+	// Message is published locally, with original metadata
+	waitFor(t, func() bool {
+		return len(toMessages(t, request(t, local, "GET", "/remote-announcements/json?poll=1", "", nil).Body.String())) == 1
+	})
+	m := toMessage(t, request(t, local, "GET", "/remote-announcements/json?poll=1", "", nil).Body.String())
+	require.Equal(t, sent.ID, m.ID)
+	require.Equal(t, sent.Time, m.Time)
+	require.Equal(t, "remote-announcements", m.Topic)
+	require.Equal(t, "maintenance tonight", m.Message)
+	require.Equal(t, "Maintenance", m.Title)
+	require.Equal(t, 4, m.Priority)
+	require.Equal(t, []string{"wrench"}, m.Tags)
+	require.Equal(t, "https://example.com/maintenance", m.Click)
+
+	// Duplicates are skipped
+	sub := local.config.RelaySubscriptions[0]
+	raw, err := json.Marshal(sent)
+	require.Nil(t, err)
+	require.Nil(t, local.handleRelayMessage(sub, string(raw)))
+	require.Equal(t, 1, len(toMessages(t, request(t, local, "GET", "/remote-announcements/json?poll=1", "", nil).Body.String())))
+}
+
+func TestServer_Relay_Attachment(t *testing.T) {
+	remote, remoteURL := newTestRelayRemoteServer(t, newTestConfig(t))
+	local := newTestRelayServer(t, &RelaySubscription{TopicURL: remoteURL + "/files", Topic: "files"}) // Remote server is on 127.0.0.1
+
+	waitFor(t, func() bool {
+		return testRelaySubscriberCount(remote, "files") == 1
+	})
+	response := request(t, remote, "PUT", "/files", "this is a log file", map[string]string{
+		"Filename": "backup.log",
+	})
+	require.Equal(t, 200, response.Code)
+	sent := toMessage(t, response.Body.String())
+	require.Contains(t, sent.Attachment.URL, remoteURL)
+
+	// Attachment is downloaded, and served from the local server
+	waitFor(t, func() bool {
+		return len(toMessages(t, request(t, local, "GET", "/files/json?poll=1", "", nil).Body.String())) == 1
+	})
+	m := toMessage(t, request(t, local, "GET", "/files/json?poll=1", "", nil).Body.String())
+	require.Equal(t, "backup.log", m.Attachment.Name)
+	require.Equal(t, int64(18), m.Attachment.Size)
+	require.Equal(t, local.config.BaseURL+"/file/"+sent.ID+".txt", m.Attachment.URL)
+
+	response = request(t, local, "GET", "/file/"+sent.ID+".txt", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "this is a log file", response.Body.String())
+}
+
+func TestServer_Relay_Attachment_InternalURL(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("internal server must not be requested")
+	}))
+	defer internal.Close()
+	local := newTestRelayServer(t)
+	sub := &RelaySubscription{TopicURL: "https://ntfy.example.com/files", Topic: "files"}
+
+	// Attachment URLs on other hosts than the remote server are not downloaded
+	for _, attachmentURL := range []string{internal.URL + "/secret.txt", "http://169.254.169.254/latest/meta-data/"} {
+		m := newDefaultMessage("files", "some file")
+		m.Attachment = &attachment{Name: "secret.txt", URL: attachmentURL}
+		raw, err := json.Marshal(m)
+		require.Nil(t, err)
+		require.Nil(t, local.handleRelayMessage(sub, string(raw)))
+		relayed, err := local.messageCache.Message(m.ID)
+		require.Nil(t, err)
+		require.Equal(t, attachmentURL, relayed.Attachment.URL)
+		require.Equal(t, int64(0), relayed.Attachment.Size)
+	}
+
+	// Internal addresses are refused if they are not the address of a configured remote server, also after redirects
+	m := newDefaultMessage("files", "some file")
+	m.Attachment = &attachment{Name: "secret.txt", URL: internal.URL + "/secret.txt"}
+	err := local.relayAttachment(local.visitor(netip.IPv4Unspecified(), nil), &RelaySubscription{TopicURL: internal.URL + "/files", Topic: "files"}, m)
+	require.ErrorIs(t, err, errRelayAttachmentAddrNotAllowed)
+	require.Nil(t, newRelayAttachmentClient(nil).CheckRedirect(
+		httptest.NewRequest("GET", "https://ntfy.example.com/file/b.txt", nil),
+		[]*http.Request{httptest.NewRequest("GET", "https://ntfy.example.com/file/a.txt", nil)},
+	))
+	require.ErrorIs(t, newRelayAttachmentClient(nil).CheckRedirect(
+		httptest.NewRequest("GET", "http://169.254.169.254/latest/meta-data/", nil),
+		[]*http.Request{httptest.NewRequest("GET", "https://ntfy.example.com/file/a.txt", nil)},
+	), errRelayAttachmentHostNotAllowed)
+}
+
+func TestRelayAttachmentDialControl(t *testing.T) {
+	control := relayAttachmentDialControl(nil)
+	for _, address := range []string{"127.0.0.1:80", "[::1]:443", "10.1.2.3:80", "192.168.1.1:80", "172.16.0.1:80", "169.254.169.254:80", "[fe80::1]:80", "[fd00::1]:80", "0.0.0.0:80", "[::ffff:127.0.0.1]:80"} {
+		require.ErrorIs(t, control(context.Background(), "tcp", address, nil), errRelayAttachmentAddrNotAllowed, address)
+	}
+	for _, address := range []string{"1.1.1.1:443", "[2606:4700::1111]:443"} {
+		require.Nil(t, control(context.Background(), "tcp", address, nil), address)
+	}
+
+	// Internal addresses of configured remote servers are allowed
+	control = relayAttachmentDialControl([]string{"10.1.2.3", "fd00::1"})
+	for _, address := range []string{"10.1.2.3:80", "[::ffff:10.1.2.3]:80", "[fd00::1]:443"} {
+		require.Nil(t, control(context.Background(), "tcp", address, nil), address)
+	}
+	for _, address := range []string{"127.0.0.1:80", "10.1.2.4:80", "169.254.169.254:80"} {
+		require.ErrorIs(t, control(context.Background(), "tcp", address, nil), errRelayAttachmentAddrNotAllowed, address)
+	}
+}
+
+func TestServer_Relay_Auth(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	remote, remoteURL := newTestRelayRemoteServer(t, c)
+	require.Nil(t, remote.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, remote.userManager.AllowAccess("phil", "alerts", user.PermissionReadWrite))
+	local := newTestRelayServer(t, &RelaySubscription{TopicURL: remoteURL + "/alerts", Topic: "alerts", Username: "phil", Password: "phil"})
+
+	waitFor(t, func() bool {
+		return testRelaySubscriberCount(remote, "alerts") == 1
+	})
+	response := request(t, remote, "PUT", "/alerts", "secret alert", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	waitFor(t, func() bool {
+		return len(toMessages(t, request(t, local, "GET", "/alerts/json?poll=1", "", nil).Body.String())) == 1
+	})
+}
+
+func TestServer_Relay_ResumeAfterLatestMessage(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	sub := &RelaySubscription{TopicURL: "https://ntfy.example.com/alerts", Topic: "alerts", Token: "tk_AgQdq7mVBoFD37zQVN29RhuMzNIz2"}
+
+	// No local messages: only new messages
+	req := applyTestRelayOptions(t, s.relaySubscribeOptions(sub))
+	require.Equal(t, "", req.URL.Query().Get("since"))
+	require.Equal(t, "Bearer tk_AgQdq7mVBoFD37zQVN29RhuMzNIz2", req.Header.Get("Authorization"))
+
+	// Resume after latest local message
+	m1, m2 := newDefaultMessage("alerts", "first"), newDefaultMessage("alerts", "second")
+	require.Nil(t, s.messageCache.AddMessage(m1))
+	require.Nil(t, s.messageCache.AddMessage(m2))
+	req = applyTestRelayOptions(t, s.relaySubscribeOptions(sub))
+	require.Equal(t, m2.ID, req.URL.Query().Get("since"))
+}
+
+func newTestRelayRemoteServer(t *testing.T, c *Config) (*Server, string) {
+	var s *Server
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { s.handle(w, r) }))
+	t.Cleanup(httpServer.Close)
+	c.BaseURL = httpServer.URL
+	s = newTestServer(t, c)
+	return s, httpServer.URL
+}
+
+func newTestRelayServer(t *testing.T, subscriptions ...*RelaySubscription) *Server {
+	c := newTestConfig(t)
+	c.RelaySubscriptions = subscriptions
+	s := newTestServer(t, c)
+	s.closeChan = make(chan bool)
+	t.Cleanup(func() { close(s.closeChan) })
+	go s.runRelay()
+	return s
+}
+
+func applyTestRelayOptions(t *testing.T, options []func(r *http.Request) error) *http.Request {
+	req, err := http.NewRequest(http.MethodGet, "https://ntfy.example.com/alerts/json", nil)
+	require.Nil(t, err)
+	for _, option := range options {
+		require.Nil(t, option(req))
+	}
+	return req
+}
+
+func testRelaySubscriberCount(s *Server, topic string) int {
+	s.mu.RLock()
+	t, ok := s.topics[topic]
+	s.mu.RUnlock()
+	if !ok {
+		return 0
+	}
+	subscribers, _ := t.Stats()
+	return subscribers
+}
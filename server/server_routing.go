@@ -0,0 +1,413 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+const (
+	routingRuleConfigIDPrefix = "config-"
+)
+
+var (
+	routingRuleRegex = regexp.MustCompile(`^(.*?)\s*->\s*(.+)$`)
+)
+
+// RoutingRule changes how matching messages are delivered. Rules are defined in the config (routing-rules), or added
+// via the admin API. All conditions must match, lists match if any of their elements match. The rule format is "<conditions> -> <actions>", e.g. "topic=prod-* priority=5 -> call=+12223334444".
+//
+// Conditions: topic=<pattern>[,<pattern>..] (with * as wildcard), priority=<p>[,<p>..], tags=<tag>[,<tag>..] and
+// title=<regex>. The title condition must be the last one, since the regular expression may contain spaces.
+//
+// Actions: email=<address>, call=<phone-number>, add-tags=<tag>[,<tag>..], priority=<p>, firebase=yes|no,
+// forward=<topic>[,<topic>..] and drop.
+type RoutingRule struct {
+	ID   string // Rule ID, e.g. config-1 for rules from the config, or ru_... for rules added via the admin API
+	Rule string // Rule expression, as passed to ParseRoutingRule
+
+	// Conditions
+	Topics     []*regexp.Regexp // Topic patterns, or all topics if empty
+	Priorities []int            // Priorities, or all priorities if empty
+	Tags       []string         // Tags (any of them), or all messages if empty
+	Title      *regexp.Regexp   // Regular expression for the title, or nil to match all messages
+
+	// Actions
+	Email    string   // Send the message as an e-mail to this address
+	Call     string   // Call this phone number
+	AddTags  []string // Add these tags to the message
+	Priority int      // Change the priority of the message, if not 0
+	Firebase *bool    // Forward (or do not forward) the message to Firebase, if not nil
+	Forward  []string // Publish a copy of the message to these topics
+	Drop     bool     // Do not deliver the message at all
+}
+
+// routingResult is the combined result of all routing rules that matched a message. For scheduled messages,
+// it is stored in the message cache, and applied when the message is sent, see sendDelayedMessage.
+type routingResult struct {
+	Rules    []string `json:"rules,omitempty"` // IDs of the matched rules
+	Emails   []string `json:"emails,omitempty"`
+	Calls    []string `json:"calls,omitempty"`
+	Firebase *bool    `json:"firebase,omitempty"`
+	Forward  []string `json:"forward,omitempty"`
+	Drop     bool     `json:"drop,omitempty"`
+}
+
+func (s *Server) handleRoutingRulesGet(w http.ResponseWriter, _ *http.Request, _ *visitor) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	response := make([]*apiRoutingRuleResponse, len(s.routingRules))
+	for i, rule := range s.routingRules {
+		response[i] = &apiRoutingRuleResponse{
+			ID:   rule.ID,
+			Rule: rule.Rule,
+		}
+	}
+	return s.writeJSON(w, response)
+}
+
+func (s *Server) handleRoutingRulesAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiRoutingRuleAddRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	if rule, err := ParseRoutingRule(req.Rule); err != nil {
+		return errHTTPBadRequestRoutingRuleInvalid.Wrap("%s", err.Error())
+	} else if err := s.checkRoutingRule(rule); err != nil {
+		return errHTTPBadRequestRoutingRuleInvalid.Wrap("%s", err.Error())
+	}
+	rule, err := s.userManager.AddRoutingRule(strings.TrimSpace(req.Rule))
+	if err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagManager).Field("routing_rule_id", rule.ID).Info("Added routing rule %s: %s", rule.ID, rule.Rule)
+	if err := s.loadRoutingRules(); err != nil {
+		return err
+	}
+	return s.writeJSON(w, &apiRoutingRuleResponse{
+		ID:   rule.ID,
+		Rule: rule.Rule,
+	})
+}
+
+func (s *Server) handleRoutingRulesDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiRoutingRuleDeleteRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if strings.HasPrefix(req.ID, routingRuleConfigIDPrefix) {
+		return errHTTPBadRequestRoutingRuleInvalid.Wrap("rules from the config cannot be removed")
+	}
+	if err := s.userManager.RemoveRoutingRule(req.ID); errors.Is(err, user.ErrRoutingRuleNotFound) {
+		return errHTTPNotFound
+	} else if err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagManager).Field("routing_rule_id", req.ID).Info("Removed routing rule %s", req.ID)
+	if err := s.loadRoutingRules(); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// handleRoutingRulesTest evaluates the routing rules against a test message, without publishing anything. If a
+// rule is passed in the request, only this rule is evaluated, otherwise all active rules are.
+func (s *Server) handleRoutingRulesTest(w http.ResponseWriter, r *http.Request, _ *visitor) error {
+	req, err := readJSONWithLimit[apiRoutingRuleTestRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !topicRegex.MatchString(req.Topic) {
+		return errHTTPBadRequestTopicInvalid
+	}
+	var rules []*RoutingRule
+	if req.Rule != "" {
+		rule, err := ParseRoutingRule(req.Rule)
+		if err != nil {
+			return errHTTPBadRequestRoutingRuleInvalid.Wrap("%s", err.Error())
+		}
+		rules = []*RoutingRule{rule}
+	} else {
+		s.mu.RLock()
+		rules = s.routingRules
+		s.mu.RUnlock()
+	}
+	m := newDefaultMessage(req.Topic, req.Message)
+	m.Title = req.Title
+	m.Priority = req.Priority
+	m.Tags = req.Tags
+	result := applyRoutingRules(rules, m)
+	return s.writeJSON(w, &apiRoutingRuleTestResponse{
+		Matched:  result.Rules,
+		Message:  m,
+		Emails:   result.Emails,
+		Calls:    result.Calls,
+		Firebase: result.Firebase,
+		Forward:  result.Forward,
+		Drop:     result.Drop,
+	})
+}
+
+// routeMessage applies the active routing rules to the given message, see applyRoutingRules. Rules only apply
+// to regular messages, not to poll requests.
+func (s *Server) routeMessage(m *message) *routingResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if m.Event != messageEvent {
+		return applyRoutingRules(nil, m)
+	}
+	return applyRoutingRules(s.routingRules, m)
+}
+
+// limitRoutedMessage removes the e-mails and calls of the routing result that exceed the publisher's e-mail and
+// call limits. Like e-mails and calls requested by the publisher, they count towards these limits.
+func (s *Server) limitRoutedMessage(v, vrate *visitor, m *message, routing *routingResult) {
+	routing.Emails = slices.DeleteFunc(routing.Emails, func(email string) bool {
+		if vrate.EmailAllowed() {
+			return false
+		}
+		logvm(v, m).Tag(tagPublish).Field("routing_email", email).Info("Not sending e-mail (routing rule), e-mail limit reached")
+		return true
+	})
+	routing.Calls = slices.DeleteFunc(routing.Calls, func(call string) bool {
+		if vrate.CallAllowed() {
+			return false
+		}
+		logvm(v, m).Tag(tagPublish).Field("routing_call", call).Info("Not calling phone number (routing rule), call limit reached")
+		return true
+	})
+}
+
+// forwardRoutedMessage publishes copies of the message to the topics of all matching "forward" actions
+func (s *Server) forwardRoutedMessage(v *visitor, m *message, topics []string, cache bool) {
+	for _, topic := range topics {
+		logvm(v, m).Tag(tagPublish).Field("routing_forward_topic", topic).Debug("Forwarding message to topic %s (routing rule)", topic)
+		if _, err := s.publishCopy(v, m, topic, cache); err != nil {
+			logvm(v, m).Tag(tagPublish).Err(err).Warn("Unable to forward message to topic %s (routing rule)", topic)
+		}
+	}
+}
+
+// loadRoutingRules (re-)loads the routing rules from the config and the user database. Rules in the config are
+// checked when the server is created. Invalid rules in the database (e.g. e-mail rules after e-mail publishing was
+// disabled) are skipped.
+func (s *Server) loadRoutingRules() error {
+	rules := make([]*RoutingRule, 0)
+	for i, configRule := range s.config.RoutingRules {
+		rule := *configRule
+		rule.ID = fmt.Sprintf("%s%d", routingRuleConfigIDPrefix, i+1)
+		if err := s.checkRoutingRule(&rule); err != nil {
+			return fmt.Errorf(`invalid routing rule "%s": %s`, rule.Rule, err.Error())
+		}
+		rules = append(rules, &rule)
+	}
+	if s.userManager != nil {
+		storedRules, err := s.userManager.RoutingRules()
+		if err != nil {
+			return err
+		}
+		for _, stored := range storedRules {
+			rule, err := ParseRoutingRule(stored.Rule)
+			if err == nil {
+				err = s.checkRoutingRule(rule)
+			}
+			if err != nil {
+				log.Tag(tagManager).Field("routing_rule_id", stored.ID).Err(err).Warn("Ignoring invalid routing rule %s", stored.ID)
+				continue
+			}
+			rule.ID = stored.ID
+			rules = append(rules, rule)
+		}
+	}
+	s.mu.Lock()
+	s.routingRules = rules
+	s.mu.Unlock()
+	return nil
+}
+
+// checkRoutingRule checks that the actions of the rule can be performed by this server
+func (s *Server) checkRoutingRule(rule *RoutingRule) error {
+	if rule.Email != "" && s.smtpSender == nil {
+		return errors.New("email action requires e-mail publishing to be enabled (smtp-sender-addr)")
+	} else if rule.Call != "" && s.config.TwilioAccount == "" {
+		return errors.New("call action requires phone calls to be enabled (twilio-account)")
+	}
+	return nil
+}
+
+// ParseRoutingRule parses a routing rule, see RoutingRule for the format
+func ParseRoutingRule(s string) (*RoutingRule, error) {
+	matches := routingRuleRegex.FindStringSubmatch(strings.TrimSpace(s))
+	if matches == nil || strings.TrimSpace(matches[1]) == "" {
+		return nil, fmt.Errorf(`invalid routing rule "%s", must be "<conditions> -> <actions>", e.g. "tags=debug -> firebase=no"`, s)
+	}
+	rule := &RoutingRule{
+		Rule: strings.TrimSpace(s),
+	}
+	conditions := strings.TrimSpace(matches[1])
+	for conditions != "" {
+		var condition string
+		if strings.HasPrefix(conditions, "title=") {
+			condition, conditions = conditions, "" // The regex is always the rest of the conditions
+		} else {
+			condition, conditions, _ = strings.Cut(conditions, " ")
+			conditions = strings.TrimSpace(conditions)
+		}
+		if err := rule.parseCondition(condition); err != nil {
+			return nil, fmt.Errorf(`invalid routing rule "%s": %s`, s, err.Error())
+		}
+	}
+	for _, action := range strings.Fields(matches[2]) {
+		if err := rule.parseAction(action); err != nil {
+			return nil, fmt.Errorf(`invalid routing rule "%s": %s`, s, err.Error())
+		}
+	}
+	return rule, nil
+}
+
+func (r *RoutingRule) parseCondition(condition string) error {
+	key, value, ok := strings.Cut(condition, "=")
+	if !ok || value == "" {
+		return fmt.Errorf(`invalid condition "%s"`, condition)
+	}
+	switch key {
+	case "topic":
+		for _, pattern := range strings.Split(value, ",") {
+			if !user.AllowedTopicPattern(pattern) {
+				return fmt.Errorf(`invalid topic pattern "%s"`, pattern)
+			}
+			r.Topics = append(r.Topics, regexp.MustCompile("^"+strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")+"$"))
+		}
+	case "priority":
+		for _, p := range strings.Split(value, ",") {
+			priority, err := util.ParsePriority(p)
+			if err != nil {
+				return fmt.Errorf(`invalid priority "%s"`, p)
+			}
+			r.Priorities = append(r.Priorities, priority)
+		}
+	case "tags":
+		r.Tags = util.SplitNoEmpty(value, ",")
+	case "title":
+		title, err := regexp.Compile(value)
+		if err != nil {
+			return fmt.Errorf("invalid regular expression: %s", err.Error())
+		}
+		r.Title = title
+	default:
+		return fmt.Errorf(`unknown condition "%s"`, key)
+	}
+	return nil
+}
+
+func (r *RoutingRule) parseAction(action string) error {
+	if action == "drop" {
+		r.Drop = true
+		return nil
+	}
+	key, value, ok := strings.Cut(action, "=")
+	if !ok || value == "" {
+		return fmt.Errorf(`invalid action "%s"`, action)
+	}
+	switch key {
+	case "email":
+		if !strings.Contains(value, "@") {
+			return fmt.Errorf(`invalid e-mail address "%s"`, value)
+		}
+		r.Email = value
+	case "call":
+		if !phoneNumberRegex.MatchString(value) {
+			return fmt.Errorf(`invalid phone number "%s", must be in E.164 format, e.g. +12223334444`, value)
+		}
+		r.Call = value
+	case "add-tags":
+		r.AddTags = util.SplitNoEmpty(value, ",")
+	case "priority":
+		priority, err := util.ParsePriority(value)
+		if err != nil {
+			return fmt.Errorf(`invalid priority "%s"`, value)
+		}
+		r.Priority = priority
+	case "firebase":
+		if !isBoolValue(strings.ToLower(value)) {
+			return fmt.Errorf(`invalid firebase value "%s", must be yes or no`, value)
+		}
+		firebase := toBool(strings.ToLower(value))
+		r.Firebase = &firebase
+	case "forward":
+		for _, topic := range strings.Split(value, ",") {
+			if !topicRegex.MatchString(topic) {
+				return fmt.Errorf(`invalid forward topic "%s"`, topic)
+			}
+			r.Forward = append(r.Forward, topic)
+		}
+	default:
+		return fmt.Errorf(`unknown action "%s"`, key)
+	}
+	return nil
+}
+
+// Matches returns true if the message matches all conditions of the rule
+func (r *RoutingRule) Matches(m *message) bool {
+	priority := m.Priority
+	if priority == 0 {
+		priority = 3 // Default priority
+	}
+	if len(r.Topics) > 0 && !slices.ContainsFunc(r.Topics, func(re *regexp.Regexp) bool { return re.MatchString(m.Topic) }) {
+		return false
+	} else if len(r.Priorities) > 0 && !util.Contains(r.Priorities, priority) {
+		return false
+	} else if len(r.Tags) > 0 && !slices.ContainsFunc(r.Tags, func(tag string) bool { return util.Contains(m.Tags, tag) }) {
+		return false
+	}
+	return r.Title == nil || r.Title.MatchString(m.Title)
+}
+
+// applyRoutingRules evaluates the rules against the message in order, and returns the combined result of all
+// matching rules. Tags and priority are changed in the message itself, so later rules see the changed values.
+func applyRoutingRules(rules []*RoutingRule, m *message) *routingResult {
+	result := &routingResult{
+		Rules:   make([]string, 0),
+		Emails:  make([]string, 0),
+		Calls:   make([]string, 0),
+		Forward: make([]string, 0),
+	}
+	for _, rule := range rules {
+		if !rule.Matches(m) {
+			continue
+		}
+		result.Rules = append(result.Rules, rule.ID)
+		if rule.Drop {
+			result.Drop = true
+			break
+		}
+		if rule.Email != "" && !util.Contains(result.Emails, rule.Email) {
+			result.Emails = append(result.Emails, rule.Email)
+		}
+		if rule.Call != "" && !util.Contains(result.Calls, rule.Call) {
+			result.Calls = append(result.Calls, rule.Call)
+		}
+		for _, tag := range rule.AddTags {
+			if !util.Contains(m.Tags, tag) {
+				m.Tags = append(m.Tags, tag)
+			}
+		}
+		if rule.Priority > 0 {
+			m.Priority = rule.Priority
+		}
+		if rule.Firebase != nil {
+			result.Firebase = rule.Firebase
+		}
+		for _, topic := range rule.Forward {
+			if topic != m.Topic && !util.Contains(result.Forward, topic) {
+				result.Forward = append(result.Forward, topic)
+			}
+		}
+	}
+	return result
+}
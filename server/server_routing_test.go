@@ -0,0 +1,356 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestParseRoutingRule(t *testing.T) {
+	rule, err := ParseRoutingRule("topic=prod-*,staging priority=4,5 tags=db,network title=^Disk (full|almost full)$ -> email=ops@example.com add-tags=ops priority=5 firebase=no forward=oncall")
+	require.Nil(t, err)
+	require.Equal(t, 2, len(rule.Topics))
+	require.Equal(t, []int{4, 5}, rule.Priorities)
+	require.Equal(t, []string{"db", "network"}, rule.Tags)
+	require.Equal(t, "^Disk (full|almost full)$", rule.Title.String())
+	require.Equal(t, "ops@example.com", rule.Email)
+	require.Equal(t, []string{"ops"}, rule.AddTags)
+	require.Equal(t, 5, rule.Priority)
+	require.False(t, *rule.Firebase)
+	require.Equal(t, []string{"oncall"}, rule.Forward)
+	require.False(t, rule.Drop)
+
+	rule, err = ParseRoutingRule("tags=debug -> drop")
+	require.Nil(t, err)
+	require.True(t, rule.Drop)
+}
+
+func TestParseRoutingRule_Invalid(t *testing.T) {
+	for _, rule := range []string{
+		"",
+		"-> drop",
+		"topic=alerts",
+		"topic=alerts ->",
+		"topic=not/a/topic -> drop",
+		"priority=7 -> drop",
+		"title=([a-z] -> drop",
+		"severity=err -> drop",
+		"topic=alerts -> email=not-an-email",
+		"topic=alerts -> call=12345",
+		"topic=alerts -> firebase=maybe",
+		"topic=alerts -> forward=not/a/topic",
+		"topic=alerts -> explode",
+	} {
+		_, err := ParseRoutingRule(rule)
+		require.Error(t, err, rule)
+	}
+}
+
+func TestRoutingRule_Matches(t *testing.T) {
+	rule, err := ParseRoutingRule("topic=prod-* priority=4,5 tags=db,network title=(?i)disk -> drop")
+	require.Nil(t, err)
+
+	m := newDefaultMessage("prod-eu", "Disk is full")
+	m.Title = "DISK space"
+	m.Priority = 5
+	m.Tags = []string{"warning", "db"}
+	require.True(t, rule.Matches(m))
+
+	m.Topic = "staging-eu"
+	require.False(t, rule.Matches(m))
+	m.Topic = "prod-eu"
+	m.Priority = 0 // Default priority
+	require.False(t, rule.Matches(m))
+	m.Priority = 4
+	m.Tags = []string{"warning"}
+	require.False(t, rule.Matches(m))
+	m.Tags = []string{"network"}
+	m.Title = "CPU usage"
+	require.False(t, rule.Matches(m))
+}
+
+func TestApplyRoutingRules(t *testing.T) {
+	rules := make([]*RoutingRule, 0)
+	for i, r := range []string{
+		"topic=alerts -> add-tags=rotating_light priority=5",
+		"priority=5 -> email=ops@example.com forward=oncall,alerts",
+		"tags=debug -> drop",
+		"topic=alerts -> email=never@example.com",
+	} {
+		rule, err := ParseRoutingRule(r)
+		require.Nil(t, err)
+		rule.ID = fmt.Sprintf("rule-%d", i+1)
+		rules = append(rules, rule)
+	}
+
+	// Later rules see the changed priority; forwarding to the own topic is ignored
+	m := newDefaultMessage("alerts", "server down")
+	result := applyRoutingRules(rules, m)
+	require.Equal(t, []string{rules[0].ID, rules[1].ID, rules[3].ID}, result.Rules)
+	require.Equal(t, 5, m.Priority)
+	require.Equal(t, []string{"rotating_light"}, m.Tags)
+	require.Equal(t, []string{"ops@example.com", "never@example.com"}, result.Emails)
+	require.Equal(t, []string{"oncall"}, result.Forward)
+	require.False(t, result.Drop)
+
+	// Dropping stops evaluating rules
+	m = newDefaultMessage("alerts", "debug output")
+	m.Tags = []string{"debug"}
+	result = applyRoutingRules(rules, m)
+	require.True(t, result.Drop)
+	require.Equal(t, []string{rules[0].ID, rules[1].ID, rules[2].ID}, result.Rules)
+	require.Equal(t, []string{"ops@example.com"}, result.Emails)
+}
+
+func TestServer_RoutingRules_Publish(t *testing.T) {
+	c := newTestConfig(t)
+	c.SMTPSenderAddr = "localhost:25"
+	c.SMTPSenderFrom = "ntfy@example.com"
+	c.RoutingRules = mustParseRoutingRules(t,
+		"topic=prod-* priority=5 -> add-tags=rotating_light email=ops@example.com forward=oncall",
+		"tags=debug -> firebase=no",
+		"title=^Heartbeat -> drop",
+	)
+	s := newTestServer(t, c)
+	mailer := &testMailer{}
+	s.smtpSender = mailer
+	sender := newTestFirebaseSender(10)
+	s.firebaseClient = newFirebaseClient(sender, &testAuther{Allow: true})
+
+	response := request(t, s, "PUT", "/prod-db", "Database is down", map[string]string{
+		"Priority": "urgent",
+		"Tags":     "db",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, []string{"db", "rotating_light"}, m.Tags)
+	waitFor(t, func() bool {
+		return mailer.Count() == 1
+	})
+	waitFor(t, func() bool {
+		messages, err := s.messageCache.Messages("oncall", sinceAllMessages, false)
+		require.Nil(t, err)
+		return len(messages) == 1 && messages[0].Message == "Database is down"
+	})
+
+	// Not forwarded to Firebase
+	response = request(t, s, "PUT", "/staging", "debug output", map[string]string{
+		"Tags": "debug",
+	})
+	require.Equal(t, 200, response.Code)
+
+	// Dropped, neither cached nor delivered
+	response = request(t, s, "PUT", "/prod-db", "all good", map[string]string{
+		"Title": "Heartbeat",
+	})
+	require.Equal(t, 200, response.Code)
+
+	time.Sleep(100 * time.Millisecond)          // Firebase publishing happens
+	require.Equal(t, 2, len(sender.Messages())) // prod-db and oncall
+	messages, err := s.messageCache.Messages("prod-db", sinceAllMessages, false)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+	messages, err = s.messageCache.Messages("staging", sinceAllMessages, false)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+}
+
+func TestServer_RoutingRules_PublishDelayed(t *testing.T) {
+	c := newTestConfig(t)
+	c.SMTPSenderAddr = "localhost:25"
+	c.SMTPSenderFrom = "ntfy@example.com"
+	c.RoutingRules = mustParseRoutingRules(t,
+		"topic=prod-* priority=5 -> email=ops@example.com forward=oncall",
+		"tags=debug -> firebase=no",
+	)
+	s := newTestServer(t, c)
+	mailer := &testMailer{}
+	s.smtpSender = mailer
+	sender := newTestFirebaseSender(10)
+	s.firebaseClient = newFirebaseClient(sender, &testAuther{Allow: true})
+
+	response := request(t, s, "PUT", "/prod-db", "Database is down", map[string]string{
+		"Priority": "urgent",
+		"Delay":    "1h",
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "PUT", "/staging", "debug output", map[string]string{
+		"Tags":  "debug",
+		"Delay": "1h",
+	})
+	require.Equal(t, 200, response.Code)
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, 0, mailer.Count())
+	require.Equal(t, 0, len(sender.Messages()))
+
+	// Routing rules are applied when the messages are sent
+	_, err := s.messageCache.db.Exec(`UPDATE messages SET time=?`, time.Now().Add(-10*time.Second).Unix())
+	require.Nil(t, err)
+	require.Nil(t, s.sendDelayedMessages())
+	waitFor(t, func() bool {
+		return mailer.Count() == 1
+	})
+	waitFor(t, func() bool {
+		messages, err := s.messageCache.Messages("oncall", sinceAllMessages, false)
+		require.Nil(t, err)
+		return len(messages) == 1 && messages[0].Message == "Database is down"
+	})
+	time.Sleep(100 * time.Millisecond)          // Firebase publishing happens
+	require.Equal(t, 2, len(sender.Messages())) // prod-db and oncall, but not staging
+}
+
+func TestServer_RoutingRules_EmailLimit(t *testing.T) {
+	c := newTestConfig(t)
+	c.SMTPSenderAddr = "localhost:25"
+	c.SMTPSenderFrom = "ntfy@example.com"
+	c.VisitorEmailLimitBurst = 2
+	c.RoutingRules = mustParseRoutingRules(t, "priority=5 -> email=ops@example.com")
+	s := newTestServer(t, c)
+	mailer := &testMailer{}
+	s.smtpSender = mailer
+
+	// E-mails of routing rules count towards the publisher's e-mail limit
+	for i := 0; i < 3; i++ {
+		response := request(t, s, "PUT", "/prod-db", "Database is down", map[string]string{
+			"Priority": "urgent",
+		})
+		require.Equal(t, 200, response.Code)
+	}
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, 2, mailer.Count())
+	response := request(t, s, "PUT", "/prod-db", "Database is down", map[string]string{
+		"Email": "phil@example.com",
+	})
+	require.Equal(t, 429, response.Code)
+}
+
+func TestServer_RoutingRules_ConfigRequiresEmail(t *testing.T) {
+	c := newTestConfig(t)
+	c.RoutingRules = mustParseRoutingRules(t, "priority=5 -> email=ops@example.com")
+	_, err := New(c)
+	require.Error(t, err)
+}
+
+func TestServer_RoutingRules_AdminAPI(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.RoutingRules = mustParseRoutingRules(t, "tags=debug -> firebase=no")
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+
+	// Add rule
+	response := request(t, s, "POST", "/v1/rules", `{"rule":"topic=alerts -> priority=5"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	var added apiRoutingRuleResponse
+	require.Nil(t, json.NewDecoder(response.Body).Decode(&added))
+	require.Equal(t, "topic=alerts -> priority=5", added.Rule)
+
+	// Invalid rules are rejected
+	response = request(t, s, "POST", "/v1/rules", `{"rule":"topic=alerts -> explode"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40064, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "POST", "/v1/rules", `{"rule":"topic=alerts -> email=ops@example.com"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 40064, toHTTPError(t, response.Body.String()).Code) // E-mail publishing not enabled
+
+	// List rules
+	response = request(t, s, "GET", "/v1/rules", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	var rules []*apiRoutingRuleResponse
+	require.Nil(t, json.NewDecoder(response.Body).Decode(&rules))
+	require.Equal(t, 2, len(rules))
+	require.Equal(t, "config-1", rules[0].ID)
+	require.Equal(t, added.ID, rules[1].ID)
+
+	// New rule is applied immediately
+	response = request(t, s, "PUT", "/alerts", "test", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 5, toMessage(t, response.Body.String()).Priority)
+
+	// Only admins can manage rules
+	response = request(t, s, "GET", "/v1/rules", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, response.Code)
+
+	// Config rules cannot be removed
+	response = request(t, s, "DELETE", "/v1/rules", `{"id":"config-1"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 40064, toHTTPError(t, response.Body.String()).Code)
+
+	// Remove rule
+	response = request(t, s, "DELETE", "/v1/rules", `{"id":"`+added.ID+`"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "DELETE", "/v1/rules", `{"id":"`+added.ID+`"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 404, response.Code)
+	response = request(t, s, "PUT", "/alerts", "test", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 0, toMessage(t, response.Body.String()).Priority)
+}
+
+func TestServer_RoutingRules_Test(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.RoutingRules = mustParseRoutingRules(t,
+		"topic=alerts -> add-tags=warning forward=oncall",
+		"tags=debug -> drop",
+	)
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+
+	// All active rules
+	response := request(t, s, "POST", "/v1/rules/test", `{"topic":"alerts","message":"hi","priority":4,"tags":["db"]}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	var result apiRoutingRuleTestResponse
+	require.Nil(t, json.NewDecoder(response.Body).Decode(&result))
+	require.Equal(t, []string{"config-1"}, result.Matched)
+	require.Equal(t, []string{"db", "warning"}, result.Message.Tags)
+	require.Equal(t, 4, result.Message.Priority)
+	require.Equal(t, []string{"oncall"}, result.Forward)
+	require.False(t, result.Drop)
+
+	// Single rule, without adding it
+	response = request(t, s, "POST", "/v1/rules/test", `{"topic":"alerts","message":"hi","title":"Heartbeat","rule":"title=^Heart -> drop"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	result = apiRoutingRuleTestResponse{}
+	require.Nil(t, json.NewDecoder(response.Body).Decode(&result))
+	require.True(t, result.Drop)
+	require.Equal(t, 1, len(result.Matched))
+
+	// Nothing was published
+	messages, err := s.messageCache.Messages("oncall", sinceAllMessages, false)
+	require.Nil(t, err)
+	require.Empty(t, messages)
+}
+
+func mustParseRoutingRules(t *testing.T, rules ...string) []*RoutingRule {
+	parsed := make([]*RoutingRule, 0)
+	for _, r := range rules {
+		rule, err := ParseRoutingRule(r)
+		require.Nil(t, err)
+		parsed = append(parsed, rule)
+	}
+	return parsed
+}
@@ -0,0 +1,262 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+// Pre-signed publish URLs:
+//
+// If publish-signing-key is set, users can create publish URLs for a single topic that can be used without any
+// credentials, e.g. by devices or webhook senders that cannot set an Authorization header. The URL carries a signed
+// token in the "sig" query parameter: base64url(payload) + "." + base64url(HMAC-SHA256(key, base64url(payload))).
+// The payload (see signedURLPayload) contains the topic, the expiry time, the publish fields the sender may set,
+// and the maximum number of uses.
+//
+// authorizeTopicWrite verifies the token without looking up the user that created it. Uses are counted in the
+// message cache when the request is authorized, so failed publishes count as a use as well.
+
+const (
+	signedURLParam          = "sig"
+	signedURLIDLength       = 16
+	signedURLDefaultExpiry  = 24 * time.Hour
+	signedURLMaxExpiry      = 365 * 24 * time.Hour
+	signedURLTokenSeparator = "."
+)
+
+// signedURLFields maps the field names that can be allowed in a signed URL to the
+// publish parameters (headers and query parameters) they correspond to, see parsePublishParams.
+// The message itself can always be set.
+var signedURLFields = map[string][]string{
+	"title":       {"x-title", "title", "t"},
+	"priority":    {"x-priority", "priority", "prio", "p"},
+	"tags":        {"x-tags", "tags", "tag", "ta"},
+	"click":       {"x-click", "click"},
+	"icon":        {"x-icon", "icon"},
+	"attach":      {"x-attach", "attach", "a"},
+	"filename":    {"x-filename", "filename", "file", "f"},
+	"actions":     {"x-actions", "actions", "action"},
+	"markdown":    {"x-markdown", "markdown", "md", "content-type", "content_type"},
+	"delay":       {"x-delay", "delay", "x-at", "at", "x-in", "in"},
+	"email":       {"x-email", "x-e-mail", "email", "e-mail", "mail", "e"},
+	"call":        {"x-call", "call"},
+	"template":    {"x-template", "template", "tpl"},
+	"cache":       {"x-cache", "cache"},
+	"firebase":    {"x-firebase", "firebase"},
+	"encoding":    {"x-encoding", "encoding"},
+	"unifiedpush": {"x-unifiedpush", "unifiedpush", "up"},
+	"expires":     {"x-expires", "expires", "x-ttl", "ttl"},
+}
+
+// signedURLFieldPrefixes maps the field names that can be allowed in a signed URL to the prefixes of the
+// publish parameters they correspond to, e.g. "X-Label-Env" or "label.env", see readLabelParams
+var signedURLFieldPrefixes = map[string][]string{
+	"labels": {"x-label-", "label."},
+}
+
+// signedURLAlwaysAllowedParams are the parameters that every signed URL allows
+var signedURLAlwaysAllowedParams = []string{signedURLParam, "x-message", "message", "m"}
+
+// signedURLDeniedParams are publish parameters that cannot be allowed in a signed URL
+var signedURLDeniedParams = []string{"x-poll-id", "poll-id"}
+
+// signedURLPayload is the signed part of a pre-signed publish URL; field names
+// are kept short to keep the URL short
+type signedURLPayload struct {
+	ID      string   `json:"i"`
+	Topic   string   `json:"t"`
+	Expires int64    `json:"e"`
+	Fields  []string `json:"f,omitempty"`
+	Uses    int      `json:"n,omitempty"` // Maximum number of uses, 0 means unlimited
+}
+
+func (s *Server) handleAccountSignedURLCreate(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiAccountSignedURLRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	expires := time.Now().Add(signedURLDefaultExpiry)
+	if req.Expires != nil {
+		expires = time.Unix(*req.Expires, 0)
+	}
+	if !topicRegex.MatchString(req.Topic) {
+		return errHTTPBadRequestTopicInvalid
+	} else if util.Contains(s.config.DisallowedTopics, req.Topic) {
+		return errHTTPBadRequestTopicDisallowed
+	} else if expires.Before(time.Now()) || expires.After(time.Now().Add(signedURLMaxExpiry)) || req.Uses < 0 {
+		return errHTTPBadRequestSignedURLRequestInvalid
+	}
+	for _, field := range req.Fields {
+		_, ok := signedURLFields[field]
+		_, okPrefix := signedURLFieldPrefixes[field]
+		if !ok && !okPrefix {
+			return errHTTPBadRequestSignedURLRequestInvalid.Wrap("unknown field %s", field)
+		}
+	}
+	u := v.User()
+	if err := s.userManager.Authorize(u, req.Topic, user.PermissionWrite); err != nil {
+		return errHTTPForbidden
+	}
+	payload := &signedURLPayload{
+		ID:      util.RandomString(signedURLIDLength),
+		Topic:   req.Topic,
+		Expires: expires.Unix(),
+		Fields:  req.Fields,
+		Uses:    req.Uses,
+	}
+	token, err := signURLPayload(s.config.PublishSigningKey, payload)
+	if err != nil {
+		return err
+	}
+	logvr(v, r).
+		Tag(tagAccount).
+		Fields(log.Context{
+			"signed_url_id":      payload.ID,
+			"signed_url_topic":   payload.Topic,
+			"signed_url_expires": payload.Expires,
+			"signed_url_fields":  strings.Join(payload.Fields, ","),
+			"signed_url_uses":    payload.Uses,
+		}).
+		Debug("Creating signed publish URL for user %s", u.Name)
+	response := &apiAccountSignedURLResponse{
+		URL:     fmt.Sprintf("%s/%s?%s=%s", s.config.BaseURL, payload.Topic, signedURLParam, url.QueryEscape(token)),
+		Expires: payload.Expires,
+	}
+	return s.writeJSON(w, response)
+}
+
+// authorizeSignedURL verifies the signed URL token of a publish request, and checks that the request
+// only sets allowed fields, and that the URL has not been used too many times
+func (s *Server) authorizeSignedURL(r *http.Request, v *visitor, token string) error {
+	payload, err := verifySignedURLToken(s.config.PublishSigningKey, token)
+	if err != nil || time.Now().Unix() > payload.Expires {
+		return errHTTPUnauthorizedSignedURL
+	}
+	topics, _, err := s.topicsFromPath(r.URL.Path)
+	if err != nil {
+		return err
+	} else if len(topics) != 1 || topics[0].ID != payload.Topic {
+		return errHTTPUnauthorizedSignedURL
+	}
+	if name := signedURLForbiddenParam(r, payload.Fields); name != "" {
+		return errHTTPForbiddenSignedURLField.Wrap("parameter %s", name)
+	}
+	if payload.Uses > 0 {
+		uses, err := s.messageCache.IncrementSignedURLUses(payload.ID, payload.Expires)
+		if err != nil {
+			return err
+		} else if uses > payload.Uses {
+			return errHTTPForbiddenSignedURLUsed
+		}
+	}
+	logvr(v, r).
+		Fields(log.Context{
+			"signed_url_id":    payload.ID,
+			"signed_url_topic": payload.Topic,
+		}).
+		Debug("Access to topic %s authorized by signed URL", payload.Topic)
+	return nil
+}
+
+// signedURLForbiddenParam returns the name of the first parameter of the request that is not allowed by the given
+// signed URL fields, or an empty string if the request is allowed. Query parameters are checked against the allowed
+// parameters only, so that unknown parameters are rejected. Headers are only checked if they are publish parameters,
+// since clients and proxies send all sorts of other headers.
+func signedURLForbiddenParam(r *http.Request, fields []string) string {
+	allowed := slices.Clone(signedURLAlwaysAllowedParams)
+	allowedPrefixes := make([]string, 0)
+	for _, field := range fields {
+		allowed = append(allowed, signedURLFields[field]...)
+		allowedPrefixes = append(allowedPrefixes, signedURLFieldPrefixes[field]...)
+	}
+	for name := range r.URL.Query() {
+		lname := strings.ToLower(name)
+		if !slices.Contains(allowed, lname) && !hasAnyPrefix(lname, allowedPrefixes) {
+			return name
+		}
+	}
+	for name := range r.Header {
+		lname := strings.ToLower(name)
+		if lname == "content-type" || slices.Contains(allowed, lname) || hasAnyPrefix(lname, allowedPrefixes) {
+			continue
+		} else if isSignedURLPublishParam(lname) {
+			return name
+		}
+	}
+	if !slices.Contains(fields, "markdown") && strings.ToLower(r.Header.Get("Content-Type")) == "text/markdown" {
+		return "content-type"
+	} else if !slices.Contains(fields, "unifiedpush") && r.Header.Get("Content-Encoding") == "aes128gcm" {
+		return "content-encoding"
+	}
+	return ""
+}
+
+func isSignedURLPublishParam(name string) bool {
+	if slices.Contains(signedURLDeniedParams, name) {
+		return true
+	}
+	for _, names := range signedURLFields {
+		if slices.Contains(names, name) {
+			return true
+		}
+	}
+	for _, prefixes := range signedURLFieldPrefixes {
+		if hasAnyPrefix(name, prefixes) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	return slices.ContainsFunc(prefixes, func(prefix string) bool {
+		return strings.HasPrefix(s, prefix)
+	})
+}
+
+func signURLPayload(key string, payload *signedURLPayload) (string, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	encodedPayload := base64.RawURLEncoding.EncodeToString(b)
+	return encodedPayload + signedURLTokenSeparator + base64.RawURLEncoding.EncodeToString(signedURLSignature(key, encodedPayload)), nil
+}
+
+func verifySignedURLToken(key, token string) (*signedURLPayload, error) {
+	encodedPayload, encodedSignature, ok := strings.Cut(token, signedURLTokenSeparator)
+	if !ok {
+		return nil, errHTTPUnauthorizedSignedURL
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, signedURLSignature(key, encodedPayload)) {
+		return nil, errHTTPUnauthorizedSignedURL
+	}
+	b, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, errHTTPUnauthorizedSignedURL
+	}
+	var payload signedURLPayload
+	if err := json.Unmarshal(b, &payload); err != nil {
+		return nil, errHTTPUnauthorizedSignedURL
+	}
+	return &payload, nil
+}
+
+func signedURLSignature(key, encodedPayload string) []byte {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte(encodedPayload))
+	return h.Sum(nil)
+}
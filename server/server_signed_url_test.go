@@ -0,0 +1,173 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+const testPublishSigningKey = "k3y-k3y-k3y-k3y-k3y-k3y-k3y-k3y-k3y"
+
+func TestServer_SignedURL_Publish(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.PublishSigningKey = testPublishSigningKey
+	s := newTestServerWithReservation(t, c, "sensors")
+
+	response := request(t, s, "POST", "/v1/account/signed-url", `{"topic":"sensors","fields":["title"],"uses":2}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	signedURL := toSignedURLResponse(t, response.Body.String())
+	require.True(t, strings.HasPrefix(signedURL.URL, "http://127.0.0.1:12345/sensors?sig="))
+	require.InDelta(t, time.Now().Add(24*time.Hour).Unix(), signedURL.Expires, 5)
+	path := strings.TrimPrefix(signedURL.URL, s.config.BaseURL)
+
+	// Publish without credentials, with an allowed field
+	response = request(t, s, "POST", path+"&title=Tank+1", "water level high", nil)
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "water level high", m.Message)
+	require.Equal(t, "Tank 1", m.Title)
+
+	// Field not allowed
+	response = request(t, s, "POST", path, "water level high", map[string]string{
+		"Priority": "5",
+	})
+	require.Equal(t, 403, response.Code)
+	require.Equal(t, 40303, toHTTPError(t, response.Body.String()).Code)
+
+	// Other topic
+	response = request(t, s, "POST", strings.Replace(path, "/sensors", "/alerts", 1), "water level high", nil)
+	require.Equal(t, 401, response.Code)
+	require.Equal(t, 40103, toHTTPError(t, response.Body.String()).Code)
+
+	// Second use is fine, third is not
+	response = request(t, s, "GET", strings.Replace(path, "/sensors", "/sensors/publish", 1)+"&message=second", "", nil)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "POST", path, "third", nil)
+	require.Equal(t, 403, response.Code)
+	require.Equal(t, 40304, toHTTPError(t, response.Body.String()).Code)
+
+	// Subscribing is not allowed with a signed URL
+	response = request(t, s, "GET", strings.Replace(path, "/sensors", "/sensors/json", 1)+"&poll=1", "", nil)
+	require.Equal(t, 403, response.Code)
+}
+
+func TestServer_SignedURL_Publish_UnknownParams(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.PublishSigningKey = testPublishSigningKey
+	s := newTestServerWithReservation(t, c, "sensors")
+	token, err := signURLPayload(testPublishSigningKey, &signedURLPayload{ID: "abc", Topic: "sensors", Expires: time.Now().Add(time.Hour).Unix(), Fields: []string{"markdown"}})
+	require.Nil(t, err)
+	path := "/sensors?sig=" + url.QueryEscape(token)
+
+	// All publish parameters that are not allowed are rejected, including unknown query parameters
+	for _, query := range []string{"ttl=5m", "x-expires=1h", "label.env=prod", "up=1", "poll-id=abc", "some-new-param=1", "auth=abc"} {
+		response := request(t, s, "POST", path+"&"+query, "water level high", nil)
+		require.Equal(t, 403, response.Code, query)
+		require.Equal(t, 40303, toHTTPError(t, response.Body.String()).Code)
+	}
+	for _, headers := range []map[string]string{
+		{"X-Expires": "5m"},
+		{"X-Label-Env": "prod"},
+		{"X-UnifiedPush": "1"},
+		{"Content-Encoding": "aes128gcm"},
+		{"Poll-ID": "abc"},
+		{"Title": "Tank 1"},
+	} {
+		response := request(t, s, "POST", path, "water level high", headers)
+		require.Equal(t, 403, response.Code, headers)
+		require.Equal(t, 40303, toHTTPError(t, response.Body.String()).Code)
+	}
+
+	// Allowed and unrelated parameters are fine
+	token, err = signURLPayload(testPublishSigningKey, &signedURLPayload{ID: "abc", Topic: "sensors", Expires: time.Now().Add(time.Hour).Unix(), Fields: []string{"markdown", "expires", "labels"}})
+	require.Nil(t, err)
+	path = "/sensors?sig=" + url.QueryEscape(token)
+	response := request(t, s, "POST", path+"&md=1&message=hi&ttl=5m&label.tank=1", "", map[string]string{
+		"Content-Type":  "text/markdown",
+		"User-Agent":    "sensor/1.0",
+		"X-GitHub-Hook": "123",
+		"X-Label-Env":   "prod",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "hi", m.Message)
+	require.Equal(t, "text/markdown", m.ContentType)
+	require.InDelta(t, time.Now().Add(5*time.Minute).Unix(), m.Expires, 2)
+	require.Equal(t, map[string]string{"tank": "1", "env": "prod"}, m.Labels)
+}
+
+func TestServer_SignedURL_Invalid(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.PublishSigningKey = testPublishSigningKey
+	s := newTestServerWithReservation(t, c, "sensors")
+
+	expired, err := signURLPayload(testPublishSigningKey, &signedURLPayload{ID: "abc", Topic: "sensors", Expires: time.Now().Add(-time.Minute).Unix()})
+	require.Nil(t, err)
+	wrongKey, err := signURLPayload("wrong", &signedURLPayload{ID: "abc", Topic: "sensors", Expires: time.Now().Add(time.Hour).Unix()})
+	require.Nil(t, err)
+	valid, err := signURLPayload(testPublishSigningKey, &signedURLPayload{ID: "abc", Topic: "sensors", Expires: time.Now().Add(time.Hour).Unix()})
+	require.Nil(t, err)
+	encodedPayload, signature, _ := strings.Cut(valid, ".")
+	tampered := encodedPayload[:len(encodedPayload)-2] + "x" + encodedPayload[len(encodedPayload)-1:] + "." + signature
+
+	for _, token := range []string{expired, wrongKey, tampered, "not-a-token", "abc.def"} {
+		response := request(t, s, "POST", "/sensors?sig="+url.QueryEscape(token), "hi", nil)
+		require.Equal(t, 401, response.Code, token)
+		require.Equal(t, 40103, toHTTPError(t, response.Body.String()).Code)
+	}
+	response := request(t, s, "POST", "/sensors?sig="+url.QueryEscape(valid), "hi", nil)
+	require.Equal(t, 200, response.Code)
+}
+
+func TestServer_SignedURL_Create(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.PublishSigningKey = testPublishSigningKey
+	s := newTestServerWithReservation(t, c, "sensors")
+	headers := map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	}
+
+	// No write access, not logged in
+	response := request(t, s, "POST", "/v1/account/signed-url", `{"topic":"other"}`, headers)
+	require.Equal(t, 403, response.Code)
+	response = request(t, s, "POST", "/v1/account/signed-url", `{"topic":"sensors"}`, nil)
+	require.Equal(t, 401, response.Code)
+
+	// Invalid requests
+	for _, body := range []string{
+		`{"topic":"sensors","fields":["color"]}`,
+		`{"topic":"sensors","uses":-1}`,
+		fmt.Sprintf(`{"topic":"sensors","expires":%d}`, time.Now().Add(-time.Hour).Unix()),
+		fmt.Sprintf(`{"topic":"sensors","expires":%d}`, time.Now().Add(2*365*24*time.Hour).Unix()),
+	} {
+		response = request(t, s, "POST", "/v1/account/signed-url", body, headers)
+		require.Equal(t, 400, response.Code, body)
+		require.Equal(t, 40058, toHTTPError(t, response.Body.String()).Code)
+	}
+}
+
+func TestServer_SignedURL_Disabled(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+
+	response := request(t, s, "POST", "/v1/account/signed-url", `{"topic":"sensors"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 404, response.Code)
+}
+
+func toSignedURLResponse(t *testing.T, s string) *apiAccountSignedURLResponse {
+	var response apiAccountSignedURLResponse
+	require.Nil(t, json.NewDecoder(strings.NewReader(s)).Decode(&response))
+	return &response
+}
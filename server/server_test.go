@@ -515,6 +515,116 @@ func TestServer_PublishAtTooLarge(t *testing.T) {
 	require.Equal(t, 40006, err.Code)
 }
 
+func TestServer_PublishWithExpires(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "PUT", "/mytopic", "door bell", map[string]string{
+		"Expires": "5m",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, m.Time+300, m.Expires)
+
+	// TTL is relative to the delivery time
+	response = request(t, s, "PUT", "/mytopic?delay=1h&ttl=10m", "later", nil)
+	require.Equal(t, 200, response.Code)
+	m = toMessage(t, response.Body.String())
+	require.Equal(t, m.Time+600, m.Expires)
+
+	// TTL cannot extend the expiry beyond the cache duration
+	response = request(t, s, "PUT", "/mytopic", "a message", map[string]string{
+		"X-TTL": "30d",
+	})
+	require.Equal(t, 200, response.Code)
+	m = toMessage(t, response.Body.String())
+	require.Equal(t, m.Time+int64(s.config.CacheDuration.Seconds()), m.Expires)
+
+	// JSON
+	response = request(t, s, "PUT", "/", `{"topic":"mytopic","message":"json","expires":"90s"}`, nil)
+	require.Equal(t, 200, response.Code)
+	m = toMessage(t, response.Body.String())
+	require.Equal(t, m.Time+90, m.Expires)
+}
+
+func TestServer_PublishWithExpires_Invalid(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	for _, expires := range []string{"INVALID", "1", "0s"} {
+		response := request(t, s, "PUT", "/mytopic", "a message", map[string]string{
+			"Expires": expires,
+		})
+		require.Equal(t, 400, response.Code, expires)
+		require.Equal(t, 40066, toHTTPError(t, response.Body.String()).Code, expires)
+	}
+}
+
+func TestServer_PublishWithExpires_NotReplayedAfterExpiry(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "PUT", "/mytopic", "expires soon", map[string]string{
+		"TTL": "1m",
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "PUT", "/mytopic", "stays", nil)
+	require.Equal(t, 200, response.Code)
+
+	// Pretend the first message expired, but was not pruned yet
+	_, err := s.messageCache.db.Exec("UPDATE messages SET expires = ? WHERE message = ?", time.Now().Unix()-1, "expires soon")
+	require.Nil(t, err)
+
+	messages := toMessages(t, request(t, s, "GET", "/mytopic/json?poll=1", "", nil).Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "stays", messages[0].Message)
+	messages = toMessages(t, request(t, s, "GET", "/mytopic/json?poll=1&limit=10", "", nil).Body.String())
+	require.Equal(t, 1, len(messages))
+}
+
+func TestServer_PublishWithTierWithoutMessageExpiry_NotReplayed(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddTier(&user.Tier{Code: "free", MessageLimit: 10}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.ChangeTier("phil", "free"))
+
+	// Message is published, and cached until the manager prunes it, but it has already expired
+	response := request(t, s, "PUT", "/mytopic", "not replayed", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, m.Time, m.Expires)
+	cached, err := s.messageCache.Message(m.ID)
+	require.Nil(t, err)
+	require.Equal(t, "not replayed", cached.Message)
+
+	// Expired messages are not returned when polling, neither with nor without pagination
+	require.Equal(t, 0, len(toMessages(t, request(t, s, "GET", "/mytopic/json?poll=1", "", nil).Body.String())))
+	require.Equal(t, 0, len(toMessages(t, request(t, s, "GET", "/mytopic/json?poll=1&limit=10", "", nil).Body.String())))
+}
+
+func TestServer_PublishForwardedMessage_KeepsExpires(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	v := s.visitor(netip.MustParseAddr("9.9.9.9"), nil)
+	topic, err := s.topicFromID("mytopic")
+	require.Nil(t, err)
+
+	// Shorter expiry of the original message (e.g. a TTL) is kept
+	m := newDefaultMessage("mytopic", "expires soon")
+	m.Expires = m.Time + 300
+	require.Nil(t, s.publishForwardedMessage(v, topic, m, nil))
+	cached, err := s.messageCache.Message(m.ID)
+	require.Nil(t, err)
+	require.Equal(t, m.Time+300, cached.Expires)
+
+	// Longer expiry, or none, is capped to the cache duration
+	for _, expires := range []int64{0, time.Now().Add(30 * 24 * time.Hour).Unix()} {
+		m = newDefaultMessage("mytopic", "expires later")
+		m.Expires = expires
+		require.Nil(t, s.publishForwardedMessage(v, topic, m, nil))
+		cached, err = s.messageCache.Message(m.ID)
+		require.Nil(t, err)
+		require.InDelta(t, time.Now().Add(s.config.CacheDuration).Unix(), cached.Expires, 2)
+	}
+}
+
 func TestServer_PublishAtAndPrune(t *testing.T) {
 	s := newTestServer(t, newTestConfig(t))
 
@@ -666,6 +776,305 @@ func TestServer_PollSinceID_MultipleTopics_IDDoesNotMatch(t *testing.T) {
 	require.Equal(t, "test 6", messages[3].Message)
 }
 
+func TestServer_PollWithLimit_PageForward(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	for i := 1; i <= 5; i++ {
+		request(t, s, "PUT", "/mytopic", fmt.Sprintf("test %d", i), nil)
+	}
+
+	response := request(t, s, "GET", "/mytopic/json?poll=1&limit=2", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, "test 1", messages[0].Message)
+	require.Equal(t, "test 2", messages[1].Message)
+	require.Equal(t, fmt.Sprintf(`</mytopic/json?after=%s&limit=2&poll=1>; rel="next"`, messages[1].ID), response.Header().Get("Link"))
+
+	response = request(t, s, "GET", nextLinkURL(t, response), "", nil)
+	messages = toMessages(t, response.Body.String())
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, "test 3", messages[0].Message)
+	require.Equal(t, "test 4", messages[1].Message)
+
+	response = request(t, s, "GET", nextLinkURL(t, response), "", nil)
+	messages = toMessages(t, response.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "test 5", messages[0].Message)
+	require.Equal(t, "", response.Header().Get("Link"))
+}
+
+func TestServer_PollWithLimit_PageBackwards(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	for i := 1; i <= 5; i++ {
+		request(t, s, "PUT", "/mytopic1", fmt.Sprintf("test %d", i), nil)
+		request(t, s, "PUT", "/mytopic2", fmt.Sprintf("other %d", i), nil)
+	}
+	response := request(t, s, "GET", "/mytopic1/json?poll=1&since=latest", "", nil)
+	latest := toMessage(t, response.Body.String())
+
+	response = request(t, s, "GET", "/mytopic1/json?poll=1&limit=3&before="+latest.ID, "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 3, len(messages))
+	require.Equal(t, "test 2", messages[0].Message)
+	require.Equal(t, "test 4", messages[2].Message)
+	require.Equal(t, fmt.Sprintf(`</mytopic1/json?before=%s&limit=3&poll=1>; rel="next"`, messages[0].ID), response.Header().Get("Link"))
+
+	response = request(t, s, "GET", nextLinkURL(t, response), "", nil)
+	messages = toMessages(t, response.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "test 1", messages[0].Message)
+	require.Equal(t, "", response.Header().Get("Link"))
+
+	// Multiple topics are paged together
+	response = request(t, s, "GET", "/mytopic1,mytopic2/json?poll=1&limit=3", "", nil)
+	messages = toMessages(t, response.Body.String())
+	require.Equal(t, 3, len(messages))
+	require.Equal(t, "test 1", messages[0].Message)
+	require.Equal(t, "other 1", messages[1].Message)
+	require.Equal(t, "test 2", messages[2].Message)
+}
+
+func TestServer_PollWithLimit_Invalid(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "GET", "/mytopic/json?poll=1&limit=0", "", nil)
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40050, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "GET", "/mytopic/json?poll=1&limit=abc", "", nil)
+	require.Equal(t, 40050, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "GET", "/mytopic/json?poll=1&before=invalid", "", nil)
+	require.Equal(t, 40051, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "GET", "/mytopic/json?poll=1&after=1h", "", nil)
+	require.Equal(t, 40051, toHTTPError(t, response.Body.String()).Code)
+}
+
+func nextLinkURL(t *testing.T, response *httptest.ResponseRecorder) string {
+	link := response.Header().Get("Link")
+	require.True(t, strings.HasPrefix(link, "<") && strings.HasSuffix(link, `>; rel="next"`))
+	return strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+}
+
+func TestServer_PollWithConsumer(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	request(t, s, "PUT", "/mytopic", "test 1", nil)
+	request(t, s, "PUT", "/mytopic", "test 2", nil)
+
+	// New consumer receives all cached messages
+	response := request(t, s, "GET", "/mytopic/json?poll=1&consumer=worker1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, "test 1", messages[0].Message)
+	require.Equal(t, "test 2", messages[1].Message)
+
+	// Consumer resumes after the last delivered message
+	request(t, s, "PUT", "/mytopic", "test 3", nil)
+	response = request(t, s, "GET", "/mytopic/json?poll=1&consumer=worker1", "", nil)
+	messages = toMessages(t, response.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "test 3", messages[0].Message)
+
+	// Nothing left, and other consumers are not affected
+	response = request(t, s, "GET", "/mytopic/json?poll=1&consumer=worker1", "", nil)
+	require.Empty(t, response.Body.String())
+	response = request(t, s, "GET", "/mytopic/json?poll=1&consumer=worker2&since=latest", "", nil)
+	messages = toMessages(t, response.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "test 3", messages[0].Message)
+}
+
+func TestServer_SubscribeWithConsumer_Stream(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	require.Nil(t, s.messageCache.AddMessage(newDefaultMessage("mytopic", "test 1")))
+
+	rr := httptest.NewRecorder()
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, "GET", "/mytopic/json?consumer=worker1", nil)
+	require.Nil(t, err)
+	req.RemoteAddr = "9.9.9.9:1234"
+	doneChan := make(chan bool)
+	go func() {
+		s.handle(rr, req)
+		doneChan <- true
+	}()
+	time.Sleep(200 * time.Millisecond)
+	request(t, s, "PUT", "/mytopic", "test 2", nil)
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+	<-doneChan
+
+	messages := toMessages(t, rr.Body.String())
+	require.Equal(t, 3, len(messages))
+	require.Equal(t, openEvent, messages[0].Event)
+	require.Equal(t, "test 1", messages[1].Message)
+	require.Equal(t, "test 2", messages[2].Message)
+
+	c, err := s.messageCache.Consumer("ip:9.9.9.9", "worker1") // Anonymous consumers are scoped by IP address
+	require.Nil(t, err)
+	require.Equal(t, []string{"mytopic"}, c.Topics)
+	require.Equal(t, messages[2].ID, c.MessageID)
+}
+
+func TestServer_SubscribeWithConsumer_NewMessagesAfterCachedMessages(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	cached := make([]*message, 0)
+	for i := 0; i < 500; i++ {
+		cached = append(cached, newDefaultMessage("mytopic", fmt.Sprintf("cached %d", i)))
+	}
+	require.Nil(t, s.messageCache.AddMessages(cached))
+
+	rr := httptest.NewRecorder()
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, "GET", "/mytopic/json?consumer=worker1", nil)
+	require.Nil(t, err)
+	req.RemoteAddr = "9.9.9.9:1234"
+	doneChan := make(chan bool)
+	go func() {
+		s.handle(rr, req)
+		doneChan <- true
+	}()
+	request(t, s, "PUT", "/mytopic", "new", nil) // Likely published while cached messages are sent
+	time.Sleep(300 * time.Millisecond)
+	cancel()
+	<-doneChan
+
+	// New message is always delivered last, so the consumer cannot skip cached messages
+	messages := toMessages(t, rr.Body.String())
+	require.Equal(t, 502, len(messages))
+	require.Equal(t, "cached 499", messages[500].Message)
+	require.Equal(t, "new", messages[501].Message)
+}
+
+func TestServer_PollWithConsumer_ManualAck(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	m1 := toMessage(t, request(t, s, "PUT", "/mytopic", "test 1", nil).Body.String())
+	request(t, s, "PUT", "/mytopic", "test 2", nil)
+
+	// Without acknowledgement, messages are delivered again
+	for i := 0; i < 2; i++ {
+		response := request(t, s, "GET", "/mytopic/json?poll=1&consumer=worker1&ack=1", "", nil)
+		require.Equal(t, 2, len(toMessages(t, response.Body.String())))
+	}
+
+	// Acknowledge first message
+	response := request(t, s, "POST", "/mytopic/ack?consumer=worker1&id="+m1.ID, "", nil)
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "GET", "/mytopic/json?poll=1&consumer=worker1&ack=1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "test 2", messages[0].Message)
+
+	// Errors
+	response = request(t, s, "POST", "/mytopic/ack?consumer=doesnotexist&id="+m1.ID, "", nil)
+	require.Equal(t, 40048, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "POST", "/mytopic/ack?consumer=worker1&id=invalid", "", nil)
+	require.Equal(t, 40049, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "POST", "/mytopic/ack?id="+m1.ID, "", nil)
+	require.Equal(t, 40047, toHTTPError(t, response.Body.String()).Code)
+
+	// Messages of other topics cannot be acknowledged, not even via the path
+	other := toMessage(t, request(t, s, "PUT", "/othertopic", "other", nil).Body.String())
+	response = request(t, s, "POST", "/mytopic/ack?consumer=worker1&id="+other.ID, "", nil)
+	require.Equal(t, 40049, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "POST", "/mytopic,othertopic/ack?consumer=worker1&id="+other.ID, "", nil)
+	require.Equal(t, 40049, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "POST", "/mytopic/ack?consumer=worker1&id=aaaaaaaaaaaa", "", nil)
+	require.Equal(t, 40049, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_PollWithConsumer_AnonymousScopedByIP(t *testing.T) {
+	c := newTestConfig(t)
+	c.BehindProxy = true
+	s := newTestServer(t, c)
+
+	m1 := toMessage(t, request(t, s, "PUT", "/mytopic", "test 1", nil).Body.String())
+	request(t, s, "PUT", "/mytopic", "test 2", nil)
+	response := request(t, s, "GET", "/mytopic/json?poll=1&consumer=worker1&ack=1", "", map[string]string{
+		"X-Forwarded-For": "1.2.3.4",
+	})
+	require.Equal(t, 2, len(toMessages(t, response.Body.String())))
+
+	// Another anonymous visitor cannot acknowledge messages for the consumer, or resume it
+	response = request(t, s, "POST", "/mytopic/ack?consumer=worker1&id="+m1.ID, "", map[string]string{
+		"X-Forwarded-For": "5.6.7.8",
+	})
+	require.Equal(t, 40048, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "GET", "/mytopic/json?poll=1&consumer=worker1&since=latest", "", map[string]string{
+		"X-Forwarded-For": "5.6.7.8",
+	})
+	require.Equal(t, 1, len(toMessages(t, response.Body.String())))
+	response = request(t, s, "GET", "/mytopic/json?poll=1&consumer=worker1&ack=1", "", map[string]string{
+		"X-Forwarded-For": "1.2.3.4",
+	})
+	require.Equal(t, 2, len(toMessages(t, response.Body.String())))
+}
+
+func TestServer_PollWithConsumer_Limit(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	for i := 0; i < consumerLimit; i++ {
+		response := request(t, s, "GET", fmt.Sprintf("/mytopic/json?poll=1&consumer=worker%d", i), "", nil)
+		require.Equal(t, 200, response.Code)
+	}
+	response := request(t, s, "GET", "/mytopic/json?poll=1&consumer=onetoomany", "", nil)
+	require.Equal(t, 429, response.Code)
+	require.Equal(t, 42911, toHTTPError(t, response.Body.String()).Code)
+
+	// Existing consumers can still be resumed
+	response = request(t, s, "GET", "/mytopic/json?poll=1&consumer=worker1", "", nil)
+	require.Equal(t, 200, response.Code)
+}
+
+func TestServer_PollWithConsumer_Prune(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	phil, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	ben, err := s.userManager.User("ben")
+	require.Nil(t, err)
+	for _, username := range []string{"phil", "ben"} {
+		response := request(t, s, "GET", "/mytopic/json?poll=1&consumer=worker1", "", map[string]string{
+			"Authorization": util.BasicAuth(username, username),
+		})
+		require.Equal(t, 200, response.Code)
+	}
+	request(t, s, "GET", "/mytopic/json?poll=1&consumer=stale", "", nil)
+	_, err = s.messageCache.db.Exec("UPDATE consumers SET updated = ? WHERE name = 'stale'", time.Now().Add(-consumerExpiryDuration-time.Hour).Unix())
+	require.Nil(t, err)
+
+	// Removed users and stale consumers are pruned
+	require.Nil(t, s.userManager.RemoveUser("ben"))
+	s.execManager()
+	_, err = s.messageCache.Consumer(phil.ID, "worker1")
+	require.Nil(t, err)
+	_, err = s.messageCache.Consumer(ben.ID, "worker1")
+	require.Equal(t, errConsumerNotFound, err)
+	_, err = s.messageCache.Consumer("ip:9.9.9.9", "stale")
+	require.Equal(t, errConsumerNotFound, err)
+
+	// Consumers are deleted right away if the user deletes their account
+	response := request(t, s, "DELETE", "/v1/account", `{"password":"phil"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	_, err = s.messageCache.Consumer(phil.ID, "worker1")
+	require.Equal(t, errConsumerNotFound, err)
+}
+
+func TestServer_PollWithConsumer_InvalidName(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "GET", "/mytopic/json?poll=1&consumer=not+valid", "", nil)
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40047, toHTTPError(t, response.Body.String()).Code)
+}
+
 func TestServer_PublishViaGET(t *testing.T) {
 	s := newTestServer(t, newTestConfig(t))
 
@@ -771,6 +1180,83 @@ func TestServer_PollWithQueryFilters(t *testing.T) {
 	}
 }
 
+func TestServer_PublishWithLabels(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "PUT", "/mytopic?label.alert_id=1234", "disk full", map[string]string{
+		"X-Label-Env":  "prod",
+		"X-Label-Host": "db1",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, map[string]string{"env": "prod", "host": "db1", "alert_id": "1234"}, m.Labels)
+
+	response = request(t, s, "PUT", "/", `{"topic":"mytopic","message":"cpu high","labels":{"env":"staging","host":"web1"}}`, nil)
+	require.Equal(t, 200, response.Code)
+	m = toMessage(t, response.Body.String())
+	require.Equal(t, map[string]string{"env": "staging", "host": "web1"}, m.Labels)
+
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, "1234", messages[0].Labels["alert_id"])
+	require.Equal(t, "web1", messages[1].Labels["host"])
+}
+
+func TestServer_PublishWithLabels_Invalid(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+		"X-Label-Not.Valid": "prod",
+	})
+	require.Equal(t, 40067, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+		"X-Label-Env": strings.Repeat("x", 257),
+	})
+	require.Equal(t, 40067, toHTTPError(t, response.Body.String()).Code)
+
+	labels := make([]string, 0)
+	for i := 0; i < 17; i++ {
+		labels = append(labels, fmt.Sprintf(`"label%d":"value"`, i))
+	}
+	response = request(t, s, "PUT", "/", `{"topic":"mytopic","labels":{`+strings.Join(labels, ",")+`}}`, nil)
+	require.Equal(t, 40067, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_PollWithLabelFilters(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	for _, query := range []string{
+		"/mytopic?m=prod+db1&label.env=prod&label.host=db1",
+		"/mytopic?m=staging+db1&label.env=staging&label.host=db1",
+		"/mytopic?m=web1&label.host=web1",
+	} {
+		response := request(t, s, "PUT", query, "", nil)
+		require.Equal(t, 200, response.Code)
+	}
+	for query, expected := range map[string][]string{
+		"/mytopic/json?poll=1&label.env=prod":               {"prod db1"},
+		"/mytopic/json?poll=1&label.env=prod,staging":       {"prod db1", "staging db1"},
+		"/mytopic/json?poll=1&label.host=db1&label.env=dev": {},
+		"/mytopic/json?poll=1&label.host=web1":              {"web1"},
+		"/mytopic/json?poll=1&LABEL.HOST=db1":               {"prod db1", "staging db1"},
+	} {
+		response := request(t, s, "GET", query, "", nil)
+		messages := toMessages(t, response.Body.String())
+		require.Equal(t, len(expected), len(messages), "Query failed: "+query)
+		for i, m := range messages {
+			require.Equal(t, expected[i], m.Message, "Query failed: "+query)
+		}
+	}
+
+	// Filters may also be passed as headers
+	response := request(t, s, "GET", "/mytopic/json?poll=1", "", map[string]string{
+		"X-Label-Env": "staging",
+	})
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "staging db1", messages[0].Message)
+}
+
 func TestServer_SubscribeWithQueryFilters(t *testing.T) {
 	t.Parallel()
 	c := newTestConfig(t)
@@ -1496,6 +1982,61 @@ func TestServer_PublishUnifiedPushText(t *testing.T) {
 	require.Equal(t, "this is a unifiedpush text message", m.Message)
 }
 
+func TestServer_PublishEncrypted_AndPoll(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	jwe := "eyJhbGciOiJkaXIiLCJlbmMiOiJBMjU2R0NNIn0..MTIzNDU2Nzg5MDEy.Y2lwaGVydGV4dA.dGFndGFndGFndGFndGFn"
+
+	response := request(t, s, "PUT", "/mytopic", jwe, map[string]string{
+		"Encoding": "jwe",
+		"Priority": "high",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "jwe", m.Encoding)
+	require.Equal(t, jwe, m.Message)
+	require.Equal(t, 4, m.Priority)
+
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	m = toMessage(t, response.Body.String())
+	require.Equal(t, "jwe", m.Encoding)
+	require.Equal(t, jwe, m.Message)
+
+	// Via JSON and GET
+	response = request(t, s, "PUT", "/", `{"topic":"mytopic","message":"`+jwe+`","encoding":"jwe"}`, nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, jwe, toMessage(t, response.Body.String()).Message)
+	response = request(t, s, "GET", "/mytopic/publish?encoding=jwe&message="+jwe, "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "jwe", toMessage(t, response.Body.String()).Encoding)
+}
+
+func TestServer_PublishEncrypted_Invalid(t *testing.T) {
+	c := newTestConfig(t)
+	c.MessageSizeLimit = 200
+	s := newTestServer(t, c)
+	jwe := "eyJhbGciOiJkaXIiLCJlbmMiOiJBMjU2R0NNIn0..MTIzNDU2Nzg5MDEy.Y2lwaGVydGV4dA.dGFndGFndGFndGFndGFn"
+
+	response := request(t, s, "PUT", "/mytopic", "this is not encrypted", map[string]string{"Encoding": "jwe"})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40061, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/mytopic", "bm90IGpzb24..MTIz.YWJj.ZGVm", map[string]string{"Encoding": "jwe"})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40061, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/mytopic", jwe, map[string]string{"Encoding": "jwe", "Attach": "https://example.com/file.jpg"})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40061, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/mytopic", jwe, map[string]string{"Encoding": "base64"})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40060, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/mytopic", jwe+strings.Repeat("A", 200), map[string]string{"Encoding": "jwe"})
+	require.Equal(t, 413, response.Code)
+	require.Equal(t, 41305, toHTTPError(t, response.Body.String()).Code)
+}
+
 func TestServer_MatrixGateway_Discovery_Success(t *testing.T) {
 	s := newTestServer(t, newTestConfig(t))
 	response := request(t, s, "GET", "/_matrix/push/v1/notify", "", nil)
@@ -3063,6 +3604,19 @@ func newTestServer(t *testing.T, config *Config) *Server {
 	return server
 }
 
+// newTestServerWithReservation creates a server that denies access by default, and a user "phil" (password "phil")
+// on the "pro" tier, who has reserved the given topic
+func newTestServerWithReservation(t *testing.T, c *Config, topic string) *Server {
+	c.AuthDefault = user.PermissionDenyAll
+	c.EnableReservations = true
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddTier(&user.Tier{Code: "pro", MessageLimit: 100, MessageExpiryDuration: 3 * 24 * time.Hour, ReservationLimit: 2}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+	require.Nil(t, s.userManager.AddReservation("phil", topic, user.PermissionDenyAll))
+	return s
+}
+
 func request(t *testing.T, s *Server, method, url, body string, headers map[string]string, fn ...func(r *http.Request)) *httptest.ResponseRecorder {
 	rr := httptest.NewRecorder()
 	r, err := http.NewRequest(method, url, strings.NewReader(body))
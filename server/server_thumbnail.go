@@ -0,0 +1,137 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // Register WebP decoder
+
+	"heckel.io/ntfy/v2/util"
+)
+
+const (
+	thumbnailFileIDSuffix     = "-thumb"
+	thumbnailMaxSize          = 640              // Max. width and height of a thumbnail (px)
+	thumbnailJPEGQuality      = 80               // JPEG quality for opaque images, transparent images are encoded as PNG
+	thumbnailMaxSourcePixels  = 40 * 1000 * 1000 // Images with more pixels are not decoded, to limit memory and CPU usage
+	thumbnailTimeout          = 2 * time.Second  // Max. time the publish request waits for a thumbnail
+	thumbnailConcurrencyLimit = 2                // Max. number of thumbnails generated at the same time
+)
+
+var (
+	thumbnailSourceTypes = []string{"image/jpeg", "image/png", "image/webp"}
+
+	errThumbnailNotNeeded      = errors.New("image is not larger than thumbnail")
+	errThumbnailSourceTooLarge = errors.New("image has too many pixels")
+)
+
+// maybeAddThumbnail generates a thumbnail for the uploaded attachment of the given message, if it is an image, stores
+// it in the file cache as "<message ID>-thumb", and sets attachment.Thumbnail. This is best effort: errors (including
+// timeouts) are logged, but not returned, since the message is valid without a thumbnail.
+func (s *Server) maybeAddThumbnail(v *visitor, m *message) {
+	if m.Attachment == nil || !util.Contains(thumbnailSourceTypes, m.Attachment.Type) {
+		return
+	}
+	ev := logvm(v, m).Tag(tagThumbnail)
+	if !s.thumbnailLimiter.TryAcquire(1) {
+		ev.Debug("Too many thumbnails being generated, skipping thumbnail")
+		return
+	}
+	type result struct {
+		thumbnail []byte
+		ext       string
+		err       error
+	}
+	resultChan := make(chan result, 1)
+	go func() {
+		// The goroutine cannot be interrupted while decoding or scaling, so it keeps its slot until it is done
+		defer s.thumbnailLimiter.Release(1)
+		thumbnail, ext, err := s.generateThumbnail(m.ID)
+		resultChan <- result{thumbnail, ext, err}
+	}()
+	select {
+	case r := <-resultChan:
+		if errors.Is(r.err, errThumbnailNotNeeded) {
+			return
+		} else if r.err != nil {
+			ev.Err(r.err).Debug("Unable to generate thumbnail")
+			return
+		}
+		fileID := m.ID + thumbnailFileIDSuffix
+		if _, err := s.fileCache.Write(fileID, bytes.NewReader(r.thumbnail)); err != nil {
+			ev.Err(err).Warn("Unable to write thumbnail")
+			return
+		}
+		m.Attachment.Thumbnail = fmt.Sprintf("%s/file/%s%s", s.config.BaseURL, fileID, r.ext)
+	case <-time.After(thumbnailTimeout):
+		ev.Debug("Generating thumbnail took longer than %s, skipping thumbnail", thumbnailTimeout)
+	}
+}
+
+// generateThumbnail reads the attachment file with the given ID from the file cache directory, and returns
+// a downscaled JPEG or PNG image, and its file extension
+func (s *Server) generateThumbnail(fileID string) ([]byte, string, error) {
+	f, err := os.Open(filepath.Join(s.config.AttachmentCacheDir, fileID))
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	return generateThumbnail(f)
+}
+
+func generateThumbnail(r io.ReadSeeker) ([]byte, string, error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, "", err
+	} else if int64(config.Width)*int64(config.Height) > thumbnailMaxSourcePixels {
+		return nil, "", errThumbnailSourceTooLarge
+	} else if config.Width <= thumbnailMaxSize && config.Height <= thumbnailMaxSize {
+		return nil, "", errThumbnailNotNeeded
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return nil, "", err
+	}
+	width, height := thumbnailDimensions(src.Bounds().Dx(), src.Bounds().Dy())
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.BiLinear.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
+	var buf bytes.Buffer
+	if dst.Opaque() {
+		if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: thumbnailJPEGQuality}); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), ".jpg", nil
+	}
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), ".png", nil
+}
+
+// thumbnailDimensions scales the given dimensions down to fit into thumbnailMaxSize, keeping the aspect ratio
+func thumbnailDimensions(width, height int) (int, int) {
+	if width >= height {
+		return thumbnailMaxSize, max(1, height*thumbnailMaxSize/width)
+	}
+	return max(1, width*thumbnailMaxSize/height), thumbnailMaxSize
+}
+
+// parseThumbnailFileID strips the thumbnail suffix from the given file ID, if it has one
+func parseThumbnailFileID(fileID string) (string, bool) {
+	if len(fileID) == messageIDLength+len(thumbnailFileIDSuffix) && fileID[messageIDLength:] == thumbnailFileIDSuffix {
+		return fileID[:messageIDLength], true
+	}
+	return fileID, false
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_PublishAttachment_Thumbnail_JPEG(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	photo := newTestImage(t, 2000, 1000, true)
+	response := request(t, s, "PUT", "/mytopic", photo, map[string]string{
+		"Filename": "photo.jpg",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "image/jpeg", m.Attachment.Type)
+	require.Equal(t, "http://127.0.0.1:12345/file/"+m.ID+".jpg", m.Attachment.URL)
+	require.Equal(t, "http://127.0.0.1:12345/file/"+m.ID+"-thumb.jpg", m.Attachment.Thumbnail)
+	require.FileExists(t, filepath.Join(s.config.AttachmentCacheDir, m.ID+"-thumb"))
+
+	// Thumbnail is downscaled, and displayed inline
+	response = request(t, s, "GET", "/file/"+m.ID+"-thumb.jpg", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "image/jpeg", response.Header().Get("Content-Type"))
+	require.Equal(t, "", response.Header().Get("Content-Disposition"))
+	thumbnail, format, err := image.Decode(response.Body)
+	require.Nil(t, err)
+	require.Equal(t, "jpeg", format)
+	require.Equal(t, 640, thumbnail.Bounds().Dx())
+	require.Equal(t, 320, thumbnail.Bounds().Dy())
+
+	// Thumbnail is cached, and deleted along with the attachment
+	messages, err := s.messageCache.Messages("mytopic", sinceAllMessages, false)
+	require.Nil(t, err)
+	require.Equal(t, m.Attachment.Thumbnail, messages[0].Attachment.Thumbnail)
+	require.Nil(t, s.fileCache.Remove(m.ID))
+	require.NoFileExists(t, filepath.Join(s.config.AttachmentCacheDir, m.ID+"-thumb"))
+}
+
+func TestServer_PublishAttachment_Thumbnail_TransparentPNG(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	response := request(t, s, "PUT", "/mytopic", newTestImage(t, 800, 1600, false), map[string]string{
+		"Filename": "logo.png",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "http://127.0.0.1:12345/file/"+m.ID+"-thumb.png", m.Attachment.Thumbnail)
+
+	response = request(t, s, "GET", "/file/"+m.ID+"-thumb.png", "", nil)
+	require.Equal(t, 200, response.Code)
+	thumbnail, format, err := image.Decode(response.Body)
+	require.Nil(t, err)
+	require.Equal(t, "png", format)
+	require.Equal(t, 320, thumbnail.Bounds().Dx())
+	require.Equal(t, 640, thumbnail.Bounds().Dy())
+}
+
+func TestServer_PublishAttachment_Thumbnail_NotGenerated(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	// Small images do not need a thumbnail
+	response := request(t, s, "PUT", "/mytopic", newTestImage(t, 300, 200, true), map[string]string{
+		"Filename": "small.jpg",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "", m.Attachment.Thumbnail)
+	require.NoFileExists(t, filepath.Join(s.config.AttachmentCacheDir, m.ID+"-thumb"))
+
+	// Neither do other files, or broken images
+	response = request(t, s, "PUT", "/mytopic", "this is a text file", map[string]string{
+		"Filename": "notes.txt",
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "", toMessage(t, response.Body.String()).Attachment.Thumbnail)
+	response = request(t, s, "PUT", "/mytopic", "\x89PNG\r\n\x1a\nnot really a png", map[string]string{
+		"Filename": "broken.png",
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "", toMessage(t, response.Body.String()).Attachment.Thumbnail)
+
+	// Thumbnails of other attachments do not exist
+	response = request(t, s, "GET", "/file/"+m.ID+"-thumb.jpg", "", nil)
+	require.Equal(t, 404, response.Code)
+}
+
+func TestServer_PublishAttachment_Thumbnail_ConcurrencyLimit(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	require.True(t, s.thumbnailLimiter.TryAcquire(thumbnailConcurrencyLimit)) // All slots busy
+	response := request(t, s, "PUT", "/mytopic", newTestImage(t, 2000, 1000, true), map[string]string{
+		"Filename": "photo.jpg",
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "", toMessage(t, response.Body.String()).Attachment.Thumbnail)
+
+	s.thumbnailLimiter.Release(thumbnailConcurrencyLimit)
+	response = request(t, s, "PUT", "/mytopic", newTestImage(t, 2000, 1000, true), map[string]string{
+		"Filename": "photo.jpg",
+	})
+	require.Equal(t, 200, response.Code)
+	require.NotEqual(t, "", toMessage(t, response.Body.String()).Attachment.Thumbnail)
+}
+
+func TestGenerateThumbnail_SourceTooLarge(t *testing.T) {
+	// Only the header is read, so the pixel data does not matter
+	var buf bytes.Buffer
+	require.Nil(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, 10000, 5000))))
+	_, _, err := generateThumbnail(bytes.NewReader(buf.Bytes()))
+	require.Equal(t, errThumbnailSourceTooLarge, err)
+}
+
+func TestThumbnailDimensions(t *testing.T) {
+	width, height := thumbnailDimensions(4000, 3000)
+	require.Equal(t, 640, width)
+	require.Equal(t, 480, height)
+	width, height = thumbnailDimensions(1000, 2000)
+	require.Equal(t, 320, width)
+	require.Equal(t, 640, height)
+	width, height = thumbnailDimensions(10000, 1)
+	require.Equal(t, 640, width)
+	require.Equal(t, 1, height)
+}
+
+// newTestImage returns an opaque JPEG or a transparent PNG image with the given dimensions
+func newTestImage(t *testing.T, width, height int, opaque bool) string {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBA{R: uint8(x), G: uint8(y), B: 128, A: 255}
+			if !opaque && x < width/2 {
+				c.A = 0
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if opaque {
+		require.Nil(t, jpeg.Encode(&buf, img, nil))
+	} else {
+		require.Nil(t, png.Encode(&buf, img))
+	}
+	return buf.String()
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"net/http"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+// handleAccountTopicLinkAdd links a topic reserved by the user (any topic, for admins) to a target topic the user
+// can write to, so that messages published to the source topic are also published to the target topic
+func (s *Server) handleAccountTopicLinkAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u := v.User()
+	req, err := readJSONWithLimit[apiAccountTopicLinkRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	if !topicRegex.MatchString(req.Source) || !topicRegex.MatchString(req.Target) {
+		return errHTTPBadRequestTopicInvalid
+	} else if req.Source == req.Target || req.MinPriority < 0 || req.MinPriority > 5 {
+		return errHTTPBadRequestTopicLinkInvalid
+	}
+	if !u.IsAdmin() {
+		owner, err := s.userManager.HasReservation(u.Name, req.Source)
+		if err != nil {
+			return err
+		} else if !owner {
+			return errHTTPForbiddenTopicLinkNotOwner
+		}
+	}
+	if err := s.userManager.Authorize(u, req.Target, user.PermissionWrite); err != nil {
+		return errHTTPForbiddenTopicLinkNotOwner
+	}
+	link := &user.TopicLink{
+		UserID:      u.ID,
+		Source:      req.Source,
+		Target:      req.Target,
+		MinPriority: req.MinPriority,
+		Tags:        req.Tags,
+	}
+	logvr(v, r).
+		Tag(tagAccount).
+		Fields(log.Context{
+			"topic_link_source": link.Source,
+			"topic_link_target": link.Target,
+		}).
+		Debug("Adding link from topic %s to topic %s", link.Source, link.Target)
+	if err := s.userManager.AddTopicLink(link); err == user.ErrTopicLinkLoop {
+		return errHTTPConflictTopicLinkLoop
+	} else if err == user.ErrInvalidArgument {
+		return errHTTPBadRequestTopicLinkInvalid
+	} else if err != nil {
+		return err
+	}
+	return s.writeJSON(w, &apiAccountTopicLinkResponse{
+		Source:      link.Source,
+		Target:      link.Target,
+		MinPriority: link.MinPriority,
+		Tags:        link.Tags,
+	})
+}
+
+func (s *Server) handleAccountTopicLinkDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	matches := apiAccountTopicLinkSingleRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 3 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	source, target := matches[1], matches[2]
+	u := v.User()
+	logvr(v, r).
+		Tag(tagAccount).
+		Fields(log.Context{
+			"topic_link_source": source,
+			"topic_link_target": target,
+		}).
+		Debug("Removing link from topic %s to topic %s", source, target)
+	if err := s.userManager.RemoveTopicLink(u.ID, source, target); err == user.ErrTopicLinkNotFound {
+		return errHTTPNotFound
+	} else if err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// fanOutMessage publishes a copy of the given message to all topics that are linked to the message's topic,
+// either directly or through other links. Each topic receives at most one copy, so that links in a diamond shape
+// do not cause duplicates, and loops cannot cause messages to be forwarded forever.
+func (s *Server) fanOutMessage(v *visitor, m *message, cache bool) {
+	if m.Event != messageEvent {
+		return
+	}
+	visited := map[string]bool{m.Topic: true}
+	queue := []*message{m}
+	for len(queue) > 0 {
+		source := queue[0]
+		queue = queue[1:]
+		links, err := s.userManager.TopicLinks(source.Topic)
+		if err != nil {
+			logvm(v, source).Tag(tagTopicLink).Err(err).Warn("Unable to read links of topic %s", source.Topic)
+			continue
+		}
+		for _, link := range links {
+			if visited[link.Target] || !topicLinkMatches(link, source) {
+				continue
+			}
+			visited[link.Target] = true
+			linked, err := s.publishToLinkedTopic(v, link, source, cache)
+			if err != nil {
+				logvm(v, source).Tag(tagTopicLink).Err(err).Debug("Unable to forward message to linked topic %s", link.Target)
+				continue
+			}
+			queue = append(queue, linked)
+		}
+	}
+}
+
+// publishToLinkedTopic publishes a copy of the given message to the target topic of the link, if the owner of the
+// link is still allowed to write to the target topic
+func (s *Server) publishToLinkedTopic(v *visitor, link *user.TopicLink, source *message, cache bool) (*message, error) {
+	owner, err := s.userManager.UserByID(link.UserID)
+	if err != nil {
+		return nil, err
+	} else if err := s.userManager.Authorize(owner, link.Target, user.PermissionWrite); err != nil {
+		return nil, err
+	}
+	logvm(v, source).
+		Tag(tagTopicLink).
+		Field("topic_link_target", link.Target).
+		Debug("Forwarding message from topic %s to linked topic %s", link.Source, link.Target)
+	return s.publishCopy(v, source, link.Target, cache)
+}
+
+// publishCopy publishes a copy of the given message with a new message ID to another topic. Like any other message,
+// the copy counts towards the publisher's message limit, is delivered to subscribers, Firebase, Web Push, the
+// upstream server and federation peers, and is added to the cache if cache is true. It is used for topic links and
+// for routing rules that forward messages.
+func (s *Server) publishCopy(v *visitor, source *message, topicID string, cache bool) (*message, error) {
+	t, err := s.topicFromID(topicID)
+	if err != nil {
+		return nil, err
+	}
+	vrate := v
+	if rateVisitor := t.RateVisitor(); rateVisitor != nil {
+		vrate = rateVisitor
+	}
+	if !util.ContainsIP(s.config.VisitorRequestExemptPrefixes, v.ip) && !vrate.MessageAllowed() {
+		return nil, errHTTPTooManyRequestsLimitMessages.With(t)
+	}
+	m := *source
+	m.ID = util.RandomString(messageIDLength)
+	m.Topic = topicID
+	s.withSignedCopiedAttachmentURLs(&m)
+	if cache {
+		if cache, err = s.applyTopicRetention(&m); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.signMessage(&m); err != nil {
+		return nil, err
+	}
+	if err := t.Publish(v, &m); err != nil {
+		return nil, err
+	}
+	if s.firebaseClient != nil {
+		go s.sendToFirebase(v, &m)
+	}
+	if s.config.UpstreamBaseURL != "" {
+		go s.forwardPollRequest(v, &m)
+	}
+	if len(s.config.FederationPeers) > 0 {
+		go s.federateMessage(v, &m, nil)
+	}
+	if s.config.WebPushPublicKey != "" {
+		go s.publishToWebPushEndpoints(v, &m)
+	}
+	if cache {
+		if err := s.messageCache.AddMessage(&m); err != nil {
+			return nil, err
+		}
+	}
+	return &m, nil
+}
+
+// topicLinkMatches returns true if the message passes the priority and tag filters of the link
+func topicLinkMatches(link *user.TopicLink, m *message) bool {
+	priority := m.Priority
+	if priority == 0 {
+		priority = 3 // Default priority
+	}
+	if link.MinPriority > 0 && priority < link.MinPriority {
+		return false
+	} else if len(link.Tags) == 0 {
+		return true
+	}
+	for _, tag := range link.Tags {
+		if util.Contains(m.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
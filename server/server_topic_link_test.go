@@ -0,0 +1,227 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_TopicLink_FanOut(t *testing.T) {
+	s := newTestServerWithReservation(t, newTestConfigWithAuthFile(t), "alerts")
+	for _, topic := range []string{"team-a", "team-b", "oncall"} {
+		require.Nil(t, s.userManager.AllowAccess("phil", topic, user.PermissionReadWrite))
+	}
+	addTestTopicLink(t, s, "phil", `{"source":"alerts","target":"team-a"}`)
+	addTestTopicLink(t, s, "phil", `{"source":"alerts","target":"oncall","min_priority":4}`)
+	addTestTopicLink(t, s, "phil", `{"source":"alerts","target":"team-b","tags":["db","network"]}`)
+
+	response := request(t, s, "PUT", "/alerts", "Disk almost full", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"Title":         "Disk space",
+		"Tags":          "db,warning",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	waitFor(t, func() bool {
+		return len(cachedTopicLinkMessages(t, s, "team-a")) == 1 && len(cachedTopicLinkMessages(t, s, "team-b")) == 1
+	})
+	linked := cachedTopicLinkMessages(t, s, "team-a")[0]
+	require.NotEqual(t, m.ID, linked.ID)
+	require.Equal(t, "team-a", linked.Topic)
+	require.Equal(t, "Disk almost full", linked.Message)
+	require.Equal(t, "Disk space", linked.Title)
+	require.Equal(t, []string{"db", "warning"}, linked.Tags)
+	require.Empty(t, cachedTopicLinkMessages(t, s, "oncall")) // Priority too low
+
+	// High priority message without matching tag
+	response = request(t, s, "PUT", "/alerts", "Server down", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"Priority":      "5",
+	})
+	require.Equal(t, 200, response.Code)
+	waitFor(t, func() bool {
+		return len(cachedTopicLinkMessages(t, s, "oncall")) == 1 && len(cachedTopicLinkMessages(t, s, "team-a")) == 2
+	})
+	require.Equal(t, 1, len(cachedTopicLinkMessages(t, s, "team-b")))
+
+	// Links are not followed if the owner lost write access to the target
+	require.Nil(t, s.userManager.AllowAccess("phil", "team-a", user.PermissionRead))
+	response = request(t, s, "PUT", "/alerts", "Another one", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"Priority":      "5",
+	})
+	require.Equal(t, 200, response.Code)
+	waitFor(t, func() bool {
+		return len(cachedTopicLinkMessages(t, s, "oncall")) == 2
+	})
+	require.Equal(t, 2, len(cachedTopicLinkMessages(t, s, "team-a")))
+}
+
+func TestServer_TopicLink_AttachmentDownload(t *testing.T) {
+	s := newTestServerWithReservation(t, newTestConfigWithAuthFile(t), "alerts")
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:                     "attachments",
+		MessageLimit:             100,
+		MessageExpiryDuration:    time.Hour,
+		ReservationLimit:         2,
+		AttachmentTotalSizeLimit: 100000,
+		AttachmentFileSizeLimit:  10000,
+		AttachmentExpiryDuration: time.Hour,
+		AttachmentBandwidthLimit: 100000,
+	}))
+	require.Nil(t, s.userManager.ChangeTier("phil", "attachments"))
+	require.Nil(t, s.userManager.AllowAccess("phil", "team-a", user.PermissionReadWrite))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("ben", "team-a", user.PermissionRead))
+	addTestTopicLink(t, s, "phil", `{"source":"alerts","target":"team-a"}`)
+
+	content := util.RandomString(5000)
+	response := request(t, s, "PUT", "/alerts", content, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	waitFor(t, func() bool {
+		return len(cachedTopicLinkMessages(t, s, "team-a")) == 1
+	})
+
+	// The file belongs to the source topic, so the copy carries a URL that is signed until the attachment expires
+	linked := cachedTopicLinkMessages(t, s, "team-a")[0]
+	unsignedURL, _, _ := strings.Cut(m.Attachment.URL, "?")
+	require.Equal(t, unsignedURL+"?sig="+s.downloadURLToken(m.ID, m.Attachment.Expires), linked.Attachment.URL)
+	response = request(t, s, "GET", strings.TrimPrefix(linked.Attachment.URL, s.config.BaseURL), "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, content, response.Body.String())
+}
+
+func TestServer_TopicLink_MessageLimitAndUpstream(t *testing.T) {
+	var upstreamPaths atomic.Int32
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamPaths.Add(1)
+	}))
+	defer upstreamServer.Close()
+
+	s := newTestServerWithReservation(t, newTestConfigWithAuthFile(t), "alerts")
+	require.Nil(t, s.userManager.AllowAccess("phil", "team-a", user.PermissionReadWrite))
+	s.config.UpstreamBaseURL = upstreamServer.URL
+	tier, err := s.userManager.Tier("pro")
+	require.Nil(t, err)
+	tier.MessageLimit = 2
+	require.Nil(t, s.userManager.UpdateTier(tier))
+	addTestTopicLink(t, s, "phil", `{"source":"alerts","target":"team-a"}`)
+
+	// Copies are delivered like any other message, and count towards the message limit
+	response := request(t, s, "PUT", "/alerts", "Disk almost full", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	waitFor(t, func() bool {
+		return len(cachedTopicLinkMessages(t, s, "team-a")) == 1 && upstreamPaths.Load() == 2
+	})
+	response = request(t, s, "PUT", "/alerts", "Disk full", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 429, response.Code)
+}
+
+func TestServer_TopicLink_ChainWithoutDuplicates(t *testing.T) {
+	s := newTestServerWithReservation(t, newTestConfigWithAuthFile(t), "alerts")
+	require.Nil(t, s.userManager.AddUser("admin", "admin", user.RoleAdmin, false))
+	for _, link := range []string{"a:b", "a:c", "b:d", "c:d", "d:e"} {
+		source, target, _ := strings.Cut(link, ":")
+		addTestTopicLink(t, s, "admin", `{"source":"`+source+`","target":"`+target+`"}`)
+	}
+	response := request(t, s, "PUT", "/a", "hi there", map[string]string{
+		"Authorization": util.BasicAuth("admin", "admin"),
+	})
+	require.Equal(t, 200, response.Code)
+	waitFor(t, func() bool {
+		return len(cachedTopicLinkMessages(t, s, "e")) == 1
+	})
+	for _, topic := range []string{"b", "c", "d", "e"} {
+		messages := cachedTopicLinkMessages(t, s, topic)
+		require.Equal(t, 1, len(messages))
+		require.Equal(t, "hi there", messages[0].Message)
+	}
+
+	// Loops are rejected
+	response = request(t, s, "POST", "/v1/account/link", `{"source":"e","target":"a"}`, map[string]string{
+		"Authorization": util.BasicAuth("admin", "admin"),
+	})
+	require.Equal(t, 409, response.Code)
+	require.Equal(t, 40905, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_TopicLink_AddInvalid(t *testing.T) {
+	s := newTestServerWithReservation(t, newTestConfigWithAuthFile(t), "alerts")
+	for _, topic := range []string{"team-a", "oncall"} {
+		require.Nil(t, s.userManager.AllowAccess("phil", topic, user.PermissionReadWrite))
+	}
+	for _, tc := range []struct {
+		body string
+		code int
+	}{
+		{`{"source":"alerts","target":"alerts"}`, 40063},
+		{`{"source":"alerts","target":"team-a","min_priority":6}`, 40063},
+		{`{"source":"alerts","target":"not a topic"}`, 40009},
+		{`{"source":"team-a","target":"oncall"}`, 40306},    // Not the owner of the source topic
+		{`{"source":"alerts","target":"forbidden"}`, 40306}, // No write access to the target topic
+	} {
+		response := request(t, s, "POST", "/v1/account/link", tc.body, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, tc.code, toHTTPError(t, response.Body.String()).Code, tc.body)
+	}
+	response := request(t, s, "POST", "/v1/account/link", `{"source":"alerts","target":"team-a"}`, nil)
+	require.Equal(t, 401, response.Code)
+}
+
+func TestServer_TopicLink_AccountListAndDelete(t *testing.T) {
+	s := newTestServerWithReservation(t, newTestConfigWithAuthFile(t), "alerts")
+	require.Nil(t, s.userManager.AllowAccess("phil", "team-a", user.PermissionReadWrite))
+	addTestTopicLink(t, s, "phil", `{"source":"alerts","target":"team-a","min_priority":4,"tags":["db"]}`)
+
+	response := request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(response.Body))
+	require.Equal(t, 1, len(account.Links))
+	require.Equal(t, "alerts", account.Links[0].Source)
+	require.Equal(t, "team-a", account.Links[0].Target)
+	require.Equal(t, 4, account.Links[0].MinPriority)
+	require.Equal(t, []string{"db"}, account.Links[0].Tags)
+
+	response = request(t, s, "DELETE", "/v1/account/link/alerts/team-a", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "DELETE", "/v1/account/link/alerts/team-a", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 404, response.Code)
+}
+
+func addTestTopicLink(t *testing.T, s *Server, username, body string) {
+	response := request(t, s, "POST", "/v1/account/link", body, map[string]string{
+		"Authorization": util.BasicAuth(username, username),
+	})
+	require.Equal(t, 200, response.Code)
+}
+
+func cachedTopicLinkMessages(t *testing.T, s *Server, topic string) []*message {
+	messages, err := s.messageCache.Messages(topic, sinceAllMessages, false)
+	require.Nil(t, err)
+	return messages
+}
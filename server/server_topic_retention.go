@@ -0,0 +1,131 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+)
+
+// handleAccountTopicRetentionChange overrides the message expiry duration and count limit of a topic reserved by the
+// user (any topic, for admins). The new expiry duration is also applied to all cached messages of the topic.
+func (s *Server) handleAccountTopicRetentionChange(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u := v.User()
+	req, err := readJSONWithLimit[apiAccountTopicRetentionRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	if !topicRegex.MatchString(req.Topic) {
+		return errHTTPBadRequestTopicInvalid
+	} else if req.MessagesExpiryDuration == nil || *req.MessagesExpiryDuration < 0 || req.MessagesCountLimit < 0 {
+		return errHTTPBadRequestTopicRetentionInvalid
+	}
+	retention := &user.TopicRetention{
+		Topic:                 req.Topic,
+		MessageExpiryDuration: time.Duration(*req.MessagesExpiryDuration) * time.Second,
+		MessageCountLimit:     req.MessagesCountLimit,
+	}
+	if !u.IsAdmin() {
+		owner, err := s.userManager.HasReservation(u.Name, req.Topic)
+		if err != nil {
+			return err
+		} else if !owner {
+			return errHTTPForbiddenTopicRetentionNotOwner
+		} else if u.Tier == nil || retention.MessageExpiryDuration > u.Tier.MessageExpiryDuration {
+			return errHTTPBadRequestTopicRetentionInvalid
+		}
+	}
+	logvr(v, r).
+		Tag(tagAccount).
+		Fields(log.Context{
+			"topic":                           retention.Topic,
+			"topic_retention_expiry_duration": retention.MessageExpiryDuration.String(),
+			"topic_retention_messages_limit":  retention.MessageCountLimit,
+		}).
+		Debug("Changing retention of topic %s", retention.Topic)
+	if err := s.userManager.SetTopicRetention(retention); err != nil {
+		return err
+	}
+	if err := s.messageCache.UpdateMessagesExpiry(retention.Topic, retention.MessageExpiryDuration); err != nil {
+		return err
+	}
+	if retention.MessageCountLimit > 0 {
+		if err := s.messageCache.ExpireMessagesBeyondCount(retention.Topic, retention.MessageCountLimit); err != nil {
+			return err
+		}
+	}
+	return s.writeJSON(w, newTopicRetentionResponse(retention))
+}
+
+func (s *Server) handleAccountTopicRetentionDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	matches := apiAccountTopicRetentionSingleRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	topic := matches[1]
+	u := v.User()
+	if !u.IsAdmin() {
+		owner, err := s.userManager.HasReservation(u.Name, topic)
+		if err != nil {
+			return err
+		} else if !owner {
+			return errHTTPForbiddenTopicRetentionNotOwner
+		}
+	}
+	logvr(v, r).Tag(tagAccount).Field("topic", topic).Debug("Removing retention of topic %s", topic)
+	if err := s.userManager.RemoveTopicRetention(topic); err == user.ErrTopicRetentionNotFound {
+		return errHTTPNotFound
+	} else if err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// applyTopicRetention sets the expiry of the message according to the retention settings of its topic, if there
+// are any. It returns false if the message must not be cached, because the topic does not keep any messages.
+// Scheduled messages are always cached, and deleted right after they were published.
+func (s *Server) applyTopicRetention(m *message) (bool, error) {
+	if s.userManager == nil {
+		return true, nil
+	}
+	retention, err := s.userManager.TopicRetention(m.Topic)
+	if err == user.ErrTopicRetentionNotFound {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	m.Expires = time.Unix(m.Time, 0).Add(retention.MessageExpiryDuration).Unix()
+	if retention.MessageExpiryDuration == 0 && m.Time <= time.Now().Unix() {
+		return false, nil
+	}
+	return true, nil
+}
+
+// pruneMessagesBeyondCount expires the oldest messages of all topics that have a message count limit, so that they
+// are deleted by pruneMessages
+func (s *Server) pruneMessagesBeyondCount() {
+	if s.userManager == nil {
+		return
+	}
+	retentions, err := s.userManager.TopicRetentions()
+	if err != nil {
+		log.Tag(tagManager).Err(err).Warn("Error retrieving topic retention settings")
+		return
+	}
+	for _, retention := range retentions {
+		if retention.MessageCountLimit == 0 {
+			continue
+		}
+		if err := s.messageCache.ExpireMessagesBeyondCount(retention.Topic, retention.MessageCountLimit); err != nil {
+			log.Tag(tagManager).Field("topic", retention.Topic).Err(err).Warn("Error expiring messages beyond count limit")
+		}
+	}
+}
+
+func newTopicRetentionResponse(retention *user.TopicRetention) *apiAccountTopicRetention {
+	return &apiAccountTopicRetention{
+		MessagesExpiryDuration: int64(retention.MessageExpiryDuration.Seconds()),
+		MessagesCountLimit:     retention.MessageCountLimit,
+	}
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_TopicRetention_ExpiryDuration(t *testing.T) {
+	s := newTestServerWithReservation(t, newTestConfigWithAuthFile(t), "alerts")
+	require.Nil(t, s.userManager.AllowAccess("phil", "other", user.PermissionReadWrite))
+
+	// Existing messages are updated when the retention changes
+	response := request(t, s, "PUT", "/alerts", "before", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	setTestTopicRetention(t, s, "phil", `{"topic":"alerts","messages_expiry_duration":172800}`)
+
+	response = request(t, s, "PUT", "/alerts", "after", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, m.Time+172800, m.Expires)
+
+	messages := cachedTopicRetentionMessages(t, s, "alerts")
+	require.Equal(t, 2, len(messages))
+	for _, m := range messages {
+		require.Equal(t, m.Time+172800, m.Expires)
+	}
+
+	// Changing the retention does not extend the expiry of messages with a short TTL
+	response = request(t, s, "PUT", "/alerts", "short-lived", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"TTL":           "5m",
+	})
+	require.Equal(t, 200, response.Code)
+	shortLived := toMessage(t, response.Body.String())
+	require.Equal(t, shortLived.Time+300, shortLived.Expires)
+	setTestTopicRetention(t, s, "phil", `{"topic":"alerts","messages_expiry_duration":86400}`)
+
+	messages = cachedTopicRetentionMessages(t, s, "alerts")
+	require.Equal(t, 3, len(messages))
+	for _, m := range messages {
+		if m.ID == shortLived.ID {
+			require.Equal(t, m.Time+300, m.Expires)
+		} else {
+			require.Equal(t, m.Time+86400, m.Expires)
+		}
+	}
+
+	// Other topics use the tier's expiry duration
+	response = request(t, s, "PUT", "/other", "hi", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	m = toMessage(t, response.Body.String())
+	require.Equal(t, m.Time+int64((3*24*time.Hour).Seconds()), m.Expires)
+}
+
+func TestServer_TopicRetention_NoHistory(t *testing.T) {
+	s := newTestServerWithReservation(t, newTestConfigWithAuthFile(t), "alerts")
+	setTestTopicRetention(t, s, "phil", `{"topic":"alerts","messages_expiry_duration":0}`)
+
+	response := request(t, s, "PUT", "/alerts", "not cached", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	require.Empty(t, cachedTopicRetentionMessages(t, s, "alerts"))
+
+	// Scheduled messages must be cached until they are published
+	response = request(t, s, "PUT", "/alerts", "scheduled", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"Delay":         "1h",
+	})
+	require.Equal(t, 200, response.Code)
+	messages, err := s.messageCache.Messages("alerts", sinceAllMessages, true)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, messages[0].Time, messages[0].Expires)
+}
+
+func TestServer_TopicRetention_ForwardedMessage(t *testing.T) {
+	s := newTestServerWithReservation(t, newTestConfigWithAuthFile(t), "alerts")
+	v := s.visitor(netip.MustParseAddr("9.9.9.9"), nil)
+
+	// Messages from federation peers and relay subscriptions follow the retention settings, too
+	setTestTopicRetention(t, s, "phil", `{"topic":"alerts","messages_expiry_duration":3600}`)
+	topic, err := s.topicFromID("alerts")
+	require.Nil(t, err)
+	m := newDefaultMessage("alerts", "forwarded")
+	require.Nil(t, s.publishForwardedMessage(v, topic, m, nil))
+	messages := cachedTopicRetentionMessages(t, s, "alerts")
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, m.Time+3600, messages[0].Expires)
+
+	setTestTopicRetention(t, s, "phil", `{"topic":"alerts","messages_expiry_duration":0}`)
+	require.Nil(t, s.publishForwardedMessage(v, topic, newDefaultMessage("alerts", "not cached"), nil))
+	messages = cachedTopicRetentionMessages(t, s, "alerts")
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "forwarded", messages[0].Message)
+}
+
+func TestServer_TopicRetention_CountLimit(t *testing.T) {
+	s := newTestServerWithReservation(t, newTestConfigWithAuthFile(t), "alerts")
+	for i := 0; i < 5; i++ {
+		response := request(t, s, "PUT", "/alerts", fmt.Sprintf("message %d", i+1), map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, response.Code)
+	}
+	setTestTopicRetention(t, s, "phil", `{"topic":"alerts","messages_expiry_duration":86400,"messages_count_limit":3}`)
+	s.pruneMessages()
+
+	messages := cachedTopicRetentionMessages(t, s, "alerts")
+	require.Equal(t, 3, len(messages))
+	require.Equal(t, "message 3", messages[0].Message)
+	require.Equal(t, "message 5", messages[2].Message)
+
+	// Ring buffer: the oldest messages are deleted when pruning
+	response := request(t, s, "PUT", "/alerts", "message 6", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, 4, len(cachedTopicRetentionMessages(t, s, "alerts")))
+	s.execManager()
+	messages = cachedTopicRetentionMessages(t, s, "alerts")
+	require.Equal(t, 3, len(messages))
+	require.Equal(t, "message 4", messages[0].Message)
+}
+
+func TestServer_TopicRetention_AccountAndPermissions(t *testing.T) {
+	s := newTestServerWithReservation(t, newTestConfigWithAuthFile(t), "alerts")
+	require.Nil(t, s.userManager.AddUser("admin", "admin", user.RoleAdmin, false))
+	for _, tc := range []struct {
+		username string
+		body     string
+		code     int
+	}{
+		{"phil", `{"topic":"alerts"}`, 40065},                                       // Expiry duration missing
+		{"phil", `{"topic":"alerts","messages_expiry_duration":-1}`, 40065},         // Negative
+		{"phil", `{"topic":"alerts","messages_expiry_duration":2592000}`, 40065},    // Exceeds tier (3 days)
+		{"phil", `{"topic":"other","messages_expiry_duration":3600}`, 40307},        // Not reserved
+		{"phil", `{"topic":"not a topic","messages_expiry_duration":3600}`, 40009},  // Invalid topic
+		{"admin", `{"topic":"not a topic","messages_expiry_duration":3600}`, 40009}, // Invalid topic
+		{"phil", `{"topic":"alerts","messages_expiry_duration":3600,"messages_count_limit":-5}`, 40065},
+	} {
+		response := request(t, s, "POST", "/v1/account/retention", tc.body, map[string]string{
+			"Authorization": util.BasicAuth(tc.username, tc.username),
+		})
+		require.Equal(t, tc.code, toHTTPError(t, response.Body.String()).Code, tc.body)
+	}
+
+	// Admins may set any retention on any topic
+	setTestTopicRetention(t, s, "admin", `{"topic":"other","messages_expiry_duration":2592000}`)
+	setTestTopicRetention(t, s, "phil", `{"topic":"alerts","messages_expiry_duration":3600,"messages_count_limit":50}`)
+
+	response := request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(response.Body))
+	require.Equal(t, 1, len(account.Reservations))
+	require.Equal(t, "alerts", account.Reservations[0].Topic)
+	require.Equal(t, int64(3600), account.Reservations[0].Retention.MessagesExpiryDuration)
+	require.Equal(t, int64(50), account.Reservations[0].Retention.MessagesCountLimit)
+
+	// Remove
+	response = request(t, s, "DELETE", "/v1/account/retention/other", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 40307, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "DELETE", "/v1/account/retention/alerts", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "DELETE", "/v1/account/retention/alerts", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 404, response.Code)
+	_, err := s.userManager.TopicRetention("alerts")
+	require.Equal(t, user.ErrTopicRetentionNotFound, err)
+}
+
+func setTestTopicRetention(t *testing.T, s *Server, username, body string) {
+	response := request(t, s, "POST", "/v1/account/retention", body, map[string]string{
+		"Authorization": util.BasicAuth(username, username),
+	})
+	require.Equal(t, 200, response.Code)
+}
+
+func cachedTopicRetentionMessages(t *testing.T, s *Server, topic string) []*message {
+	messages, err := s.messageCache.Messages(topic, sinceAllMessages, false)
+	require.Nil(t, err)
+	return messages
+}
@@ -0,0 +1,338 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/util"
+)
+
+const (
+	tusVersion           = "1.0.0"
+	uploadContentType    = "application/offset+octet-stream"
+	uploadExpiryDuration = 24 * time.Hour // Incomplete uploads are deleted if no bytes were received for this long
+	uploadsLimit         = 10             // Max. number of incomplete uploads per user or IP address
+)
+
+var (
+	// uploadHeadersExcluded are request headers that are not stored with an upload, because they are
+	// either credentials or only describe the creation request itself
+	uploadHeadersExcluded = []string{"Authorization", "Cookie", "Content-Length", "Transfer-Encoding", "Tus-Resumable", "Upload-Length", "Upload-Metadata"}
+
+	// uploadQueryExcluded are query parameters that are not stored with an upload, see uploadHeadersExcluded
+	uploadQueryExcluded = []string{"auth", signedURLParam}
+)
+
+// handleUploadCreate creates a resumable upload, using a subset of the tus protocol (https://tus.io/protocols/resumable-upload).
+// The publish parameters are validated right away, so that the client does not find out that they are invalid
+// only after uploading the entire file.
+func (s *Server) handleUploadCreate(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	t, err := fromContext[*topic](r, contextTopic)
+	if err != nil {
+		return err
+	}
+	if s.fileCache == nil || s.config.BaseURL == "" {
+		return errHTTPBadRequestAttachmentsDisallowed.With(t)
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		return errHTTPBadRequestUploadInvalid.With(t)
+	}
+	m := newDefaultMessage(t.ID, "") // Only used to validate the parameters
+	_, _, _, _, template, unifiedpush, e := s.parsePublishParams(r, m)
+	if e != nil {
+		return e.With(t)
+	} else if template || unifiedpush || m.PollID != "" || m.Encoding == encodingJWE || (m.Attachment != nil && m.Attachment.URL != "") {
+		return errHTTPBadRequestUploadParamsInvalid.With(t)
+	}
+	vinfo, err := v.Info()
+	if err != nil {
+		return err
+	}
+	// The announced length of incomplete uploads is already included in the attachment total size, so that
+	// concurrent uploads cannot exceed the remaining storage together
+	if length > vinfo.Limits.AttachmentFileSizeLimit || length > vinfo.Stats.AttachmentTotalSizeRemaining {
+		return errHTTPEntityTooLargeAttachment.With(t).Fields(log.Context{
+			"upload_length":                   length,
+			"attachment_total_size_remaining": vinfo.Stats.AttachmentTotalSizeRemaining,
+			"attachment_file_size_limit":      vinfo.Limits.AttachmentFileSizeLimit,
+		})
+	}
+	uploads, err := s.messageCache.UploadsCount(v.MaybeUserID(), v.IP())
+	if err != nil {
+		return err
+	} else if uploads >= uploadsLimit {
+		return errHTTPTooManyRequestsLimitUploads.With(t)
+	}
+	u := &upload{
+		ID:      util.RandomString(messageIDLength),
+		Topic:   t.ID,
+		User:    v.MaybeUserID(),
+		Sender:  v.IP(),
+		Length:  length,
+		Header:  uploadPublishHeader(r),
+		Query:   uploadPublishQuery(r),
+		Expires: time.Now().Add(uploadExpiryDuration).Unix(),
+	}
+	if err := s.messageCache.AddUpload(u); err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagUpload).With(t, u).Debug("Created resumable upload")
+	w.Header().Set("Location", fmt.Sprintf("%s/%s/upload/%s", s.config.BaseURL, t.ID, u.ID))
+	s.writeUploadHeaders(w, u)
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+// handleUploadHead returns the number of bytes received so far, so that the client knows where to resume
+func (s *Server) handleUploadHead(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u, err := s.uploadFromPath(r, v)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	s.writeUploadHeaders(w, u)
+	return nil
+}
+
+// handleUploadPatch appends a chunk to a resumable upload. The chunk is subject to the visitor's bandwidth
+// limit and remaining attachment storage, and may not exceed the announced upload length. If the connection
+// drops, all bytes received up to that point are kept. Once the upload is complete, the message is published.
+func (s *Server) handleUploadPatch(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u, unlock, err := s.lockUpload(r, v)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || r.Header.Get("Content-Type") != uploadContentType {
+		return errHTTPBadRequestUploadInvalid.With(u)
+	} else if offset != u.Received {
+		return errHTTPConflictUploadOffsetMismatch.With(u)
+	}
+	vinfo, err := v.Info()
+	if err != nil {
+		return err
+	}
+	// The attachment total size already includes the full length of this upload, so the bytes that are still
+	// missing are added back. This only limits the chunk if the storage limit was lowered in the meantime.
+	remaining := vinfo.Limits.AttachmentTotalSizeLimit - vinfo.Stats.AttachmentTotalSize + u.Length - u.Received
+	limiters := []util.Limiter{
+		v.BandwidthLimiter(),
+		util.NewFixedLimiterWithValue(u.Length, u.Received),
+		util.NewFixedLimiter(zeroIfNegative(remaining)),
+	}
+	size, err := s.fileCache.Append(u.ID, u.Received, r.Body, limiters...)
+	if size > 0 {
+		u.Received += size
+		u.Expires = time.Now().Add(uploadExpiryDuration).Unix()
+		if err := s.messageCache.UpdateUploadReceived(u.ID, u.Received, u.Expires); err != nil {
+			return err
+		}
+	}
+	if errors.Is(err, util.ErrLimitReached) {
+		return errHTTPEntityTooLargeAttachment.With(u)
+	} else if err != nil {
+		logvr(v, r).Tag(tagUpload).With(u).Err(err).Debug("Upload interrupted")
+		return err
+	}
+	if u.Received < u.Length {
+		s.writeUploadHeaders(w, u)
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return s.handleUploadComplete(w, r, v, u)
+}
+
+// handleUploadComplete publishes the message of a completed upload by replaying the stored publish parameters
+// through the regular publishing flow, see handleBodyAsUploadedAttachment. If publishing fails (e.g. because the
+// message limit was reached), the upload is kept, and the client can retry with an empty PATCH request.
+func (s *Server) handleUploadComplete(w http.ResponseWriter, r *http.Request, v *visitor, u *upload) error {
+	publishRequest := withContext(r, map[contextKey]any{
+		contextUpload: u,
+	})
+	publishRequest.Header = u.Header.Clone()
+	publishRequest.URL = &url.URL{Path: r.URL.Path, RawQuery: u.Query}
+	publishRequest.Body = http.NoBody
+	m, err := s.handlePublishInternal(publishRequest, v)
+	if err != nil {
+		minc(metricMessagesPublishedFailure)
+		return err
+	}
+	minc(metricMessagesPublishedSuccess)
+	logvrm(v, r, m).Tag(tagUpload).With(u).Debug("Completed resumable upload")
+	if err := s.messageCache.DeleteUploads(u.ID); err != nil {
+		return err
+	}
+	s.writeUploadHeaders(w, u)
+	return s.writeJSON(w, s.withSignedAttachmentURLs(m))
+}
+
+// handleUploadDelete cancels a resumable upload, and deletes the bytes received so far
+func (s *Server) handleUploadDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u, unlock, err := s.lockUpload(r, v)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := s.fileCache.Remove(u.ID); err != nil {
+		return err
+	} else if err := s.messageCache.DeleteUploads(u.ID); err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagUpload).With(u).Debug("Deleted resumable upload")
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// handleBodyAsUploadedAttachment attaches the file of a completed resumable upload to the message. The file was
+// written to the file cache under the upload ID, so the upload ID becomes the message ID.
+func (s *Server) handleBodyAsUploadedAttachment(v *visitor, m *message, u *upload) error {
+	vinfo, err := v.Info()
+	if err != nil {
+		return err
+	}
+	attachmentExpiry := time.Now().Add(vinfo.Limits.AttachmentExpiryDuration).Unix()
+	if m.Time > attachmentExpiry {
+		return errHTTPBadRequestAttachmentsExpiryBeforeDelivery.With(m)
+	} else if vinfo.Stats.AttachmentTotalSize > vinfo.Limits.AttachmentTotalSizeLimit {
+		return errHTTPEntityTooLargeAttachment.With(m).Fields(log.Context{
+			"attachment_total_size":       vinfo.Stats.AttachmentTotalSize,
+			"attachment_total_size_limit": vinfo.Limits.AttachmentTotalSizeLimit,
+		}) // The upload itself is already included in the total size
+	}
+	f, err := os.Open(filepath.Join(s.config.AttachmentCacheDir, u.ID))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	buf := make([]byte, 512) // Enough to detect the content type
+	n, err := f.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	m.ID = u.ID
+	if m.Attachment == nil {
+		m.Attachment = &attachment{}
+	}
+	var ext string
+	m.Attachment.Type, ext = util.DetectContentType(buf[:n], m.Attachment.Name)
+	m.Attachment.Size = u.Length
+	m.Attachment.Expires = attachmentExpiry
+	m.Attachment.URL = fmt.Sprintf("%s/file/%s%s", s.config.BaseURL, m.ID, ext)
+	if m.Attachment.Name == "" {
+		m.Attachment.Name = fmt.Sprintf("attachment%s", ext)
+	}
+	if m.Message == "" {
+		m.Message = fmt.Sprintf(defaultAttachmentMessage, m.Attachment.Name)
+	}
+	return nil
+}
+
+// uploadFromPath returns the upload referenced in the request path. Uploads can only be accessed by the
+// user who created them; anonymous uploads can be accessed by anyone who knows the upload ID, since the
+// IP address of mobile clients may change when the upload is resumed.
+func (s *Server) uploadFromPath(r *http.Request, v *visitor) (*upload, error) {
+	matches := uploadIDPathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		return nil, errHTTPInternalErrorInvalidPath
+	}
+	t, err := fromContext[*topic](r, contextTopic)
+	if err != nil {
+		return nil, err
+	}
+	u, err := s.messageCache.Upload(matches[1])
+	if errors.Is(err, errUploadNotFound) {
+		return nil, errHTTPNotFoundUpload.With(t)
+	} else if err != nil {
+		return nil, err
+	} else if u.Topic != t.ID || u.User != v.MaybeUserID() || u.Expires < time.Now().Unix() {
+		return nil, errHTTPNotFoundUpload.With(t)
+	}
+	return u, nil
+}
+
+// lockUpload returns the upload referenced in the request path, and makes sure that no other request writes
+// to it at the same time. The returned function must be called to release the upload.
+func (s *Server) lockUpload(r *http.Request, v *visitor) (*upload, func(), error) {
+	matches := uploadIDPathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		return nil, nil, errHTTPInternalErrorInvalidPath
+	}
+	id := matches[1]
+	s.mu.Lock()
+	if s.uploadsActive[id] {
+		s.mu.Unlock()
+		return nil, nil, errHTTPConflictUploadInProgress
+	}
+	s.uploadsActive[id] = true
+	s.mu.Unlock()
+	unlock := func() {
+		s.mu.Lock()
+		delete(s.uploadsActive, id)
+		s.mu.Unlock()
+	}
+	u, err := s.uploadFromPath(r, v)
+	if err != nil {
+		unlock()
+		return nil, nil, err
+	}
+	return u, unlock, nil
+}
+
+func (s *Server) writeUploadHeaders(w http.ResponseWriter, u *upload) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Received, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	w.Header().Set("Upload-Expires", time.Unix(u.Expires, 0).UTC().Format(http.TimeFormat))
+	w.Header().Set("Access-Control-Allow-Origin", s.config.AccessControlAllowOrigin) // CORS, allow cross-origin requests
+	w.Header().Set("Access-Control-Expose-Headers", "Location, Tus-Resumable, Upload-Offset, Upload-Length, Upload-Expires")
+}
+
+// uploadPublishHeader returns the request headers that are stored with an upload. If the client passed a
+// file name in the tus Upload-Metadata header, and no other file name, it is stored as X-Filename.
+func uploadPublishHeader(r *http.Request) http.Header {
+	header := r.Header.Clone()
+	for _, name := range uploadHeadersExcluded {
+		header.Del(name)
+	}
+	if filename := uploadMetadata(r)["filename"]; filename != "" && readParam(r, "x-filename", "filename", "file", "f") == "" {
+		header.Set("X-Filename", filename)
+	}
+	return header
+}
+
+// uploadPublishQuery returns the query parameters that are stored with an upload, see uploadPublishHeader
+func uploadPublishQuery(r *http.Request) string {
+	query := r.URL.Query()
+	for _, name := range uploadQueryExcluded {
+		query.Del(name)
+	}
+	return query.Encode()
+}
+
+// uploadMetadata parses the tus Upload-Metadata header, a comma-separated list of
+// keys and base64-encoded values, e.g. "filename d29ybGRfZG9taW5hdGlvbl9wbGFuLnBkZg==,is_confidential"
+func uploadMetadata(r *http.Request) map[string]string {
+	metadata := make(map[string]string)
+	for _, pair := range util.SplitNoEmpty(r.Header.Get("Upload-Metadata"), ",") {
+		key, encodedValue, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		value, err := base64.StdEncoding.DecodeString(encodedValue)
+		if err != nil {
+			continue
+		}
+		metadata[key] = string(value)
+	}
+	return metadata
+}
@@ -0,0 +1,311 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_Upload_Chunked(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	content := util.RandomString(10000)
+
+	// Create upload, publish parameters are stored until the upload is complete
+	response := request(t, s, "POST", "/mytopic/upload", "", map[string]string{
+		"Tus-Resumable": "1.0.0",
+		"Upload-Length": "10000",
+		"Title":         "Flight log",
+		"Tags":          "airplane",
+		"Filename":      "flight.log",
+	})
+	require.Equal(t, 201, response.Code)
+	require.Equal(t, "1.0.0", response.Header().Get("Tus-Resumable"))
+	require.Equal(t, "0", response.Header().Get("Upload-Offset"))
+	location := strings.TrimPrefix(response.Header().Get("Location"), s.config.BaseURL)
+	require.Regexp(t, `^/mytopic/upload/[-_A-Za-z0-9]{12}$`, location)
+	uploadID := strings.TrimPrefix(location, "/mytopic/upload/")
+
+	// Upload first chunk
+	response = request(t, s, "PATCH", location, content[:4000], map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	})
+	require.Equal(t, 204, response.Code)
+	require.Equal(t, "4000", response.Header().Get("Upload-Offset"))
+	require.Equal(t, "10000", response.Header().Get("Upload-Length"))
+
+	// Nothing is published yet
+	messages, err := s.messageCache.Messages("mytopic", sinceAllMessages, false)
+	require.Nil(t, err)
+	require.Empty(t, messages)
+
+	// Chunk with wrong offset is rejected, client asks for the offset
+	response = request(t, s, "PATCH", location, content[:4000], map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	})
+	require.Equal(t, 409, response.Code)
+	require.Equal(t, 40906, toHTTPError(t, response.Body.String()).Code)
+	response = request(t, s, "HEAD", location, "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "4000", response.Header().Get("Upload-Offset"))
+	require.Equal(t, "no-store", response.Header().Get("Cache-Control"))
+
+	// Last chunk publishes the message
+	response = request(t, s, "PATCH", location, content[4000:], map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "4000",
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "10000", response.Header().Get("Upload-Offset"))
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, uploadID, m.ID)
+	require.Equal(t, "Flight log", m.Title)
+	require.Equal(t, []string{"airplane"}, m.Tags)
+	require.Equal(t, "You received a file: flight.log", m.Message)
+	require.Equal(t, "flight.log", m.Attachment.Name)
+	require.Equal(t, int64(10000), m.Attachment.Size)
+	require.Equal(t, "text/plain; charset=utf-8", m.Attachment.Type)
+	require.Equal(t, "http://127.0.0.1:12345/file/"+uploadID+".txt", m.Attachment.URL)
+
+	messages, err = s.messageCache.Messages("mytopic", sinceAllMessages, false)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, m.Attachment, messages[0].Attachment)
+
+	response = request(t, s, "GET", "/file/"+uploadID+".txt", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, content, response.Body.String())
+
+	// Upload is gone
+	response = request(t, s, "HEAD", location, "", nil)
+	require.Equal(t, 404, response.Code)
+}
+
+func TestServer_Upload_ResumeAfterInterruptedChunk(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	content := util.RandomString(8000)
+
+	response := request(t, s, "POST", "/mytopic/upload", "", map[string]string{
+		"Upload-Length":   "8000",
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("data.bin")) + ",is_confidential",
+	})
+	require.Equal(t, 201, response.Code)
+	location := strings.TrimPrefix(response.Header().Get("Location"), s.config.BaseURL)
+
+	// Connection drops after 3000 bytes; the bytes received so far are kept
+	response = request(t, s, "PATCH", location, "", map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	}, func(r *http.Request) {
+		r.Body = io.NopCloser(io.MultiReader(strings.NewReader(content[:3000]), iotest.ErrReader(errors.New("connection reset"))))
+	})
+	require.Equal(t, 500, response.Code)
+	response = request(t, s, "HEAD", location, "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "3000", response.Header().Get("Upload-Offset"))
+
+	// Resume from there
+	response = request(t, s, "PATCH", location, content[3000:], map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "3000",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "data.bin", m.Attachment.Name)
+	require.Equal(t, int64(8000), m.Attachment.Size)
+
+	response = request(t, s, "GET", "/file/"+m.ID+".bin", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, content, response.Body.String())
+}
+
+func TestServer_Upload_Invalid(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "POST", "/mytopic/upload", "", nil)
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40070, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "POST", "/mytopic/upload", "", map[string]string{
+		"Upload-Length": "100",
+		"Attach":        "https://example.com/file.jpg",
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40071, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "POST", "/mytopic/upload", "", map[string]string{
+		"Upload-Length": "100",
+		"Priority":      "invalid",
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40007, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "POST", "/mytopic/upload", "", map[string]string{
+		"Upload-Length": "100",
+	})
+	require.Equal(t, 201, response.Code)
+	location := strings.TrimPrefix(response.Header().Get("Location"), s.config.BaseURL)
+
+	response = request(t, s, "PATCH", location, "some data", map[string]string{
+		"Upload-Offset": "0",
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40070, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PATCH", "/othertopic/upload/"+strings.TrimPrefix(location, "/mytopic/upload/"), "some data", map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	})
+	require.Equal(t, 404, response.Code)
+	require.Equal(t, 40402, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_Upload_Limits(t *testing.T) {
+	c := newTestConfig(t)
+	c.AttachmentFileSizeLimit = 8000
+	c.VisitorAttachmentTotalSizeLimit = 10000
+	s := newTestServer(t, c)
+
+	// Announced length is checked against the file size limit
+	response := request(t, s, "POST", "/mytopic/upload", "", map[string]string{
+		"Upload-Length": "8001",
+	})
+	require.Equal(t, 413, response.Code)
+	require.Equal(t, 41301, toHTTPError(t, response.Body.String()).Code)
+
+	// Chunks may not exceed the announced length
+	response = request(t, s, "POST", "/mytopic/upload", "", map[string]string{
+		"Upload-Length": "6000",
+	})
+	require.Equal(t, 201, response.Code)
+	location := strings.TrimPrefix(response.Header().Get("Location"), s.config.BaseURL)
+	response = request(t, s, "PATCH", location, util.RandomString(7000), map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	})
+	require.Equal(t, 413, response.Code)
+
+	// The announced length of incomplete uploads counts towards the visitor's attachment storage,
+	// so two uploads that each fit on their own cannot be created at the same time
+	response = request(t, s, "PATCH", location, util.RandomString(1000), map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	})
+	require.Equal(t, 204, response.Code)
+	size, err := s.messageCache.AttachmentBytesUsedBySender("9.9.9.9") // See request()
+	require.Nil(t, err)
+	require.Equal(t, int64(6000), size)
+	response = request(t, s, "POST", "/mytopic/upload", "", map[string]string{
+		"Upload-Length": "4001",
+	})
+	require.Equal(t, 413, response.Code)
+	response = request(t, s, "POST", "/mytopic/upload", "", map[string]string{
+		"Upload-Length": "4000",
+	})
+	require.Equal(t, 201, response.Code)
+	location2 := strings.TrimPrefix(response.Header().Get("Location"), s.config.BaseURL)
+	response = request(t, s, "DELETE", location2, "", nil)
+	require.Equal(t, 204, response.Code)
+
+	// The remaining bytes of the upload can still be sent
+	response = request(t, s, "PATCH", location, util.RandomString(4000), map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "1000",
+	})
+	require.Equal(t, 204, response.Code)
+
+	// Deleting the upload frees the storage
+	response = request(t, s, "DELETE", location, "", nil)
+	require.Equal(t, 204, response.Code)
+	require.NoFileExists(t, filepath.Join(s.config.AttachmentCacheDir, strings.TrimPrefix(location, "/mytopic/upload/")))
+	size, err = s.messageCache.AttachmentBytesUsedBySender("9.9.9.9")
+	require.Nil(t, err)
+	require.Equal(t, int64(0), size)
+	response = request(t, s, "HEAD", location, "", nil)
+	require.Equal(t, 404, response.Code)
+}
+
+func TestServer_Upload_ConcurrentUploadsLimit(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+	for i := 0; i < uploadsLimit; i++ {
+		response := request(t, s, "POST", "/mytopic/upload", "", map[string]string{
+			"Upload-Length": "100",
+		})
+		require.Equal(t, 201, response.Code)
+	}
+	response := request(t, s, "POST", "/mytopic/upload", "", map[string]string{
+		"Upload-Length": "100",
+	})
+	require.Equal(t, 429, response.Code)
+	require.Equal(t, 42912, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_Upload_AccessByOtherUser(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin, false))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleAdmin, false))
+
+	response := request(t, s, "POST", "/mytopic/upload", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"Upload-Length": "100",
+	})
+	require.Equal(t, 201, response.Code)
+	location := strings.TrimPrefix(response.Header().Get("Location"), s.config.BaseURL)
+
+	response = request(t, s, "HEAD", location, "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 404, response.Code)
+	response = request(t, s, "HEAD", location, "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	// Authorization header is not stored with the upload
+	phil, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	u, err := s.messageCache.Upload(strings.TrimPrefix(location, "/mytopic/upload/"))
+	require.Nil(t, err)
+	require.Equal(t, phil.ID, u.User)
+	require.Empty(t, u.Header.Get("Authorization"))
+}
+
+func TestServer_Upload_AbandonedUploadsPruned(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "POST", "/mytopic/upload", "", map[string]string{
+		"Upload-Length": "1000",
+	})
+	require.Equal(t, 201, response.Code)
+	location := strings.TrimPrefix(response.Header().Get("Location"), s.config.BaseURL)
+	uploadID := strings.TrimPrefix(location, "/mytopic/upload/")
+	response = request(t, s, "PATCH", location, util.RandomString(500), map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	})
+	require.Equal(t, 204, response.Code)
+	require.FileExists(t, filepath.Join(s.config.AttachmentCacheDir, uploadID))
+
+	// Not expired yet
+	s.execManager()
+	require.FileExists(t, filepath.Join(s.config.AttachmentCacheDir, uploadID))
+
+	// Expired
+	require.Nil(t, s.messageCache.UpdateUploadReceived(uploadID, 500, time.Now().Add(-time.Minute).Unix()))
+	s.execManager()
+	require.NoFileExists(t, filepath.Join(s.config.AttachmentCacheDir, uploadID))
+	_, err := s.messageCache.Upload(uploadID)
+	require.Equal(t, errUploadNotFound, err)
+	require.Equal(t, int64(0), s.fileCache.Size())
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+// Inbound webhook signatures:
+//
+// Webhook senders like GitHub, Gitea or Stripe cannot send ntfy credentials, but they sign the request body with a
+// shared secret. Topic owners can attach such a secret and the sender's signature scheme to a topic (see user.Webhook).
+// If an anonymous publish request to that topic carries the scheme's signature header, authorizeTopic reads the body,
+// verifies the signature, and authorizes the request as the topic owner. The body is then passed on to the next
+// handler unchanged.
+//
+// Only the body is signed, so a webhook request may only set the message, title, tags and priority (and use a
+// template to render them); all other publish parameters (e.g. X-Call or X-Email) are rejected, since anyone who
+// captured a delivery could add them.
+// Most schemes carry no timestamp, so each signature is only accepted once (see webhookDeliveryExpiry).
+
+const (
+	webhookSecretLength       = 32
+	webhookSecretMinLength    = 16
+	webhookDefaultHeader      = "X-Signature"
+	webhookBodyLimit          = 1024 * 1024
+	webhookStripeMaxClockSkew = 5 * time.Minute
+	webhookDeliveryExpiry     = 30 * 24 * time.Hour // How long a signature is remembered to reject replays
+)
+
+var (
+	webhookHeaderRegex   = regexp.MustCompile(`^[-A-Za-z0-9]{1,64}$`)
+	webhookAllowedFields = []string{"title", "tags", "priority", "template"} // See signedURLFields; the message can always be set
+)
+
+func (s *Server) handleAccountWebhookAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u := v.User()
+	req, err := readJSONWithLimit[apiAccountWebhookRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	if !topicRegex.MatchString(req.Topic) {
+		return errHTTPBadRequestTopicInvalid
+	}
+	webhook := &user.Webhook{
+		UserID: u.ID,
+		Topic:  req.Topic,
+		Scheme: user.WebhookScheme(req.Scheme),
+		Secret: req.Secret,
+	}
+	if webhook.Scheme == user.WebhookSchemeHMAC {
+		webhook.Header = req.Header
+		if webhook.Header == "" {
+			webhook.Header = webhookDefaultHeader
+		}
+	}
+	if webhook.Secret == "" {
+		webhook.Secret = util.RandomString(webhookSecretLength)
+	}
+	if !user.AllowedWebhookScheme(webhook.Scheme) || (webhook.Header != "" && !webhookHeaderRegex.MatchString(webhook.Header)) || len(webhook.Secret) < webhookSecretMinLength {
+		return errHTTPBadRequestWebhookInvalid
+	}
+	if !u.IsAdmin() {
+		owner, err := s.userManager.HasReservation(u.Name, req.Topic)
+		if err != nil {
+			return err
+		} else if !owner {
+			return errHTTPForbiddenWebhookNotOwner
+		}
+	}
+	logvr(v, r).
+		Tag(tagAccount).
+		Fields(log.Context{
+			"topic":          webhook.Topic,
+			"webhook_scheme": webhook.Scheme,
+		}).
+		Debug("Adding webhook to topic %s", webhook.Topic)
+	if err := s.userManager.AddWebhook(webhook); err != nil {
+		return err
+	}
+	return s.writeJSON(w, &apiAccountWebhookResponse{
+		Topic:  webhook.Topic,
+		Scheme: string(webhook.Scheme),
+		Header: webhook.Header,
+		Secret: webhook.Secret,
+	})
+}
+
+func (s *Server) handleAccountWebhookDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	matches := apiAccountWebhookSingleRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	topic := matches[1]
+	u := v.User()
+	logvr(v, r).Tag(tagAccount).Field("topic", topic).Debug("Removing webhook from topic %s", topic)
+	if err := s.userManager.RemoveWebhook(u.ID, topic); err == user.ErrWebhookNotFound {
+		return errHTTPNotFound
+	} else if err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// authorizeWebhook checks if the publish request is a signed webhook for a topic with an attached webhook secret.
+// If it is, and the signature is valid, it returns the visitor of the topic owner. If the request is not a signed
+// webhook, it returns nil and no error.
+func (s *Server) authorizeWebhook(r *http.Request, v *visitor) (*visitor, error) {
+	topics, _, err := s.topicsFromPath(r.URL.Path)
+	if err != nil || len(topics) != 1 {
+		return nil, nil
+	}
+	webhook, err := s.userManager.Webhook(topics[0].ID)
+	if err == user.ErrWebhookNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	signature := r.Header.Get(webhookSignatureHeader(webhook))
+	if signature == "" {
+		return nil, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, webhookBodyLimit+1))
+	if err != nil {
+		return nil, err
+	} else if len(body) > webhookBodyLimit {
+		return nil, errHTTPEntityTooLargeWebhook
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	mac, ok := verifyWebhookSignature(webhook, signature, body, time.Now())
+	if !ok {
+		logvr(v, r).With(topics[0]).Field("webhook_scheme", webhook.Scheme).Debug("Invalid webhook signature for topic %s", webhook.Topic)
+		return nil, errHTTPUnauthorizedWebhook
+	}
+	if name := signedURLForbiddenParam(r, webhookAllowedFields); name != "" {
+		return nil, errHTTPForbiddenWebhookParam.Wrap("parameter %s", name)
+	}
+	owner, err := s.userManager.UserByID(webhook.UserID)
+	if err != nil {
+		return nil, err
+	} else if err := s.userManager.Authorize(owner, webhook.Topic, user.PermissionWrite); err != nil {
+		return nil, errHTTPForbidden.With(topics[0])
+	}
+	deliveryID := fmt.Sprintf("%s:%s:%s", webhook.Topic, webhook.Scheme, hex.EncodeToString(mac))
+	if first, err := s.messageCache.AddWebhookDelivery(deliveryID, time.Now().Add(webhookDeliveryExpiry).Unix()); err != nil {
+		return nil, err
+	} else if !first {
+		logvr(v, r).With(topics[0]).Field("webhook_scheme", webhook.Scheme).Debug("Webhook for topic %s was already delivered", webhook.Topic)
+		return nil, errHTTPConflictWebhookReplayed
+	}
+	logvr(v, r).With(topics[0]).Field("webhook_scheme", webhook.Scheme).Debug("Access to topic %s authorized by webhook signature, publishing as %s", webhook.Topic, owner.Name)
+	return s.visitor(v.IP(), owner), nil
+}
+
+func webhookSignatureHeader(webhook *user.Webhook) string {
+	switch webhook.Scheme {
+	case user.WebhookSchemeGitHub:
+		return "X-Hub-Signature-256"
+	case user.WebhookSchemeGitea:
+		return "X-Gitea-Signature"
+	case user.WebhookSchemeStripe:
+		return "Stripe-Signature"
+	default:
+		return webhook.Header
+	}
+}
+
+// verifyWebhookSignature verifies the signature header of a webhook request, and returns the expected signature.
+// Unlike the header, which may be formatted in different ways, it identifies the delivery.
+func verifyWebhookSignature(webhook *user.Webhook, signature string, body []byte, now time.Time) ([]byte, bool) {
+	switch webhook.Scheme {
+	case user.WebhookSchemeGitHub:
+		expected := webhookSignature(webhook.Secret, body)
+		hexSignature, ok := strings.CutPrefix(signature, "sha256=")
+		return expected, ok && webhookSignatureEqual(hexSignature, expected)
+	case user.WebhookSchemeGitea:
+		expected := webhookSignature(webhook.Secret, body)
+		return expected, webhookSignatureEqual(signature, expected)
+	case user.WebhookSchemeStripe:
+		return verifyStripeWebhookSignature(webhook.Secret, signature, body, now)
+	case user.WebhookSchemeHMAC:
+		expected := webhookSignature(webhook.Secret, body)
+		return expected, webhookSignatureEqual(strings.TrimPrefix(signature, "sha256="), expected)
+	}
+	return nil, false
+}
+
+// verifyStripeWebhookSignature verifies a Stripe-style signature header ("t=<timestamp>,v1=<signature>[,v1=..]"),
+// where the signature is computed over "<timestamp>.<body>"
+func verifyStripeWebhookSignature(secret, header string, body []byte, now time.Time) ([]byte, bool) {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if key == "t" {
+			timestamp = value
+		} else if key == "v1" {
+			signatures = append(signatures, value)
+		}
+	}
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, false
+	} else if skew := now.Sub(time.Unix(t, 0)); skew > webhookStripeMaxClockSkew || skew < -webhookStripeMaxClockSkew {
+		return nil, false
+	}
+	expected := webhookSignature(secret, append([]byte(timestamp+"."), body...))
+	for _, signature := range signatures {
+		if webhookSignatureEqual(signature, expected) {
+			return expected, true
+		}
+	}
+	return nil, false
+}
+
+func webhookSignature(secret string, data []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func webhookSignatureEqual(hexSignature string, expected []byte) bool {
+	signature, err := hex.DecodeString(strings.TrimSpace(hexSignature))
+	return err == nil && hmac.Equal(signature, expected)
+}
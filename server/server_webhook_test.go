@@ -0,0 +1,186 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+const testWebhookSecret = "s3cr3t-s3cr3t-s3cr3t"
+
+func TestServer_Webhook_GitHub(t *testing.T) {
+	s := newTestServerWithReservation(t, newTestConfigWithAuthFile(t), "builds")
+	addTestWebhook(t, s, `{"topic":"builds","scheme":"github","secret":"`+testWebhookSecret+`"}`)
+
+	body := `{"action":"completed","repository":{"full_name":"binwiederhier/ntfy"}}`
+	response := request(t, s, "POST", "/builds", body, map[string]string{
+		"X-Hub-Signature-256": "sha256=" + hex.EncodeToString(webhookSignature(testWebhookSecret, []byte(body))),
+		"X-Title":             "Build finished",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, body, m.Message)
+	require.Equal(t, "Build finished", m.Title)
+
+	// Published as the topic owner
+	phil, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	cached, err := s.messageCache.Message(m.ID)
+	require.Nil(t, err)
+	require.Equal(t, phil.ID, cached.User)
+
+	// Wrong signature, missing prefix, no signature
+	for _, headers := range []map[string]string{
+		{"X-Hub-Signature-256": "sha256=" + hex.EncodeToString(webhookSignature("wrong", []byte(body)))},
+		{"X-Hub-Signature-256": hex.EncodeToString(webhookSignature(testWebhookSecret, []byte(body)))},
+		{"X-Hub-Signature-256": "sha256=not-hex"},
+	} {
+		response = request(t, s, "POST", "/builds", body, headers)
+		require.Equal(t, 401, response.Code)
+		require.Equal(t, 40104, toHTTPError(t, response.Body.String()).Code)
+	}
+	response = request(t, s, "POST", "/builds", body, nil)
+	require.Equal(t, 403, response.Code)
+	require.Equal(t, 40301, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_Webhook_GiteaStripeHMAC(t *testing.T) {
+	s := newTestServerWithReservation(t, newTestConfigWithAuthFile(t), "builds")
+	body := `{"event":"something"}`
+	signature := hex.EncodeToString(webhookSignature(testWebhookSecret, []byte(body)))
+
+	addTestWebhook(t, s, `{"topic":"builds","scheme":"gitea","secret":"`+testWebhookSecret+`"}`)
+	response := request(t, s, "POST", "/builds", body, map[string]string{
+		"X-Gitea-Signature": signature,
+	})
+	require.Equal(t, 200, response.Code)
+
+	addTestWebhook(t, s, `{"topic":"builds","scheme":"hmac","header":"X-Webhook-Signature","secret":"`+testWebhookSecret+`"}`)
+	response = request(t, s, "POST", "/builds", body, map[string]string{
+		"X-Webhook-Signature": signature,
+	})
+	require.Equal(t, 200, response.Code)
+
+	addTestWebhook(t, s, `{"topic":"builds","scheme":"stripe","secret":"`+testWebhookSecret+`"}`)
+	timestamp := fmt.Sprintf("%d", time.Now().Unix())
+	stripeSignature := hex.EncodeToString(webhookSignature(testWebhookSecret, []byte(timestamp+"."+body)))
+	response = request(t, s, "POST", "/builds", body, map[string]string{
+		"Stripe-Signature": "t=" + timestamp + ",v1=" + stripeSignature + ",v0=abc",
+	})
+	require.Equal(t, 200, response.Code)
+
+	oldTimestamp := fmt.Sprintf("%d", time.Now().Add(-time.Hour).Unix())
+	oldSignature := hex.EncodeToString(webhookSignature(testWebhookSecret, []byte(oldTimestamp+"."+body)))
+	response = request(t, s, "POST", "/builds", body, map[string]string{
+		"Stripe-Signature": "t=" + oldTimestamp + ",v1=" + oldSignature,
+	})
+	require.Equal(t, 401, response.Code)
+
+	messages, err := s.messageCache.Messages("builds", sinceAllMessages, false)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(messages))
+}
+
+func TestServer_Webhook_ForbiddenParamsAndReplay(t *testing.T) {
+	s := newTestServerWithReservation(t, newTestConfigWithAuthFile(t), "builds")
+	addTestWebhook(t, s, `{"topic":"builds","scheme":"github","secret":"`+testWebhookSecret+`"}`)
+	body := `{"action":"completed"}`
+	signature := "sha256=" + hex.EncodeToString(webhookSignature(testWebhookSecret, []byte(body)))
+
+	// Only the body is signed, so other publish params cannot be added to a captured request
+	for _, headers := range []map[string]string{
+		{"X-Hub-Signature-256": signature, "X-Email": "phil@example.com"},
+		{"X-Hub-Signature-256": signature, "X-Call": "+12223334444"},
+		{"X-Hub-Signature-256": signature, "X-Attach": "https://example.com/file.jpg"},
+	} {
+		response := request(t, s, "POST", "/builds", body, headers)
+		require.Equal(t, 403, response.Code)
+		require.Equal(t, 40308, toHTTPError(t, response.Body.String()).Code)
+	}
+	response := request(t, s, "POST", "/builds?delay=1h", body, map[string]string{
+		"X-Hub-Signature-256": signature,
+	})
+	require.Equal(t, 403, response.Code)
+	require.Equal(t, 40308, toHTTPError(t, response.Body.String()).Code)
+
+	// Title, tags and priority are allowed
+	response = request(t, s, "POST", "/builds", body, map[string]string{
+		"X-Hub-Signature-256": signature,
+		"X-Title":             "Build",
+		"X-Tags":              "white_check_mark",
+		"X-Priority":          "4",
+		"X-Template":          "yes",
+	})
+	require.Equal(t, 200, response.Code)
+
+	// Replayed delivery is rejected
+	response = request(t, s, "POST", "/builds", body, map[string]string{
+		"X-Hub-Signature-256": signature,
+	})
+	require.Equal(t, 409, response.Code)
+	require.Equal(t, 40908, toHTTPError(t, response.Body.String()).Code)
+
+	messages, err := s.messageCache.Messages("builds", sinceAllMessages, false)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+}
+
+func TestServer_Webhook_AddRemove(t *testing.T) {
+	s := newTestServerWithReservation(t, newTestConfigWithAuthFile(t), "builds")
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser, false))
+	phil := map[string]string{"Authorization": util.BasicAuth("phil", "phil")}
+	ben := map[string]string{"Authorization": util.BasicAuth("ben", "ben")}
+
+	// Generated secret
+	response := request(t, s, "POST", "/v1/account/webhook", `{"topic":"builds","scheme":"hmac"}`, phil)
+	require.Equal(t, 200, response.Code)
+	var webhook apiAccountWebhookResponse
+	require.Nil(t, json.NewDecoder(strings.NewReader(response.Body.String())).Decode(&webhook))
+	require.Equal(t, "X-Signature", webhook.Header)
+	require.Equal(t, 32, len(webhook.Secret))
+
+	// Listed in account, without secret
+	response = request(t, s, "GET", "/v1/account", "", phil)
+	require.Equal(t, 200, response.Code)
+	account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(response.Body))
+	require.Equal(t, 1, len(account.Webhooks))
+	require.Equal(t, "builds", account.Webhooks[0].Topic)
+	require.Equal(t, "", account.Webhooks[0].Secret)
+
+	// Not the owner, invalid requests
+	response = request(t, s, "POST", "/v1/account/webhook", `{"topic":"builds","scheme":"github"}`, ben)
+	require.Equal(t, 403, response.Code)
+	require.Equal(t, 40305, toHTTPError(t, response.Body.String()).Code)
+	for _, body := range []string{
+		`{"topic":"builds","scheme":"unknown"}`,
+		`{"topic":"builds","scheme":"github","secret":"short"}`,
+		`{"topic":"builds","scheme":"hmac","header":"X Invalid"}`,
+	} {
+		response = request(t, s, "POST", "/v1/account/webhook", body, phil)
+		require.Equal(t, 400, response.Code, body)
+		require.Equal(t, 40059, toHTTPError(t, response.Body.String()).Code)
+	}
+
+	// Remove
+	response = request(t, s, "DELETE", "/v1/account/webhook/builds", "", ben)
+	require.Equal(t, 404, response.Code)
+	response = request(t, s, "DELETE", "/v1/account/webhook/builds", "", phil)
+	require.Equal(t, 200, response.Code)
+	_, err := s.userManager.Webhook("builds")
+	require.Equal(t, user.ErrWebhookNotFound, err)
+}
+
+func addTestWebhook(t *testing.T, s *Server, body string) {
+	response := request(t, s, "POST", "/v1/account/webhook", body, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+}
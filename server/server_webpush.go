@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/SherClockHolmes/webpush-go"
 	"heckel.io/ntfy/v2/log"
@@ -83,18 +84,29 @@ func (s *Server) publishToWebPushEndpoints(v *visitor, m *message) {
 		return
 	}
 	log.Tag(tagWebPush).With(v, m).Debug("Publishing web push message to %d subscribers", len(subscriptions))
-	payload, err := json.Marshal(newWebPushPayload(fmt.Sprintf("%s/%s", s.config.BaseURL, m.Topic), m))
+	payload, err := json.Marshal(newWebPushPayload(fmt.Sprintf("%s/%s", s.config.BaseURL, m.Topic), s.withSignedAttachmentURLs(m)))
 	if err != nil {
 		log.Tag(tagWebPush).Err(err).With(v, m).Warn("Unable to marshal expiring payload")
 		return
 	}
 	for _, subscription := range subscriptions {
-		if err := s.sendWebPushNotification(subscription, payload, v, m); err != nil {
+		if err := s.sendWebPushNotification(subscription, payload, webPushTTL(m, s.config.CacheDuration), v, m); err != nil {
 			log.Tag(tagWebPush).Err(err).With(v, m, subscription).Warn("Unable to publish web push message")
 		}
 	}
 }
 
+// webPushTTL returns how long the push service should try to deliver the message, which is until the message
+// expires, but at most the given default TTL
+func webPushTTL(m *message, defaultTTL time.Duration) time.Duration {
+	if m.Expires == 0 {
+		return defaultTTL
+	} else if ttl := time.Until(time.Unix(m.Expires, 0)); ttl < defaultTTL {
+		return max(ttl, time.Second)
+	}
+	return defaultTTL
+}
+
 func (s *Server) pruneAndNotifyWebPushSubscriptions() {
 	if s.config.WebPushPublicKey == "" {
 		return
@@ -124,7 +136,7 @@ func (s *Server) pruneAndNotifyWebPushSubscriptionsInternal() error {
 	}
 	warningSent := make([]*webPushSubscription, 0)
 	for _, subscription := range subscriptions {
-		if err := s.sendWebPushNotification(subscription, payload); err != nil {
+		if err := s.sendWebPushNotification(subscription, payload, s.config.CacheDuration); err != nil {
 			log.Tag(tagWebPush).Err(err).With(subscription).Warn("Unable to publish expiry imminent warning")
 			continue
 		}
@@ -137,7 +149,7 @@ func (s *Server) pruneAndNotifyWebPushSubscriptionsInternal() error {
 	return nil
 }
 
-func (s *Server) sendWebPushNotification(sub *webPushSubscription, message []byte, contexters ...log.Contexter) error {
+func (s *Server) sendWebPushNotification(sub *webPushSubscription, message []byte, ttl time.Duration, contexters ...log.Contexter) error {
 	log.Tag(tagWebPush).With(sub).With(contexters...).Debug("Sending web push message")
 	payload := &webpush.Subscription{
 		Endpoint: sub.Endpoint,
@@ -151,7 +163,7 @@ func (s *Server) sendWebPushNotification(sub *webPushSubscription, message []byt
 		VAPIDPublicKey:  s.config.WebPushPublicKey,
 		VAPIDPrivateKey: s.config.WebPushPrivateKey,
 		Urgency:         webpush.UrgencyHigh, // iOS requires this to ensure delivery
-		TTL:             int(s.config.CacheDuration.Seconds()),
+		TTL:             int(ttl.Seconds()),
 	})
 	if err != nil {
 		log.Tag(tagWebPush).With(sub).With(contexters...).Err(err).Debug("Unable to publish web push message, removing endpoint")
@@ -4,9 +4,11 @@ import (
 	_ "embed" // required by go:embed
 	"encoding/json"
 	"fmt"
+	"maps"
 	"mime"
 	"net"
 	"net/smtp"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -107,6 +109,16 @@ func formatMail(baseURL, senderIP, from, to string, m *message) (string, error)
 		}
 		trailer += fmt.Sprintf("Priority: %s", priority)
 	}
+	if len(m.Labels) > 0 {
+		labels := make([]string, 0)
+		for _, key := range slices.Sorted(maps.Keys(m.Labels)) {
+			labels = append(labels, fmt.Sprintf("%s=%s", key, m.Labels[key]))
+		}
+		if trailer != "" {
+			trailer += "\n"
+		}
+		trailer += "Labels: " + strings.Join(labels, ", ")
+	}
 	if trailer != "" {
 		message += "\n\n" + trailer
 	}
@@ -145,3 +145,29 @@ Priority: max
 This message was sent by 1.2.3.4 at Fri, 24 Dec 2021 21:43:24 UTC via https://ntfy.sh/alerts`
 	require.Equal(t, expected, actual)
 }
+
+func TestFormatMail_WithLabels(t *testing.T) {
+	actual, _ := formatMail("https://ntfy.sh", "1.2.3.4", "ntfy@ntfy.sh", "phil@example.com", &message{
+		ID:       "abc",
+		Time:     1640382204,
+		Event:    "message",
+		Topic:    "alerts",
+		Message:  "Disk full",
+		Priority: 4,
+		Labels:   map[string]string{"host": "db1", "env": "prod"},
+	})
+	expected := `From: "ntfy.sh/alerts" <ntfy@ntfy.sh>
+To: phil@example.com
+Date: Fri, 24 Dec 2021 21:43:24 +0000
+Subject: Disk full
+Content-Type: text/plain; charset="utf-8"
+
+Disk full
+
+Priority: high
+Labels: env=prod, host=db1
+
+--
+This message was sent by 1.2.3.4 at Fri, 24 Dec 2021 21:43:24 UTC via https://ntfy.sh/alerts`
+	require.Equal(t, expected, actual)
+}
@@ -0,0 +1,451 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/sync/semaphore"
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/util"
+)
+
+// Syslog receiver:
+//
+// If syslog-listen is set, ntfy accepts syslog messages (RFC 5424 and RFC 3164) via UDP and TCP, e.g. from network
+// gear. Each message is matched against the syslog rules (see SyslogRule), and published to the topic of the first
+// matching rule. Messages that do not match any rule are dropped. Like e-mails received via SMTP, messages are
+// published via a fake HTTP request, so that the sending host is rate limited like any other visitor. Since the
+// source address of UDP packets can be spoofed, and rules may publish with an access token, messages are only
+// accepted from the networks in syslog-allowed-sources (by default loopback and private networks).
+
+const (
+	syslogMaxMessageSize      = 64 * 1024 // Max. UDP datagram size, and max. TCP frame size
+	syslogTCPReadTimeout      = 5 * time.Minute
+	syslogTCPConnectionsLimit = 100 // Max. number of concurrent TCP connections, each of which has its own read buffer
+	syslogNilValue            = "-"
+	syslogTag                 = "syslog"
+)
+
+var (
+	errSyslogMessageInvalid = errors.New("invalid syslog message")
+	errSyslogFrameTooLarge  = errors.New("syslog frame too large")
+)
+
+var (
+	syslogRuleRegex          = regexp.MustCompile(`^(.*?)\s*->\s*([-_A-Za-z0-9]{1,64})(?:\+(tk_[-_A-Za-z0-9]+))?$`)
+	syslogRFC3164TimeRegex   = regexp.MustCompile(`^[A-Z][a-z]{2} [ 0-9]\d \d{2}:\d{2}:\d{2} `)
+	syslogRFC3164AppTagRegex = regexp.MustCompile(`^([^\s:\[]+)(?:\[[^\]]*\])?:\s?`)
+)
+
+// syslogSeverities are the syslog severity names, indexed by their numeric value
+var syslogSeverities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// syslogFacilities are the syslog facility names, indexed by their numeric value
+var syslogFacilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news", "uucp", "cron", "authpriv", "ftp", "ntp",
+	"security", "console", "solaris-cron", "local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// syslogPriorities maps syslog severities to ntfy priorities
+var syslogPriorities = []int{5, 5, 4, 4, 3, 2, 2, 1}
+
+// SyslogRule routes syslog messages to a topic. All conditions that are set must match. The rule format is
+// "[facility=<name>[,<name>..]] [severity=<name>] [host=<glob>] [match=<regex>] -> <topic>[+<token>]", e.g.
+// "facility=daemon severity=warning host=switch-* match=link (up|down) -> network". The severity condition
+// matches messages with the given severity or higher, and the match condition must be the last one, since the
+// regular expression may contain spaces.
+type SyslogRule struct {
+	Facilities []int          // Facilities to match, or all if empty
+	Severity   int            // Lowest severity to match, e.g. 4 (warning) matches 0-4
+	Host       string         // Glob pattern for the hostname, or empty to match all hosts
+	Pattern    *regexp.Regexp // Regular expression for the message, or nil to match all messages
+	Topic      string         // Topic to publish to
+	Token      string         // Access token used to publish, may be empty
+}
+
+// ParseSyslogRule parses a syslog rule, see SyslogRule for the format
+func ParseSyslogRule(s string) (*SyslogRule, error) {
+	matches := syslogRuleRegex.FindStringSubmatch(strings.TrimSpace(s))
+	if matches == nil {
+		return nil, fmt.Errorf(`invalid syslog rule "%s", must be "[conditions] -> topic", e.g. "severity=err -> alerts"`, s)
+	}
+	rule := &SyslogRule{
+		Severity: len(syslogSeverities) - 1,
+		Topic:    matches[2],
+		Token:    matches[3],
+	}
+	conditions := matches[1]
+	for conditions != "" {
+		var condition string
+		if strings.HasPrefix(conditions, "match=") {
+			condition, conditions = conditions, "" // The regex is always the rest of the conditions
+		} else {
+			condition, conditions, _ = strings.Cut(conditions, " ")
+			conditions = strings.TrimSpace(conditions)
+		}
+		key, value, ok := strings.Cut(condition, "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf(`invalid syslog rule "%s": invalid condition "%s"`, s, condition)
+		}
+		switch key {
+		case "facility":
+			for _, name := range strings.Split(value, ",") {
+				facility := syslogIndex(syslogFacilities, name)
+				if facility == -1 {
+					return nil, fmt.Errorf(`invalid syslog rule "%s": unknown facility "%s"`, s, name)
+				}
+				rule.Facilities = append(rule.Facilities, facility)
+			}
+		case "severity":
+			rule.Severity = syslogIndex(syslogSeverities, value)
+			if rule.Severity == -1 {
+				return nil, fmt.Errorf(`invalid syslog rule "%s": unknown severity "%s"`, s, value)
+			}
+		case "host":
+			if _, err := path.Match(value, ""); err != nil {
+				return nil, fmt.Errorf(`invalid syslog rule "%s": invalid host pattern "%s"`, s, value)
+			}
+			rule.Host = value
+		case "match":
+			pattern, err := regexp.Compile(value)
+			if err != nil {
+				return nil, fmt.Errorf(`invalid syslog rule "%s": invalid regular expression: %s`, s, err.Error())
+			}
+			rule.Pattern = pattern
+		default:
+			return nil, fmt.Errorf(`invalid syslog rule "%s": unknown condition "%s"`, s, key)
+		}
+	}
+	return rule, nil
+}
+
+// Matches returns true if the syslog message matches all conditions of the rule
+func (r *SyslogRule) Matches(m *syslogMessage) bool {
+	if len(r.Facilities) > 0 && syslogIndexInt(r.Facilities, m.Facility) == -1 {
+		return false
+	} else if m.Severity > r.Severity {
+		return false
+	} else if r.Host != "" {
+		if matched, _ := path.Match(strings.ToLower(r.Host), strings.ToLower(m.Hostname)); !matched {
+			return false
+		}
+	}
+	return r.Pattern == nil || r.Pattern.MatchString(m.Message)
+}
+
+// syslogMessage is a parsed syslog message
+type syslogMessage struct {
+	Facility int
+	Severity int
+	Hostname string
+	AppName  string
+	Message  string
+}
+
+// parseSyslogMessage parses a syslog message in RFC 5424 or RFC 3164 format. RFC 3164 is really just a description
+// of common practice, so parsing is lenient: if the hostname is missing, the address of the sender is used.
+func parseSyslogMessage(line string, remoteHost string) (*syslogMessage, error) {
+	line = strings.TrimRight(line, "\r\n\x00")
+	if !strings.HasPrefix(line, "<") {
+		return nil, errSyslogMessageInvalid
+	}
+	end := strings.Index(line, ">")
+	if end < 2 || end > 4 {
+		return nil, errSyslogMessageInvalid
+	}
+	pri, err := strconv.Atoi(line[1:end])
+	if err != nil || pri < 0 || pri > 191 {
+		return nil, errSyslogMessageInvalid
+	}
+	m := &syslogMessage{
+		Facility: pri / 8,
+		Severity: pri % 8,
+		Hostname: remoteHost,
+	}
+	rest := line[end+1:]
+	if strings.HasPrefix(rest, "1 ") {
+		return parseSyslogRFC5424(m, rest[2:])
+	}
+	return parseSyslogRFC3164(m, rest), nil
+}
+
+// parseSyslogRFC5424 parses the part after "<PRI>1 ", i.e. "TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD [MSG]"
+func parseSyslogRFC5424(m *syslogMessage, rest string) (*syslogMessage, error) {
+	fields := strings.SplitN(rest, " ", 6)
+	if len(fields) < 6 {
+		return nil, errSyslogMessageInvalid
+	}
+	if fields[1] != syslogNilValue {
+		m.Hostname = fields[1]
+	}
+	if fields[2] != syslogNilValue {
+		m.AppName = fields[2]
+	}
+	message, err := skipSyslogStructuredData(fields[5])
+	if err != nil {
+		return nil, err
+	}
+	m.Message = strings.TrimPrefix(strings.TrimSpace(message), "\ufeff") // Strip UTF-8 BOM
+	return m, nil
+}
+
+// skipSyslogStructuredData skips the RFC 5424 structured data, e.g. `[id key="value"][id2 key="v\]"] msg`,
+// and returns the remaining message
+func skipSyslogStructuredData(s string) (string, error) {
+	if strings.HasPrefix(s, syslogNilValue) {
+		return s[1:], nil
+	}
+	inElement, inValue := false, false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case inValue && c == '\\':
+			i++ // Skip escaped character
+		case inValue && c == '"':
+			inValue = false
+		case inElement && c == '"':
+			inValue = true
+		case inElement && c == ']':
+			inElement = false
+		case !inElement && c == '[':
+			inElement = true
+		case !inElement:
+			return s[i:], nil
+		}
+	}
+	if inElement {
+		return "", errSyslogMessageInvalid
+	}
+	return "", nil
+}
+
+// parseSyslogRFC3164 parses the part after "<PRI>", i.e. "Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG"
+func parseSyslogRFC3164(m *syslogMessage, rest string) *syslogMessage {
+	if syslogRFC3164TimeRegex.MatchString(rest) {
+		rest = rest[16:]
+		if hostname, message, ok := strings.Cut(rest, " "); ok && !strings.HasSuffix(hostname, ":") {
+			m.Hostname, rest = hostname, message
+		}
+	}
+	if matches := syslogRFC3164AppTagRegex.FindStringSubmatch(rest); matches != nil {
+		m.AppName, rest = matches[1], rest[len(matches[0]):]
+	}
+	m.Message = strings.TrimSpace(rest)
+	return m
+}
+
+func (s *Server) runSyslogServer() error {
+	udpConn, err := net.ListenPacket("udp", s.config.SyslogListen)
+	if err != nil {
+		return err
+	}
+	tcpListener, err := net.Listen("tcp", s.config.SyslogListen)
+	if err != nil {
+		udpConn.Close()
+		return err
+	}
+	s.mu.Lock()
+	s.syslogUDPConn, s.syslogTCPListener = udpConn, tcpListener
+	s.mu.Unlock()
+	errChan := make(chan error, 2)
+	go func() {
+		errChan <- s.serveSyslogUDP(udpConn)
+	}()
+	go func() {
+		errChan <- s.serveSyslogTCP(tcpListener)
+	}()
+	return <-errChan
+}
+
+func (s *Server) serveSyslogUDP(conn net.PacketConn) error {
+	buf := make([]byte, syslogMaxMessageSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		} else if !s.syslogSourceAllowed(addr) {
+			logsl(addr.String()).Trace("Dropping syslog message, source address not allowed")
+			continue
+		}
+		s.handleSyslogMessage(string(buf[:n]), addr.String())
+	}
+}
+
+func (s *Server) serveSyslogTCP(listener net.Listener) error {
+	connections := semaphore.NewWeighted(syslogTCPConnectionsLimit)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		} else if !s.syslogSourceAllowed(conn.RemoteAddr()) {
+			logsl(conn.RemoteAddr().String()).Debug("Closing syslog connection, source address not allowed")
+			conn.Close()
+			continue
+		} else if !connections.TryAcquire(1) {
+			logsl(conn.RemoteAddr().String()).Debug("Closing syslog connection, too many connections")
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer connections.Release(1)
+			s.handleSyslogConn(conn)
+		}()
+	}
+}
+
+// syslogSourceAllowed returns true if the address is in one of the networks syslog messages are accepted from
+func (s *Server) syslogSourceAllowed(addr net.Addr) bool {
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+	return util.ContainsIP(s.config.SyslogAllowedPrefixes, addrPort.Addr().Unmap())
+}
+
+// handleSyslogConn reads syslog messages from a TCP connection. Both octet-counting ("<length> <message>") and
+// newline-delimited framing are supported, see RFC 6587.
+func (s *Server) handleSyslogConn(conn net.Conn) {
+	defer conn.Close()
+	remoteAddr := conn.RemoteAddr().String()
+	reader := bufio.NewReaderSize(conn, syslogMaxMessageSize)
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(syslogTCPReadTimeout)); err != nil {
+			return
+		}
+		line, err := readSyslogFrame(reader)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				logsl(remoteAddr).Err(err).Debug("Syslog connection closed with error")
+			}
+			return
+		}
+		s.handleSyslogMessage(line, remoteAddr)
+	}
+}
+
+func readSyslogFrame(reader *bufio.Reader) (string, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return "", err
+	}
+	if first[0] >= '0' && first[0] <= '9' {
+		lengthStr, err := reader.ReadString(' ')
+		if err != nil {
+			return "", err
+		}
+		length, err := strconv.Atoi(strings.TrimSpace(lengthStr))
+		if err != nil || length < 0 {
+			return "", errSyslogMessageInvalid
+		} else if length > syslogMaxMessageSize {
+			return "", errSyslogFrameTooLarge
+		}
+		frame := make([]byte, length)
+		if _, err := io.ReadFull(reader, frame); err != nil {
+			return "", err
+		}
+		return string(frame), nil
+	}
+	line, err := reader.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", errSyslogFrameTooLarge
+	} else if err != nil && (!errors.Is(err, io.EOF) || len(line) == 0) {
+		return "", err
+	}
+	return string(line), nil
+}
+
+// handleSyslogMessage parses a syslog message, and publishes it to the topic of the first matching rule
+func (s *Server) handleSyslogMessage(line, remoteAddr string) {
+	remoteHost, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		remoteHost = remoteAddr
+	}
+	ev := logsl(remoteAddr)
+	if !utf8.ValidString(line) {
+		ev.Debug("Ignoring syslog message, not valid UTF-8")
+		return
+	}
+	m, err := parseSyslogMessage(line, remoteHost)
+	if err != nil {
+		ev.Err(err).Debug("Ignoring invalid syslog message")
+		return
+	}
+	for _, rule := range s.config.SyslogRules {
+		if rule.Matches(m) {
+			if err := s.publishSyslogMessage(m, rule, remoteAddr); err != nil {
+				ev.Err(err).Debug("Publishing syslog message to topic %s failed", rule.Topic)
+				minc(metricSyslogMessagesReceivedFailure)
+				return
+			}
+			minc(metricSyslogMessagesReceivedSuccess)
+			return
+		}
+	}
+	ev.Trace("Dropping syslog message, no matching rule")
+}
+
+func (s *Server) publishSyslogMessage(m *syslogMessage, rule *SyslogRule, remoteAddr string) error {
+	message := m.Message
+	if message == "" {
+		message = emptyMessageBody
+	} else if len(message) > s.config.MessageSizeLimit {
+		// Cut on a rune boundary, otherwise the message is no longer valid UTF-8 and would become an attachment
+		end := s.config.MessageSizeLimit
+		for end > 0 && !utf8.RuneStart(message[end]) {
+			end--
+		}
+		message = message[:end]
+	}
+	title := m.Hostname
+	if m.AppName != "" {
+		title = fmt.Sprintf("%s: %s", m.Hostname, m.AppName)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/"+rule.Topic, strings.NewReader(message))
+	req.RemoteAddr = remoteAddr // Rate limiting by sender
+	req.Header.Set("X-Title", title)
+	req.Header.Set("X-Priority", strconv.Itoa(syslogPriorities[m.Severity]))
+	req.Header.Set("X-Tags", fmt.Sprintf("%s,%s,%s", syslogTag, syslogFacilities[m.Facility], syslogSeverities[m.Severity]))
+	if rule.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+rule.Token)
+	}
+	rr := httptest.NewRecorder()
+	s.handle(rr, req)
+	if rr.Code != http.StatusOK {
+		return errors.New(strings.TrimSpace(rr.Body.String()))
+	}
+	return nil
+}
+
+func syslogIndex(names []string, name string) int {
+	for i, n := range names {
+		if strings.EqualFold(n, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func syslogIndexInt(values []int, value int) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+// logsl creates a new log event with syslog fields
+func logsl(remoteAddr string) *log.Event {
+	return log.Tag(tagSyslog).Field("syslog_remote_addr", remoteAddr)
+}
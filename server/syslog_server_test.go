@@ -0,0 +1,263 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestSyslogServer_ParseRFC5424(t *testing.T) {
+	m, err := parseSyslogMessage(`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="App\]lication"] An application event log entry...`, "1.2.3.4")
+	require.Nil(t, err)
+	require.Equal(t, 20, m.Facility) // local4
+	require.Equal(t, 5, m.Severity)  // notice
+	require.Equal(t, "mymachine.example.com", m.Hostname)
+	require.Equal(t, "evntslog", m.AppName)
+	require.Equal(t, "An application event log entry...", m.Message)
+
+	m, err = parseSyslogMessage("<34>1 2003-10-11T22:14:15.003Z - - - - - \ufeffsu root failed\n", "1.2.3.4")
+	require.Nil(t, err)
+	require.Equal(t, 4, m.Facility)
+	require.Equal(t, 2, m.Severity)
+	require.Equal(t, "1.2.3.4", m.Hostname)
+	require.Equal(t, "", m.AppName)
+	require.Equal(t, "su root failed", m.Message)
+}
+
+func TestSyslogServer_ParseRFC3164(t *testing.T) {
+	m, err := parseSyslogMessage("<34>Oct 11 22:14:15 mymachine su[123]: 'su root' failed for lonvick on /dev/pts/8", "1.2.3.4")
+	require.Nil(t, err)
+	require.Equal(t, 4, m.Facility)
+	require.Equal(t, 2, m.Severity)
+	require.Equal(t, "mymachine", m.Hostname)
+	require.Equal(t, "su", m.AppName)
+	require.Equal(t, "'su root' failed for lonvick on /dev/pts/8", m.Message)
+
+	// No timestamp and hostname, as sent by some network devices
+	m, err = parseSyslogMessage("<3>kernel: link eth0 down", "1.2.3.4")
+	require.Nil(t, err)
+	require.Equal(t, 0, m.Facility)
+	require.Equal(t, 3, m.Severity)
+	require.Equal(t, "1.2.3.4", m.Hostname)
+	require.Equal(t, "kernel", m.AppName)
+	require.Equal(t, "link eth0 down", m.Message)
+
+	for _, line := range []string{"", "no priority", "<>msg", "<192>msg", "<1a>msg", "<12345>msg", "<13>1 incomplete"} {
+		_, err := parseSyslogMessage(line, "1.2.3.4")
+		require.Equal(t, errSyslogMessageInvalid, err, line)
+	}
+}
+
+func TestSyslogServer_ParseRule(t *testing.T) {
+	rule, err := ParseSyslogRule("facility=kern,daemon severity=warning host=switch-* match=link (up|down) -> network+tk_AbC123dEf456")
+	require.Nil(t, err)
+	require.Equal(t, []int{0, 3}, rule.Facilities)
+	require.Equal(t, 4, rule.Severity)
+	require.Equal(t, "switch-*", rule.Host)
+	require.Equal(t, "link (up|down)", rule.Pattern.String())
+	require.Equal(t, "network", rule.Topic)
+	require.Equal(t, "tk_AbC123dEf456", rule.Token)
+
+	require.True(t, rule.Matches(&syslogMessage{Facility: 3, Severity: 2, Hostname: "Switch-01", Message: "port 1 link down"}))
+	require.False(t, rule.Matches(&syslogMessage{Facility: 1, Severity: 2, Hostname: "switch-01", Message: "port 1 link down"}))
+	require.False(t, rule.Matches(&syslogMessage{Facility: 3, Severity: 6, Hostname: "switch-01", Message: "port 1 link down"}))
+	require.False(t, rule.Matches(&syslogMessage{Facility: 3, Severity: 2, Hostname: "router-01", Message: "port 1 link down"}))
+	require.False(t, rule.Matches(&syslogMessage{Facility: 3, Severity: 2, Hostname: "switch-01", Message: "fan failure"}))
+
+	rule, err = ParseSyslogRule("-> everything")
+	require.Nil(t, err)
+	require.True(t, rule.Matches(&syslogMessage{Facility: 23, Severity: 7, Hostname: "host"}))
+
+	for _, s := range []string{"", "severity=err", "severity=err -> ", "severity=bad -> alerts", "facility=kern,bad -> alerts", "color=red -> alerts", "match=( -> alerts", "host=[ -> alerts", "severity -> alerts", "-> invalid/topic"} {
+		_, err := ParseSyslogRule(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestSyslogServer_PublishUDP(t *testing.T) {
+	c := newTestConfig(t)
+	c.SyslogRules = []*SyslogRule{
+		mustParseSyslogRule(t, "facility=auth match=Failed password -> security"),
+		mustParseSyslogRule(t, "severity=err -> alerts"),
+	}
+	s := newTestServer(t, c)
+	addr := newTestSyslogListeners(t, s)
+
+	conn, err := net.Dial("udp", addr)
+	require.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("<38>Oct 11 22:14:15 server01 sshd[123]: Failed password for root from 1.2.3.4"))
+	require.Nil(t, err)
+	_, err = conn.Write([]byte("<30>Oct 11 22:14:16 server01 systemd[1]: Started Daily Cleanup")) // Dropped, no matching rule
+	require.Nil(t, err)
+	_, err = conn.Write([]byte("<11>1 2003-10-11T22:14:15.003Z server02 backup - - - Backup failed"))
+	require.Nil(t, err)
+
+	waitFor(t, func() bool {
+		return len(pollSyslogMessages(t, s, "security")) == 1 && len(pollSyslogMessages(t, s, "alerts")) == 1
+	})
+	m := pollSyslogMessages(t, s, "security")[0]
+	require.Equal(t, "server01: sshd", m.Title)
+	require.Equal(t, "Failed password for root from 1.2.3.4", m.Message)
+	require.Equal(t, 2, m.Priority)
+	require.Equal(t, []string{"syslog", "auth", "info"}, m.Tags)
+
+	m = pollSyslogMessages(t, s, "alerts")[0]
+	require.Equal(t, "server02: backup", m.Title)
+	require.Equal(t, "Backup failed", m.Message)
+	require.Equal(t, 4, m.Priority)
+	require.Equal(t, []string{"syslog", "user", "err"}, m.Tags)
+}
+
+func TestSyslogServer_PublishTCP(t *testing.T) {
+	c := newTestConfig(t)
+	c.SyslogRules = []*SyslogRule{mustParseSyslogRule(t, "-> logs")}
+	s := newTestServer(t, c)
+	addr := newTestSyslogListeners(t, s)
+
+	conn, err := net.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer conn.Close()
+	octetCounted := "<13>1 - host1 app - - - first message"
+	_, err = fmt.Fprintf(conn, "%d %s<13>host2: second message\n<13>host3: third message\n", len(octetCounted), octetCounted)
+	require.Nil(t, err)
+
+	waitFor(t, func() bool {
+		return len(pollSyslogMessages(t, s, "logs")) == 3
+	})
+	messages := pollSyslogMessages(t, s, "logs")
+	require.Equal(t, "first message", messages[0].Message)
+	require.Equal(t, "host1: app", messages[0].Title)
+	require.Equal(t, "second message", messages[1].Message)
+	require.Equal(t, "third message", messages[2].Message)
+}
+
+func TestSyslogServer_PublishWithToken(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser, false))
+	require.Nil(t, s.userManager.AllowAccess("phil", "alerts", user.PermissionReadWrite))
+	u, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	token, err := s.userManager.CreateToken(u.ID, "", time.Unix(0, 0), netip.IPv4Unspecified())
+	require.Nil(t, err)
+	s.config.SyslogRules = []*SyslogRule{
+		mustParseSyslogRule(t, "match=^denied -> alerts"),
+		mustParseSyslogRule(t, "-> alerts+"+token.Value),
+	}
+
+	s.handleSyslogMessage("<11>host: denied, no token", "1.2.3.4:5678")
+	s.handleSyslogMessage("<11>host: allowed with token", "1.2.3.4:5678")
+	response := request(t, s, "GET", "/alerts/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "allowed with token", messages[0].Message)
+}
+
+func TestSyslogServer_RateLimitedBySender(t *testing.T) {
+	c := newTestConfig(t)
+	c.VisitorRequestLimitBurst = 3
+	c.SyslogRules = []*SyslogRule{mustParseSyslogRule(t, "-> logs")}
+	s := newTestServer(t, c)
+
+	for i := 0; i < 5; i++ {
+		s.handleSyslogMessage(fmt.Sprintf("<13>host: message %d", i), "1.2.3.4:5678")
+	}
+	s.handleSyslogMessage("<13>host: other sender", "5.6.7.8:5678")
+	messages := pollSyslogMessages(t, s, "logs")
+	require.Equal(t, 4, len(messages))
+	require.Equal(t, "other sender", messages[3].Message)
+}
+
+func TestSyslogServer_TruncateOnRuneBoundary(t *testing.T) {
+	c := newTestConfig(t)
+	c.MessageSizeLimit = 10
+	c.SyslogRules = []*SyslogRule{mustParseSyslogRule(t, "-> logs")}
+	s := newTestServer(t, c)
+
+	s.handleSyslogMessage("<13>host: 123456789äöü", "1.2.3.4:5678") // "ä" spans bytes 10 and 11
+	messages := pollSyslogMessages(t, s, "logs")
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "123456789", messages[0].Message)
+	require.Nil(t, messages[0].Attachment)
+}
+
+func TestSyslogServer_AllowedSources(t *testing.T) {
+	c := newTestConfig(t)
+	c.SyslogRules = []*SyslogRule{mustParseSyslogRule(t, "-> logs")}
+	s := newTestServer(t, c)
+	for _, addr := range []string{"127.0.0.1:514", "[::1]:514", "10.1.2.3:514", "192.168.1.1:514", "[fd00::1]:514", "[::ffff:192.168.1.1]:514"} {
+		require.True(t, s.syslogSourceAllowed(netAddr(addr)), addr)
+	}
+	for _, addr := range []string{"1.2.3.4:514", "[2001:db8::1]:514", "invalid"} {
+		require.False(t, s.syslogSourceAllowed(netAddr(addr)), addr)
+	}
+
+	// Connections from other sources are closed right away
+	s.config.SyslogAllowedPrefixes = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	addr := newTestSyslogListeners(t, s)
+	conn, err := net.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer conn.Close()
+	require.Nil(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestSyslogServer_TCPConnectionsLimit(t *testing.T) {
+	c := newTestConfig(t)
+	c.SyslogRules = []*SyslogRule{mustParseSyslogRule(t, "-> logs")}
+	s := newTestServer(t, c)
+	addr := newTestSyslogListeners(t, s)
+
+	for i := 0; i < syslogTCPConnectionsLimit; i++ {
+		conn, err := net.Dial("tcp", addr)
+		require.Nil(t, err)
+		defer conn.Close()
+	}
+	conn, err := net.Dial("tcp", addr)
+	require.Nil(t, err)
+	defer conn.Close()
+	require.Nil(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func newTestSyslogListeners(t *testing.T, s *Server) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { listener.Close() })
+	conn, err := net.ListenPacket("udp", listener.Addr().String())
+	require.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	go s.serveSyslogTCP(listener)
+	go s.serveSyslogUDP(conn)
+	return listener.Addr().String()
+}
+
+func mustParseSyslogRule(t *testing.T, s string) *SyslogRule {
+	rule, err := ParseSyslogRule(s)
+	require.Nil(t, err)
+	return rule
+}
+
+func pollSyslogMessages(t *testing.T, s *Server, topic string) []*message {
+	response := request(t, s, "GET", "/"+topic+"/json?poll=1", "", nil)
+	return toMessages(t, response.Body.String())
+}
+
+// netAddr is a net.Addr with the given string representation
+type netAddr string
+
+func (a netAddr) Network() string { return "tcp" }
+func (a netAddr) String() string  { return string(a) }
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"math/rand"
 	"sync"
 	"time"
@@ -32,9 +34,107 @@ type topicSubscriber struct {
 	cancel     func()
 }
 
-// subscriber is a function that is called for every new message on a topic
+// subscriber is a function that is called for every new message on a topic. Subscribers passed to
+// topic.Subscribe must not block, see subscriberQueue.
 type subscriber func(v *visitor, msg *message) error
 
+// subscriberQueue is a bounded outbound queue in front of a (potentially slow) subscriber. Publishing to a topic
+// only enqueues the message, and a single Go routine per subscriber connection hands the queued messages to the
+// actual subscriber function. If the queue overflows, the message is either dropped, or the subscriber is
+// disconnected, depending on the queue policy (see Config.SubscriberQueuePolicy).
+type subscriberQueue struct {
+	subscriber subscriber
+	queue      chan *subscriberQueueItem
+	policy     string
+	cancel     func()
+	done       chan struct{} // Closed when Run returns
+	overflowed bool
+	closed     bool
+	mu         sync.Mutex
+}
+
+type subscriberQueueItem struct {
+	v   *visitor
+	msg *message
+}
+
+var errSubscriberQueueFull = errors.New("subscriber queue full")
+
+// newSubscriberQueue creates a new subscriber queue of the given size. The cancel function is
+// called to disconnect the subscriber, i.e. if the subscriber fails or cannot keep up.
+func newSubscriberQueue(sub subscriber, size int, policy string, cancel func()) *subscriberQueue {
+	return &subscriberQueue{
+		subscriber: sub,
+		queue:      make(chan *subscriberQueueItem, size),
+		policy:     policy,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+}
+
+// Enqueue queues a message for delivery. It never blocks, and is meant to be passed to topic.Subscribe.
+func (q *subscriberQueue) Enqueue(v *visitor, msg *message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed || q.overflowed {
+		return nil
+	}
+	select {
+	case q.queue <- &subscriberQueueItem{v: v, msg: msg}:
+		madd(metricSubscriberQueueDepth, 1)
+		return nil
+	default:
+	}
+	if q.policy == SubscriberQueuePolicyDrop {
+		minc(metricSubscriberMessagesDropped)
+		return errSubscriberQueueFull
+	}
+	q.overflowed = true
+	minc(metricSubscribersDropped)
+	q.cancel()
+	return errSubscriberQueueFull
+}
+
+// Run hands queued messages to the subscriber until the context is canceled. If the subscriber returns
+// an error (e.g. because the write deadline was exceeded), the subscriber is disconnected.
+func (q *subscriberQueue) Run(ctx context.Context) {
+	defer close(q.done)
+	defer q.close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case item := <-q.queue:
+			madd(metricSubscriberQueueDepth, -1)
+			if ctx.Err() != nil {
+				return // select picks a random case if both are ready, so the subscriber may already be gone
+			}
+			if err := q.subscriber(item.v, item.msg); err != nil {
+				logvm(item.v, item.msg).Tag(tagSubscribe).Err(err).Debug("Error forwarding to subscriber, disconnecting")
+				q.cancel()
+				return
+			}
+		}
+	}
+}
+
+// Stop disconnects the subscriber and waits for Run to return, so that the subscriber is not called anymore
+// afterwards. It must only be called if Run was started.
+func (q *subscriberQueue) Stop() {
+	q.cancel()
+	<-q.done
+}
+
+func (q *subscriberQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	madd(metricSubscriberQueueDepth, -len(q.queue))
+	for len(q.queue) > 0 {
+		<-q.queue
+	}
+}
+
 // newTopic creates a new topic
 func newTopic(id string) *topic {
 	return &topic{
@@ -102,28 +202,23 @@ func (t *topic) Unsubscribe(id int) {
 	delete(t.subscribers, id)
 }
 
-// Publish asynchronously publishes to all subscribers
+// Publish publishes to all subscribers. Since subscribers only enqueue the message (see subscriberQueue),
+// this does not block, and messages are handed to each subscriber in the order they were published.
 func (t *topic) Publish(v *visitor, m *message) error {
-	go func() {
-		// We want to lock the topic as short as possible, so we make a shallow copy of the
-		// subscribers map here. Actually sending out the messages then doesn't have to lock.
-		subscribers := t.subscribersCopy()
-		if len(subscribers) > 0 {
-			logvm(v, m).Tag(tagPublish).Debug("Forwarding to %d subscriber(s)", len(subscribers))
-			for _, s := range subscribers {
-				// We call the subscriber functions in their own Go routines because they are blocking, and
-				// we don't want individual slow subscribers to be able to block others.
-				go func(s subscriber) {
-					if err := s(v, m); err != nil {
-						logvm(v, m).Tag(tagPublish).Err(err).Warn("Error forwarding to subscriber")
-					}
-				}(s.subscriber)
+	// We want to lock the topic as short as possible, so we make a shallow copy of the
+	// subscribers map here. Actually sending out the messages then doesn't have to lock.
+	subscribers := t.subscribersCopy()
+	if len(subscribers) > 0 {
+		logvm(v, m).Tag(tagPublish).Debug("Forwarding to %d subscriber(s)", len(subscribers))
+		for _, s := range subscribers {
+			if err := s.subscriber(v, m); err != nil {
+				logvm(v, m).Tag(tagPublish).Err(err).Warn("Error forwarding to subscriber")
 			}
-		} else {
-			logvm(v, m).Tag(tagPublish).Trace("No stream or WebSocket subscribers, not forwarding")
 		}
-		t.Keepalive()
-	}()
+	} else {
+		logvm(v, m).Tag(tagPublish).Trace("No stream or WebSocket subscribers, not forwarding")
+	}
+	t.Keepalive()
 	return nil
 }
 
@@ -1,6 +1,9 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"math/rand"
 	"sync/atomic"
 	"testing"
@@ -88,3 +91,109 @@ func TestTopic_Subscribe_DuplicateID(t *testing.T) {
 	require.NotEqual(t, id, a)
 	require.Equal(t, "b", res.userID, "b")
 }
+
+func TestSubscriberQueue_Deliver(t *testing.T) {
+	t.Parallel()
+
+	received := make(chan *message, 10)
+	subFn := func(v *visitor, msg *message) error {
+		received <- msg
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q := newSubscriberQueue(subFn, 5, SubscriberQueuePolicyDisconnect, cancel)
+	go q.Run(ctx)
+
+	to := newTopic("mytopic")
+	to.Subscribe(q.Enqueue, "", cancel)
+	for i := 0; i < 3; i++ {
+		require.Nil(t, to.Publish(nil, newDefaultMessage("mytopic", fmt.Sprintf("message %d", i))))
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case m := <-received:
+			require.Equal(t, fmt.Sprintf("message %d", i), m.Message)
+		case <-time.After(time.Second):
+			t.Fatal("message not received")
+		}
+	}
+}
+
+func TestSubscriberQueue_Overflow_Drop(t *testing.T) {
+	t.Parallel()
+
+	canceled := atomic.Bool{}
+	subFn := func(v *visitor, msg *message) error {
+		return nil
+	}
+	q := newSubscriberQueue(subFn, 2, SubscriberQueuePolicyDrop, func() { canceled.Store(true) })
+
+	// Queue is not drained, so the third message is dropped
+	require.Nil(t, q.Enqueue(nil, newDefaultMessage("mytopic", "message 1")))
+	require.Nil(t, q.Enqueue(nil, newDefaultMessage("mytopic", "message 2")))
+	require.Equal(t, errSubscriberQueueFull, q.Enqueue(nil, newDefaultMessage("mytopic", "message 3")))
+	require.False(t, canceled.Load())
+	require.Equal(t, 2, len(q.queue))
+}
+
+func TestSubscriberQueue_Overflow_Disconnect(t *testing.T) {
+	t.Parallel()
+
+	canceled := atomic.Int32{}
+	subFn := func(v *visitor, msg *message) error {
+		return nil
+	}
+	q := newSubscriberQueue(subFn, 1, SubscriberQueuePolicyDisconnect, func() { canceled.Add(1) })
+
+	require.Nil(t, q.Enqueue(nil, newDefaultMessage("mytopic", "message 1")))
+	require.Equal(t, errSubscriberQueueFull, q.Enqueue(nil, newDefaultMessage("mytopic", "message 2")))
+	require.Nil(t, q.Enqueue(nil, newDefaultMessage("mytopic", "message 3"))) // Already disconnected, ignored
+	require.Equal(t, int32(1), canceled.Load())
+}
+
+func TestSubscriberQueue_SubscriberError_Disconnect(t *testing.T) {
+	t.Parallel()
+
+	subFn := func(v *visitor, msg *message) error {
+		return errors.New("write deadline exceeded")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q := newSubscriberQueue(subFn, 5, SubscriberQueuePolicyDrop, cancel)
+	done := make(chan bool)
+	go func() {
+		q.Run(ctx)
+		done <- true
+	}()
+	require.Nil(t, q.Enqueue(nil, newDefaultMessage("mytopic", "message 1")))
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("queue did not stop")
+	}
+	require.NotNil(t, ctx.Err())
+	require.Nil(t, q.Enqueue(nil, newDefaultMessage("mytopic", "message 2"))) // Closed, ignored
+}
+
+func TestSubscriberQueue_NoDeliveryAfterCancel(t *testing.T) {
+	t.Parallel()
+
+	delivered := atomic.Int32{}
+	subFn := func(v *visitor, msg *message) error {
+		delivered.Add(1)
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	q := newSubscriberQueue(subFn, 10, SubscriberQueuePolicyDisconnect, cancel)
+	for i := 0; i < 10; i++ {
+		require.Nil(t, q.Enqueue(nil, newDefaultMessage("mytopic", fmt.Sprintf("message %d", i))))
+	}
+	cancel()
+
+	// Both the context and the queue are ready, but queued messages must not be delivered anymore
+	go q.Run(ctx)
+	q.Stop()
+	require.Equal(t, int32(0), delivered.Load())
+	require.Equal(t, 0, len(q.queue))
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"net/http"
 	"net/netip"
+	"strings"
 	"time"
 
 	"heckel.io/ntfy/v2/log"
@@ -25,24 +26,28 @@ const (
 
 // message represents a message published to a topic
 type message struct {
-	ID          string      `json:"id"`                // Random message ID
-	Time        int64       `json:"time"`              // Unix time in seconds
-	Expires     int64       `json:"expires,omitempty"` // Unix time in seconds (not required for open/keepalive)
-	Event       string      `json:"event"`             // One of the above
-	Topic       string      `json:"topic"`
-	Title       string      `json:"title,omitempty"`
-	Message     string      `json:"message,omitempty"`
-	Priority    int         `json:"priority,omitempty"`
-	Tags        []string    `json:"tags,omitempty"`
-	Click       string      `json:"click,omitempty"`
-	Icon        string      `json:"icon,omitempty"`
-	Actions     []*action   `json:"actions,omitempty"`
-	Attachment  *attachment `json:"attachment,omitempty"`
-	PollID      string      `json:"poll_id,omitempty"`
-	ContentType string      `json:"content_type,omitempty"` // text/plain by default (if empty), or text/markdown
-	Encoding    string      `json:"encoding,omitempty"`     // empty for raw UTF-8, or "base64" for encoded bytes
-	Sender      netip.Addr  `json:"-"`                      // IP address of uploader, used for rate limiting
-	User        string      `json:"-"`                      // UserID of the uploader, used to associated attachments
+	ID          string            `json:"id"`                // Random message ID
+	Time        int64             `json:"time"`              // Unix time in seconds
+	Expires     int64             `json:"expires,omitempty"` // Unix time in seconds (not required for open/keepalive)
+	Event       string            `json:"event"`             // One of the above
+	Topic       string            `json:"topic"`
+	Title       string            `json:"title,omitempty"`
+	Message     string            `json:"message,omitempty"`
+	Priority    int               `json:"priority,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Click       string            `json:"click,omitempty"`
+	Icon        string            `json:"icon,omitempty"`
+	Actions     []*action         `json:"actions,omitempty"`
+	Attachment  *attachment       `json:"attachment,omitempty"`  // First attachment, for clients that do not support multiple attachments
+	Attachments []*attachment     `json:"attachments,omitempty"` // All attachments, only set if there is more than one
+	PollID      string            `json:"poll_id,omitempty"`
+	ContentType string            `json:"content_type,omitempty"` // text/plain by default (if empty), or text/markdown
+	Encoding    string            `json:"encoding,omitempty"`     // empty for raw UTF-8, "base64" for encoded bytes, or "jwe" for encrypted messages
+	Labels      map[string]string `json:"labels,omitempty"`       // Custom key/value metadata, see parseLabels
+	Signature   string            `json:"signature,omitempty"`    // Base64-encoded Ed25519 signature, see signMessage
+	Sender      netip.Addr        `json:"-"`                      // IP address of uploader, used for rate limiting
+	User        string            `json:"-"`                      // UserID of the uploader, used to associated attachments
+	Routing     *routingResult    `json:"-"`                      // Routing result of scheduled messages, applied when they are sent
 }
 
 func (m *message) Context() log.Context {
@@ -62,12 +67,36 @@ func (m *message) Context() log.Context {
 	return fields
 }
 
+// addAttachment adds an attachment to the message. The first attachment is always stored in the Attachment
+// field, so that older clients can display it. All attachments are listed in Attachments if there is more than one.
+func (m *message) addAttachment(a *attachment) {
+	if m.Attachment == nil {
+		m.Attachment = a
+		return
+	}
+	if len(m.Attachments) == 0 {
+		m.Attachments = []*attachment{m.Attachment}
+	}
+	m.Attachments = append(m.Attachments, a)
+}
+
+// allAttachments returns all attachments of the message, or an empty slice if there are none
+func (m *message) allAttachments() []*attachment {
+	if len(m.Attachments) > 0 {
+		return m.Attachments
+	} else if m.Attachment != nil {
+		return []*attachment{m.Attachment}
+	}
+	return []*attachment{}
+}
+
 type attachment struct {
-	Name    string `json:"name"`
-	Type    string `json:"type,omitempty"`
-	Size    int64  `json:"size,omitempty"`
-	Expires int64  `json:"expires,omitempty"`
-	URL     string `json:"url"`
+	Name      string `json:"name"`
+	Type      string `json:"type,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	Expires   int64  `json:"expires,omitempty"`
+	URL       string `json:"url"`
+	Thumbnail string `json:"thumbnail,omitempty"` // URL of a downscaled image, only set for uploaded images
 }
 
 type action struct {
@@ -92,22 +121,25 @@ func newAction() *action {
 
 // publishMessage is used as input when publishing as JSON
 type publishMessage struct {
-	Topic    string   `json:"topic"`
-	Title    string   `json:"title"`
-	Message  string   `json:"message"`
-	Priority int      `json:"priority"`
-	Tags     []string `json:"tags"`
-	Click    string   `json:"click"`
-	Icon     string   `json:"icon"`
-	Actions  []action `json:"actions"`
-	Attach   string   `json:"attach"`
-	Markdown bool     `json:"markdown"`
-	Filename string   `json:"filename"`
-	Email    string   `json:"email"`
-	Call     string   `json:"call"`
-	Cache    string   `json:"cache"`    // use string as it defaults to true (or use &bool instead)
-	Firebase string   `json:"firebase"` // use string as it defaults to true (or use &bool instead)
-	Delay    string   `json:"delay"`
+	Topic    string            `json:"topic"`
+	Title    string            `json:"title"`
+	Message  string            `json:"message"`
+	Priority int               `json:"priority"`
+	Tags     []string          `json:"tags"`
+	Click    string            `json:"click"`
+	Icon     string            `json:"icon"`
+	Actions  []action          `json:"actions"`
+	Attach   string            `json:"attach"`
+	Markdown bool              `json:"markdown"`
+	Filename string            `json:"filename"`
+	Email    string            `json:"email"`
+	Call     string            `json:"call"`
+	Cache    string            `json:"cache"`    // use string as it defaults to true (or use &bool instead)
+	Firebase string            `json:"firebase"` // use string as it defaults to true (or use &bool instead)
+	Delay    string            `json:"delay"`
+	Expires  string            `json:"expires"`
+	Encoding string            `json:"encoding"`
+	Labels   map[string]string `json:"labels"`
 }
 
 // messageEncoder is a function that knows how to encode a message
@@ -193,12 +225,24 @@ var (
 	sinceLatestMessage = sinceMarker{time.Unix(0, 0), "latest"}
 )
 
+// pagination limits the cached messages returned to a subscriber to a single page, see parsePagination
+type pagination struct {
+	limit  int    // Max. number of messages on the page, or 0 for no limit
+	before string // If set, only messages before this message ID are returned
+	newest bool   // If set, the page ends with the newest message instead of starting with the oldest (unless since is a message ID)
+}
+
+func (p *pagination) IsNone() bool {
+	return p.limit == 0 && p.before == "" && !p.newest
+}
+
 type queryFilter struct {
 	ID       string
 	Message  string
 	Title    string
 	Tags     []string
 	Priority []int
+	Labels   map[string][]string
 }
 
 func parseQueryFilters(r *http.Request) (*queryFilter, error) {
@@ -214,12 +258,17 @@ func parseQueryFilters(r *http.Request) (*queryFilter, error) {
 		}
 		priorityFilter = append(priorityFilter, priority)
 	}
+	labelsFilter := make(map[string][]string)
+	for key, value := range readLabelParams(r) {
+		labelsFilter[key] = util.Map(util.SplitNoEmpty(value, ","), strings.TrimSpace)
+	}
 	return &queryFilter{
 		ID:       idFilter,
 		Message:  messageFilter,
 		Title:    titleFilter,
 		Tags:     tagsFilter,
 		Priority: priorityFilter,
+		Labels:   labelsFilter,
 	}, nil
 }
 
@@ -243,13 +292,108 @@ func (q *queryFilter) Pass(msg *message) bool {
 	if len(q.Tags) > 0 && !util.ContainsAll(msg.Tags, q.Tags) {
 		return false
 	}
+	for key, values := range q.Labels {
+		if value, ok := msg.Labels[key]; !ok || (len(values) > 0 && !util.Contains(values, value)) {
+			return false
+		}
+	}
 	return true
 }
 
+// consumer is a named, durable subscription of a user. It remembers the last message that was
+// delivered to (or acknowledged by) the consumer, so that it can resume where it left off.
+type consumer struct {
+	UserID    string
+	Name      string
+	Topics    []string
+	MessageID string // Last delivered or acknowledged message, may be empty
+	Updated   int64
+}
+
+func (c *consumer) Context() log.Context {
+	return map[string]any{
+		"consumer_name":       c.Name,
+		"consumer_user_id":    c.UserID,
+		"consumer_message_id": c.MessageID,
+	}
+}
+
+// upload is a resumable attachment upload that has not been completed yet. The upload ID is also
+// the ID of the file in the file cache, and the ID of the message that is published once the upload
+// is complete, see handleUploadPatch.
+type upload struct {
+	ID       string
+	Topic    string
+	User     string      // User ID of the uploader, may be empty
+	Sender   netip.Addr  // IP address of the uploader
+	Length   int64       // Total size of the file, as announced by the client
+	Received int64       // Bytes received so far, i.e. the current upload offset
+	Header   http.Header // Publish headers, replayed when the message is published
+	Query    string      // Publish query parameters, replayed when the message is published
+	Expires  int64
+}
+
+func (u *upload) Context() log.Context {
+	return map[string]any{
+		"upload_id":       u.ID,
+		"upload_topic":    u.Topic,
+		"upload_length":   u.Length,
+		"upload_received": u.Received,
+	}
+}
+
 type apiHealthResponse struct {
 	Healthy bool `json:"healthy"`
 }
 
+type apiMessageSigningKeyResponse struct {
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"public_key"` // Base64-encoded
+}
+
+type apiPublishBatchResponse struct {
+	Results []*apiPublishBatchResult `json:"results"`
+}
+
+// apiPublishBatchResult is the result of a single message in a batch publish request. Exactly one
+// of the fields is set.
+type apiPublishBatchResult struct {
+	Message *message `json:"message,omitempty"`
+	Error   *errHTTP `json:"error,omitempty"`
+}
+
+type apiRoutingRuleAddRequest struct {
+	Rule string `json:"rule"`
+}
+
+type apiRoutingRuleDeleteRequest struct {
+	ID string `json:"id"`
+}
+
+type apiRoutingRuleResponse struct {
+	ID   string `json:"id"`
+	Rule string `json:"rule"`
+}
+
+type apiRoutingRuleTestRequest struct {
+	Topic    string   `json:"topic"`
+	Title    string   `json:"title"`
+	Message  string   `json:"message"`
+	Priority int      `json:"priority"`
+	Tags     []string `json:"tags"`
+	Rule     string   `json:"rule,omitempty"` // If set, only this rule is tested
+}
+
+type apiRoutingRuleTestResponse struct {
+	Matched  []string `json:"matched"`
+	Message  *message `json:"message"`
+	Emails   []string `json:"emails,omitempty"`
+	Calls    []string `json:"calls,omitempty"`
+	Firebase *bool    `json:"firebase,omitempty"`
+	Forward  []string `json:"forward,omitempty"`
+	Drop     bool     `json:"drop,omitempty"`
+}
+
 type apiStatsResponse struct {
 	Messages     int64   `json:"messages"`
 	MessagesRate float64 `json:"messages_rate"` // Average number of messages per second
@@ -275,6 +419,16 @@ type apiUserGrantResponse struct {
 	Permission string `json:"permission"`
 }
 
+type apiConsumerResponse struct {
+	User      string   `json:"user,omitempty"` // Empty for anonymous consumers
+	IP        string   `json:"ip,omitempty"`   // Only set for anonymous consumers
+	Consumer  string   `json:"consumer"`
+	Topics    []string `json:"topics"`
+	MessageID string   `json:"message_id,omitempty"`
+	Updated   int64    `json:"updated"`
+	Pending   int64    `json:"pending"`
+}
+
 type apiUserDeleteRequest struct {
 	Username string `json:"username"`
 }
@@ -323,6 +477,46 @@ type apiAccountTokenResponse struct {
 	Expires    int64  `json:"expires,omitempty"` // Unix timestamp
 }
 
+type apiAccountSignedURLRequest struct {
+	Topic   string   `json:"topic"`
+	Expires *int64   `json:"expires"` // Unix timestamp
+	Fields  []string `json:"fields"`
+	Uses    int      `json:"uses"`
+}
+
+type apiAccountSignedURLResponse struct {
+	URL     string `json:"url"`
+	Expires int64  `json:"expires"` // Unix timestamp
+}
+
+type apiAccountWebhookRequest struct {
+	Topic  string `json:"topic"`
+	Scheme string `json:"scheme"`
+	Header string `json:"header,omitempty"`
+	Secret string `json:"secret,omitempty"`
+}
+
+type apiAccountWebhookResponse struct {
+	Topic  string `json:"topic"`
+	Scheme string `json:"scheme"`
+	Header string `json:"header,omitempty"`
+	Secret string `json:"secret,omitempty"`
+}
+
+type apiAccountTopicLinkRequest struct {
+	Source      string   `json:"source"`
+	Target      string   `json:"target"`
+	MinPriority int      `json:"min_priority,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+type apiAccountTopicLinkResponse struct {
+	Source      string   `json:"source"`
+	Target      string   `json:"target"`
+	MinPriority int      `json:"min_priority,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
 type apiAccountPhoneNumberVerifyRequest struct {
 	Number  string `json:"number"`
 	Channel string `json:"channel"`
@@ -365,8 +559,20 @@ type apiAccountStats struct {
 }
 
 type apiAccountReservation struct {
-	Topic    string `json:"topic"`
-	Everyone string `json:"everyone"`
+	Topic     string                    `json:"topic"`
+	Everyone  string                    `json:"everyone"`
+	Retention *apiAccountTopicRetention `json:"retention,omitempty"`
+}
+
+type apiAccountTopicRetention struct {
+	MessagesExpiryDuration int64 `json:"messages_expiry_duration"` // Seconds
+	MessagesCountLimit     int64 `json:"messages_count_limit,omitempty"`
+}
+
+type apiAccountTopicRetentionRequest struct {
+	Topic                  string `json:"topic"`
+	MessagesExpiryDuration *int64 `json:"messages_expiry_duration"` // Seconds, required; 0 means messages are not cached
+	MessagesCountLimit     int64  `json:"messages_count_limit"`     // 0 means no limit
 }
 
 type apiAccountBilling struct {
@@ -379,19 +585,21 @@ type apiAccountBilling struct {
 }
 
 type apiAccountResponse struct {
-	Username      string                     `json:"username"`
-	Role          string                     `json:"role,omitempty"`
-	SyncTopic     string                     `json:"sync_topic,omitempty"`
-	Language      string                     `json:"language,omitempty"`
-	Notification  *user.NotificationPrefs    `json:"notification,omitempty"`
-	Subscriptions []*user.Subscription       `json:"subscriptions,omitempty"`
-	Reservations  []*apiAccountReservation   `json:"reservations,omitempty"`
-	Tokens        []*apiAccountTokenResponse `json:"tokens,omitempty"`
-	PhoneNumbers  []string                   `json:"phone_numbers,omitempty"`
-	Tier          *apiAccountTier            `json:"tier,omitempty"`
-	Limits        *apiAccountLimits          `json:"limits,omitempty"`
-	Stats         *apiAccountStats           `json:"stats,omitempty"`
-	Billing       *apiAccountBilling         `json:"billing,omitempty"`
+	Username      string                         `json:"username"`
+	Role          string                         `json:"role,omitempty"`
+	SyncTopic     string                         `json:"sync_topic,omitempty"`
+	Language      string                         `json:"language,omitempty"`
+	Notification  *user.NotificationPrefs        `json:"notification,omitempty"`
+	Subscriptions []*user.Subscription           `json:"subscriptions,omitempty"`
+	Reservations  []*apiAccountReservation       `json:"reservations,omitempty"`
+	Webhooks      []*apiAccountWebhookResponse   `json:"webhooks,omitempty"`
+	Links         []*apiAccountTopicLinkResponse `json:"links,omitempty"`
+	Tokens        []*apiAccountTokenResponse     `json:"tokens,omitempty"`
+	PhoneNumbers  []string                       `json:"phone_numbers,omitempty"`
+	Tier          *apiAccountTier                `json:"tier,omitempty"`
+	Limits        *apiAccountLimits              `json:"limits,omitempty"`
+	Stats         *apiAccountStats               `json:"stats,omitempty"`
+	Billing       *apiAccountBilling             `json:"billing,omitempty"`
 }
 
 type apiAccountReservationRequest struct {
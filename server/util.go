@@ -2,6 +2,8 @@ package server
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -81,6 +83,73 @@ func readQueryParam(r *http.Request, names ...string) string {
 	return ""
 }
 
+// readMultiParam reads all values of the first parameter that is set, e.g. multiple "X-Attach" headers,
+// or repeated "attach" query parameters. Headers take precedence over query parameters.
+func readMultiParam(r *http.Request, names ...string) []string {
+	for _, name := range names {
+		values := make([]string, 0)
+		for _, value := range r.Header.Values(name) {
+			if value = strings.TrimSpace(maybeDecodeHeader(name, value)); value != "" {
+				values = append(values, value)
+			}
+		}
+		if len(values) > 0 {
+			return values
+		}
+	}
+	for _, name := range names {
+		values := make([]string, 0)
+		for _, value := range r.URL.Query()[strings.ToLower(name)] {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+		if len(values) > 0 {
+			return values
+		}
+	}
+	return []string{}
+}
+
+// readLabelParams reads custom message labels from "X-Label-<key>" headers and "label.<key>" query parameters.
+// Keys are lowercased, and headers take precedence over query parameters. Empty values are ignored.
+func readLabelParams(r *http.Request) map[string]string {
+	labels := make(map[string]string)
+	for name, values := range r.URL.Query() {
+		key, ok := strings.CutPrefix(strings.ToLower(name), "label.")
+		if ok && len(values) > 0 && strings.TrimSpace(values[0]) != "" {
+			labels[key] = strings.TrimSpace(values[0])
+		}
+	}
+	for name := range r.Header {
+		key, ok := strings.CutPrefix(strings.ToLower(name), "x-label-")
+		if !ok {
+			continue
+		}
+		if value := readHeaderParam(r, name); value != "" {
+			labels[key] = value
+		}
+	}
+	return labels
+}
+
+// parseLabels validates the given message labels, and returns nil if there are none
+func parseLabels(labels map[string]string) (map[string]string, error) {
+	if len(labels) == 0 {
+		return nil, nil
+	} else if len(labels) > labelsLimit {
+		return nil, fmt.Errorf("too many labels, only %d labels are allowed", labelsLimit)
+	}
+	for key, value := range labels {
+		if !labelKeyRegex.MatchString(key) {
+			return nil, fmt.Errorf("label key '%s' is invalid, only lowercase letters, numbers, '-' and '_' are allowed (max. 32 characters)", key)
+		} else if len(value) > labelValueLengthLimit {
+			return nil, fmt.Errorf("value of label '%s' is too long, max. %d characters are allowed", key, labelValueLengthLimit)
+		}
+	}
+	return labels, nil
+}
+
 // extractIPAddress extracts the IP address of the visitor from the request,
 // either from the TCP socket or from a proxy header.
 func extractIPAddress(r *http.Request, behindProxy bool, proxyForwardedHeader string, proxyTrustedPrefixes []netip.Prefix) netip.Addr {
@@ -196,3 +265,24 @@ func maybeIgnoreSpecialHeader(name, value string) string {
 	}
 	return value
 }
+
+// isJWECompact checks if the given string looks like a JWE in compact serialization (RFC 7516), i.e. five
+// base64url-encoded parts separated by dots, with a JSON header that defines "alg" and "enc". It does not
+// (and cannot) decrypt the message.
+func isJWECompact(s string) bool {
+	parts := strings.Split(s, ".")
+	if len(parts) != 5 || parts[0] == "" || parts[3] == "" {
+		return false
+	}
+	for _, part := range parts {
+		if _, err := base64.RawURLEncoding.DecodeString(part); err != nil {
+			return false
+		}
+	}
+	headerBytes, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	var header struct {
+		Alg string `json:"alg"`
+		Enc string `json:"enc"`
+	}
+	return json.Unmarshal(headerBytes, &header) == nil && header.Alg != "" && header.Enc != ""
+}
@@ -29,6 +29,8 @@ const (
 	tokenPrefix                     = "tk_"
 	tokenLength                     = 32
 	tokenMaxCount                   = 60 // Only keep this many tokens in the table per user
+	routingRuleIDPrefix             = "ru_"
+	routingRuleIDLength             = 12
 	tag                             = "user_manager"
 )
 
@@ -117,6 +119,37 @@ const (
 			PRIMARY KEY (user_id, phone_number),
 			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
 		);
+		CREATE TABLE IF NOT EXISTS user_webhook (
+			user_id TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			scheme TEXT NOT NULL,
+			header TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			PRIMARY KEY (topic),
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS user_topic_link (
+			user_id TEXT NOT NULL,
+			source TEXT NOT NULL,
+			target TEXT NOT NULL,
+			min_priority INT NOT NULL,
+			tags TEXT NOT NULL,
+			PRIMARY KEY (source, target),
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_user_topic_link_user_id ON user_topic_link (user_id);
+		CREATE TABLE IF NOT EXISTS routing_rule (
+			id TEXT NOT NULL,
+			rule TEXT NOT NULL,
+			created INT NOT NULL,
+			PRIMARY KEY (id)
+		);
+		CREATE TABLE IF NOT EXISTS topic_retention (
+			topic TEXT NOT NULL,
+			messages_expiry_duration INT NOT NULL,
+			messages_count_limit INT NOT NULL,
+			PRIMARY KEY (topic)
+		);
 		CREATE TABLE IF NOT EXISTS schemaVersion (
 			id INT PRIMARY KEY,
 			version INT NOT NULL
@@ -276,6 +309,38 @@ const (
 	insertPhoneNumberQuery  = `INSERT INTO user_phone (user_id, phone_number) VALUES (?, ?)`
 	deletePhoneNumberQuery  = `DELETE FROM user_phone WHERE user_id = ? AND phone_number = ?`
 
+	upsertWebhookQuery = `
+		INSERT INTO user_webhook (user_id, topic, scheme, header, secret)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (topic) DO UPDATE SET user_id = excluded.user_id, scheme = excluded.scheme, header = excluded.header, secret = excluded.secret
+	`
+	selectWebhookQuery       = `SELECT user_id, topic, scheme, header, secret FROM user_webhook WHERE topic = ?`
+	selectWebhooksQuery      = `SELECT user_id, topic, scheme, header, secret FROM user_webhook WHERE user_id = ? ORDER BY topic`
+	deleteWebhookQuery       = `DELETE FROM user_webhook WHERE user_id = ? AND topic = ?`
+	deleteWebhookByUserQuery = `DELETE FROM user_webhook WHERE user_id = (SELECT id FROM user WHERE user = ?) AND topic = ?`
+
+	upsertTopicLinkQuery = `
+		INSERT INTO user_topic_link (user_id, source, target, min_priority, tags)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (source, target) DO UPDATE SET user_id = excluded.user_id, min_priority = excluded.min_priority, tags = excluded.tags
+	`
+	selectTopicLinksBySourceQuery = `SELECT user_id, source, target, min_priority, tags FROM user_topic_link WHERE source = ? ORDER BY target`
+	selectTopicLinksByUserQuery   = `SELECT user_id, source, target, min_priority, tags FROM user_topic_link WHERE user_id = ? ORDER BY source, target`
+	deleteTopicLinkQuery          = `DELETE FROM user_topic_link WHERE user_id = ? AND source = ? AND target = ?`
+
+	insertRoutingRuleQuery  = `INSERT INTO routing_rule (id, rule, created) VALUES (?, ?, ?)`
+	selectRoutingRulesQuery = `SELECT id, rule FROM routing_rule ORDER BY created, id`
+	deleteRoutingRuleQuery  = `DELETE FROM routing_rule WHERE id = ?`
+
+	upsertTopicRetentionQuery = `
+		INSERT INTO topic_retention (topic, messages_expiry_duration, messages_count_limit)
+		VALUES (?, ?, ?)
+		ON CONFLICT (topic) DO UPDATE SET messages_expiry_duration = excluded.messages_expiry_duration, messages_count_limit = excluded.messages_count_limit
+	`
+	selectTopicRetentionQuery  = `SELECT topic, messages_expiry_duration, messages_count_limit FROM topic_retention WHERE topic = ?`
+	selectTopicRetentionsQuery = `SELECT topic, messages_expiry_duration, messages_count_limit FROM topic_retention ORDER BY topic`
+	deleteTopicRetentionQuery  = `DELETE FROM topic_retention WHERE topic = ?`
+
 	insertTierQuery = `
 		INSERT INTO tier (id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id)
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
@@ -312,7 +377,7 @@ const (
 
 // Schema management queries
 const (
-	currentSchemaVersion     = 5
+	currentSchemaVersion     = 9
 	insertSchemaVersion      = `INSERT INTO schemaVersion VALUES (1, ?)`
 	updateSchemaVersion      = `UPDATE schemaVersion SET version = ? WHERE id = 1`
 	selectSchemaVersionQuery = `SELECT version FROM schemaVersion WHERE id = 1`
@@ -427,6 +492,53 @@ const (
 	migrate4To5UpdateQueries = `
 		UPDATE user_access SET topic = REPLACE(topic, '_', '\_');
 	`
+
+	// 5 -> 6
+	migrate5To6UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_webhook (
+			user_id TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			scheme TEXT NOT NULL,
+			header TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			PRIMARY KEY (topic),
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+	`
+
+	// 6 -> 7
+	migrate6To7UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_topic_link (
+			user_id TEXT NOT NULL,
+			source TEXT NOT NULL,
+			target TEXT NOT NULL,
+			min_priority INT NOT NULL,
+			tags TEXT NOT NULL,
+			PRIMARY KEY (source, target),
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE INDEX IF NOT EXISTS idx_user_topic_link_user_id ON user_topic_link (user_id);
+	`
+
+	// 7 -> 8
+	migrate7To8UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS routing_rule (
+			id TEXT NOT NULL,
+			rule TEXT NOT NULL,
+			created INT NOT NULL,
+			PRIMARY KEY (id)
+		);
+	`
+
+	// 8 -> 9
+	migrate8To9UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS topic_retention (
+			topic TEXT NOT NULL,
+			messages_expiry_duration INT NOT NULL,
+			messages_count_limit INT NOT NULL,
+			PRIMARY KEY (topic)
+		);
+	`
 )
 
 var (
@@ -435,6 +547,10 @@ var (
 		2: migrateFrom2,
 		3: migrateFrom3,
 		4: migrateFrom4,
+		5: migrateFrom5,
+		6: migrateFrom6,
+		7: migrateFrom7,
+		8: migrateFrom8,
 	}
 )
 
@@ -706,6 +822,283 @@ func (a *Manager) RemovePhoneNumber(userID string, phoneNumber string) error {
 	return err
 }
 
+// Webhook returns the webhook attached to the given topic, or ErrWebhookNotFound if there is none
+func (a *Manager) Webhook(topic string) (*Webhook, error) {
+	rows, err := a.db.Query(selectWebhookQuery, topic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return a.readWebhook(rows)
+}
+
+// Webhooks returns all webhooks owned by the user with the given user ID
+func (a *Manager) Webhooks(userID string) ([]*Webhook, error) {
+	rows, err := a.db.Query(selectWebhooksQuery, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	webhooks := make([]*Webhook, 0)
+	for {
+		webhook, err := a.readWebhook(rows)
+		if err == ErrWebhookNotFound {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, nil
+}
+
+func (a *Manager) readWebhook(rows *sql.Rows) (*Webhook, error) {
+	var userID, topic, scheme, header, secret string
+	if !rows.Next() {
+		return nil, ErrWebhookNotFound
+	}
+	if err := rows.Scan(&userID, &topic, &scheme, &header, &secret); err != nil {
+		return nil, err
+	} else if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &Webhook{
+		UserID: userID,
+		Topic:  topic,
+		Scheme: WebhookScheme(scheme),
+		Header: header,
+		Secret: secret,
+	}, nil
+}
+
+// AddWebhook attaches a webhook to a topic, replacing any existing webhook for that topic
+func (a *Manager) AddWebhook(webhook *Webhook) error {
+	if !AllowedTopic(webhook.Topic) || !AllowedWebhookScheme(webhook.Scheme) || webhook.Secret == "" {
+		return ErrInvalidArgument
+	}
+	_, err := a.db.Exec(upsertWebhookQuery, webhook.UserID, webhook.Topic, string(webhook.Scheme), webhook.Header, webhook.Secret)
+	return err
+}
+
+// RemoveWebhook removes the webhook attached to the given topic, if it is owned by the user with the given user ID
+func (a *Manager) RemoveWebhook(userID, topic string) error {
+	result, err := a.db.Exec(deleteWebhookQuery, userID, topic)
+	if err != nil {
+		return err
+	} else if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// TopicLinks returns all links from the given source topic
+func (a *Manager) TopicLinks(source string) ([]*TopicLink, error) {
+	return a.topicLinks(selectTopicLinksBySourceQuery, source)
+}
+
+// TopicLinksByUser returns all links owned by the user with the given user ID
+func (a *Manager) TopicLinksByUser(userID string) ([]*TopicLink, error) {
+	return a.topicLinks(selectTopicLinksByUserQuery, userID)
+}
+
+func (a *Manager) topicLinks(query string, args ...any) ([]*TopicLink, error) {
+	rows, err := a.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	links := make([]*TopicLink, 0)
+	for rows.Next() {
+		var userID, source, target, tags string
+		var minPriority int
+		if err := rows.Scan(&userID, &source, &target, &minPriority, &tags); err != nil {
+			return nil, err
+		}
+		links = append(links, &TopicLink{
+			UserID:      userID,
+			Source:      source,
+			Target:      target,
+			MinPriority: minPriority,
+			Tags:        util.SplitNoEmpty(tags, ","),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// AddTopicLink adds a link from a source topic to a target topic, replacing any existing link between the
+// two topics. If the link would create a loop, i.e. if the source topic can already be reached from the
+// target topic, ErrTopicLinkLoop is returned.
+func (a *Manager) AddTopicLink(link *TopicLink) error {
+	if !AllowedTopic(link.Source) || !AllowedTopic(link.Target) || link.Source == link.Target || link.MinPriority < 0 || link.MinPriority > 5 {
+		return ErrInvalidArgument
+	}
+	reachable, err := a.topicLinkReachable(link.Target, link.Source)
+	if err != nil {
+		return err
+	} else if reachable {
+		return ErrTopicLinkLoop
+	}
+	_, err = a.db.Exec(upsertTopicLinkQuery, link.UserID, link.Source, link.Target, link.MinPriority, strings.Join(link.Tags, ","))
+	return err
+}
+
+// topicLinkReachable returns true if the target topic can be reached from the source topic by following links
+func (a *Manager) topicLinkReachable(source, target string) (bool, error) {
+	visited := map[string]bool{source: true}
+	queue := []string{source}
+	for len(queue) > 0 {
+		links, err := a.TopicLinks(queue[0])
+		if err != nil {
+			return false, err
+		}
+		queue = queue[1:]
+		for _, link := range links {
+			if link.Target == target {
+				return true, nil
+			} else if !visited[link.Target] {
+				visited[link.Target] = true
+				queue = append(queue, link.Target)
+			}
+		}
+	}
+	return false, nil
+}
+
+// RemoveTopicLink removes the link between the given topics, if it is owned by the user with the given user ID
+func (a *Manager) RemoveTopicLink(userID, source, target string) error {
+	result, err := a.db.Exec(deleteTopicLinkQuery, userID, source, target)
+	if err != nil {
+		return err
+	} else if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrTopicLinkNotFound
+	}
+	return nil
+}
+
+// RoutingRules returns all routing rules that were added via AddRoutingRule, in the order they were added
+func (a *Manager) RoutingRules() ([]*RoutingRule, error) {
+	rows, err := a.db.Query(selectRoutingRulesQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rules := make([]*RoutingRule, 0)
+	for rows.Next() {
+		var id, rule string
+		if err := rows.Scan(&id, &rule); err != nil {
+			return nil, err
+		}
+		rules = append(rules, &RoutingRule{ID: id, Rule: rule})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// AddRoutingRule stores a new routing rule and returns it. The rule expression is not validated.
+func (a *Manager) AddRoutingRule(rule string) (*RoutingRule, error) {
+	if rule == "" {
+		return nil, ErrInvalidArgument
+	}
+	id := util.RandomStringPrefix(routingRuleIDPrefix, routingRuleIDLength)
+	if _, err := a.db.Exec(insertRoutingRuleQuery, id, rule, time.Now().UnixNano()); err != nil {
+		return nil, err
+	}
+	return &RoutingRule{ID: id, Rule: rule}, nil
+}
+
+// RemoveRoutingRule removes the routing rule with the given ID
+func (a *Manager) RemoveRoutingRule(id string) error {
+	result, err := a.db.Exec(deleteRoutingRuleQuery, id)
+	if err != nil {
+		return err
+	} else if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrRoutingRuleNotFound
+	}
+	return nil
+}
+
+// TopicRetention returns the retention settings of the given topic, or ErrTopicRetentionNotFound if the
+// topic uses the default message expiry duration
+func (a *Manager) TopicRetention(topic string) (*TopicRetention, error) {
+	rows, err := a.db.Query(selectTopicRetentionQuery, topic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return a.readTopicRetention(rows)
+}
+
+// TopicRetentions returns the retention settings of all topics that have them
+func (a *Manager) TopicRetentions() ([]*TopicRetention, error) {
+	rows, err := a.db.Query(selectTopicRetentionsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	retentions := make([]*TopicRetention, 0)
+	for {
+		retention, err := a.readTopicRetention(rows)
+		if err == ErrTopicRetentionNotFound {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		retentions = append(retentions, retention)
+	}
+	return retentions, nil
+}
+
+func (a *Manager) readTopicRetention(rows *sql.Rows) (*TopicRetention, error) {
+	var topic string
+	var expiryDuration, countLimit int64
+	if !rows.Next() {
+		return nil, ErrTopicRetentionNotFound
+	}
+	if err := rows.Scan(&topic, &expiryDuration, &countLimit); err != nil {
+		return nil, err
+	} else if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &TopicRetention{
+		Topic:                 topic,
+		MessageExpiryDuration: time.Duration(expiryDuration) * time.Second,
+		MessageCountLimit:     countLimit,
+	}, nil
+}
+
+// SetTopicRetention adds or replaces the retention settings of a topic
+func (a *Manager) SetTopicRetention(retention *TopicRetention) error {
+	if !AllowedTopic(retention.Topic) || retention.MessageExpiryDuration < 0 || retention.MessageCountLimit < 0 {
+		return ErrInvalidArgument
+	}
+	_, err := a.db.Exec(upsertTopicRetentionQuery, retention.Topic, int64(retention.MessageExpiryDuration.Seconds()), retention.MessageCountLimit)
+	return err
+}
+
+// RemoveTopicRetention removes the retention settings of a topic, so that the default message expiry duration applies
+func (a *Manager) RemoveTopicRetention(topic string) error {
+	result, err := a.db.Exec(deleteTopicRetentionQuery, topic)
+	if err != nil {
+		return err
+	} else if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrTopicRetentionNotFound
+	}
+	return nil
+}
+
 // RemoveDeletedUsers deletes all users that have been marked deleted for
 func (a *Manager) RemoveDeletedUsers() error {
 	if _, err := a.db.Exec(deleteUsersMarkedQuery, time.Now().Unix()); err != nil {
@@ -1142,6 +1535,20 @@ func (a *Manager) Reservations(username string) ([]Reservation, error) {
 			Everyone: NewPermission(everyoneRead.Bool, everyoneWrite.Bool), // false if null
 		})
 	}
+	if len(reservations) == 0 {
+		return reservations, nil
+	}
+	retentions, err := a.TopicRetentions()
+	if err != nil {
+		return nil, err
+	}
+	for i := range reservations {
+		for _, retention := range retentions {
+			if retention.Topic == reservations[i].Topic {
+				reservations[i].Retention = retention
+			}
+		}
+	}
 	return reservations, nil
 }
 
@@ -1359,7 +1766,8 @@ func (a *Manager) AddReservation(username string, topic string, everyone Permiss
 }
 
 // RemoveReservations deletes the access control entries associated with the given username/topic, as
-// well as all entries with Everyone/topic. This is the counterpart for AddReservation.
+// well as all entries with Everyone/topic, the retention settings of the topic, and the user's webhook for the
+// topic. This is the counterpart for AddReservation.
 func (a *Manager) RemoveReservations(username string, topics ...string) error {
 	if !AllowedUsername(username) || username == Everyone || len(topics) == 0 {
 		return ErrInvalidArgument
@@ -1381,6 +1789,12 @@ func (a *Manager) RemoveReservations(username string, topics ...string) error {
 		if _, err := tx.Exec(deleteTopicAccessQuery, Everyone, Everyone, escapeUnderscore(topic)); err != nil {
 			return err
 		}
+		if _, err := tx.Exec(deleteTopicRetentionQuery, topic); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(deleteWebhookByUserQuery, username, topic); err != nil {
+			return err
+		}
 	}
 	return tx.Commit()
 }
@@ -1676,6 +2090,70 @@ func migrateFrom4(db *sql.DB) error {
 	return tx.Commit()
 }
 
+func migrateFrom5(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 5 to 6")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate5To6UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 6); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom6(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 6 to 7")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate6To7UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 7); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom7(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 7 to 8")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate7To8UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 8); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom8(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 8 to 9")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate8To9UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 9); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 func nullString(s string) sql.NullString {
 	if s == "" {
 		return sql.NullString{}
@@ -1052,6 +1052,167 @@ func TestUser_PhoneNumberAdd_Multiple_Users_Same_Number(t *testing.T) {
 	require.Nil(t, a.AddPhoneNumber(ben.ID, "+1234567890"))
 }
 
+func TestManager_WebhookAddListRemove(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+
+	require.Nil(t, a.AddUser("phil", "phil", RoleUser, false))
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+	phil, err := a.User("phil")
+	require.Nil(t, err)
+	ben, err := a.User("ben")
+	require.Nil(t, err)
+
+	require.Nil(t, a.AddWebhook(&Webhook{UserID: phil.ID, Topic: "builds", Scheme: WebhookSchemeGitHub, Secret: "s3cr3t"}))
+	require.Nil(t, a.AddWebhook(&Webhook{UserID: phil.ID, Topic: "alerts", Scheme: WebhookSchemeHMAC, Header: "X-Signature", Secret: "s3cr3t"}))
+	require.Equal(t, ErrInvalidArgument, a.AddWebhook(&Webhook{UserID: phil.ID, Topic: "alerts", Scheme: "unknown", Secret: "s3cr3t"}))
+	require.Equal(t, ErrInvalidArgument, a.AddWebhook(&Webhook{UserID: phil.ID, Topic: "alerts", Scheme: WebhookSchemeGitea}))
+
+	webhook, err := a.Webhook("alerts")
+	require.Nil(t, err)
+	require.Equal(t, phil.ID, webhook.UserID)
+	require.Equal(t, WebhookSchemeHMAC, webhook.Scheme)
+	require.Equal(t, "X-Signature", webhook.Header)
+	require.Equal(t, "s3cr3t", webhook.Secret)
+	_, err = a.Webhook("other")
+	require.Equal(t, ErrWebhookNotFound, err)
+
+	webhooks, err := a.Webhooks(phil.ID)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(webhooks))
+	require.Equal(t, "alerts", webhooks[0].Topic)
+	require.Equal(t, "builds", webhooks[1].Topic)
+
+	// Only the owner can remove a webhook
+	require.Equal(t, ErrWebhookNotFound, a.RemoveWebhook(ben.ID, "alerts"))
+	require.Nil(t, a.RemoveWebhook(phil.ID, "alerts"))
+	require.Equal(t, ErrWebhookNotFound, a.RemoveWebhook(phil.ID, "alerts"))
+
+	// Webhooks are removed with the reservation
+	require.Nil(t, a.AddWebhook(&Webhook{UserID: phil.ID, Topic: "alerts", Scheme: WebhookSchemeGitea, Secret: "s3cr3t"}))
+	require.Nil(t, a.AddWebhook(&Webhook{UserID: ben.ID, Topic: "deploys", Scheme: WebhookSchemeGitea, Secret: "s3cr3t"}))
+	require.Nil(t, a.AddReservation("phil", "alerts", PermissionDenyAll))
+	require.Nil(t, a.RemoveReservations("phil", "alerts", "deploys"))
+	_, err = a.Webhook("alerts")
+	require.Equal(t, ErrWebhookNotFound, err)
+	_, err = a.Webhook("deploys") // Owned by another user
+	require.Nil(t, err)
+
+	// Webhooks are removed with the user
+	require.Nil(t, a.RemoveUser("phil"))
+	_, err = a.Webhook("builds")
+	require.Equal(t, ErrWebhookNotFound, err)
+}
+
+func TestManager_TopicLinkAddListRemove(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+
+	require.Nil(t, a.AddUser("phil", "phil", RoleUser, false))
+	require.Nil(t, a.AddUser("ben", "ben", RoleUser, false))
+	phil, err := a.User("phil")
+	require.Nil(t, err)
+	ben, err := a.User("ben")
+	require.Nil(t, err)
+
+	require.Nil(t, a.AddTopicLink(&TopicLink{UserID: phil.ID, Source: "alerts", Target: "team-a"}))
+	require.Nil(t, a.AddTopicLink(&TopicLink{UserID: phil.ID, Source: "alerts", Target: "oncall", MinPriority: 4, Tags: []string{"urgent", "db"}}))
+	require.Nil(t, a.AddTopicLink(&TopicLink{UserID: ben.ID, Source: "team-a", Target: "team-a-archive"}))
+	require.Equal(t, ErrInvalidArgument, a.AddTopicLink(&TopicLink{UserID: phil.ID, Source: "alerts", Target: "alerts"}))
+	require.Equal(t, ErrInvalidArgument, a.AddTopicLink(&TopicLink{UserID: phil.ID, Source: "alerts", Target: "other", MinPriority: 6}))
+
+	// Loops are detected, also across multiple links
+	require.Equal(t, ErrTopicLinkLoop, a.AddTopicLink(&TopicLink{UserID: phil.ID, Source: "team-a", Target: "alerts"}))
+	require.Equal(t, ErrTopicLinkLoop, a.AddTopicLink(&TopicLink{UserID: ben.ID, Source: "team-a-archive", Target: "alerts"}))
+
+	links, err := a.TopicLinks("alerts")
+	require.Nil(t, err)
+	require.Equal(t, 2, len(links))
+	require.Equal(t, "oncall", links[0].Target)
+	require.Equal(t, 4, links[0].MinPriority)
+	require.Equal(t, []string{"urgent", "db"}, links[0].Tags)
+	require.Equal(t, "team-a", links[1].Target)
+	require.Equal(t, 0, links[1].MinPriority)
+	require.Empty(t, links[1].Tags)
+
+	links, err = a.TopicLinksByUser(ben.ID)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(links))
+	require.Equal(t, "team-a", links[0].Source)
+	require.Equal(t, "team-a-archive", links[0].Target)
+
+	// Only the owner can remove a link
+	require.Equal(t, ErrTopicLinkNotFound, a.RemoveTopicLink(ben.ID, "alerts", "oncall"))
+	require.Nil(t, a.RemoveTopicLink(phil.ID, "alerts", "oncall"))
+	require.Equal(t, ErrTopicLinkNotFound, a.RemoveTopicLink(phil.ID, "alerts", "oncall"))
+
+	// Links are removed with the user
+	require.Nil(t, a.RemoveUser("phil"))
+	links, err = a.TopicLinks("alerts")
+	require.Nil(t, err)
+	require.Empty(t, links)
+}
+
+func TestManager_RoutingRuleAddListRemove(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+
+	rule1, err := a.AddRoutingRule("topic=prod-* priority=5 -> call=+12223334444")
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(rule1.ID, "ru_"))
+	rule2, err := a.AddRoutingRule("tags=debug -> firebase=no")
+	require.Nil(t, err)
+	_, err = a.AddRoutingRule("")
+	require.Equal(t, ErrInvalidArgument, err)
+
+	rules, err := a.RoutingRules()
+	require.Nil(t, err)
+	require.Equal(t, 2, len(rules))
+	require.Equal(t, rule1.ID, rules[0].ID)
+	require.Equal(t, "topic=prod-* priority=5 -> call=+12223334444", rules[0].Rule)
+	require.Equal(t, rule2.ID, rules[1].ID)
+
+	require.Nil(t, a.RemoveRoutingRule(rule1.ID))
+	require.Equal(t, ErrRoutingRuleNotFound, a.RemoveRoutingRule(rule1.ID))
+	rules, err = a.RoutingRules()
+	require.Nil(t, err)
+	require.Equal(t, 1, len(rules))
+	require.Equal(t, "tags=debug -> firebase=no", rules[0].Rule)
+}
+
+func TestManager_TopicRetention(t *testing.T) {
+	a := newTestManager(t, PermissionDenyAll)
+	require.Nil(t, a.AddUser("phil", "phil", RoleUser, false))
+	require.Nil(t, a.AddReservation("phil", "my_alerts", PermissionDenyAll))
+
+	_, err := a.TopicRetention("my_alerts")
+	require.Equal(t, ErrTopicRetentionNotFound, err)
+	require.Nil(t, a.SetTopicRetention(&TopicRetention{Topic: "my_alerts", MessageExpiryDuration: 30 * 24 * time.Hour, MessageCountLimit: 100}))
+	require.Nil(t, a.SetTopicRetention(&TopicRetention{Topic: "noise", MessageExpiryDuration: 0}))
+	require.Equal(t, ErrInvalidArgument, a.SetTopicRetention(&TopicRetention{Topic: "noise", MessageCountLimit: -1}))
+
+	retention, err := a.TopicRetention("my_alerts")
+	require.Nil(t, err)
+	require.Equal(t, 30*24*time.Hour, retention.MessageExpiryDuration)
+	require.Equal(t, int64(100), retention.MessageCountLimit)
+
+	retentions, err := a.TopicRetentions()
+	require.Nil(t, err)
+	require.Equal(t, 2, len(retentions))
+	require.Equal(t, "my_alerts", retentions[0].Topic)
+	require.Equal(t, "noise", retentions[1].Topic)
+
+	reservations, err := a.Reservations("phil")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(reservations))
+	require.Equal(t, retention, reservations[0].Retention)
+
+	// Retention is removed with the reservation
+	require.Nil(t, a.RemoveReservations("phil", "my_alerts"))
+	_, err = a.TopicRetention("my_alerts")
+	require.Equal(t, ErrTopicRetentionNotFound, err)
+
+	require.Nil(t, a.RemoveTopicRetention("noise"))
+	require.Equal(t, ErrTopicRetentionNotFound, a.RemoveTopicRetention("noise"))
+}
+
 func TestManager_Topic_Wildcard_With_Asterisk_Underscore(t *testing.T) {
 	f := filepath.Join(t.TempDir(), "user.db")
 	a := newTestManagerFromFile(t, f, "", PermissionDenyAll, DefaultUserPasswordBcryptCost, DefaultUserStatsQueueWriterInterval)
@@ -153,11 +153,59 @@ type Grant struct {
 
 // Reservation is a struct that represents the ownership over a topic by a user
 type Reservation struct {
-	Topic    string
-	Owner    Permission
-	Everyone Permission
+	Topic     string
+	Owner     Permission
+	Everyone  Permission
+	Retention *TopicRetention // Retention settings of the topic, or nil if the defaults apply
 }
 
+// TopicRetention overrides how long messages of a topic are kept in the message cache, and how many of them.
+// If a topic has no retention settings, the message expiry duration of the publisher's tier (or cache-duration) applies.
+type TopicRetention struct {
+	Topic                 string
+	MessageExpiryDuration time.Duration // Duration after which messages are deleted; 0 means messages are not cached at all
+	MessageCountLimit     int64         // Max. number of messages kept, the oldest messages are deleted first; 0 means no limit
+}
+
+// Webhook is a shared secret attached to a topic by its owner. Publish requests to the topic that are signed with
+// the secret (e.g. by GitHub, Gitea or Stripe) are authorized as the owner.
+type Webhook struct {
+	UserID string        // Owner of the webhook
+	Topic  string        // Topic the webhook is attached to
+	Scheme WebhookScheme // Signature scheme used by the sender
+	Header string        // Header carrying the signature, only used for WebhookSchemeHMAC
+	Secret string        // Secret shared with the sender
+}
+
+// TopicLink forwards messages published to the source topic to the target topic, if they match the link's
+// filters. Links are created by the owner of the source topic (or an admin), and messages are only forwarded
+// if the owner may still write to the target topic.
+type TopicLink struct {
+	UserID      string   // Owner of the link
+	Source      string   // Topic the messages are published to
+	Target      string   // Topic the messages are forwarded to
+	MinPriority int      // Only forward messages with at least this priority (1-5), or 0 for all messages
+	Tags        []string // Only forward messages with at least one of these tags, or all messages if empty
+}
+
+// RoutingRule is a server-side routing rule that was added via the admin API. The rule expression is parsed
+// and evaluated by the server, see server.Config.RoutingRules for the format.
+type RoutingRule struct {
+	ID   string // Random rule ID, e.g. ru_iF3Jk2mGvT0a
+	Rule string // Rule expression, e.g. "topic=prod-* priority=5 -> call=+12223334444"
+}
+
+// WebhookScheme defines how the signature of an inbound webhook is sent and computed
+type WebhookScheme string
+
+// Webhook signature schemes
+const (
+	WebhookSchemeGitHub WebhookScheme = "github" // X-Hub-Signature-256: sha256=<hex(HMAC-SHA256(body))>
+	WebhookSchemeGitea  WebhookScheme = "gitea"  // X-Gitea-Signature: <hex(HMAC-SHA256(body))>
+	WebhookSchemeStripe WebhookScheme = "stripe" // Stripe-Signature: t=<timestamp>,v1=<hex(HMAC-SHA256(timestamp.body))>
+	WebhookSchemeHMAC   WebhookScheme = "hmac"   // <Header>: [sha256=]<hex(HMAC-SHA256(body))>
+)
+
 // Permission represents a read or write permission to a topic
 type Permission uint8
 
@@ -267,6 +315,11 @@ func AllowedTopicPattern(topic string) bool {
 	return allowedTopicPatternRegex.MatchString(topic)
 }
 
+// AllowedWebhookScheme returns true if the given webhook signature scheme is supported
+func AllowedWebhookScheme(scheme WebhookScheme) bool {
+	return scheme == WebhookSchemeGitHub || scheme == WebhookSchemeGitea || scheme == WebhookSchemeStripe || scheme == WebhookSchemeHMAC
+}
+
 // AllowedTier returns true if the given tier name is valid
 func AllowedTier(tier string) bool {
 	return allowedTierRegex.MatchString(tier)
@@ -274,14 +327,19 @@ func AllowedTier(tier string) bool {
 
 // Error constants used by the package
 var (
-	ErrUnauthenticated     = errors.New("unauthenticated")
-	ErrUnauthorized        = errors.New("unauthorized")
-	ErrInvalidArgument     = errors.New("invalid argument")
-	ErrUserNotFound        = errors.New("user not found")
-	ErrUserExists          = errors.New("user already exists")
-	ErrTierNotFound        = errors.New("tier not found")
-	ErrTokenNotFound       = errors.New("token not found")
-	ErrPhoneNumberNotFound = errors.New("phone number not found")
-	ErrTooManyReservations = errors.New("new tier has lower reservation limit")
-	ErrPhoneNumberExists   = errors.New("phone number already exists")
+	ErrUnauthenticated        = errors.New("unauthenticated")
+	ErrUnauthorized           = errors.New("unauthorized")
+	ErrInvalidArgument        = errors.New("invalid argument")
+	ErrUserNotFound           = errors.New("user not found")
+	ErrUserExists             = errors.New("user already exists")
+	ErrTierNotFound           = errors.New("tier not found")
+	ErrTokenNotFound          = errors.New("token not found")
+	ErrPhoneNumberNotFound    = errors.New("phone number not found")
+	ErrTooManyReservations    = errors.New("new tier has lower reservation limit")
+	ErrPhoneNumberExists      = errors.New("phone number already exists")
+	ErrWebhookNotFound        = errors.New("webhook not found")
+	ErrTopicLinkNotFound      = errors.New("topic link not found")
+	ErrTopicLinkLoop          = errors.New("topic link would create a loop")
+	ErrRoutingRuleNotFound    = errors.New("routing rule not found")
+	ErrTopicRetentionNotFound = errors.New("topic retention not found")
 )
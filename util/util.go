@@ -381,6 +381,28 @@ func UnmarshalJSONWithLimit[T any](r io.ReadCloser, limit int, allowEmpty bool)
 	return &obj, nil
 }
 
+// CanonicalJSON re-encodes the given JSON object in a canonical form, leaving out the given top-level
+// fields: Object keys are sorted, there is no insignificant whitespace, numbers are kept as they are,
+// and HTML characters are not escaped. This is useful to sign and verify JSON objects.
+func CanonicalJSON(b []byte, exclude ...string) ([]byte, error) {
+	var obj map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&obj); err != nil {
+		return nil, err
+	}
+	for _, field := range exclude {
+		delete(obj, field)
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(obj); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
 // Retry executes function f until if succeeds, and then returns t. If f fails, it sleeps
 // and tries again. The sleep durations are passed as the after params.
 func Retry[T any](f func() (*T, error), after ...time.Duration) (t *T, err error) {
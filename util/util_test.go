@@ -275,3 +275,12 @@ func TestMaybeMarshalJSON(t *testing.T) {
 	require.Equal(t, `"`+strings.Repeat("x", 4999), MaybeMarshalJSON(strings.Repeat("x", 6000)))
 
 }
+
+func TestCanonicalJSON(t *testing.T) {
+	b, err := CanonicalJSON([]byte(`{"topic":"mytopic", "time":1700000000, "message":"<b>hi</b> & bye", "signature":"abc", "tags":["b","a"], "attachment":{"url":"x","name":"y"}}`), "signature")
+	require.Nil(t, err)
+	require.Equal(t, `{"attachment":{"name":"y","url":"x"},"message":"<b>hi</b> & bye","tags":["b","a"],"time":1700000000,"topic":"mytopic"}`, string(b))
+
+	_, err = CanonicalJSON([]byte(`not json`))
+	require.NotNil(t, err)
+}
@@ -51,7 +51,7 @@ export const icon = "/static/images/ntfy.png";
 export const badge = "/static/images/mask-icon.svg";
 
 export const toNotificationParams = ({ subscriptionId, message, defaultTitle, topicRoute }) => {
-  const image = isImage(message.attachment) ? message.attachment.url : undefined;
+  const image = isImage(message.attachment) ? (message.attachment.thumbnail ?? message.attachment.url) : undefined;
 
   // https://developer.mozilla.org/en-US/docs/Web/API/Notifications_API
   return [